@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// currentAccount is the account the user is trading in, set next to the
+// user by CheckIfLoggedIn.
+func currentAccount(c *gin.Context) (models.Account, bool) {
+	aVal, ok := c.Get("account")
+	if !ok {
+		return models.Account{}, false
+	}
+	acct, ok := aVal.(models.Account)
+	return acct, ok
+}
+
+// GET /accounts
+func GetAccounts(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/accounts",
+		}))
+		return
+	}
+	renderAccounts(c, "accounts", gin.H{}, map[string]string{}, "")
+}
+
+// POST /accounts
+func PostOpenAccount(c *gin.Context) {
+	form := gin.H{
+		"Name":     c.PostForm("name"),
+		"Capital":  c.PostForm("capital"),
+		"FundFrom": c.PostForm("fund_from"),
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		renderAccounts(c, "accountsBox", form, map[string]string{"_form": "There was an error getting user"}, "")
+		return
+	}
+
+	in := services.AccountInput{
+		Name:     c.PostForm("name"),
+		Currency: c.PostForm("currency"),
+	}
+	if v := strings.TrimSpace(c.PostForm("capital")); v != "" {
+		capital, err := decimal.Parse(v)
+		if err != nil {
+			renderAccounts(c, "accountsBox", form, map[string]string{"capital": "Enter a valid amount."}, "")
+			return
+		}
+		in.StartingCapital = capital
+	}
+	in.FundFrom, _ = primitive.ObjectIDFromHex(strings.TrimSpace(c.PostForm("fund_from")))
+
+	a, errs := services.OpenAccount(user.ID, in)
+	if len(errs) > 0 {
+		renderAccounts(c, "accountsBox", form, errs, "")
+		return
+	}
+
+	// the new account is the active one now
+	c.Set("account", a)
+	c.Header("HX-Trigger", "accountSwitched")
+	renderAccounts(c, "accountsBox", gin.H{}, map[string]string{},
+		"\""+a.Name+"\" is open and you are now trading in it.")
+}
+
+// POST /accounts/:id/switch
+// The page is reloaded, since everything on it belongs to the old account.
+func PostSwitchAccount(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if _, err := services.SwitchAccount(user.ID, id); err == services.ErrAccountNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("HX-Refresh", "true")
+	c.Status(http.StatusNoContent)
+}
+
+// GET /accounts/switcher (the navbar dropdown)
+func GetAccountSwitcher(c *gin.Context) {
+	accounts := []models.Account{}
+	if user, ok := currentUser(c); ok {
+		if list, err := services.ListAccounts(user.ID); err == nil {
+			accounts = list
+		}
+	}
+	c.HTML(http.StatusOK, "accountSwitcher", middlewares.WithAuth(c, gin.H{
+		"Accounts": accounts,
+	}))
+}
+
+func renderAccounts(c *gin.Context, name string, form gin.H, errs map[string]string, succ string) {
+	accounts := []models.Account{}
+	if user, ok := currentUser(c); ok {
+		if list, err := services.ListAccounts(user.ID); err == nil {
+			accounts = list
+		}
+	}
+	c.HTML(http.StatusOK, name, middlewares.WithAuth(c, gin.H{
+		"Accounts": accounts,
+		"Currency": services.AccountCurrency,
+		"form":     form,
+		"errors":   errs,
+		"succ":     succ,
+	}))
+}
+
+// GET /api/v1/accounts
+func GetAPIAccounts(c *gin.Context) {
+	user, ok := apiUser(c)
+	if !ok {
+		return
+	}
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+
+	list, err := services.ListAccounts(user.ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load accounts.", nil)
+		return
+	}
+	apiList(c, apiSlice(list, page, perPage), page, perPage, int64(len(list)))
+}
@@ -0,0 +1,420 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GET /admin
+func GetAdminPage(c *gin.Context) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.HTML(200, "admin", middlewares.WithAuth(c, gin.H{}))
+		return
+	}
+	c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+		"InitialPath": "/admin",
+	}))
+}
+
+// GET /admin/users?q= (HTMX partial)
+func GetAdminUsers(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+
+	users, err := services.SearchUsers(q, 50)
+	if err != nil {
+		c.HTML(http.StatusOK, "adminUsers", middlewares.WithAuth(c, gin.H{
+			"Query": q,
+			"Error": "Could not load users.",
+		}))
+		return
+	}
+
+	c.HTML(http.StatusOK, "adminUsers", middlewares.WithAuth(c, gin.H{
+		"Query": q,
+		"Users": users,
+	}))
+}
+
+// GET /admin/users/:id
+func GetAdminUser(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/admin/users/" + c.Param("id"),
+		}))
+		return
+	}
+
+	renderAdminUser(c, nil, "")
+}
+
+// POST /admin/users/:id/balance (account_id in the form)
+func PostAdminAdjustBalance(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	amountStr := strings.TrimSpace(c.PostForm("amount"))
+	amount, err := decimal.Parse(amountStr)
+	if err != nil {
+		renderAdminUser(c, map[string]string{"amount": "Enter a valid amount."}, "")
+		return
+	}
+
+	acct, ok := adminTargetAccount(c, target)
+	if !ok {
+		return
+	}
+	_, errs := services.AdminAdjustBalance(admin.ID, acct.ID, amount, c.PostForm("reason"))
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	renderAdminUser(c, nil, "Balance adjusted by "+amount.StringFixed(2)+".")
+}
+
+// POST /admin/users/:id/disable
+func PostAdminDisableUser(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	_, errs := services.AdminSetDisabled(admin.ID, target, true)
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	renderAdminUser(c, nil, "Account disabled and signed out everywhere.")
+}
+
+// POST /admin/users/:id/enable
+func PostAdminEnableUser(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	_, errs := services.AdminSetDisabled(admin.ID, target, false)
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	renderAdminUser(c, nil, "Account enabled.")
+}
+
+// POST /admin/users/:id/logout
+func PostAdminForceLogout(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	_, errs := services.AdminForceLogout(admin.ID, target)
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	renderAdminUser(c, nil, "All sessions have been logged out.")
+}
+
+// POST /admin/users/:id/role
+func PostAdminSetRole(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	role := strings.TrimSpace(c.PostForm("role"))
+	_, errs := services.AdminSetRole(admin.ID, target, role)
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	renderAdminUser(c, nil, "Role changed to "+role+".")
+}
+
+// POST /admin/users/:id/fees
+func PostAdminSetFees(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	var scheduleID primitive.ObjectID
+	if raw := strings.TrimSpace(c.PostForm("fee_schedule")); raw != "" {
+		oid, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			renderAdminUser(c, map[string]string{"fee_schedule": "Unknown fee schedule."}, "")
+			return
+		}
+		scheduleID = oid
+	}
+
+	tier := strings.TrimSpace(c.PostForm("tier"))
+	_, errs := services.AdminSetFees(admin.ID, target, tier, scheduleID)
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	renderAdminUser(c, nil, "Fees updated.")
+}
+
+// POST /admin/users/:id/margin (account_id in the form)
+func PostAdminSetMargin(c *gin.Context) {
+	admin, target, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	acct, ok := adminTargetAccount(c, target)
+	if !ok {
+		return
+	}
+	enabled := c.PostForm("enabled") == "true"
+	_, errs := services.AdminSetMargin(admin.ID, acct.ID, enabled)
+	if len(errs) > 0 {
+		renderAdminUser(c, errs, "")
+		return
+	}
+	if enabled {
+		renderAdminUser(c, nil, "Margin account enabled.")
+		return
+	}
+	renderAdminUser(c, nil, "Margin disabled. Open shorts and any debit balance can still be reduced.")
+}
+
+// GET /admin/transfers (HTMX partial)
+func GetAdminTransfers(c *gin.Context) {
+	renderAdminTransfers(c, "", "")
+}
+
+// POST /admin/transfers/:id/approve
+func PostAdminApproveTransfer(c *gin.Context) {
+	admin, id, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	t, err := services.AdminApproveTransfer(admin.ID, id)
+	if err == services.ErrTransferNotPending {
+		renderAdminTransfers(c, "That transfer was already decided.", "")
+		return
+	}
+	if err == services.ErrPaymentNotSettled {
+		renderAdminTransfers(c, "The payment for that deposit hasn't settled yet.", "")
+		return
+	}
+	if err != nil {
+		renderAdminTransfers(c, "Could not approve the transfer.", "")
+		return
+	}
+	renderAdminTransfers(c, "", "Approved a "+t.Type+" of "+t.Amount.StringFixed(2)+".")
+}
+
+// POST /admin/transfers/:id/reject
+func PostAdminRejectTransfer(c *gin.Context) {
+	admin, id, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	t, err := services.AdminRejectTransfer(admin.ID, id, c.PostForm("reason"))
+	if err == services.ErrTransferNotPending {
+		renderAdminTransfers(c, "That transfer was already decided.", "")
+		return
+	}
+	if err != nil {
+		renderAdminTransfers(c, "Could not reject the transfer.", "")
+		return
+	}
+	renderAdminTransfers(c, "", "Rejected a "+t.Type+" of "+t.Amount.StringFixed(2)+".")
+}
+
+// GET /admin/fees (HTMX partial)
+func GetAdminFees(c *gin.Context) {
+	renderAdminFees(c, nil, "")
+}
+
+// POST /admin/fees
+func PostAdminSaveFeeSchedule(c *gin.Context) {
+	s, errs := services.SaveFeeSchedule(services.FeeScheduleInput{
+		Name:           c.PostForm("name"),
+		Tier:           c.PostForm("tier"),
+		Flat:           c.PostForm("flat"),
+		PerShare:       c.PostForm("per_share"),
+		Percent:        c.PostForm("percent"),
+		Min:            c.PostForm("min"),
+		Max:            c.PostForm("max"),
+		RegPercent:     c.PostForm("reg_percent"),
+		RegPerShare:    c.PostForm("reg_per_share"),
+		RegPerShareMax: c.PostForm("reg_per_share_max"),
+	})
+	if len(errs) > 0 {
+		renderAdminFees(c, errs, "")
+		return
+	}
+	renderAdminFees(c, nil, "Saved "+s.Name+".")
+}
+
+// POST /admin/fees/:id/delete
+func PostAdminDeleteFeeSchedule(c *gin.Context) {
+	_, id, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	err := services.DeleteFeeSchedule(id)
+	if err == services.ErrFeeScheduleNotFound {
+		renderAdminFees(c, map[string]string{"_form": "That schedule was already deleted."}, "")
+		return
+	}
+	if err != nil {
+		renderAdminFees(c, map[string]string{"_form": "Could not delete the schedule."}, "")
+		return
+	}
+	renderAdminFees(c, nil, "Schedule deleted.")
+}
+
+func renderAdminFees(c *gin.Context, errs map[string]string, succ string) {
+	schedules, err := services.ListFeeSchedules()
+	if err != nil {
+		schedules = []models.FeeSchedule{}
+	}
+	if errs == nil {
+		errs = map[string]string{}
+	}
+	c.HTML(http.StatusOK, "adminFees", middlewares.WithAuth(c, gin.H{
+		"Schedules": schedules,
+		"Tiers":     services.AccountTiers,
+		"errors":    errs,
+		"succ":      succ,
+	}))
+}
+
+func renderAdminTransfers(c *gin.Context, errMsg, succ string) {
+	transfers, err := services.ListPendingTransfers(100)
+	if err != nil {
+		transfers = []models.FundTransfer{}
+		errMsg = "Could not load transfers."
+	}
+	c.HTML(http.StatusOK, "adminTransfers", middlewares.WithAuth(c, gin.H{
+		"Transfers": transfers,
+		"Error":     errMsg,
+		"succ":      succ,
+	}))
+}
+
+func adminTarget(c *gin.Context) (models.User, primitive.ObjectID, bool) {
+	uVal, _ := c.Get("user")
+	admin, ok := uVal.(models.User)
+	if !ok {
+		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
+		return models.User{}, primitive.NilObjectID, false
+	}
+
+	oid, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		c.String(http.StatusOK, `<div class="text-danger">Unknown user.</div>`)
+		return models.User{}, primitive.NilObjectID, false
+	}
+	return admin, oid, true
+}
+
+// adminTargetAccount is the target user's account named by the account_id
+// form field.
+func adminTargetAccount(c *gin.Context, userID primitive.ObjectID) (models.Account, bool) {
+	accountID, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.PostForm("account_id")))
+	if err != nil {
+		renderAdminUser(c, map[string]string{"_form": "Unknown account."}, "")
+		return models.Account{}, false
+	}
+	acct, err := services.GetUserAccount(userID, accountID)
+	if err != nil {
+		renderAdminUser(c, map[string]string{"_form": "Unknown account."}, "")
+		return models.Account{}, false
+	}
+	return acct, true
+}
+
+func renderAdminUser(c *gin.Context, errs map[string]string, succ string) {
+	oid, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		c.HTML(http.StatusOK, "404", middlewares.WithAuth(c, gin.H{"message": "Unknown user."}))
+		return
+	}
+
+	u, ok := database.GetUser(oid)
+	if !ok {
+		c.HTML(http.StatusOK, "404", middlewares.WithAuth(c, gin.H{"message": "Unknown user."}))
+		return
+	}
+
+	// the page shows one account at a time: the one picked (?account= or
+	// the form's account_id), else the one the user is trading in
+	accounts, err := services.ListAccounts(oid)
+	if err != nil {
+		accounts = []models.Account{}
+	}
+	pick := c.Query("account")
+	if pick == "" {
+		pick = c.PostForm("account_id")
+	}
+	var acct models.Account
+	for _, a := range accounts {
+		if a.ID.Hex() == pick || (pick == "" && a.ID == u.ActiveAccountID) {
+			acct = a
+		}
+	}
+	if acct.ID.IsZero() && len(accounts) > 0 {
+		acct = accounts[0]
+	}
+
+	positions, err := services.ListAccountPositions(acct.ID)
+	if err != nil {
+		positions = []models.Position{}
+	}
+	orders, err := services.ListAccountOrders(acct.ID, 50)
+	if err != nil {
+		orders = []models.Order{}
+	}
+	alerts, err := services.ListAccountAlerts(acct.ID)
+	if err != nil {
+		alerts = []models.PriceAlert{}
+	}
+	actions, err := services.ListAdminActions(oid, 20)
+	if err != nil {
+		actions = []models.AdminAction{}
+	}
+
+	schedules, err := services.ListFeeSchedules()
+	if err != nil {
+		schedules = []models.FeeSchedule{}
+	}
+
+	if errs == nil {
+		errs = map[string]string{}
+	}
+
+	c.HTML(http.StatusOK, "adminUser", middlewares.WithAuth(c, gin.H{
+		"Target":       u,
+		"Accounts":     accounts,
+		"Account":      acct,
+		"Positions":    positions,
+		"Orders":       orders,
+		"Alerts":       alerts,
+		"Actions":      actions,
+		"FeeSchedules": schedules,
+		"Tiers":        services.AccountTiers,
+		"errors":       errs,
+		"succ":         succ,
+	}))
+}
@@ -1,11 +1,11 @@
 package controllers
 
 import (
-	"strconv"
 	"net/http"
     "strings"
     "sort"
     "github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"github.com/GeorgiStoyanov05/GoMarket/models"
 	"github.com/GeorgiStoyanov05/GoMarket/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,8 +13,8 @@ import (
 )
 
 type AlertGroup struct {
-	Symbol string
-	Alerts []models.PriceAlert
+	Symbol string              `json:"symbol"`
+	Alerts []models.PriceAlert `json:"alerts"`
 }
 
 func PostCreateAlert(c *gin.Context) {
@@ -22,24 +22,24 @@ func PostCreateAlert(c *gin.Context) {
 	cond := strings.TrimSpace(c.PostForm("condition"))
 	targetStr := strings.TrimSpace(c.PostForm("targetPrice"))
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
 		return
 	}
-	user, ok := uVal.(models.User)
+	acct, ok := aVal.(models.Account)
 	if !ok {
 		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
 		return
 	}
 
-	target, err := strconv.ParseFloat(targetStr, 64)
+	target, err := decimal.Parse(targetStr)
 	if targetStr == "" || err != nil {
 		c.String(http.StatusOK, `<div class="text-danger">Please enter a valid target price.</div>`)
 		return
 	}
 
-	_, errs := services.CreatePriceAlert(user.ID, symbol, cond, target)
+	_, errs := services.CreatePriceAlert(acct, symbol, cond, target)
 	if len(errs) > 0 {
 		msgs := make([]string, 0, len(errs))
 		if v, ok := errs["_form"]; ok && v != "" {
@@ -65,7 +65,7 @@ func PostCreateAlert(c *gin.Context) {
 func GetAlertsList(c *gin.Context) {
 	symbol := strings.ToUpper(strings.TrimSpace(c.Param("symbol")))
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.HTML(http.StatusOK, "alertsList", middlewares.WithAuth(c, gin.H{
 			"Symbol": symbol,
@@ -74,9 +74,9 @@ func GetAlertsList(c *gin.Context) {
 		return
 	}
 
-	user := uVal.(models.User)
+	acct := aVal.(models.Account)
 
-	alerts, err := services.ListPriceAlerts(user.ID, symbol)
+	alerts, err := services.ListPriceAlerts(acct.ID, symbol)
 	if err != nil {
 		alerts = []models.PriceAlert{}
 	}
@@ -92,12 +92,12 @@ func PostDeleteAlert(c *gin.Context) {
 	symbol := strings.ToUpper(strings.TrimSpace(c.Param("symbol")))
 	idStr := strings.TrimSpace(c.Param("id"))
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
 		return
 	}
-	user, ok := uVal.(models.User)
+	acct, ok := aVal.(models.Account)
 	if !ok {
 		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
 		return
@@ -105,10 +105,10 @@ func PostDeleteAlert(c *gin.Context) {
 
 	oid, err := primitive.ObjectIDFromHex(idStr)
 	if err == nil {
-		_ = services.DeletePriceAlert(user.ID, oid)
+		_ = services.DeletePriceAlert(acct.ID, oid)
 	}
 
-	alerts, err := services.ListPriceAlerts(user.ID, symbol)
+	alerts, err := services.ListPriceAlerts(acct.ID, symbol)
 	if err != nil {
 		alerts = []models.PriceAlert{}
 	}
@@ -121,21 +121,26 @@ func PostDeleteAlert(c *gin.Context) {
 
 // GET /alerts/list
 func GetWatchlistAlerts(c *gin.Context) {
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.HTML(http.StatusOK, "watchlistAlerts", middlewares.WithAuth(c, gin.H{
 			"Groups": []AlertGroup{},
 		}))
 		return
 	}
-	user := uVal.(models.User)
+	acct := aVal.(models.Account)
 
-	alerts, err := services.ListAllUserAlerts(user.ID)
+	alerts, err := services.ListAccountAlerts(acct.ID)
 	if err != nil {
 		alerts = []models.PriceAlert{}
 	}
 
-	// group by symbol
+	c.HTML(http.StatusOK, "watchlistAlerts", middlewares.WithAuth(c, gin.H{
+		"Groups": groupAlertsBySymbol(alerts),
+	}))
+}
+
+func groupAlertsBySymbol(alerts []models.PriceAlert) []AlertGroup {
 	m := map[string][]models.PriceAlert{}
 	for _, a := range alerts {
 		s := strings.ToUpper(strings.TrimSpace(a.Symbol))
@@ -151,25 +156,22 @@ func GetWatchlistAlerts(c *gin.Context) {
 	sort.Slice(groups, func(i, j int) bool {
 		return groups[i].Symbol < groups[j].Symbol
 	})
-
-	c.HTML(http.StatusOK, "watchlistAlerts", middlewares.WithAuth(c, gin.H{
-		"Groups": groups,
-	}))
+	return groups
 }
 
 func PostDeleteAlertGlobal(c *gin.Context) {
 	idStr := strings.TrimSpace(c.Param("id"))
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.Status(http.StatusUnauthorized)
 		return
 	}
-	user := uVal.(models.User)
+	acct := aVal.(models.Account)
 
 	oid, err := primitive.ObjectIDFromHex(idStr)
 	if err == nil {
-		_ = services.DeletePriceAlert(user.ID, oid)
+		_ = services.DeletePriceAlert(acct.ID, oid)
 	}
 
 	// Make both Details + Watchlist refresh wherever they are
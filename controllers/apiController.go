@@ -0,0 +1,612 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Error codes used in the {"error": {...}} envelope.
+const (
+	APIErrBadRequest   = "bad_request"
+	APIErrUnauthorized = "unauthorized"
+	APIErrForbidden    = "forbidden"
+	APIErrNotFound     = "not_found"
+	APIErrConflict     = "conflict"
+	APIErrValidation   = "validation_failed"
+	APIErrUpstream     = "upstream_unavailable"
+	APIErrInternal     = "internal_error"
+)
+
+const (
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 200
+)
+
+type APIOrderRequest struct {
+	Symbol string          `json:"symbol"`
+	Side   string          `json:"side"` // "buy" | "sell"
+	Qty    decimal.Decimal `json:"qty"`
+	Amount decimal.Decimal `json:"amount"` // buy by amount instead of qty
+}
+
+type APIAlertRequest struct {
+	Symbol      string          `json:"symbol"`
+	Condition   string          `json:"condition"` // "above" | "below"
+	TargetPrice decimal.Decimal `json:"target_price"`
+}
+
+type APIOrderResult struct {
+	Side       string          `json:"side"`
+	Symbol     string          `json:"symbol"`
+	Qty        decimal.Decimal `json:"qty"`
+	FillPrice  decimal.Decimal `json:"fill_price"`
+	Amount     decimal.Decimal `json:"amount"` // cost of a buy, proceeds of a sell
+	Fees       decimal.Decimal `json:"fees"`   // commission and regulatory fees
+	NewBalance decimal.Decimal `json:"new_balance"`
+}
+
+type APIBalance struct {
+	AccountID primitive.ObjectID `json:"account_id"`
+	Account   string             `json:"account"`
+
+	Balance   decimal.Decimal `json:"balance"`
+	Reserved  decimal.Decimal `json:"reserved"`  // held for pending withdrawals
+	Available decimal.Decimal `json:"available"` // balance minus reserved
+	// What can be spent on stock now; above the balance for a margin account
+	BuyingPower decimal.Decimal `json:"buying_power"`
+}
+
+type APITransferRequest struct {
+	Type   string          `json:"type"` // "deposit" | "withdrawal"
+	Amount decimal.Decimal `json:"amount"`
+}
+
+type APIPagination struct {
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+	Total   int64 `json:"total"`
+}
+
+func apiUser(c *gin.Context) (models.User, bool) {
+	uVal, ok := c.Get("user")
+	if !ok {
+		apiError(c, http.StatusUnauthorized, APIErrUnauthorized, "Missing or invalid credentials.", nil)
+		return models.User{}, false
+	}
+	user, ok := uVal.(models.User)
+	if !ok {
+		apiError(c, http.StatusUnauthorized, APIErrUnauthorized, "Missing or invalid credentials.", nil)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// apiAccount is the account the request trades in: the one named by
+// X-Account-ID, else the user's active one.
+func apiAccount(c *gin.Context) (models.Account, bool) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load the account.", nil)
+		return models.Account{}, false
+	}
+	return acct, true
+}
+
+func apiError(c *gin.Context, status int, code, msg string, fields map[string]string) {
+	body := gin.H{"code": code, "message": msg}
+	if len(fields) > 0 {
+		body["fields"] = fields
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": body})
+}
+
+// apiFormErrors turns the map[string]string errors used by services into
+// an API error, using "_form" as the message when there is one.
+func apiFormErrors(c *gin.Context, errs map[string]string) {
+	msg := errs["_form"]
+	fields := map[string]string{}
+	for k, v := range errs {
+		if k != "_form" {
+			fields[k] = v
+		}
+	}
+	if msg == "" {
+		msg = "Validation failed."
+	}
+	apiError(c, http.StatusUnprocessableEntity, APIErrValidation, msg, fields)
+}
+
+func apiData(c *gin.Context, status int, data any) {
+	c.JSON(status, gin.H{"data": data})
+}
+
+func apiList(c *gin.Context, data any, page, perPage int, total int64) {
+	c.JSON(http.StatusOK, gin.H{
+		"data":       data,
+		"pagination": APIPagination{Page: page, PerPage: perPage, Total: total},
+	})
+}
+
+// apiPage reads ?page=&per_page=, writing a 422 and returning ok=false if
+// they are malformed.
+func apiPage(c *gin.Context) (page, perPage int, ok bool) {
+	page, perPage = 1, apiDefaultPerPage
+	errs := map[string]string{}
+
+	if v := strings.TrimSpace(c.Query("page")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs["page"] = "Page must be a positive integer."
+		}
+		page = n
+	}
+	if v := strings.TrimSpace(c.Query("per_page")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			errs["per_page"] = "per_page must be between 1 and " + strconv.Itoa(apiMaxPerPage) + "."
+		}
+		perPage = n
+	}
+
+	if len(errs) > 0 {
+		apiError(c, http.StatusUnprocessableEntity, APIErrValidation, "Invalid pagination.", errs)
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+func apiSlice[T any](items []T, page, perPage int) []T {
+	start := (page - 1) * perPage
+	if start >= len(items) {
+		return []T{}
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end]
+}
+
+// GET /api/v1/account
+func GetAPIAccount(c *gin.Context) {
+	user, ok := apiUser(c)
+	if !ok {
+		return
+	}
+	apiData(c, http.StatusOK, user)
+}
+
+// GET /api/v1/account/balance
+func GetAPIBalance(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	buyingPower := acct.Available()
+	if a, err := services.GetMarginAccount(acct); err == nil {
+		buyingPower = a.BuyingPower
+	}
+	apiData(c, http.StatusOK, APIBalance{
+		AccountID:   acct.ID,
+		Account:     acct.Name,
+		Balance:     acct.Balance,
+		Reserved:    acct.Reserved,
+		Available:   acct.Available(),
+		BuyingPower: buyingPower,
+	})
+}
+
+// GET /api/v1/account/margin
+func GetAPIMargin(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	account, err := services.GetMarginAccount(acct)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load the account.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, account)
+}
+
+// GET /api/v1/positions
+func GetAPIPositions(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+
+	positions, err := services.ListAccountPositions(acct.ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load positions.", nil)
+		return
+	}
+	apiList(c, apiSlice(positions, page, perPage), page, perPage, int64(len(positions)))
+}
+
+// GET /api/v1/positions/:symbol
+func GetAPIPosition(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	pos, err := services.GetAccountPosition(acct.ID, c.Param("symbol"))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load the position.", nil)
+		return
+	}
+	if pos == nil || pos.Qty.IsZero() {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "No open position for this symbol.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, pos)
+}
+
+// GET /api/v1/portfolio/history?range=
+func GetAPIPortfolioHistory(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	points, err := services.PortfolioHistory(acct.ID, c.DefaultQuery("range", services.Range1M))
+	if err == services.ErrUnknownRange {
+		apiFormErrors(c, map[string]string{"range": "Range must be one of " + strings.Join(services.PortfolioRanges, ", ") + "."})
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load history.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, points)
+}
+
+// GET /api/v1/portfolio/performance?range=
+func GetAPIPortfolioPerformance(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	perf, err := services.PortfolioPerformance(acct.ID, c.DefaultQuery("range", services.Range1M))
+	if err == services.ErrUnknownRange {
+		apiFormErrors(c, map[string]string{"range": "Range must be one of " + strings.Join(services.PortfolioRanges, ", ") + "."})
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not compute performance.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, perf)
+}
+
+// GET /api/v1/portfolio/risk?window=
+func GetAPIPortfolioRisk(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	rep, err := services.PortfolioRisk(acct.ID, c.DefaultQuery("window", services.Window1Y))
+	if err == services.ErrUnknownWindow {
+		apiFormErrors(c, map[string]string{"window": "Window must be one of " + strings.Join(services.RiskWindows, ", ") + "."})
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not compute risk statistics.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, rep)
+}
+
+// GET /api/v1/portfolio/allocation
+func GetAPIPortfolioAllocation(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	alloc, err := services.PortfolioAllocation(acct)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load the allocation.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, alloc)
+}
+
+// GET /api/v1/transfers
+func GetAPITransfers(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	list, err := services.ListFundTransfers(acct.ID, 200)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load transfers.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, list)
+}
+
+// POST /api/v1/transfers
+func PostAPITransfer(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	var req APITransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, APIErrBadRequest, "Invalid JSON body.", nil)
+		return
+	}
+
+	t, errs := services.RequestTransfer(acct, strings.ToLower(strings.TrimSpace(req.Type)), req.Amount)
+	if len(errs) > 0 {
+		apiFormErrors(c, errs)
+		return
+	}
+	apiData(c, http.StatusCreated, t)
+}
+
+// GET /api/v1/statements
+func GetAPIStatements(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	list, err := services.ListStatements(acct.ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load statements.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, list)
+}
+
+// GET /api/v1/statements/:id/pdf
+func GetAPIStatementPDF(c *gin.Context) {
+	user, ok := apiUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "Statement not found.", nil)
+		return
+	}
+	s, err := services.GetStatement(user.ID, id)
+	if err == services.ErrStatementNotFound {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "Statement not found.", nil)
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load the statement.", nil)
+		return
+	}
+	sendStatementPDF(c, s.PDF, services.StatementFilename(s))
+}
+
+// GET /api/v1/orders
+func GetAPIOrders(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+
+	orders, total, err := services.ListAccountOrdersPage(acct.ID, int64((page-1)*perPage), int64(perPage))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load orders.", nil)
+		return
+	}
+	apiList(c, orders, page, perPage, total)
+}
+
+// POST /api/v1/orders
+func PostAPIOrder(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	var req APIOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, APIErrBadRequest, "Invalid JSON body.", nil)
+		return
+	}
+
+	switch strings.ToLower(strings.TrimSpace(req.Side)) {
+	case "buy":
+		var res services.BuyResult
+		var errs map[string]string
+		if !req.Amount.IsZero() {
+			res, errs = services.MarketBuyAmount(acct.ID, req.Symbol, req.Amount)
+		} else {
+			res, errs = services.MarketBuy(acct.ID, req.Symbol, req.Qty)
+		}
+		if len(errs) > 0 {
+			apiFormErrors(c, errs)
+			return
+		}
+		apiData(c, http.StatusCreated, APIOrderResult{
+			Side:       "buy",
+			Symbol:     res.Symbol,
+			Qty:        res.Qty,
+			FillPrice:  res.FillPrice,
+			Amount:     res.Cost,
+			Fees:       res.Fees,
+			NewBalance: res.NewBalance,
+		})
+	case "sell":
+		res, errs := services.MarketSell(acct.ID, req.Symbol, req.Qty)
+		if len(errs) > 0 {
+			apiFormErrors(c, errs)
+			return
+		}
+		apiData(c, http.StatusCreated, APIOrderResult{
+			Side:       "sell",
+			Symbol:     res.Symbol,
+			Qty:        res.Qty,
+			FillPrice:  res.FillPrice,
+			Amount:     res.Proceeds,
+			Fees:       res.Fees,
+			NewBalance: res.NewBalance,
+		})
+	default:
+		apiFormErrors(c, map[string]string{"side": "Side must be 'buy' or 'sell'."})
+	}
+}
+
+// GET /api/v1/alerts
+func GetAPIAlerts(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+
+	alerts, err := services.ListAccountAlerts(acct.ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load alerts.", nil)
+		return
+	}
+	apiList(c, apiSlice(alerts, page, perPage), page, perPage, int64(len(alerts)))
+}
+
+// POST /api/v1/alerts
+func PostAPIAlert(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	var req APIAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, APIErrBadRequest, "Invalid JSON body.", nil)
+		return
+	}
+
+	a, errs := services.CreatePriceAlert(acct, req.Symbol, req.Condition, req.TargetPrice)
+	if len(errs) > 0 {
+		apiFormErrors(c, errs)
+		return
+	}
+	apiData(c, http.StatusCreated, a)
+}
+
+// DELETE /api/v1/alerts/:id
+func DeleteAPIAlert(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "Alert not found.", nil)
+		return
+	}
+	if err := services.DeletePriceAlert(acct.ID, oid); err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not delete the alert.", nil)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /api/v1/watchlists
+func GetAPIWatchlists(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+
+	alerts, err := services.ListAccountAlerts(acct.ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load the watchlist.", nil)
+		return
+	}
+	groups := groupAlertsBySymbol(alerts)
+	apiList(c, apiSlice(groups, page, perPage), page, perPage, int64(len(groups)))
+}
+
+// GET /api/v1/quotes/:symbol
+func GetAPIQuote(c *gin.Context) {
+	sym := strings.ToUpper(strings.TrimSpace(c.Param("symbol")))
+	if sym == "" {
+		apiFormErrors(c, map[string]string{"symbol": "Missing symbol."})
+		return
+	}
+
+	q, err := services.FetchQuote(sym)
+	if err != nil {
+		apiError(c, http.StatusBadGateway, APIErrUpstream, "Quote unavailable right now.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, q)
+}
+
+// GET /api/v1/market/status?exchange=&symbol=
+func GetAPIMarketStatus(c *gin.Context) {
+	cal := services.CalendarForSymbol(c.Query("symbol"))
+	if code := strings.TrimSpace(c.Query("exchange")); code != "" {
+		var err error
+		if cal, err = services.ExchangeCalendar(code); err != nil {
+			apiError(c, http.StatusNotFound, APIErrNotFound, "Unknown exchange.", nil)
+			return
+		}
+	}
+	apiData(c, http.StatusOK, cal.Status(time.Now()))
+}
+
+// GET /api/v1/symbols/search?q=
+func GetAPISymbolSearch(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		apiFormErrors(c, map[string]string{"q": "Query is required."})
+		return
+	}
+
+	limit := 10
+	if v := strings.TrimSpace(c.Query("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 50 {
+			apiFormErrors(c, map[string]string{"limit": "Limit must be between 1 and 50."})
+			return
+		}
+		limit = n
+	}
+
+	results, err := services.SearchSymbols(q, limit)
+	if err != nil {
+		apiError(c, http.StatusBadGateway, APIErrUpstream, "Search unavailable right now.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, results)
+}
@@ -21,8 +21,9 @@ func isValidEmailRegex(s string) bool {
 func GetRegisterPage(c *gin.Context) {
 	if c.GetHeader("HX-Request") == "true" {
 		c.HTML(200, "register", middlewares.WithAuth(c, gin.H{
-			"values": models.RegisterModel{},
-			"errors": map[string]string{},
+			"values":    models.RegisterModel{},
+			"errors":    map[string]string{},
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
@@ -68,8 +69,9 @@ func PostRegisterPage(c *gin.Context) {
 
 	if len(errs) > 0 {
 		c.HTML(http.StatusOK, "register", middlewares.WithAuth(c, gin.H{
-			"values": user,
-			"errors": errs,
+			"values":    user,
+			"errors":    errs,
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
@@ -78,8 +80,9 @@ func PostRegisterPage(c *gin.Context) {
 
 	if len(newErrs) > 0 {
 		c.HTML(http.StatusOK, "register", middlewares.WithAuth(c, gin.H{
-			"values": user,
-			"errors": newErrs,
+			"values":    user,
+			"errors":    newErrs,
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
@@ -95,13 +98,15 @@ func PostRegisterPage(c *gin.Context) {
 	tokenStr, err:=services.CreateAndSignJWT(&u, ttl)
 	if err!=nil{
 		c.HTML(http.StatusOK, "login", middlewares.WithAuth(c, gin.H{
-			"values": user,
-			"errors": map[string]string{"_form": err.Error()},
+			"values":    user,
+			"errors":    map[string]string{"_form": err.Error()},
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
 
 	services.SetCookie(c, tokenStr, ttl)
+	middlewares.RotateCSRFToken(c)
 
 	c.Header("HX-Redirect", "/")
 	c.Status(204)
@@ -109,14 +114,19 @@ func PostRegisterPage(c *gin.Context) {
 
 func GetLoginPage(c *gin.Context) {
 	if c.GetHeader("HX-Request") == "true" {
+		errs := map[string]string{}
+		if msg := c.Query("oidc_error"); msg != "" {
+			errs["_form"] = msg
+		}
 		c.HTML(200, "login", middlewares.WithAuth(c, gin.H{
-			"values": models.RegisterModel{},
-			"errors": map[string]string{},
+			"values":    models.RegisterModel{},
+			"errors":    errs,
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
 	c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
-		"InitialPath": "/login",
+		"InitialPath": "/login" + queryString(c),
 	}))
 }
 
@@ -145,8 +155,9 @@ func PostLoginPage(c *gin.Context) {
 
 	if len(errs) > 0 {
 		c.HTML(http.StatusOK, "login", middlewares.WithAuth(c, gin.H{
-			"values": user,
-			"errors": errs,
+			"values":    user,
+			"errors":    errs,
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
@@ -154,8 +165,9 @@ func PostLoginPage(c *gin.Context) {
 	u,authErrs:=services.LoginUser(&user)
 	if(len(authErrs)>0){
 		c.HTML(http.StatusOK, "login", middlewares.WithAuth(c, gin.H{
-			"values": user,
-			"errors": authErrs,
+			"values":    user,
+			"errors":    authErrs,
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
@@ -170,13 +182,15 @@ func PostLoginPage(c *gin.Context) {
 	tokenStr, err:=services.CreateAndSignJWT(&u, ttl)
 	if err!=nil{
 		c.HTML(http.StatusOK, "login", middlewares.WithAuth(c, gin.H{
-			"values": user,
-			"errors": map[string]string{"_form": err.Error()},
+			"values":    user,
+			"errors":    map[string]string{"_form": err.Error()},
+			"Providers": services.OIDCProviders(),
 		}))
 		return
 	}
 
 	services.SetCookie(c, tokenStr, ttl)
+	middlewares.RotateCSRFToken(c)
 
 	c.Header("HX-Redirect", "/")
 	c.Status(204)
@@ -184,6 +198,7 @@ func PostLoginPage(c *gin.Context) {
 
 func UserLogout(c *gin.Context) {
 	services.ClearAuthCookie(c)
+	middlewares.RotateCSRFToken(c)
 
 	if c.GetHeader("HX-Request") == "true" {
 		c.Header("HX-Redirect", "/")
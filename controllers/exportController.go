@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type exportOption struct {
+	Key     string
+	Label   string
+	Columns string
+}
+
+var exportLabels = map[string]string{
+	services.ExportOrders:    "Orders",
+	services.ExportPositions: "Positions (current)",
+	services.ExportAlerts:    "Price alerts",
+	services.ExportCash:      "Cash movements",
+}
+
+// GET /settings/export
+func GetExportPage(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/settings/export",
+		}))
+		return
+	}
+
+	opts := make([]exportOption, 0, len(services.ExportDatasets))
+	for _, d := range services.ExportDatasets {
+		cols, _ := services.ExportColumns(d)
+		opts = append(opts, exportOption{Key: d, Label: exportLabels[d], Columns: strings.Join(cols, ", ")})
+	}
+
+	c.HTML(http.StatusOK, "exportData", middlewares.WithAuth(c, gin.H{
+		"Datasets": opts,
+		"Formats":  services.ExportFormats,
+	}))
+}
+
+// GET /export?dataset=orders&format=csv&from=YYYY-MM-DD&to=YYYY-MM-DD
+// A plain download, so errors are plain text.
+func GetExport(c *gin.Context) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	dataset, format, err := services.ValidateExport(c.Query("dataset"), c.DefaultQuery("format", services.FormatCSV))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Unknown dataset or format.")
+		return
+	}
+	from, to, errs := services.ParseExportRange(c.Query("from"), c.Query("to"))
+	if len(errs) > 0 {
+		c.String(http.StatusBadRequest, "Invalid date range.")
+		return
+	}
+
+	streamExport(c, acct.ID, dataset, format, from, to)
+}
+
+// GET /api/v1/export/:dataset?format=&from=&to=
+func GetAPIExport(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	dataset, format, err := services.ValidateExport(c.Param("dataset"), c.DefaultQuery("format", services.FormatCSV))
+	if err == services.ErrUnknownDataset {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "Unknown dataset.", nil)
+		return
+	}
+	if err != nil {
+		apiFormErrors(c, map[string]string{"format": "Format must be one of " + strings.Join(services.ExportFormats, ", ") + "."})
+		return
+	}
+	from, to, errs := services.ParseExportRange(c.Query("from"), c.Query("to"))
+	if len(errs) > 0 {
+		apiFormErrors(c, errs)
+		return
+	}
+
+	streamExport(c, acct.ID, dataset, format, from, to)
+}
+
+// streamExport writes the file straight to the response. Once rows have gone
+// out the status can't change any more, so a failure midway is only logged
+// (the client sees a truncated file).
+func streamExport(c *gin.Context, accountID primitive.ObjectID, dataset, format string, from, to time.Time) {
+	c.Header("Content-Type", services.ExportContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="`+services.ExportFilename(dataset, format, from, to)+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	if err := services.StreamExport(c.Request.Context(), accountID, dataset, format, from, to, c.Writer); err != nil {
+		log.Println("export:", dataset, err)
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxImportUpload = 2 << 20 // 2 MB
+
+// GET /settings/import
+func GetImportTrades(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/settings/import",
+		}))
+		return
+	}
+	renderImportForm(c, map[string]string{}, "generic", models.ImportMapping{}, "")
+}
+
+// POST /settings/import (multipart: layout, map_*, file)
+func PostImportTrades(c *gin.Context) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		renderImportForm(c, map[string]string{"_form": "There was an error getting the account"}, "", models.ImportMapping{}, "")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportUpload)
+
+	layout := strings.TrimSpace(c.PostForm("layout"))
+	custom := models.ImportMapping{
+		Date:   c.PostForm("map_date"),
+		Symbol: c.PostForm("map_symbol"),
+		Side:   c.PostForm("map_side"),
+		Qty:    c.PostForm("map_qty"),
+		Price:  c.PostForm("map_price"),
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		renderImportForm(c, map[string]string{"file": "Choose a CSV file (up to 2 MB)."}, layout, custom, "")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		renderImportForm(c, map[string]string{"file": "Could not read the file."}, layout, custom, "")
+		return
+	}
+	defer f.Close()
+
+	imp, summary, errs := services.PreviewTradeImport(acct, filepath.Base(fh.Filename), layout, custom, f)
+	if len(errs) > 0 && len(imp.Trades) == 0 {
+		renderImportForm(c, errs, layout, custom, "")
+		return
+	}
+
+	c.HTML(http.StatusOK, "importPreview", middlewares.WithAuth(c, gin.H{
+		"Import":  imp,
+		"Summary": summary,
+		"errors":  errs,
+	}))
+}
+
+// POST /settings/import/:id/apply
+func PostApplyImport(c *gin.Context) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		renderImportForm(c, map[string]string{"_form": "There was an error getting the account"}, "", models.ImportMapping{}, "")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		renderImportForm(c, map[string]string{"_form": "Unknown import."}, "generic", models.ImportMapping{}, "")
+		return
+	}
+
+	summary, errs := services.ApplyTradeImport(acct, id)
+	if len(errs) > 0 && summary.Trades == 0 {
+		renderImportForm(c, errs, "generic", models.ImportMapping{}, "")
+		return
+	}
+
+	succ := fmt.Sprintf("Imported %d trades into %d positions.", summary.Trades, len(summary.Holdings))
+	renderImportForm(c, errs, "generic", models.ImportMapping{}, succ)
+}
+
+func renderImportForm(c *gin.Context, errs map[string]string, layout string, custom models.ImportMapping, succ string) {
+	c.HTML(http.StatusOK, "importTrades", middlewares.WithAuth(c, gin.H{
+		"Layouts": services.ImportLayouts,
+		"Layout":  layout,
+		"Custom":  custom,
+		"MaxRows": services.MaxImportRows,
+		"errors":  errs,
+		"succ":    succ,
+	}))
+}
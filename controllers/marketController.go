@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GET /market/status?symbol= (HTMX partial)
+func GetMarketStatus(c *gin.Context) {
+	sym := strings.ToUpper(strings.TrimSpace(c.Query("symbol")))
+	cal := services.CalendarForSymbol(sym)
+
+	c.HTML(http.StatusOK, "marketStatus", middlewares.WithAuth(c, gin.H{
+		"Status": cal.Status(time.Now()),
+		"Symbol": sym,
+	}))
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GET /notifications
+// Opening the list marks everything as read.
+func GetNotifications(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/notifications",
+		}))
+		return
+	}
+
+	list := []models.Notification{}
+	if user, ok := currentUser(c); ok {
+		if l, err := services.ListNotifications(user.ID, 100); err == nil {
+			list = l
+		}
+		_ = services.MarkNotificationsRead(user.ID)
+		c.Header("HX-Trigger", "notificationsRead")
+	}
+
+	c.HTML(http.StatusOK, "notifications", middlewares.WithAuth(c, gin.H{
+		"Notifications": list,
+	}))
+}
+
+// GET /notifications/badge (polled by the navbar)
+func GetNotificationBadge(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.String(http.StatusOK, "")
+		return
+	}
+	n, err := services.CountUnreadNotifications(user.ID)
+	if err != nil || n == 0 {
+		c.String(http.StatusOK, "")
+		return
+	}
+	label := strconv.FormatInt(n, 10)
+	if n > 99 {
+		label = "99+"
+	}
+	c.String(http.StatusOK, `<span class="badge rounded-pill text-bg-danger">`+label+`</span>`)
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+const oidcStateCookie = "OIDC"
+
+// GET /auth/oidc/:provider
+// Full-page redirect to the provider (HTMX can't follow cross-origin redirects).
+func GetOIDCLogin(c *gin.Context) {
+	startOIDC(c, "")
+}
+
+// GET /auth/oidc/:provider/link
+func GetOIDCLink(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	startOIDC(c, user.ID.Hex())
+}
+
+func startOIDC(c *gin.Context, linkUser string) {
+	p, err := services.GetOIDCProvider(c.Param("provider"))
+	if err != nil {
+		oidcFail(c, linkUser != "", "Unknown sign-in provider.")
+		return
+	}
+
+	state, nonce, verifier := services.NewOIDCAuthRequest()
+	authURL, err := services.OIDCAuthURL(p, state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc %s: %v", p.Name, err)
+		oidcFail(c, linkUser != "", p.DisplayName+" sign-in is unavailable right now.")
+		return
+	}
+
+	signed, err := services.SignOIDCState(services.OIDCState{
+		Provider: p.Name,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		LinkUser: linkUser,
+	})
+	if err != nil {
+		oidcFail(c, linkUser != "", "Could not start sign-in.")
+		return
+	}
+
+	// Lax so the cookie comes back on the provider's top-level redirect
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, signed, int((10 * time.Minute).Seconds()), "/auth/oidc/", "", services.CookieSecure(), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// GET /auth/oidc/:provider/callback
+func GetOIDCCallback(c *gin.Context) {
+	raw, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc/", "", services.CookieSecure(), true)
+
+	st, err := services.ParseOIDCState(raw)
+	if err != nil || st.Provider != c.Param("provider") || st.State == "" || st.State != c.Query("state") {
+		oidcFail(c, false, "Sign-in expired or was tampered with. Please try again.")
+		return
+	}
+	linking := st.LinkUser != ""
+
+	if e := c.Query("error"); e != "" {
+		oidcFail(c, linking, "Sign-in was cancelled.")
+		return
+	}
+
+	p, err := services.GetOIDCProvider(st.Provider)
+	if err != nil {
+		oidcFail(c, linking, "Unknown sign-in provider.")
+		return
+	}
+
+	claims, err := services.OIDCExchangeCode(p, c.Query("code"), st.Verifier, st.Nonce)
+	if err != nil {
+		log.Printf("oidc %s: %v", p.Name, err)
+		oidcFail(c, linking, "Could not verify your "+p.DisplayName+" sign-in.")
+		return
+	}
+
+	if linking {
+		user, ok := currentUser(c)
+		if !ok || user.ID.Hex() != st.LinkUser {
+			oidcFail(c, true, "Please sign in again before linking an account.")
+			return
+		}
+		if err := services.LinkIdentity(user.ID, p.Name, claims); err != nil {
+			msg := "Could not link the account."
+			switch {
+			case errors.Is(err, services.ErrOIDCIdentityTaken):
+				msg = "That " + p.DisplayName + " account is already linked to another user."
+			case errors.Is(err, services.ErrOIDCProviderLinked):
+				msg = "Another " + p.DisplayName + " account is already linked. Unlink it first."
+			}
+			oidcFail(c, true, msg)
+			return
+		}
+		c.Redirect(http.StatusFound, "/settings/connections")
+		return
+	}
+
+	u, err := services.LoginWithOIDC(p.Name, claims)
+	if err != nil {
+		msg := "Could not sign you in with " + p.DisplayName + "."
+		switch {
+		case errors.Is(err, services.ErrOIDCEmailUnverified):
+			msg = p.DisplayName + " did not confirm your email address."
+		case errors.Is(err, services.ErrOIDCDisabled):
+			msg = "This account has been disabled."
+		case errors.Is(err, services.ErrOIDCIdentityTaken):
+			msg = "That " + p.DisplayName + " account is already linked to another user."
+		case errors.Is(err, services.ErrOIDCProviderLinked):
+			msg = "Your GoMarket account is linked to another " + p.DisplayName + " account. Sign in with that one."
+		default:
+			log.Printf("oidc %s login: %v", p.Name, err)
+		}
+		oidcFail(c, false, msg)
+		return
+	}
+
+	ttl := time.Now().Add(time.Hour * 2).Unix()
+	tokenStr, err := services.CreateAndSignJWT(&u, ttl)
+	if err != nil {
+		oidcFail(c, false, err.Error())
+		return
+	}
+
+	services.SetCookie(c, tokenStr, ttl)
+	middlewares.RotateCSRFToken(c)
+	c.Redirect(http.StatusFound, "/")
+}
+
+// POST /auth/oidc/:provider/unlink
+func PostOIDCUnlink(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		renderConnections(c, map[string]string{"_form": "There was an error getting user"}, "")
+		return
+	}
+
+	if errs := services.UnlinkIdentity(user, c.Param("provider")); len(errs) > 0 {
+		renderConnections(c, errs, "")
+		return
+	}
+	renderConnections(c, map[string]string{}, "The account was unlinked.")
+}
+
+// GET /settings/connections
+func GetConnections(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/settings/connections" + queryString(c),
+		}))
+		return
+	}
+	renderConnections(c, map[string]string{"_form": c.Query("oidc_error")}, "")
+}
+
+type connectionRow struct {
+	Provider services.OIDCProvider
+	Identity *models.UserIdentity
+}
+
+func renderConnections(c *gin.Context, errs map[string]string, succ string) {
+	rows := []connectionRow{}
+	hasPassword := false
+
+	if user, ok := currentUser(c); ok {
+		hasPassword = user.PasswordHash != ""
+		linked := map[string]models.UserIdentity{}
+		if list, err := services.ListUserIdentities(user.ID); err == nil {
+			for _, i := range list {
+				linked[i.Provider] = i
+			}
+		}
+		for _, p := range services.OIDCProviders() {
+			row := connectionRow{Provider: p}
+			if i, ok := linked[p.Name]; ok {
+				row.Identity = &i
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	if errs["_form"] == "" {
+		delete(errs, "_form")
+	}
+
+	c.HTML(http.StatusOK, "connections", middlewares.WithAuth(c, gin.H{
+		"Rows":        rows,
+		"HasPassword": hasPassword,
+		"errors":      errs,
+		"succ":        succ,
+	}))
+}
+
+// oidcFail sends the browser back to the login page (or the connections
+// page when linking) with the message in ?oidc_error=.
+func oidcFail(c *gin.Context, linking bool, msg string) {
+	target := "/login"
+	if linking {
+		target = "/settings/connections"
+	}
+	c.Redirect(http.StatusFound, target+"?oidc_error="+url.QueryEscape(msg))
+}
+
+func currentUser(c *gin.Context) (models.User, bool) {
+	uVal, ok := c.Get("user")
+	if !ok {
+		return models.User{}, false
+	}
+	user, ok := uVal.(models.User)
+	return user, ok
+}
+
+func queryString(c *gin.Context) string {
+	if q := c.Request.URL.RawQuery; q != "" {
+		return "?" + q
+	}
+	return ""
+}
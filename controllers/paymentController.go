@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+const maxWebhookBytes = 64 << 10
+
+// POST /payments/webhook/:gateway
+// Called by the payment gateway, not a browser: no session, no CSRF token.
+// Anything but a 2xx makes the gateway retry later.
+func PostPaymentWebhook(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBytes))
+	if err != nil {
+		c.String(http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+
+	switch err := services.HandlePaymentWebhook(c.Param("gateway"), c.Request.Header, body); err {
+	case nil:
+		c.String(http.StatusOK, "ok")
+	case services.ErrUnknownGateway:
+		c.String(http.StatusNotFound, "unknown gateway")
+	case services.ErrInvalidWebhook:
+		c.String(http.StatusBadRequest, "invalid webhook")
+	default:
+		c.String(http.StatusInternalServerError, "try again")
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GET /plans
+func GetPlansPage(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/plans",
+		}))
+		return
+	}
+	renderPlans(c, "plans", services.PlanInput{}, map[string]string{}, "")
+}
+
+// POST /plans
+func PostCreatePlan(c *gin.Context) {
+	in := services.PlanInput{
+		Name:     c.PostForm("name"),
+		Mode:     c.PostForm("mode"),
+		Amount:   c.PostForm("amount"),
+		Items:    c.PostForm("items"),
+		Schedule: c.PostForm("schedule"),
+		Start:    c.PostForm("start"),
+		End:      c.PostForm("end"),
+	}
+
+	acct, ok := currentAccount(c)
+	if !ok {
+		renderPlans(c, "plansBox", in, map[string]string{"_form": "There was an error getting the account"}, "")
+		return
+	}
+
+	p, errs := services.CreateInvestmentPlan(acct, in)
+	if len(errs) > 0 {
+		renderPlans(c, "plansBox", in, errs, "")
+		return
+	}
+	renderPlans(c, "plansBox", services.PlanInput{}, map[string]string{},
+		"\""+p.Name+"\" first runs on "+p.NextRunAt.Format("Mon, 2 Jan 2006 15:04")+" UTC.")
+}
+
+// POST /plans/:id/pause
+func PostPausePlan(c *gin.Context) {
+	planAction(c, services.PauseInvestmentPlan, "Plan paused.")
+}
+
+// POST /plans/:id/resume
+func PostResumePlan(c *gin.Context) {
+	planAction(c, services.ResumeInvestmentPlan, "Plan resumed.")
+}
+
+// POST /plans/:id/delete
+func PostDeletePlan(c *gin.Context) {
+	planAction(c, services.DeleteInvestmentPlan, "Plan deleted.")
+}
+
+func planAction(c *gin.Context, fn func(userID, planID primitive.ObjectID) error, succ string) {
+	user, ok := currentUser(c)
+	if !ok {
+		renderPlans(c, "plansBox", services.PlanInput{}, map[string]string{"_form": "There was an error getting user"}, "")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		err = services.ErrPlanNotFound
+	} else {
+		err = fn(user.ID, id)
+	}
+	switch err {
+	case nil:
+		renderPlans(c, "plansBox", services.PlanInput{}, map[string]string{}, succ)
+	case services.ErrPlanNotFound:
+		renderPlans(c, "plansBox", services.PlanInput{}, map[string]string{"_form": "Plan not found."}, "")
+	default:
+		renderPlans(c, "plansBox", services.PlanInput{}, map[string]string{"_form": "Could not update the plan."}, "")
+	}
+}
+
+// GET /plans/:id/runs
+func GetPlanRuns(c *gin.Context) {
+	runs := []models.PlanRun{}
+	if user, ok := currentUser(c); ok {
+		if id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id"))); err == nil {
+			if list, err := services.ListPlanRuns(user.ID, id); err == nil {
+				runs = list
+			}
+		}
+	}
+	c.HTML(http.StatusOK, "planRuns", middlewares.WithAuth(c, gin.H{
+		"Runs": runs,
+	}))
+}
+
+func renderPlans(c *gin.Context, name string, form services.PlanInput, errs map[string]string, succ string) {
+	list := []models.InvestmentPlan{}
+	available := decimal.Zero
+	if acct, ok := currentAccount(c); ok {
+		if plans, err := services.ListInvestmentPlans(acct.ID); err == nil {
+			list = plans
+		}
+		available = acct.Available()
+	}
+
+	if form.Mode == "" {
+		form.Mode = models.PlanModeWeights
+	}
+	if form.Schedule == "" {
+		form.Schedule = models.PlanMonthly
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	if form.Start == "" {
+		form.Start = today
+	}
+
+	c.HTML(http.StatusOK, name, middlewares.WithAuth(c, gin.H{
+		"Plans":     list,
+		"Available": available,
+		"Today":     today,
+		"form":      form,
+		"errors":    errs,
+		"succ":      succ,
+	}))
+}
+
+// GET /api/v1/plans
+func GetAPIPlans(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	list, err := services.ListInvestmentPlans(acct.ID)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load plans.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, list)
+}
+
+// GET /api/v1/plans/:id/runs
+func GetAPIPlanRuns(c *gin.Context) {
+	user, ok := apiUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "Plan not found.", nil)
+		return
+	}
+	runs, err := services.ListPlanRuns(user.ID, id)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not load plan runs.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, runs)
+}
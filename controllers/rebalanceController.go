@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GET /portfolio/rebalance (HTMX partial for the Rebalance tab)
+func GetPortfolioRebalance(c *gin.Context) {
+	renderRebalance(c, nil, map[string]string{}, "")
+}
+
+// POST /portfolio/rebalance/targets
+func PostRebalanceTargets(c *gin.Context) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		renderRebalance(c, nil, map[string]string{"_form": "There was an error getting the account"}, "")
+		return
+	}
+
+	form := gin.H{
+		"Targets":  c.PostForm("targets"),
+		"Band":     c.PostForm("band"),
+		"Schedule": c.PostForm("schedule"),
+	}
+	if _, errs := services.SaveTargetAllocation(acct, c.PostForm("targets"), c.PostForm("band"), c.PostForm("schedule")); len(errs) > 0 {
+		renderRebalance(c, form, errs, "")
+		return
+	}
+	renderRebalance(c, nil, map[string]string{}, "Target allocation saved.")
+}
+
+// POST /portfolio/rebalance/execute
+func PostExecuteRebalance(c *gin.Context) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		renderRebalance(c, nil, map[string]string{"_form": "There was an error getting the account"}, "")
+		return
+	}
+
+	rb, err := services.ExecuteRebalance(acct, models.RebalanceManual)
+	switch err {
+	case nil:
+	case services.ErrRebalanceNotNeeded:
+		renderRebalance(c, nil, map[string]string{"_form": "Nothing to do: the portfolio is within its drift bands."}, "")
+		return
+	case services.ErrRebalanceInProgress:
+		renderRebalance(c, nil, map[string]string{"_form": "A rebalance is already running."}, "")
+		return
+	case services.ErrMarketClosed:
+		renderRebalance(c, nil, map[string]string{"_form": "A market the rebalance trades in is closed. Rebalance while all of them are open."}, "")
+		return
+	case services.ErrRebalanceShorts:
+		renderRebalance(c, nil, map[string]string{"_form": "Buy back your short positions before rebalancing."}, "")
+		return
+	case services.ErrNoTargetAllocation:
+		renderRebalance(c, nil, map[string]string{"_form": "Set a target allocation first."}, "")
+		return
+	default:
+		renderRebalance(c, nil, map[string]string{"_form": "Could not rebalance the portfolio."}, "")
+		return
+	}
+
+	failed := 0
+	for _, o := range rb.Orders {
+		if o.Error != "" {
+			failed++
+		}
+	}
+	c.Header("HX-Trigger", "positionUpdated")
+	if failed > 0 {
+		renderRebalance(c, nil, map[string]string{"_form": "Some orders could not be placed; see the latest rebalance below."}, "")
+		return
+	}
+	renderRebalance(c, nil, map[string]string{}, "Rebalanced: all orders were placed.")
+}
+
+// renderRebalance reloads the account so the preview sees the balance after
+// any orders just placed.
+func renderRebalance(c *gin.Context, form gin.H, errs map[string]string, succ string) {
+	data := gin.H{
+		"Schedules": services.RebalanceSchedules,
+		"errors":    errs,
+		"succ":      succ,
+	}
+
+	acct, ok := currentAccount(c)
+	if ok {
+		if a, found := database.GetAccount(acct.ID); found {
+			acct = a
+		}
+		target, err := services.GetTargetAllocation(acct.ID)
+		if err == nil {
+			if pv, err := services.PreviewRebalance(acct); err == nil {
+				data["Preview"] = pv
+			} else if err == services.ErrRebalanceShorts {
+				data["ShortsHeld"] = true
+			}
+		}
+		if form == nil {
+			form = gin.H{"Band": "5", "Schedule": ""}
+			if err == nil {
+				form["Targets"] = services.FormatAllocationTargets(target)
+				form["Band"] = target.DriftBand
+				form["Schedule"] = target.Schedule
+			}
+		}
+		if list, err := services.ListRebalances(acct.ID, 5); err == nil {
+			data["History"] = list
+		}
+	}
+	data["form"] = form
+
+	c.HTML(http.StatusOK, "portfolioRebalance", middlewares.WithAuth(c, data))
+}
+
+// GET /api/v1/rebalance
+func GetAPIRebalance(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	pv, err := services.PreviewRebalance(acct)
+	if err == services.ErrNoTargetAllocation {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "No target allocation set.", nil)
+		return
+	}
+	if err == services.ErrRebalanceShorts {
+		apiError(c, http.StatusConflict, APIErrConflict, err.Error()+".", nil)
+		return
+	}
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not compute the rebalance.", nil)
+		return
+	}
+	apiData(c, http.StatusOK, pv)
+}
+
+// POST /api/v1/rebalance
+func PostAPIRebalance(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	rb, err := services.ExecuteRebalance(acct, models.RebalanceManual)
+	switch err {
+	case nil:
+		apiData(c, http.StatusCreated, rb)
+	case services.ErrNoTargetAllocation:
+		apiError(c, http.StatusNotFound, APIErrNotFound, "No target allocation set.", nil)
+	case services.ErrRebalanceNotNeeded, services.ErrRebalanceInProgress, services.ErrMarketClosed, services.ErrRebalanceShorts:
+		apiError(c, http.StatusConflict, APIErrConflict, err.Error()+".", nil)
+	default:
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not rebalance the portfolio.", nil)
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GET /settings/statements
+func GetStatements(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/settings/statements",
+		}))
+		return
+	}
+	renderStatements(c, map[string]string{}, "")
+}
+
+// POST /settings/statements (period=YYYY-MM)
+func PostGenerateStatement(c *gin.Context) {
+	user, ok := currentUser(c)
+	acct, found := currentAccount(c)
+	if !ok || !found {
+		renderStatements(c, map[string]string{"_form": "There was an error getting user"}, "")
+		return
+	}
+
+	s, err := services.GenerateStatement(user, acct, c.PostForm("period"))
+	switch err {
+	case nil:
+	case services.ErrInvalidPeriod:
+		renderStatements(c, map[string]string{"period": "Choose this month or an earlier one."}, "")
+		return
+	case services.ErrStatementTooEarly:
+		renderStatements(c, map[string]string{"period": "Your account didn't exist yet in that month."}, "")
+		return
+	default:
+		renderStatements(c, map[string]string{"_form": "Could not generate the statement."}, "")
+		return
+	}
+
+	renderStatements(c, map[string]string{}, "The statement for "+s.PeriodStart.Format("January 2006")+" is ready.")
+}
+
+// GET /statements/:id/pdf
+func GetStatementPDF(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil {
+		c.String(http.StatusNotFound, "Statement not found.")
+		return
+	}
+	s, err := services.GetStatement(user.ID, id)
+	if err != nil {
+		c.String(http.StatusNotFound, "Statement not found.")
+		return
+	}
+	sendStatementPDF(c, s.PDF, services.StatementFilename(s))
+}
+
+func sendStatementPDF(c *gin.Context, body []byte, filename string) {
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/pdf", body)
+}
+
+func renderStatements(c *gin.Context, errs map[string]string, succ string) {
+	list := []models.Statement{}
+	if acct, ok := currentAccount(c); ok {
+		if statements, err := services.ListStatements(acct.ID); err == nil {
+			list = statements
+		}
+	}
+
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	c.HTML(http.StatusOK, "statements", middlewares.WithAuth(c, gin.H{
+		"Statements": list,
+		"MaxPeriod":  now.Format("2006-01"),
+		"Default":    thisMonth.AddDate(0, -1, 0).Format("2006-01"),
+		"errors":     errs,
+		"succ":       succ,
+	}))
+}
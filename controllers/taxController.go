@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GET /settings/tax?year=YYYY
+func GetTaxReport(c *gin.Context) {
+	path := "/settings/tax"
+	if y := c.Query("year"); y != "" {
+		path += "?year=" + y
+	}
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": path,
+		}))
+		return
+	}
+
+	acct, ok := currentAccount(c)
+	if !ok {
+		c.HTML(http.StatusOK, "taxReport", middlewares.WithAuth(c, gin.H{
+			"errors": map[string]string{"_form": "There was an error getting account"},
+		}))
+		return
+	}
+
+	years, err := services.TaxYears(acct.ID)
+	if err != nil {
+		c.HTML(http.StatusOK, "taxReport", middlewares.WithAuth(c, gin.H{
+			"errors": map[string]string{"_form": "Could not load your orders."},
+		}))
+		return
+	}
+
+	year := years[0]
+	if y, err := services.ParseTaxYear(c.Query("year"), time.Now()); err == nil {
+		year = y
+	}
+
+	rep, err := services.BuildTaxReport(acct.ID, year)
+	if err != nil {
+		c.HTML(http.StatusOK, "taxReport", middlewares.WithAuth(c, gin.H{
+			"Years":  years,
+			"Year":   year,
+			"errors": map[string]string{"_form": "Could not build the tax report."},
+		}))
+		return
+	}
+
+	c.HTML(http.StatusOK, "taxReport", middlewares.WithAuth(c, gin.H{
+		"Years":  years,
+		"Year":   year,
+		"Report": rep,
+		"errors": map[string]string{},
+	}))
+}
+
+// GET /tax/:year/:file (summary.csv or lots.csv)
+// A plain download, so errors are plain text.
+func GetTaxReportCSV(c *gin.Context) {
+	acct, ok := currentAccount(c)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	year, err := services.ParseTaxYear(c.Param("year"), time.Now())
+	if err != nil {
+		c.String(http.StatusNotFound, "Unknown tax year.")
+		return
+	}
+	part := strings.TrimSuffix(c.Param("file"), ".csv")
+	if part != services.TaxPartSummary && part != services.TaxPartLots {
+		c.String(http.StatusNotFound, "Unknown report.")
+		return
+	}
+
+	rep, err := services.BuildTaxReport(acct.ID, year)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Could not build the tax report.")
+		return
+	}
+	sendTaxReportCSV(c, rep, part)
+}
+
+// GET /api/v1/tax/:year?part=summary|lots (JSON without part, CSV with it)
+func GetAPITaxReport(c *gin.Context) {
+	acct, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+
+	year, err := services.ParseTaxYear(c.Param("year"), time.Now())
+	if err != nil {
+		apiError(c, http.StatusNotFound, APIErrNotFound, "Unknown tax year.", nil)
+		return
+	}
+
+	part := c.Query("part")
+	if part != "" && part != services.TaxPartSummary && part != services.TaxPartLots {
+		apiFormErrors(c, map[string]string{"part": "Part must be summary or lots."})
+		return
+	}
+
+	rep, err := services.BuildTaxReport(acct.ID, year)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, APIErrInternal, "Could not build the tax report.", nil)
+		return
+	}
+	if part != "" {
+		sendTaxReportCSV(c, rep, part)
+		return
+	}
+	apiData(c, http.StatusOK, rep)
+}
+
+// sendTaxReportCSV writes one part of an already built report; a failed
+// write is only logged.
+func sendTaxReportCSV(c *gin.Context, rep services.TaxReport, part string) {
+	c.Header("Content-Type", services.ExportContentType(services.FormatCSV))
+	c.Header("Content-Disposition", `attachment; filename="`+services.TaxReportFilename(rep.Year, part)+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	if err := services.WriteTaxReportCSV(c.Writer, rep, part); err != nil {
+		log.Println("tax report:", rep.Year, part, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
 	"github.com/GeorgiStoyanov05/GoMarket/models"
 	"github.com/GeorgiStoyanov05/GoMarket/services"
@@ -15,7 +16,8 @@ import (
 type PortfolioGroup struct {
 	Symbol       string
 	Key          string
-	Qty          int64
+	Qty          decimal.Decimal
+	Short        bool
 	AvgCost      float64
 	CurrentPrice float64
 	PnL          float64
@@ -30,27 +32,41 @@ func safeKey(sym string) string {
 func PostMarketBuy(c *gin.Context) {
 	symbol := c.Param("symbol")
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
 		return
 	}
-	user := uVal.(models.User)
-
-	qtyStr := strings.TrimSpace(c.PostForm("qty"))
-	qty, err := strconv.ParseInt(qtyStr, 10, 64)
-	if qtyStr == "" || err != nil {
-		c.String(http.StatusOK, `<div class="text-danger">Enter a valid quantity.</div>`)
-		return
+	acct := aVal.(models.Account)
+
+	var res services.BuyResult
+	var errs map[string]string
+	if amountStr := strings.TrimSpace(c.PostForm("amount")); amountStr != "" {
+		// buy by amount: as many (fractional) shares as the amount pays for
+		amount, err := decimal.Parse(amountStr)
+		if err != nil {
+			c.String(http.StatusOK, `<div class="text-danger">Enter a valid amount.</div>`)
+			return
+		}
+		res, errs = services.MarketBuyAmount(acct.ID, symbol, amount)
+	} else {
+		qty, err := decimal.Parse(strings.TrimSpace(c.PostForm("qty")))
+		if err != nil {
+			c.String(http.StatusOK, `<div class="text-danger">Enter a valid quantity.</div>`)
+			return
+		}
+		res, errs = services.MarketBuy(acct.ID, symbol, qty)
 	}
-
-	res, errs := services.MarketBuy(user.ID, symbol, qty)
 	if len(errs) > 0 {
 		// show first useful error
 		if v, ok := errs["balance"]; ok {
 			c.String(http.StatusOK, `<div class="text-danger">`+v+`</div>`)
 			return
 		}
+		if v, ok := errs["amount"]; ok {
+			c.String(http.StatusOK, `<div class="text-danger">`+v+`</div>`)
+			return
+		}
 		if v, ok := errs["qty"]; ok {
 			c.String(http.StatusOK, `<div class="text-danger">`+v+`</div>`)
 			return
@@ -67,16 +83,17 @@ func PostMarketBuy(c *gin.Context) {
 	c.Header("HX-Trigger", "positionUpdated")
 
 	c.String(http.StatusOK,
-		`<div class="text-success">Bought `+strconv.FormatInt(res.Qty, 10)+` `+res.Symbol+
-			` @ `+format2(res.FillPrice)+
-			` (Cost: `+format2(res.Cost)+
-			`, New balance: `+format2(res.NewBalance)+`)</div>`)
+		`<div class="text-success">Bought `+res.Qty.String()+` `+res.Symbol+
+			` @ `+res.FillPrice.StringFixed(2)+
+			` (Cost: `+res.Cost.StringFixed(2)+
+			`, Fees: `+res.Fees.StringFixed(2)+
+			`, New balance: `+res.NewBalance.StringFixed(2)+`)</div>`)
 }
 
 func GetPositionPanel(c *gin.Context) {
 	symbol := strings.ToUpper(strings.TrimSpace(c.Param("symbol")))
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.HTML(http.StatusOK, "positionPanel", middlewares.WithAuth(c, gin.H{
 			"Symbol":      symbol,
@@ -84,10 +101,10 @@ func GetPositionPanel(c *gin.Context) {
 		}))
 		return
 	}
-	user := uVal.(models.User)
+	acct := aVal.(models.Account)
 
-	pos, err := services.GetUserPosition(user.ID, symbol)
-	if err != nil || pos == nil || pos.Qty <= 0 {
+	pos, err := services.GetAccountPosition(acct.ID, symbol)
+	if err != nil || pos == nil || pos.Qty.IsZero() {
 		c.HTML(http.StatusOK, "positionPanel", middlewares.WithAuth(c, gin.H{
 			"Symbol":      symbol,
 			"HasPosition": false,
@@ -95,18 +112,20 @@ func GetPositionPanel(c *gin.Context) {
 		return
 	}
 
+	avgCost := pos.AvgCost.Float64()
 	price, err := services.FetchCurrentPrice(symbol)
 	if err != nil || price <= 0 {
-		price = pos.AvgCost
+		price = avgCost
 	}
 	price = math.Round(price*100) / 100
 
-	pnl := (price - pos.AvgCost) * float64(pos.Qty)
+	pnl := (price - avgCost) * pos.Qty.Float64()
 	pnl = math.Round(pnl*100) / 100
 
+	// of the cost basis, or of the short proceeds for a short
 	pct := 0.0
-	if pos.AvgCost > 0 {
-		pct = (price - pos.AvgCost) / pos.AvgCost * 100.0
+	if avgCost > 0 {
+		pct = pnl / (avgCost * math.Abs(pos.Qty.Float64())) * 100.0
 		pct = math.Round(pct*100) / 100
 	}
 
@@ -127,21 +146,20 @@ func format2(v float64) string {
 func PostMarketSell(c *gin.Context) {
 	symbol := c.Param("symbol")
 
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
 		return
 	}
-	user := uVal.(models.User)
+	acct := aVal.(models.Account)
 
-	qtyStr := strings.TrimSpace(c.PostForm("qty"))
-	qty, err := strconv.ParseInt(qtyStr, 10, 64)
-	if qtyStr == "" || err != nil {
+	qty, err := decimal.Parse(strings.TrimSpace(c.PostForm("qty")))
+	if err != nil {
 		c.String(http.StatusOK, `<div class="text-danger">Enter a valid quantity.</div>`)
 		return
 	}
 
-	res, errs := services.MarketSell(user.ID, symbol, qty)
+	res, errs := services.MarketSell(acct.ID, symbol, qty)
 	if len(errs) > 0 {
 		if v, ok := errs["qty"]; ok {
 			c.String(http.StatusOK, `<div class="text-danger">`+v+`</div>`)
@@ -158,16 +176,19 @@ func PostMarketSell(c *gin.Context) {
 	c.Header("HX-Trigger", "positionUpdated")
 
 	c.String(http.StatusOK,
-		`<div class="text-success">Sold `+strconv.FormatInt(res.Qty, 10)+` `+res.Symbol+
-			` @ `+format2(res.FillPrice)+
-			` (Proceeds: `+format2(res.Proceeds)+
-			`, New balance: `+format2(res.NewBalance)+`)</div>`)
+		`<div class="text-success">Sold `+res.Qty.String()+` `+res.Symbol+
+			` @ `+res.FillPrice.StringFixed(2)+
+			` (Proceeds: `+res.Proceeds.StringFixed(2)+
+			`, Fees: `+res.Fees.StringFixed(2)+
+			`, New balance: `+res.NewBalance.StringFixed(2)+`)</div>`)
 }
 
 // GET /portfolio (SSR page)
 func GetPortfolioPage(c *gin.Context) {
 	if c.GetHeader("HX-Request") == "true" {
-		c.HTML(200, "portfolio", middlewares.WithAuth(c, gin.H{}))
+		c.HTML(200, "portfolio", middlewares.WithAuth(c, gin.H{
+			"Ranges": services.PortfolioRanges,
+		}))
 		return
 	}
 	c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
@@ -177,32 +198,33 @@ func GetPortfolioPage(c *gin.Context) {
 
 // GET /portfolio/positions (HTMX partial)
 func GetPortfolioPositions(c *gin.Context) {
-	uVal, ok := c.Get("user")
+	aVal, ok := c.Get("account")
 	if !ok {
 		c.HTML(http.StatusOK, "portfolioPositions", middlewares.WithAuth(c, gin.H{"Groups": []PortfolioGroup{}}))
 		return
 	}
-	user := uVal.(models.User)
+	acct := aVal.(models.Account)
 
-	positions, err := services.ListUserPositions(user.ID)
+	positions, err := services.ListAccountPositions(acct.ID)
 	if err != nil {
 		positions = []models.Position{}
 	}
 
 	groups := make([]PortfolioGroup, 0, len(positions))
 	for _, p := range positions {
+		avgCost := p.AvgCost.Float64()
 		price, err := services.FetchCurrentPrice(p.Symbol)
 		if err != nil || price <= 0 {
-			price = p.AvgCost
+			price = avgCost
 		}
 		price = math.Round(price*100) / 100
 
-		pnl := (price - p.AvgCost) * float64(p.Qty)
+		pnl := (price - avgCost) * p.Qty.Float64()
 		pnl = math.Round(pnl*100) / 100
 
 		pct := 0.0
-		if p.AvgCost > 0 {
-			pct = (price - p.AvgCost) / p.AvgCost * 100.0
+		if avgCost > 0 {
+			pct = pnl / (avgCost * math.Abs(p.Qty.Float64())) * 100.0
 			pct = math.Round(pct*100) / 100
 		}
 
@@ -210,7 +232,8 @@ func GetPortfolioPositions(c *gin.Context) {
 			Symbol:       p.Symbol,
 			Key:          safeKey(p.Symbol),
 			Qty:          p.Qty,
-			AvgCost:      p.AvgCost,
+			Short:        p.Qty.IsNegative(),
+			AvgCost:      avgCost,
 			CurrentPrice: price,
 			PnL:          pnl,
 			PnLPct:       pct,
@@ -221,3 +244,136 @@ func GetPortfolioPositions(c *gin.Context) {
 		"Groups": groups,
 	}))
 }
+
+// GET /portfolio/history?range=1M (JSON for the portfolio chart)
+func GetPortfolioHistory(c *gin.Context) {
+	aVal, ok := c.Get("account")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	acct := aVal.(models.Account)
+
+	r := c.DefaultQuery("range", services.Range1M)
+	points, err := services.PortfolioHistory(acct.ID, r)
+	if err == services.ErrUnknownRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown range."})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load history."})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"range": strings.ToUpper(r), "points": points})
+}
+
+// GET /portfolio/performance?range=1M (HTMX partial)
+func GetPortfolioPerformance(c *gin.Context) {
+	aVal, ok := c.Get("account")
+	if !ok {
+		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
+		return
+	}
+	acct := aVal.(models.Account)
+
+	perf, err := services.PortfolioPerformance(acct.ID, c.DefaultQuery("range", services.Range1M))
+	if err == services.ErrUnknownRange {
+		c.String(http.StatusOK, `<div class="text-danger">Unknown range.</div>`)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusOK, `<div class="text-danger">Could not load performance.</div>`)
+		return
+	}
+
+	c.HTML(http.StatusOK, "portfolioPerformance", middlewares.WithAuth(c, gin.H{
+		"Perf": perf,
+	}))
+}
+
+// GET /portfolio/risk?window=1Y (HTMX partial for the Risk tab)
+func GetPortfolioRisk(c *gin.Context) {
+	aVal, ok := c.Get("account")
+	if !ok {
+		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
+		return
+	}
+	acct := aVal.(models.Account)
+
+	rep, err := services.PortfolioRisk(acct.ID, c.DefaultQuery("window", services.Window1Y))
+	if err == services.ErrUnknownWindow {
+		c.String(http.StatusOK, `<div class="text-danger">Unknown window.</div>`)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusOK, `<div class="text-danger">Could not compute risk statistics.</div>`)
+		return
+	}
+
+	c.HTML(http.StatusOK, "portfolioRisk", middlewares.WithAuth(c, gin.H{
+		"Risk":    rep,
+		"Windows": services.RiskWindows,
+	}))
+}
+
+// GET /portfolio/allocation?by=sector (HTMX partial for the Allocation tab)
+func GetPortfolioAllocation(c *gin.Context) {
+	aVal, ok := c.Get("account")
+	if !ok {
+		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
+		return
+	}
+	acct := aVal.(models.Account)
+
+	dim, err := services.ValidAllocationDimension(c.DefaultQuery("by", services.BySector))
+	if err != nil {
+		c.String(http.StatusOK, `<div class="text-danger">Unknown breakdown.</div>`)
+		return
+	}
+
+	alloc, err := services.PortfolioAllocation(acct)
+	if err != nil {
+		c.String(http.StatusOK, `<div class="text-danger">Could not load the allocation.</div>`)
+		return
+	}
+
+	c.HTML(http.StatusOK, "portfolioAllocation", middlewares.WithAuth(c, gin.H{
+		"Alloc":      alloc,
+		"Slices":     alloc.Breakdown[dim],
+		"Dimension":  dim,
+		"Dimensions": allocationTabs,
+	}))
+}
+
+// GetPortfolioAccount is the cash, buying power and margin summary above
+// the positions.
+func GetPortfolioAccount(c *gin.Context) {
+	aVal, ok := c.Get("account")
+	if !ok {
+		c.String(http.StatusUnauthorized, `<div class="text-danger">Unauthorized</div>`)
+		return
+	}
+	acct := aVal.(models.Account)
+
+	account, err := services.GetMarginAccount(acct)
+	if err != nil {
+		c.String(http.StatusOK, `<div class="text-danger">Could not load the account.</div>`)
+		return
+	}
+
+	c.HTML(http.StatusOK, "portfolioAccount", middlewares.WithAuth(c, gin.H{
+		"Account": account,
+	}))
+}
+
+type allocationTab struct {
+	Key   string
+	Label string
+}
+
+var allocationTabs = []allocationTab{
+	{services.BySector, "Sector"},
+	{services.ByIndustry, "Industry"},
+	{services.ByCountry, "Country"},
+	{services.ByAssetType, "Asset type"},
+}
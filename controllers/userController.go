@@ -2,13 +2,15 @@ package controllers
 
 import (
 	"net/http"
-	"strconv"
 	"strings"
 
+	"github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
 	"github.com/GeorgiStoyanov05/GoMarket/models"
 	"github.com/GeorgiStoyanov05/GoMarket/services"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func GetUserSettings(c *gin.Context) {
@@ -135,11 +137,7 @@ func PostChangePassword(c *gin.Context) {
 
 func GetFunds(c *gin.Context) {
 	if c.GetHeader("HX-Request") == "true" {
-		c.HTML(200, "depositFunds", middlewares.WithAuth(c, gin.H{
-			"errors": map[string]string{},
-			"amount": 0,
-			"succ":   "",
-		}))
+		renderFunds(c, map[string]string{}, models.TransferDeposit, decimal.Zero, "")
 		return
 	}
 	c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
@@ -149,49 +147,165 @@ func GetFunds(c *gin.Context) {
 
 }
 
+// POST /funds (type=deposit|withdrawal, amount)
 func PostFunds(c *gin.Context) {
 	errs := map[string]string{}
+	typ := strings.TrimSpace(c.DefaultPostForm("type", models.TransferDeposit))
 	amountStr := strings.TrimSpace(c.PostForm("amount"))
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	amount, err := decimal.Parse(amountStr)
 	if err != nil {
-		errs["_form"] = "There was an error with the amount!"
+		errs["amount"] = "There was an error with the amount!"
+	}
+	acct, ok := currentAccount(c)
+	if !ok {
+		errs["_form"] = "There was an error getting the account"
+	}
+
+	if len(errs) > 0 {
+		renderFunds(c, errs, typ, amount, "")
+		return
+	}
+
+	t, newErrs := services.RequestTransfer(acct, typ, amount)
+	if len(newErrs) > 0 {
+		renderFunds(c, newErrs, typ, amount, "")
+		return
+	}
+
+	if a, ok := database.GetAccount(acct.ID); ok {
+		c.Set("account", a)
+	}
+
+	// paid through a gateway: continue on its checkout page
+	if t.CheckoutURL != "" {
+		c.Header("HX-Redirect", t.CheckoutURL)
+		renderFunds(c, map[string]string{}, typ, decimal.Zero, "Continue to the payment page to finish your deposit.")
+		return
+	}
+
+	succ := "The deposit was successful!"
+	switch {
+	case t.Status == models.TransferPending:
+		succ = "Your " + t.Type + " of " + t.Amount.StringFixed(2) + " is waiting for approval."
+	case t.Type == models.TransferWithdrawal:
+		succ = "The withdrawal was successful!"
 	}
+	renderFunds(c, map[string]string{}, typ, decimal.Zero, succ)
+}
+
+func renderFunds(c *gin.Context, errs map[string]string, typ string, amount decimal.Decimal, succ string) {
+	available := decimal.Zero
+	if acct, ok := currentAccount(c); ok {
+		available = acct.Available()
+	}
+	c.HTML(http.StatusOK, "depositFunds", middlewares.WithAuth(c, gin.H{
+		"errors":    errs,
+		"amount":    amount,
+		"type":      typ,
+		"available": available,
+		"currency":  services.AccountCurrency,
+		"limits":    services.CurrentFundLimits(),
+		"succ":      succ,
+	}))
+}
+
+// GET /settings/transfers
+func GetTransfers(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/settings/transfers",
+		}))
+		return
+	}
+
+	acct, ok := currentAccount(c)
+	if !ok {
+		c.HTML(http.StatusOK, "transfers", middlewares.WithAuth(c, gin.H{
+			"errors": map[string]string{"_form": "There was an error getting the account"},
+		}))
+		return
+	}
+
+	transfers, err := services.ListFundTransfers(acct.ID, 100)
+	errs := map[string]string{}
+	if err != nil {
+		errs["_form"] = "Could not load your transfers."
+	}
+	c.HTML(http.StatusOK, "transfers", middlewares.WithAuth(c, gin.H{
+		"Transfers": transfers,
+		"Available": acct.Available(),
+		"Reserved":  acct.Reserved,
+		"errors":    errs,
+	}))
+}
+
+func GetAPIKeys(c *gin.Context) {
+	if c.GetHeader("HX-Request") != "true" {
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
+			"InitialPath": "/settings/api-keys",
+		}))
+		return
+	}
+	renderAPIKeys(c, map[string]string{}, "", "")
+}
+
+func PostCreateAPIKey(c *gin.Context) {
 	uVal, ok := c.Get("user")
 	if !ok {
-		errs["_form"] = "Error getting user!"
+		renderAPIKeys(c, map[string]string{"_form": "There was an error getting user"}, "", "")
+		return
 	}
 	user, ok := uVal.(models.User)
 	if !ok {
-		errs["_form"] = "There was an error getting user"
-	}
-	if amount <= 0 {
-		errs["amount"] = "Amount must be bigger than zero!"
+		renderAPIKeys(c, map[string]string{"_form": "There was an error getting user"}, "", "")
+		return
 	}
 
+	_, token, errs := services.CreateAPIKey(user.ID, c.PostForm("name"), c.PostFormArray("scopes"))
 	if len(errs) > 0 {
-		c.HTML(http.StatusOK, "depositFunds", middlewares.WithAuth(c, gin.H{
-			"errors": errs,
-			"amount": amount,
-			"succ":   "",
-		}))
+		renderAPIKeys(c, errs, "", "")
 		return
 	}
 
-	u, newErrs := services.ChangeUserBalance(user.ID, amount)
+	renderAPIKeys(c, map[string]string{}, token, "Your API key was created. Copy it now, it won't be shown again.")
+}
 
-	if len(newErrs) > 0 {
-		c.HTML(http.StatusOK, "depositFunds", middlewares.WithAuth(c, gin.H{
-			"errors": newErrs,
-			"succ":   "",
-			"amount": amount,
-		}))
+func PostRevokeAPIKey(c *gin.Context) {
+	uVal, ok := c.Get("user")
+	if !ok {
+		renderAPIKeys(c, map[string]string{"_form": "There was an error getting user"}, "", "")
+		return
+	}
+	user, ok := uVal.(models.User)
+	if !ok {
+		renderAPIKeys(c, map[string]string{"_form": "There was an error getting user"}, "", "")
 		return
 	}
 
-	c.Set("user", u)
-	c.HTML(http.StatusOK, "depositFunds", middlewares.WithAuth(c, gin.H{
-		"errors": newErrs,
-		"succ":   "The deposit was successful!",
-		"amount": 0,
+	oid, err := primitive.ObjectIDFromHex(strings.TrimSpace(c.Param("id")))
+	if err != nil || services.RevokeAPIKey(user.ID, oid) != nil {
+		renderAPIKeys(c, map[string]string{"_form": "Could not revoke the API key."}, "", "")
+		return
+	}
+
+	renderAPIKeys(c, map[string]string{}, "", "The API key was revoked.")
+}
+
+func renderAPIKeys(c *gin.Context, errs map[string]string, token, succ string) {
+	keys := []models.APIKey{}
+	if uVal, ok := c.Get("user"); ok {
+		if user, ok := uVal.(models.User); ok {
+			if list, err := services.ListAPIKeys(user.ID); err == nil {
+				keys = list
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "apiKeys", middlewares.WithAuth(c, gin.H{
+		"Keys":   keys,
+		"Scopes": models.APIKeyScopes,
+		"token":  token,
+		"errors": errs,
+		"succ":   succ,
 	}))
 }
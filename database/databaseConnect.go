@@ -56,3 +56,13 @@ func GetUser(id primitive.ObjectID) (models.User, bool) {
 	}
 	return u, true
 }
+
+func GetAccount(id primitive.ObjectID) (models.Account, bool) {
+	var a models.Account
+	coll := Client.Database("gomarket").Collection("accounts")
+	err := coll.FindOne(nil, bson.M{"_id": id}).Decode(&a)
+	if err != nil {
+		return models.Account{}, false
+	}
+	return a, true
+}
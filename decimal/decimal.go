@@ -0,0 +1,502 @@
+// Package decimal is an exact fixed-point number for share quantities and
+// money. Values carry Scale decimal places in an int64, which covers
+// ±92 billion. Arithmetic that would leave that range never loses
+// precision silently: the Checked methods and FromFloat return ErrRange
+// (or ErrDivisionByZero), while Add, Sub, Mul, Div, New and NewFromFloat
+// panic and are only for values already known to be in range. Anything
+// derived from user input goes through Parse and the checked forms.
+//
+// In MongoDB values are stored as Decimal128 so the database does exact
+// arithmetic too ($inc, $add, $multiply). Reading also accepts the int32,
+// int64 and double values written before the switch.
+package decimal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Scale is the number of decimal places every value carries.
+const Scale = 8
+
+const one = 100_000_000 // 10^Scale
+
+var pow10 = [...]int64{1, 10, 100, 1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000}
+
+var (
+	ErrSyntax         = errors.New("decimal: invalid syntax")
+	ErrRange          = errors.New("decimal: value out of range")
+	ErrDivisionByZero = errors.New("decimal: division by zero")
+)
+
+// Decimal is comparable with == and its zero value is 0.
+type Decimal struct {
+	v int64 // units of 10^-Scale
+}
+
+var Zero = Decimal{}
+
+func New(i int64) Decimal {
+	return must(fromInt(i))
+}
+
+func fromInt(i int64) (Decimal, error) {
+	if i > math.MaxInt64/one || i < math.MinInt64/one {
+		return Zero, ErrRange
+	}
+	return Decimal{i * one}, nil
+}
+
+// NewFromFloat converts a float (e.g. a quote from a market data feed) by
+// its shortest decimal representation, rounded to Scale places.
+func NewFromFloat(f float64) Decimal {
+	return must(FromFloat(f))
+}
+
+// FromFloat is NewFromFloat returning ErrRange for NaN, infinities and
+// floats too large to hold.
+func FromFloat(f float64) (Decimal, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Zero, ErrRange
+	}
+	return parse(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+func must(d Decimal, err error) Decimal {
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Parse reads plain decimal notation ("12", "-0.5", "3.25"). Digits
+// beyond Scale places are rounded half away from zero.
+func Parse(s string) (Decimal, error) {
+	return parse(strings.TrimSpace(s))
+}
+
+// MustParse is Parse for constants.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func parse(s string) (Decimal, error) {
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg, s = true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return Zero, ErrSyntax
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return Zero, ErrSyntax
+			}
+		}
+	}
+
+	roundUp := false
+	if len(frac) > Scale {
+		roundUp = frac[Scale] >= '5'
+		frac = frac[:Scale]
+	}
+	frac += strings.Repeat("0", Scale-len(frac))
+	whole = strings.TrimLeft(whole, "0")
+	if len(whole) > 11 {
+		return Zero, ErrRange
+	}
+
+	u, err := strconv.ParseUint(whole+frac, 10, 64)
+	if err != nil || u > math.MaxInt64-1 {
+		return Zero, ErrRange
+	}
+	if roundUp {
+		u++
+	}
+	if neg {
+		return Decimal{-int64(u)}, nil
+	}
+	return Decimal{int64(u)}, nil
+}
+
+func (d Decimal) Add(e Decimal) Decimal { return must(d.CheckedAdd(e)) }
+func (d Decimal) Sub(e Decimal) Decimal { return must(d.CheckedSub(e)) }
+
+// Mul rounds the exact product half away from zero to Scale places.
+func (d Decimal) Mul(e Decimal) Decimal { return must(d.CheckedMul(e)) }
+
+// Div rounds the quotient half away from zero to Scale places.
+func (d Decimal) Div(e Decimal) Decimal { return must(d.CheckedDiv(e)) }
+
+func (d Decimal) CheckedAdd(e Decimal) (Decimal, error) {
+	s := d.v + e.v
+	if (s > d.v) != (e.v > 0) {
+		return Zero, ErrRange
+	}
+	return Decimal{s}, nil
+}
+
+func (d Decimal) CheckedSub(e Decimal) (Decimal, error) {
+	if e.v == math.MinInt64 {
+		return Zero, ErrRange
+	}
+	return d.CheckedAdd(Decimal{-e.v})
+}
+
+func (d Decimal) CheckedMul(e Decimal) (Decimal, error) {
+	hi, lo := bits.Mul64(abs(d.v), abs(e.v))
+	q, ok := divRound(hi, lo, one)
+	if !ok {
+		return Zero, ErrRange
+	}
+	return signed(q, (d.v < 0) != (e.v < 0))
+}
+
+func (d Decimal) CheckedDiv(e Decimal) (Decimal, error) {
+	if e.v == 0 {
+		return Zero, ErrDivisionByZero
+	}
+	hi, lo := bits.Mul64(abs(d.v), one)
+	q, ok := divRound(hi, lo, abs(e.v))
+	if !ok {
+		return Zero, ErrRange
+	}
+	return signed(q, (d.v < 0) != (e.v < 0))
+}
+
+// divRound is (hi, lo) / y rounded half away from zero; !ok if the
+// quotient doesn't fit in 64 bits.
+func divRound(hi, lo, y uint64) (uint64, bool) {
+	if hi >= y {
+		return 0, false
+	}
+	q, r := bits.Div64(hi, lo, y)
+	if r >= y-r { // 2r >= y without overflowing
+		if q == math.MaxUint64 {
+			return 0, false
+		}
+		q++
+	}
+	return q, true
+}
+
+func abs(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
+
+func signed(u uint64, neg bool) (Decimal, error) {
+	if u > math.MaxInt64 {
+		return Zero, ErrRange
+	}
+	if neg {
+		return Decimal{-int64(u)}, nil
+	}
+	return Decimal{int64(u)}, nil
+}
+
+func (d Decimal) Neg() Decimal { return Decimal{-d.v} }
+
+func (d Decimal) Abs() Decimal {
+	if d.v < 0 {
+		return Decimal{-d.v}
+	}
+	return d
+}
+
+// Round rounds half away from zero to places (0..Scale) decimals.
+func (d Decimal) Round(places int) Decimal {
+	f := pow10[Scale-clampPlaces(places)]
+	q, r := d.v/f, d.v%f
+	if r >= f-r && r > 0 {
+		q++
+	} else if r < 0 && -r >= f+r {
+		q--
+	}
+	return scaled(q, f)
+}
+
+// RoundBank rounds half to even to places decimals.
+func (d Decimal) RoundBank(places int) Decimal {
+	f := pow10[Scale-clampPlaces(places)]
+	q, r := d.v/f, d.v%f
+	if r < 0 {
+		r = -r
+	}
+	if 2*r > f || (2*r == f && q%2 != 0) {
+		if d.v < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return scaled(q, f)
+}
+
+// scaled is q units of f; rounding up the largest values can leave the
+// range.
+func scaled(q, f int64) Decimal {
+	if q > math.MaxInt64/f || q < math.MinInt64/f {
+		panic(ErrRange)
+	}
+	return Decimal{q * f}
+}
+
+// Truncate drops digits beyond places decimals (rounds toward zero).
+func (d Decimal) Truncate(places int) Decimal {
+	f := pow10[Scale-clampPlaces(places)]
+	return Decimal{d.v / f * f}
+}
+
+func clampPlaces(p int) int {
+	if p < 0 {
+		return 0
+	}
+	if p > Scale {
+		return Scale
+	}
+	return p
+}
+
+// Places is the number of decimals needed to write d exactly.
+func (d Decimal) Places() int {
+	p := Scale
+	for v := d.v; p > 0 && v%10 == 0; v /= 10 {
+		p--
+	}
+	return p
+}
+
+func (d Decimal) Cmp(e Decimal) int {
+	switch {
+	case d.v < e.v:
+		return -1
+	case d.v > e.v:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) Sign() int                  { return d.Cmp(Zero) }
+func (d Decimal) IsZero() bool               { return d.v == 0 }
+func (d Decimal) IsPositive() bool           { return d.v > 0 }
+func (d Decimal) IsNegative() bool           { return d.v < 0 }
+func (d Decimal) LessThan(e Decimal) bool    { return d.v < e.v }
+func (d Decimal) GreaterThan(e Decimal) bool { return d.v > e.v }
+
+func Min(a, b Decimal) Decimal {
+	if a.v < b.v {
+		return a
+	}
+	return b
+}
+
+func Max(a, b Decimal) Decimal {
+	if a.v > b.v {
+		return a
+	}
+	return b
+}
+
+// Float64 is for display and statistics only, never for arithmetic that
+// ends up in the database.
+func (d Decimal) Float64() float64 {
+	return float64(d.v) / one
+}
+
+// IntPart drops the fractional part.
+func (d Decimal) IntPart() int64 { return d.v / one }
+
+// String writes d with as few decimals as it needs ("1.5", "100").
+func (d Decimal) String() string {
+	return d.StringFixed(d.Places())
+}
+
+// StringFixed writes d rounded to exactly places decimals.
+func (d Decimal) StringFixed(places int) string {
+	places = clampPlaces(places)
+	v := d.Round(places).v
+	neg := v < 0
+	u := abs(v)
+
+	digits := strconv.FormatUint(u, 10)
+	if len(digits) <= Scale {
+		digits = strings.Repeat("0", Scale-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-Scale], digits[len(digits)-Scale:][:places]
+
+	s := whole
+	if places > 0 {
+		s += "." + frac
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// Format supports %v and %s (String), %f with an optional precision, and
+// %d (the integer part), so templates can keep using printf "%.2f".
+func (d Decimal) Format(f fmt.State, verb rune) {
+	var s string
+	switch verb {
+	case 'f', 'F':
+		p, ok := f.Precision()
+		if !ok {
+			p = Scale
+		}
+		s = d.StringFixed(p)
+		if f.Flag('+') && d.v >= 0 {
+			s = "+" + s
+		}
+	case 'd':
+		s = strconv.FormatInt(d.IntPart(), 10)
+	case 'v', 's':
+		s = d.String()
+	default:
+		fmt.Fprintf(f, "%%!%c(decimal.Decimal=%s)", verb, d.String())
+		return
+	}
+	if w, ok := f.Width(); ok && len(s) < w {
+		pad := strings.Repeat(" ", w-len(s))
+		if f.Flag('-') {
+			s += pad
+		} else {
+			s = pad + s
+		}
+	}
+	fmt.Fprint(f, s)
+}
+
+// MarshalJSON writes a plain JSON number with exactly the digits of d.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a numeric string.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return ErrSyntax
+		}
+		v, err := FromFloat(f)
+		if err != nil {
+			return err
+		}
+		*d = v
+		return nil
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Decimal128 is d in MongoDB's decimal type, without trailing zeros.
+func (d Decimal) Decimal128() primitive.Decimal128 {
+	v, exp := d.v, -Scale
+	for v != 0 && v%10 == 0 && exp < 0 {
+		v /= 10
+		exp++
+	}
+	if v == 0 {
+		exp = 0
+	}
+	out, _ := primitive.ParseDecimal128FromBigInt(big.NewInt(v), exp)
+	return out
+}
+
+// FromDecimal128 converts exactly, rounding beyond Scale places.
+func FromDecimal128(x primitive.Decimal128) (Decimal, error) {
+	bi, exp, err := x.BigInt()
+	if err != nil {
+		return Zero, err
+	}
+	shift := exp + Scale
+	if shift >= 0 {
+		bi.Mul(bi, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(shift)), nil))
+	} else {
+		div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-shift)), nil)
+		q, r := new(big.Int).QuoRem(bi, div, new(big.Int))
+		if r.Abs(r).Lsh(r, 1).Cmp(div) >= 0 {
+			if bi.Sign() < 0 {
+				q.Sub(q, big.NewInt(1))
+			} else {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+		bi = q
+	}
+	if !bi.IsInt64() {
+		return Zero, ErrRange
+	}
+	return Decimal{bi.Int64()}, nil
+}
+
+func (d Decimal) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	x := d.Decimal128()
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, x), nil
+}
+
+func (d *Decimal) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bsontype.Decimal128:
+		x, ok := v.Decimal128OK()
+		if !ok {
+			return ErrSyntax
+		}
+		out, err := FromDecimal128(x)
+		if err != nil {
+			return err
+		}
+		*d = out
+	case bsontype.Int32:
+		*d = New(int64(v.Int32()))
+	case bsontype.Int64:
+		out, err := fromInt(v.Int64())
+		if err != nil {
+			return err
+		}
+		*d = out
+	case bsontype.Double:
+		out, err := FromFloat(v.Double())
+		if err != nil {
+			return err
+		}
+		*d = out
+	case bsontype.Null, bsontype.Undefined:
+		*d = Zero
+	default:
+		return fmt.Errorf("decimal: cannot decode BSON %s", t)
+	}
+	return nil
+}
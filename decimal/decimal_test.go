@@ -0,0 +1,201 @@
+package decimal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"0", "0", nil},
+		{"12", "12", nil},
+		{" 12 ", "12", nil},
+		{"-0.5", "-0.5", nil},
+		{"+3.25", "3.25", nil},
+		{".5", "0.5", nil},
+		{"5.", "5", nil},
+		{"007.10", "7.1", nil},
+		{"0.123456789", "0.12345679", nil},   // 9th place rounds up
+		{"0.123456784", "0.12345678", nil},   // and down
+		{"-0.123456785", "-0.12345679", nil}, // half away from zero
+		{"92233720368", "92233720368", nil},
+		{"92233720368.54775806", "92233720368.54775806", nil},
+		{"92233720369", "", ErrRange},
+		{"123456789012", "", ErrRange},
+		{"", "", ErrSyntax},
+		{".", "", ErrSyntax},
+		{"-", "", ErrSyntax},
+		{"1e3", "", ErrSyntax},
+		{"1,000", "", ErrSyntax},
+		{"1.2.3", "", ErrSyntax},
+		{"abc", "", ErrSyntax},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && got.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		half   string // Round: half away from zero
+		bank   string // RoundBank: half to even
+		trunc  string
+	}{
+		{"1.005", 2, "1.01", "1", "1"},
+		{"1.015", 2, "1.02", "1.02", "1.01"},
+		{"1.025", 2, "1.03", "1.02", "1.02"},
+		{"-1.005", 2, "-1.01", "-1", "-1"},
+		{"-1.015", 2, "-1.02", "-1.02", "-1.01"},
+		{"2.5", 0, "3", "2", "2"},
+		{"3.5", 0, "4", "4", "3"},
+		{"-2.5", 0, "-3", "-2", "-2"},
+		{"1.234", 2, "1.23", "1.23", "1.23"},
+		{"1.236", 2, "1.24", "1.24", "1.23"},
+		{"1.23456789", 8, "1.23456789", "1.23456789", "1.23456789"},
+		{"1.5", -1, "2", "2", "1"}, // places are clamped to 0..Scale
+		{"1.5", 20, "1.5", "1.5", "1.5"},
+	}
+	for _, tt := range tests {
+		d := MustParse(tt.in)
+		if got := d.Round(tt.places).String(); got != tt.half {
+			t.Errorf("%s.Round(%d) = %s, want %s", tt.in, tt.places, got, tt.half)
+		}
+		if got := d.RoundBank(tt.places).String(); got != tt.bank {
+			t.Errorf("%s.RoundBank(%d) = %s, want %s", tt.in, tt.places, got, tt.bank)
+		}
+		if got := d.Truncate(tt.places).String(); got != tt.trunc {
+			t.Errorf("%s.Truncate(%d) = %s, want %s", tt.in, tt.places, got, tt.trunc)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		mul, div string
+	}{
+		{"2", "3", "6", "0.66666667"},
+		{"-2", "3", "-6", "-0.66666667"},
+		{"-2", "-3", "6", "0.66666667"},
+		{"0.1", "0.2", "0.02", "0.5"},
+		{"10.25", "4", "41", "2.5625"},
+		{"0.00000001", "0.5", "0.00000001", "0.00000002"}, // product rounds half away from zero
+		{"0.00000001", "0.4", "0", "0.00000003"},
+		{"1", "3", "3", "0.33333333"},
+		{"2", "3", "6", "0.66666667"},
+		{"1000000000", "90", "90000000000", "11111111.11111111"},
+		{"0", "7", "0", "0"},
+	}
+	for _, tt := range tests {
+		a, b := MustParse(tt.a), MustParse(tt.b)
+		if got := a.Mul(b).String(); got != tt.mul {
+			t.Errorf("%s * %s = %s, want %s", tt.a, tt.b, got, tt.mul)
+		}
+		if got := a.Div(b).String(); got != tt.div {
+			t.Errorf("%s / %s = %s, want %s", tt.a, tt.b, got, tt.div)
+		}
+	}
+}
+
+func TestRangeLimits(t *testing.T) {
+	max := MustParse("92233720368.54775806") // the largest Parse accepts
+	big := New(1_000_000)
+
+	if _, err := max.CheckedAdd(MustParse("0.00000002")); err != ErrRange {
+		t.Errorf("max + 2e-8: err = %v, want ErrRange", err)
+	}
+	if _, err := max.Neg().CheckedSub(MustParse("0.00000003")); err != ErrRange {
+		t.Errorf("-max - 3e-8: err = %v, want ErrRange", err)
+	}
+	if _, err := big.CheckedMul(big); err != ErrRange {
+		t.Errorf("1e6 * 1e6: err = %v, want ErrRange", err)
+	}
+	if _, err := big.CheckedDiv(MustParse("0.00001")); err != ErrRange {
+		t.Errorf("1e6 / 1e-5: err = %v, want ErrRange", err)
+	}
+	if _, err := big.CheckedDiv(Zero); err != ErrDivisionByZero {
+		t.Errorf("1e6 / 0: err = %v, want ErrDivisionByZero", err)
+	}
+	if got, err := big.CheckedMul(New(90_000)); err != nil || got.String() != "90000000000" {
+		t.Errorf("1e6 * 9e4 = %s, %v; want 90000000000", got, err)
+	}
+
+	for _, f := range []float64{1e11, -1e11, 1e300} {
+		if _, err := FromFloat(f); err != ErrRange {
+			t.Errorf("FromFloat(%g): err = %v, want ErrRange", f, err)
+		}
+	}
+	if got, err := FromFloat(0.1 + 0.2); err != nil || got.String() != "0.3" {
+		t.Errorf("FromFloat(0.1+0.2) = %s, %v; want 0.3", got, err)
+	}
+
+	mustPanic(t, "Mul overflow", func() { big.Mul(big) })
+	mustPanic(t, "Div by zero", func() { big.Div(Zero) })
+	mustPanic(t, "New overflow", func() { New(100_000_000_000) })
+	mustPanic(t, "Round past max", func() { max.Round(0) })
+}
+
+func TestDecode(t *testing.T) {
+	var d Decimal
+	if err := json.Unmarshal([]byte(`"12.50"`), &d); err != nil || d.String() != "12.5" {
+		t.Errorf("json string: %s, %v", d, err)
+	}
+	if err := json.Unmarshal([]byte(`1.5e3`), &d); err != nil || d.String() != "1500" {
+		t.Errorf("json exponent: %s, %v", d, err)
+	}
+	if err := json.Unmarshal([]byte(`1e300`), &d); err != ErrRange {
+		t.Errorf("json 1e300: err = %v, want ErrRange", err)
+	}
+
+	x := MustParse("-1234.5").Decimal128()
+	back, err := FromDecimal128(x)
+	if err != nil || back.String() != "-1234.5" {
+		t.Errorf("Decimal128 round trip = %s, %v", back, err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	d := MustParse("1234.5")
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "1234.5"},
+		{"%.2f", "1234.50"},
+		{"%.0f", "1235"},
+		{"%+.1f", "+1234.5"},
+		{"%d", "1234"},
+		{"%8.1f", "  1234.5"},
+		{"%-8.1f|", "1234.5  |"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf(tt.format, d); got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func mustPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: no panic", name)
+		}
+	}()
+	f()
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"html/template"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 	"context"
 	"github.com/GeorgiStoyanov05/GoMarket/services"
@@ -21,6 +23,8 @@ func main() {
 	}
 
 	router := gin.New()
+	// a bug in one handler answers 500 instead of dropping the connection
+	router.Use(gin.Recovery())
 	router.Static("/static", "./static")
 	tmpl := template.Must(template.ParseGlob("views/*.html"))
 	template.Must(tmpl.ParseGlob("views/components/*.html"))
@@ -29,28 +33,73 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token", "X-Account-ID"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 	router.Use(middlewares.CheckIfLoggedIn())
+	// the mock issuer's token endpoint and payment webhooks are called
+	// server-to-server; the mock checkout page is a standalone form
+	router.Use(middlewares.CSRFProtection(services.MockOIDCPath+"/", services.PaymentWebhookPath, services.MockPaymentsPath+"/"))
+	if services.OIDCMockEnabled() {
+		issuer := services.NewMockOIDCIssuer(services.MockOIDCIssuerURL())
+		router.Any(services.MockOIDCPath+"/*path", gin.WrapH(http.StripPrefix(services.MockOIDCPath, issuer)))
+	}
+	if services.PaymentMockEnabled() {
+		gateway := services.NewMockPaymentGateway(services.PaymentBaseURL())
+		services.RegisterPaymentGateway(gateway)
+		router.Any(services.MockPaymentsPath+"/*path", gin.WrapH(http.StripPrefix(services.MockPaymentsPath, gateway)))
+	}
 	routes.AuthRoutes(router)
 	routes.UserRoutes(router)
 	routes.HomeRoutes(router)
 	routes.StocksRoutes(router)
 	routes.AlertsRoutes(router)
 	routes.TradingRoutes(router)
+	routes.AdminRoutes(router)
+	routes.APIRoutes(router)
 	router.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.JSON(404, gin.H{"error": gin.H{"code": "not_found", "message": "Not found."}})
+			return
+		}
 		if c.GetHeader("HX-Request") == "true" {
 			c.HTML(200, "404.html", gin.H{})
 			return
 		}
-		c.HTML(200, "index.html", gin.H{
+		c.HTML(200, "index.html", middlewares.WithAuth(c, gin.H{
 			"InitialPath": "/",
-		})
+		}))
 	})
 	database.Init()
-	services.StartPriceAlertMonitor(context.Background())
+	services.BootstrapAdmin()
+	services.LoadMarketCalendars("calendars")
+	services.MigrateDecimalQuantities()
+	services.MigrateDecimalMoney()
+	services.MigrateAccounts()
+	services.EnsureAccountIndexes()
 	services.EnsureTradingIndexes()
+	services.StartPriceAlertMonitor(context.Background())
+	services.EnsureAPIKeyIndexes()
+	services.EnsureIdentityIndexes()
+	services.EnsureSnapshotIndexes()
+	services.EnsureCashIndexes()
+	services.EnsureFundIndexes()
+	services.BackfillFundTransfers()
+	services.EnsurePaymentIndexes()
+	services.BackfillCashTransactions()
+	services.EnsurePriceHistoryIndexes()
+	services.EnsureSymbolIndexes()
+	services.EnsureImportIndexes()
+	services.EnsureStatementIndexes()
+	services.EnsureNotificationIndexes()
+	services.EnsurePlanIndexes()
+	services.EnsureRebalanceIndexes()
+	services.EnsureFeeIndexes()
+	services.StartPortfolioSnapshotter(context.Background())
+	services.StartStatementScheduler(context.Background())
+	services.StartInvestmentPlanScheduler(context.Background())
+	services.StartRebalanceScheduler(context.Background())
+	services.StartMarginScheduler(context.Background())
 	router.Run(":" + port)
 }
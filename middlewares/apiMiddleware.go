@@ -0,0 +1,60 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIAuth is the JSON counterpart of AuthMiddleware.
+func APIAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, exists := c.Get("IsLoggedIn")
+		if !exists || v.(bool) == false {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": gin.H{"code": "unauthorized", "message": "Missing or invalid credentials."},
+			})
+			return
+		}
+
+		// X-Account-ID picks one of the user's other accounts for this request
+		if v := strings.TrimSpace(c.GetHeader("X-Account-ID")); v != "" {
+			uVal, _ := c.Get("user")
+			user, _ := uVal.(models.User)
+			id, _ := primitive.ObjectIDFromHex(v)
+			acct, err := services.GetUserAccount(user.ID, id)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"error": gin.H{"code": "not_found", "message": "No account with the ID in X-Account-ID."},
+				})
+				return
+			}
+			c.Set("account", acct)
+		}
+		c.Next()
+	}
+}
+
+// RequireScope only restricts API keys; a browser session can do everything
+// its user can.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kVal, ok := c.Get("apiKey")
+		if !ok || scope == "" {
+			c.Next()
+			return
+		}
+		key, ok := kVal.(models.APIKey)
+		if !ok || !key.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": gin.H{"code": "forbidden", "message": "This API key is missing the '" + scope + "' scope."},
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -2,10 +2,14 @@ package middlewares
 
 import (
 	"errors"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -22,6 +26,23 @@ func AuthMiddleware() gin.HandlerFunc{
 	}
 }
 
+// RequireRole must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uVal, _ := c.Get("user")
+		user, ok := uVal.(models.User)
+		if ok {
+			for _, r := range roles {
+				if user.Role == r {
+					c.Next()
+					return
+				}
+			}
+		}
+		render404(c, "You don't have access to this page.")
+	}
+}
+
 func isHTMX(c *gin.Context) bool {
 	return c.GetHeader("HX-Request") == "true"
 }
@@ -48,6 +69,22 @@ func CheckIfLoggedIn() gin.HandlerFunc{
 	return func(c *gin.Context) {
 
 		c.Set("IsLoggedIn", false)
+
+		// API keys are only accepted on the JSON API, never on the HTML pages
+		if isBearerAPIRequest(c) {
+			token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			key, user, err := services.AuthenticateAPIKey(token, c.ClientIP())
+			if err == nil {
+				c.Set("IsLoggedIn", true)
+				c.Set("user", user)
+				c.Set("apiKey", key)
+				c.Set("authMethod", "api_key")
+				setActiveAccount(c, user)
+			}
+			c.Next()
+			return
+		}
+
 		tokenStr, err:=c.Cookie("Auth")
 		if err!=nil{
 			c.Next()
@@ -86,9 +123,28 @@ func CheckIfLoggedIn() gin.HandlerFunc{
 			return
 		}
 
+		// tokens issued before a forced logout carry an older session version
+		sv, _ := claims["sv"].(float64)
+		if user.Disabled || int64(sv) != user.SessionVersion {
+			c.Next()
+			return
+		}
+
 		c.Set("IsLoggedIn", true)
 		c.Set("user", user)
+		c.Set("authMethod", "cookie")
+		setActiveAccount(c, user)
 
 		c.Next()
 	}
 }
+
+// setActiveAccount puts the account the user is trading in next to the user.
+func setActiveAccount(c *gin.Context, user models.User) {
+	acct, err := services.ActiveAccount(user)
+	if err != nil {
+		log.Println("accounts:", user.ID.Hex(), err)
+		return
+	}
+	c.Set("account", acct)
+}
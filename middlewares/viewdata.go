@@ -18,5 +18,13 @@ func WithAuth(c *gin.Context, data gin.H) gin.H {
 		data["user"] = u
 	}
 
+	if a, ok := c.Get("account"); ok {
+		data["account"] = a
+	}
+
+	if t, ok := c.Get("csrfToken"); ok {
+		data["csrfToken"] = t
+	}
+
 	return data
 }
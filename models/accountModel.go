@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Account types
+const (
+	AccountCash   = "cash"
+	AccountMargin = "margin" // can borrow against its positions and sell short
+)
+
+// Account is one of a user's portfolios. Positions, orders, alerts and cash
+// belong to an account; every user has at least one, and trades in the one
+// they last switched to.
+type Account struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+
+	Name     string `bson:"name" json:"name"`
+	Type     string `bson:"type" json:"type"` // "cash" | "margin"
+	Currency string `bson:"currency" json:"currency"`
+	// Moved in from another account when it was opened; zero for one
+	// funded by deposits
+	StartingCapital decimal.Decimal `bson:"starting_capital" json:"starting_capital"`
+
+	Balance decimal.Decimal `bson:"balance" json:"balance"`
+	// Part of the balance held for pending withdrawals; it can't be spent
+	Reserved decimal.Decimal `bson:"reserved,omitempty" json:"reserved"`
+
+	// Set while the account is below its maintenance margin
+	MarginCallAt time.Time `bson:"margin_call_at,omitempty" json:"margin_call_at,omitempty"`
+	// Last day margin interest was charged on a negative balance
+	InterestAccruedOn string `bson:"margin_interest_accrued_on,omitempty" json:"-"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Margin reports whether this is a margin account.
+func (a Account) Margin() bool {
+	return a.Type == AccountMargin
+}
+
+// Available is the cash that can be spent or withdrawn.
+func (a Account) Available() decimal.Decimal {
+	return a.Balance.Sub(a.Reserved)
+}
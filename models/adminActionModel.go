@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminAction is the audit trail for everything done from the admin console.
+type AdminAction struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AdminID primitive.ObjectID `bson:"admin_id" json:"admin_id"`
+	UserID  primitive.ObjectID `bson:"user_id" json:"user_id"`
+	// The account acted on, for balance adjustments and account types
+	AccountID primitive.ObjectID `bson:"account_id,omitempty" json:"account_id,omitempty"`
+
+	Action string          `bson:"action" json:"action"` // "balance" | "disable" | "enable" | "logout" | "role" | "transfer_approve" | "transfer_reject" | "fees" | "margin"
+	Amount decimal.Decimal `bson:"amount,omitempty" json:"amount,omitempty"`
+	Role   string          `bson:"role,omitempty" json:"role,omitempty"`
+	Reason string          `bson:"reason,omitempty" json:"reason,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ScopeRead   = "read"
+	ScopeTrade  = "trade"
+	ScopeAlerts = "alerts"
+)
+
+var APIKeyScopes = []string{ScopeRead, ScopeTrade, ScopeAlerts}
+
+type APIKey struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+
+	Name   string   `bson:"name" json:"name"`
+	Prefix string   `bson:"prefix" json:"prefix"` // first chars of the token, for display only
+	Hash   string   `bson:"hash" json:"-"`        // sha256 of the full token
+	Scopes []string `bson:"scopes" json:"scopes"`
+
+	LastUsedAt time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	LastUsedIP string    `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+
+	Revoked   bool      `bson:"revoked" json:"revoked"`
+	RevokedAt time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
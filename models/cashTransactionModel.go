@@ -0,0 +1,61 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	CashDeposit    = "deposit"
+	CashWithdrawal = "withdrawal"
+	CashAdjustment = "adjustment"
+
+	// Holdings brought in from another broker; no cash moved, but the value
+	// came from outside the account
+	CashTransfer = "transfer"
+
+	// Commissions and regulatory fees charged on a fill. They leave the
+	// account but are a cost of trading, not a withdrawal.
+	CashFee = "fee"
+
+	// Daily fee for borrowing the shares of a short position
+	CashBorrowFee = "borrow_fee"
+
+	// Daily interest on a margin account's negative balance
+	CashMarginInterest = "margin_interest"
+
+	// Moved between two of the user's accounts, e.g. the starting capital
+	// of a new one
+	CashAccountTransfer = "account_transfer"
+)
+
+// ChargeTypes are what the account charges the user: costs, not money
+// moved in or out.
+var ChargeTypes = []string{CashFee, CashBorrowFee, CashMarginInterest}
+
+// IsCharge reports whether t is one of ChargeTypes.
+func (t CashTransaction) IsCharge() bool {
+	return slices.Contains(ChargeTypes, t.Type)
+}
+
+// CashTransaction is money moving into or out of an account from outside,
+// plus trading fees (trades themselves only move cash between balance and
+// positions and aren't recorded here). Amount is signed: positive in,
+// negative out.
+type CashTransaction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Type   string          `bson:"type" json:"type"` // "deposit" | "withdrawal" | "adjustment" | "transfer" | "fee" | "borrow_fee" | "margin_interest" | "account_transfer"
+	Amount decimal.Decimal `bson:"amount" json:"amount"`
+	Note   string          `bson:"note,omitempty" json:"note,omitempty"`
+
+	// Source document (e.g. the admin action), so backfills are idempotent
+	Ref primitive.ObjectID `bson:"ref,omitempty" json:"-"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// DailyPrice is one stored daily close, used for performance and risk
+// calculations and benchmark comparisons.
+type DailyPrice struct {
+	Symbol string    `bson:"symbol" json:"symbol"`
+	Day    string    `bson:"day" json:"day"` // YYYY-MM-DD (UTC)
+	Date   time.Time `bson:"date" json:"date"`
+	Close  float64   `bson:"close" json:"close"`
+}
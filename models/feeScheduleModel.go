@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Account tiers. Each tier can have a default fee schedule.
+const (
+	TierStandard = "standard"
+	TierPro      = "pro"
+)
+
+// FeeSchedule prices a fill. The commission is Flat + PerShare per share +
+// Percent of the trade value, held between Min and Max (zero means no
+// bound). Sells also pay regulatory fees: RegPercent of the proceeds plus
+// RegPerShare per share, the per-share part capped at RegPerShareMax.
+type FeeSchedule struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name string             `bson:"name" json:"name"`
+	Tier string             `bson:"tier,omitempty" json:"tier,omitempty"` // the tier this is the default for
+
+	Flat     decimal.Decimal `bson:"flat" json:"flat"`
+	PerShare decimal.Decimal `bson:"per_share" json:"per_share"`
+	Percent  decimal.Decimal `bson:"percent" json:"percent"`
+	Min      decimal.Decimal `bson:"min" json:"min"`
+	Max      decimal.Decimal `bson:"max" json:"max"`
+
+	RegPercent     decimal.Decimal `bson:"reg_percent" json:"reg_percent"`
+	RegPerShare    decimal.Decimal `bson:"reg_per_share" json:"reg_per_share"`
+	RegPerShareMax decimal.Decimal `bson:"reg_per_share_max" json:"reg_per_share_max"`
+
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
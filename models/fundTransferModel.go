@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TransferDeposit    = "deposit"
+	TransferWithdrawal = "withdrawal"
+
+	TransferPending   = "pending"
+	TransferCompleted = "completed"
+	TransferRejected  = "rejected"
+)
+
+// Payment states of a deposit paid through a gateway.
+const (
+	PaymentRequiresAction = "requires_payment"
+	PaymentProcessing     = "processing"
+	PaymentSucceeded      = "succeeded"
+	PaymentFailed         = "failed"
+	PaymentRefunded       = "refunded"
+)
+
+// FundTransfer is a user's request to move money into or out of one of
+// their accounts. Small transfers complete at once; larger ones wait for an admin.
+// A pending withdrawal keeps its amount in Account.Reserved until it is
+// decided.
+// With a payment gateway configured, a deposit also stays pending until its
+// payment settles; the balance is only credited then.
+type FundTransfer struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Type   string          `bson:"type" json:"type"`     // "deposit" | "withdrawal"
+	Amount decimal.Decimal `bson:"amount" json:"amount"` // always positive
+	Status string          `bson:"status" json:"status"` // "pending" | "completed" | "rejected"
+
+	// Set when the deposit is paid through a payment gateway
+	Gateway       string `bson:"gateway,omitempty" json:"gateway,omitempty"`
+	PaymentID     string `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	PaymentStatus string `bson:"payment_status,omitempty" json:"payment_status,omitempty"`
+	CheckoutURL   string `bson:"checkout_url,omitempty" json:"checkout_url,omitempty"`
+
+	ReviewedBy   primitive.ObjectID `bson:"reviewed_by,omitempty" json:"-"`
+	ReviewReason string             `bson:"review_reason,omitempty" json:"review_reason,omitempty"`
+
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	CompletedAt time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	RejectedAt  time.Time `bson:"rejected_at,omitempty" json:"rejected_at,omitempty"`
+}
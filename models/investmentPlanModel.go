@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Plan schedules
+const (
+	PlanDaily   = "daily"
+	PlanWeekly  = "weekly"
+	PlanMonthly = "monthly"
+)
+
+// Plan statuses
+const (
+	PlanActive = "active"
+	PlanPaused = "paused"
+	PlanEnded  = "ended"
+)
+
+// How a plan splits its money: by percentage of Amount, or a fixed amount
+// per symbol.
+const (
+	PlanModeWeights = "weights"
+	PlanModeAmounts = "amounts"
+)
+
+type PlanItem struct {
+	Symbol string          `bson:"symbol" json:"symbol"`
+	Weight float64         `bson:"weight,omitempty" json:"weight,omitempty"` // percent, weights mode
+	Amount decimal.Decimal `bson:"amount,omitempty" json:"amount,omitempty"` // amounts mode
+}
+
+// InvestmentPlan buys the same basket on a schedule (dollar-cost averaging).
+type InvestmentPlan struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Name   string          `bson:"name" json:"name"`
+	Mode   string          `bson:"mode" json:"mode"`
+	Amount decimal.Decimal `bson:"amount" json:"amount"` // per run; the items' sum in amounts mode
+	Items  []PlanItem      `bson:"items" json:"items"`
+
+	Schedule  string    `bson:"schedule" json:"schedule"`
+	StartDate time.Time `bson:"start_date" json:"start_date"`
+	EndDate   time.Time `bson:"end_date,omitempty" json:"end_date,omitempty"` // zero = open-ended
+
+	Status    string    `bson:"status" json:"status"`
+	NextRunAt time.Time `bson:"next_run_at,omitempty" json:"next_run_at,omitempty"`
+	LastRunAt time.Time `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Plan run statuses
+const (
+	PlanRunCompleted = "completed"
+	PlanRunPartial   = "partial" // some orders failed
+	PlanRunSkipped   = "skipped" // not enough cash, nothing bought
+	PlanRunFailed    = "failed"  // no order went through
+)
+
+type PlanRunOrder struct {
+	Symbol string          `bson:"symbol" json:"symbol"`
+	Amount decimal.Decimal `bson:"amount" json:"amount"` // budgeted
+	Qty    decimal.Decimal `bson:"qty" json:"qty"`
+	Price  decimal.Decimal `bson:"price,omitempty" json:"price,omitempty"`
+	Cost   decimal.Decimal `bson:"cost,omitempty" json:"cost,omitempty"`
+	Error  string          `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// PlanRun is one scheduled execution of a plan.
+type PlanRun struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PlanID    primitive.ObjectID `bson:"plan_id" json:"plan_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	ScheduledFor time.Time       `bson:"scheduled_for" json:"scheduled_for"`
+	RanAt        time.Time       `bson:"ran_at" json:"ran_at"`
+	Status       string          `bson:"status" json:"status"`
+	Orders       []PlanRunOrder  `bson:"orders,omitempty" json:"orders,omitempty"`
+	Invested     decimal.Decimal `bson:"invested" json:"invested"`
+	Note         string          `bson:"note,omitempty" json:"note,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification is an in-app message for a user, e.g. about a skipped
+// investment plan run.
+type Notification struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+
+	Kind  string `bson:"kind" json:"kind"` // e.g. "plan_skipped"
+	Title string `bson:"title" json:"title"`
+	Body  string `bson:"body,omitempty" json:"body,omitempty"`
+	Link  string `bson:"link,omitempty" json:"link,omitempty"` // in-app path
+
+	Read      bool      `bson:"read" json:"read"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
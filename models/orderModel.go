@@ -3,18 +3,35 @@ package models
 import (
 	"time"
 
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Order struct {
-	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
 
 	Symbol string `bson:"symbol" json:"symbol"`
 	Side   string `bson:"side" json:"side"` // "buy" | "sell"
 
-	Qty   int64   `bson:"qty" json:"qty"`
-	Price float64 `bson:"price" json:"price"` // fill price (market = quote at time)
+	Qty   decimal.Decimal `bson:"qty" json:"qty"`
+	Price decimal.Decimal `bson:"price" json:"price"` // fill price (market = quote at time)
+
+	// What the fill cost on top of the trade; imported orders have none
+	Commission decimal.Decimal `bson:"commission,omitempty" json:"commission"`
+	RegFee     decimal.Decimal `bson:"reg_fee,omitempty" json:"reg_fee"` // regulatory fees, sells only
+
+	// Set on orders replayed from a broker import; live orders leave it empty
+	Source   string             `bson:"source,omitempty" json:"source,omitempty"` // "import"
+	ImportID primitive.ObjectID `bson:"import_id,omitempty" json:"-"`
 
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 }
+
+const OrderSourceImport = "import"
+
+// Fees is everything the fill cost besides the trade itself.
+func (o Order) Fees() decimal.Decimal {
+	return o.Commission.Add(o.RegFee)
+}
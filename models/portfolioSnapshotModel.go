@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	SnapshotDaily    = "daily"
+	SnapshotIntraday = "intraday"
+)
+
+// PortfolioSnapshot is an account's cash plus marked-to-market positions at
+// a point in time. Daily snapshots are kept forever (one per account per day),
+// intraday ones expire after a few days.
+type PortfolioSnapshot struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Kind string `bson:"kind" json:"kind"`                   // "daily" | "intraday"
+	Day  string `bson:"day,omitempty" json:"day,omitempty"` // YYYY-MM-DD (UTC), daily only
+
+	Cash           decimal.Decimal    `bson:"cash" json:"cash"`
+	PositionsValue decimal.Decimal    `bson:"positions_value" json:"positions_value"`
+	TotalValue     decimal.Decimal    `bson:"total_value" json:"total_value"`
+	Positions      []SnapshotPosition `bson:"positions" json:"positions"`
+
+	TakenAt   time.Time `bson:"taken_at" json:"taken_at"`
+	ExpiresAt time.Time `bson:"expires_at,omitempty" json:"-"`
+}
+
+type SnapshotPosition struct {
+	Symbol string          `bson:"symbol" json:"symbol"`
+	Qty    decimal.Decimal `bson:"qty" json:"qty"`
+	Price  decimal.Decimal `bson:"price" json:"price"`
+	Value  decimal.Decimal `bson:"value" json:"value"`
+}
@@ -3,16 +3,21 @@ package models
 import (
 	"time"
 
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Position struct {
-	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
 
-	Symbol  string `bson:"symbol" json:"symbol"`
-	Qty     int64  `bson:"qty" json:"qty"`
-	AvgCost float64 `bson:"avg_cost" json:"avg_cost"`
+	Symbol  string          `bson:"symbol" json:"symbol"`
+	Qty     decimal.Decimal `bson:"qty" json:"qty"`           // negative for a short
+	AvgCost decimal.Decimal `bson:"avg_cost" json:"avg_cost"` // average short price for a short
+
+	// Day ("2006-01-02") borrow fees on a short were last charged up to
+	BorrowAccruedOn string `bson:"borrow_accrued_on,omitempty" json:"-"`
 
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
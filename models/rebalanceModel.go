@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rebalance schedules; empty means manual only.
+const (
+	RebalanceMonthly   = "monthly"
+	RebalanceQuarterly = "quarterly"
+	RebalanceYearly    = "yearly"
+)
+
+type AllocationTarget struct {
+	Symbol string  `bson:"symbol" json:"symbol"`
+	Pct    float64 `bson:"pct" json:"pct"`
+}
+
+// TargetAllocation is a user's model portfolio. Targets plus CashPct add up
+// to 100. A rebalance is due once any holding, or cash, is more than
+// DriftBand percentage points away from its target.
+type TargetAllocation struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"-"`
+
+	Targets   []AllocationTarget `bson:"targets" json:"targets"`
+	CashPct   float64            `bson:"cash_pct" json:"cash_pct"`
+	DriftBand float64            `bson:"drift_band" json:"drift_band"`
+
+	Schedule  string    `bson:"schedule,omitempty" json:"schedule,omitempty"`
+	NextRunAt time.Time `bson:"next_run_at,omitempty" json:"next_run_at,omitempty"`
+
+	// set while a rebalance is executing so two can't overlap
+	LockedUntil time.Time `bson:"locked_until,omitempty" json:"-"`
+
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Rebalance triggers
+const (
+	RebalanceManual    = "manual"
+	RebalanceScheduled = "scheduled"
+)
+
+type RebalanceOrder struct {
+	Symbol string          `bson:"symbol" json:"symbol"`
+	Side   string          `bson:"side" json:"side"`
+	Qty    decimal.Decimal `bson:"qty" json:"qty"`
+	Price  decimal.Decimal `bson:"price,omitempty" json:"price,omitempty"`
+	Value  decimal.Decimal `bson:"value,omitempty" json:"value,omitempty"`
+	Error  string          `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// Rebalance records one executed rebalance.
+type Rebalance struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Trigger   string           `bson:"trigger" json:"trigger"`
+	Orders    []RebalanceOrder `bson:"orders" json:"orders"`
+	Note      string           `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt time.Time        `bson:"created_at" json:"created_at"`
+}
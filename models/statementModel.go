@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statement is a monthly account statement. The figures are kept next to the
+// rendered PDF so they can be listed without opening the file.
+type Statement struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Period      string    `bson:"period" json:"period"` // "2006-01"
+	PeriodStart time.Time `bson:"period_start" json:"period_start"`
+	PeriodEnd   time.Time `bson:"period_end" json:"period_end"` // exclusive
+	Partial     bool      `bson:"partial" json:"partial"`       // generated before the month ended
+
+	OpeningCash  decimal.Decimal `bson:"opening_cash" json:"opening_cash"`
+	ClosingCash  decimal.Decimal `bson:"closing_cash" json:"closing_cash"`
+	OpeningValue decimal.Decimal `bson:"opening_value" json:"opening_value"`
+	ClosingValue decimal.Decimal `bson:"closing_value" json:"closing_value"`
+
+	Deposits    decimal.Decimal `bson:"deposits" json:"deposits"`       // money in, including adjustments up
+	Withdrawals decimal.Decimal `bson:"withdrawals" json:"withdrawals"` // money out, as a positive amount
+	Bought      decimal.Decimal `bson:"bought" json:"bought"`
+	Sold        decimal.Decimal `bson:"sold" json:"sold"`
+	Fees        decimal.Decimal `bson:"fees" json:"fees"` // commissions and regulatory fees
+	Fills       int             `bson:"fills" json:"fills"`
+
+	RealizedPnL   decimal.Decimal `bson:"realized_pnl" json:"realized_pnl"`
+	UnrealizedPnL decimal.Decimal `bson:"unrealized_pnl" json:"unrealized_pnl"` // on closing holdings
+
+	PDF  []byte `bson:"pdf" json:"-"`
+	Size int    `bson:"size" json:"size"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Symbol is company profile data for a ticker, cached from the market data
+// provider in the "symbols" collection.
+type Symbol struct {
+	Symbol    string  `bson:"symbol" json:"symbol"`
+	Name      string  `bson:"name" json:"name"`
+	AssetType string  `bson:"asset_type" json:"asset_type"` // FinnhubSearchItem.Type, e.g. "Common Stock", "ETP"
+	Sector    string  `bson:"sector" json:"sector"`
+	Industry  string  `bson:"industry" json:"industry"`
+	Country   string  `bson:"country" json:"country"`
+	Currency  string  `bson:"currency" json:"currency"`
+	Exchange  string  `bson:"exchange" json:"exchange"`
+	MarketCap float64 `bson:"market_cap" json:"market_cap"` // millions, in Currency
+	Logo      string  `bson:"logo,omitempty" json:"logo,omitempty"`
+
+	FetchedAt time.Time `bson:"fetched_at" json:"fetched_at"`
+}
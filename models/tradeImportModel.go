@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ImportPreview  = "preview"
+	ImportApplying = "applying"
+	ImportApplied  = "applied"
+)
+
+// ImportMapping names the CSV columns holding each field. An empty Side means
+// the side comes from the sign of the quantity (negative = sell).
+type ImportMapping struct {
+	Date   string `bson:"date" json:"date"`
+	Symbol string `bson:"symbol" json:"symbol"`
+	Side   string `bson:"side" json:"side"`
+	Qty    string `bson:"qty" json:"qty"`
+	Price  string `bson:"price" json:"price"`
+}
+
+// ImportedTrade is one parsed CSV row. Rows with an Error are not replayed;
+// Skipped rows (dividends, transfers, ...) weren't trades to begin with.
+type ImportedTrade struct {
+	Row    int             `bson:"row" json:"row"` // 1-based line in the file
+	Date   time.Time       `bson:"date" json:"date"`
+	Symbol string          `bson:"symbol" json:"symbol"`
+	Side   string          `bson:"side" json:"side"`
+	Qty    decimal.Decimal `bson:"qty" json:"qty"`
+	Price  decimal.Decimal `bson:"price" json:"price"`
+
+	Skipped bool   `bson:"skipped,omitempty" json:"skipped,omitempty"`
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// TradeImport is an uploaded broker file: first parsed into a preview, then
+// applied once the user confirms it.
+type TradeImport struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+
+	Filename string          `bson:"filename" json:"filename"`
+	Layout   string          `bson:"layout" json:"layout"`
+	Mapping  ImportMapping   `bson:"mapping" json:"mapping"`
+	Trades   []ImportedTrade `bson:"trades" json:"trades"`
+	Status   string          `bson:"status" json:"status"` // "preview" | "applying" | "applied"
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	AppliedAt time.Time `bson:"applied_at,omitempty" json:"applied_at,omitempty"`
+	ExpiresAt time.Time `bson:"expires_at,omitempty" json:"-"` // unset once applied
+}
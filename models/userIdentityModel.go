@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserIdentity links a GoMarket user to an account at an OpenID Connect
+// provider ("Sign in with …").
+type UserIdentity struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID primitive.ObjectID `bson:"user_id" json:"user_id"`
+
+	Provider string `bson:"provider" json:"provider"`
+	Subject  string `bson:"subject" json:"subject"` // the provider's stable user id ("sub")
+	Email    string `bson:"email" json:"email"`
+
+	LinkedAt    time.Time `bson:"linked_at" json:"linked_at"`
+	LastLoginAt time.Time `bson:"last_login_at" json:"last_login_at"`
+}
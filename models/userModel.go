@@ -2,9 +2,15 @@ package models
 
 import (
 	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	RoleUser  = "User"
+	RoleAdmin = "Admin"
+)
+
 type User struct{
 	ID primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 
@@ -14,8 +20,31 @@ type User struct{
 	PasswordHash string `bson:"password_hash" json:"-"`
 	Role         string `bson:"role" json:"role"`
 
-	Balance float64 `bson:"balance" json:"balance"`
+	// Fees: the tier's default schedule unless a schedule is set directly
+	Tier          string             `bson:"tier,omitempty" json:"tier"` // empty means TierStandard
+	FeeScheduleID primitive.ObjectID `bson:"fee_schedule_id,omitempty" json:"-"`
+
+	// The account the user is trading in; see Account
+	ActiveAccountID primitive.ObjectID `bson:"active_account_id,omitempty" json:"active_account_id"`
+
+	Disabled   bool      `bson:"disabled" json:"disabled"`
+	DisabledAt time.Time `bson:"disabled_at,omitempty" json:"disabled_at,omitempty"`
+
+	// Bumped to invalidate every JWT issued before (force logout).
+	SessionVersion int64 `bson:"session_version" json:"-"`
 
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 }
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// AccountTier is the user's tier, TierStandard unless set.
+func (u User) AccountTier() string {
+	if u.Tier == "" {
+		return TierStandard
+	}
+	return u.Tier
+}
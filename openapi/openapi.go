@@ -0,0 +1,350 @@
+// Package openapi builds an OpenAPI 3 document from the routes registered on
+// the JSON API, so the spec can't drift from what is actually served.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Param struct {
+	Name        string
+	In          string // "path" | "query"
+	Type        string // "string" | "integer" | "number" | "boolean"
+	Description string
+	Required    bool
+}
+
+type Operation struct {
+	Method  string
+	Path    string // gin style, relative to the server URL, e.g. "/alerts/:id"
+	Summary string
+	Tag     string
+	Scope   string // API key scope needed, empty for public endpoints
+	Params  []Param
+
+	Request  any // zero value of the JSON body type, nil if none
+	Response any // zero value of the "data" type, nil for 204
+	List     bool
+	Status   int // defaults to 200
+}
+
+type Spec struct {
+	Title   string
+	Version string
+	Server  string
+
+	ops     []Operation
+	schemas map[string]any
+}
+
+func New(title, version, server string) *Spec {
+	return &Spec{Title: title, Version: version, Server: server}
+}
+
+func (s *Spec) Add(op Operation) {
+	s.ops = append(s.ops, op)
+}
+
+// PaginationParams are accepted by every list endpoint.
+var PaginationParams = []Param{
+	{Name: "page", In: "query", Type: "integer", Description: "1-based page number (default 1)."},
+	{Name: "per_page", In: "query", Type: "integer", Description: "Items per page (default 50, max 200)."},
+}
+
+var ginParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// Document renders the spec as a JSON-ready OpenAPI 3.0 object.
+func (s *Spec) Document() map[string]any {
+	s.schemas = map[string]any{
+		"Error": map[string]any{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]any{
+				"error": map[string]any{
+					"type":     "object",
+					"required": []string{"code", "message"},
+					"properties": map[string]any{
+						"code":    map[string]any{"type": "string"},
+						"message": map[string]any{"type": "string"},
+						"fields": map[string]any{
+							"type":                 "object",
+							"additionalProperties": map[string]any{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+		"Pagination": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"page":     map[string]any{"type": "integer"},
+				"per_page": map[string]any{"type": "integer"},
+				"total":    map[string]any{"type": "integer"},
+			},
+		},
+	}
+
+	paths := map[string]any{}
+	for _, op := range s.ops {
+		p := ginParam.ReplaceAllString(op.Path, "{$1}")
+		item, _ := paths[p].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[p] = item
+		}
+		item[strings.ToLower(op.Method)] = s.operation(op)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   s.Title,
+			"version": s.Version,
+		},
+		"servers": []any{map[string]any{"url": s.Server}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal API key created under Settings → API Keys.",
+				},
+				"cookie": map[string]any{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "Auth",
+				},
+			},
+		},
+	}
+}
+
+func (s *Spec) operation(op Operation) map[string]any {
+	out := map[string]any{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+	}
+	if op.Tag != "" {
+		out["tags"] = []string{op.Tag}
+	}
+
+	params := []any{}
+	for _, m := range ginParam.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]any{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	extra := op.Params
+	if op.List {
+		extra = append(append([]Param{}, extra...), PaginationParams...)
+	}
+	for _, p := range extra {
+		param := map[string]any{
+			"name":   p.Name,
+			"in":     p.In,
+			"schema": map[string]any{"type": p.Type},
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		if p.Required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	if op.Request != nil {
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": s.schemaFor(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	responses := map[string]any{}
+	if op.Response == nil {
+		responses[strconv.Itoa(status)] = map[string]any{"description": http.StatusText(status)}
+	} else {
+		data := s.schemaFor(reflect.TypeOf(op.Response))
+		if op.List {
+			data = map[string]any{"type": "array", "items": data}
+		}
+		props := map[string]any{"data": data}
+		if op.List {
+			props["pagination"] = map[string]any{"$ref": "#/components/schemas/Pagination"}
+		}
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": map[string]any{"type": "object", "properties": props},
+				},
+			},
+		}
+	}
+
+	errRef := map[string]any{
+		"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+	}
+	if op.Scope != "" {
+		responses["401"] = map[string]any{"description": "Missing or invalid credentials", "content": errRef}
+		responses["403"] = map[string]any{"description": "API key lacks the '" + op.Scope + "' scope", "content": errRef}
+		out["security"] = []any{
+			map[string]any{"apiKey": []string{}},
+			map[string]any{"cookie": []string{}},
+		}
+		out["x-scope"] = op.Scope
+	}
+	if op.Request != nil || len(extra) > 0 {
+		responses["422"] = map[string]any{"description": "Validation failed", "content": errRef}
+	}
+	responses["default"] = map[string]any{"description": "Error", "content": errRef}
+	out["responses"] = responses
+
+	return out
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	decimalType  = reflect.TypeOf(decimal.Decimal{})
+)
+
+// schemaFor maps a Go type to a JSON schema, registering named structs under
+// components/schemas and referencing them.
+func (s *Spec) schemaFor(t reflect.Type) map[string]any {
+	t = derefType(t)
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]any{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case decimalType:
+		return map[string]any{"type": "number"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if name == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s.schemas[name]; !ok {
+			s.schemas[name] = map[string]any{} // placeholder for recursive types
+			s.schemas[name] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (s *Spec) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := f.Name
+		if n := strings.Split(tag, ",")[0]; n != "" {
+			name = n
+		}
+		if f.Anonymous && tag == "" {
+			if emb, ok := s.structSchema(derefType(f.Type))["properties"].(map[string]any); ok {
+				for k, v := range emb {
+					props[k] = v
+				}
+			}
+			continue
+		}
+		props[name] = s.schemaFor(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func schemaName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	// models.Position -> Position, services.BuyResult -> BuyResult
+	switch pkg {
+	case "models", "services", "controllers":
+		return t.Name()
+	}
+	return upperFirst(pkg) + t.Name()
+}
+
+func operationID(op Operation) string {
+	parts := []string{strings.ToLower(op.Method)}
+	for _, seg := range strings.Split(op.Path, "/") {
+		if seg == "" {
+			continue
+		}
+		if strings.HasPrefix(seg, ":") {
+			parts = append(parts, "By"+upperFirst(seg[1:]))
+			continue
+		}
+		for _, w := range strings.FieldsFunc(seg, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+			parts = append(parts, upperFirst(w))
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,216 @@
+// Package pdf writes simple text-and-lines PDF documents (statements,
+// reports) without external tools. It only knows the standard Helvetica
+// fonts, which every PDF viewer ships, so nothing has to be embedded.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A4 in points.
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+)
+
+type Document struct {
+	Title  string
+	Author string
+
+	pages []*Page
+}
+
+// Page coordinates start at the top-left corner, y grows downwards.
+type Page struct {
+	content bytes.Buffer
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+func (d *Document) Pages() []*Page {
+	return d.pages
+}
+
+// Text draws s with its baseline at (x, y).
+func (p *Page) Text(x, y, size float64, f Font, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %s Tf %s %s Td (%s) Tj ET\n",
+		int(f)+1, num(size), num(x), num(PageHeight-y), escape(s))
+}
+
+// TextRight draws s so that it ends at x.
+func (p *Page) TextRight(x, y, size float64, f Font, s string) {
+	p.Text(x-TextWidth(s, size, f), y, size, f, s)
+}
+
+// TextGray is Text in a shade of gray (0 = black, 1 = white).
+func (p *Page) TextGray(x, y, size float64, f Font, gray float64, s string) {
+	fmt.Fprintf(&p.content, "%s g\n", num(gray))
+	p.Text(x, y, size, f, s)
+	p.content.WriteString("0 g\n")
+}
+
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n",
+		num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// FillRect fills a rectangle whose top-left corner is (x, y).
+func (p *Page) FillRect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.content, "%s g %s %s %s %s re f 0 g\n",
+		num(gray), num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// TextWidth is the width of s in points.
+func TextWidth(s string, size float64, f Font) float64 {
+	widths := helveticaWidths
+	if f == Bold {
+		widths = helveticaBoldWidths
+	}
+	total := 0
+	for _, b := range winAnsi(s) {
+		if b >= 32 && int(b-32) < len(widths) {
+			total += widths[b-32]
+		} else {
+			total += 556 // € and other mapped characters
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// WriteTo writes the complete file.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var buf bytes.Buffer
+	offsets := []int{}
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3-4 fonts, 5 info, then a page and its
+	// content stream per page
+	n := len(d.pages)
+	kids := make([]string, n)
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %s %s] >>",
+		strings.Join(kids, " "), n, num(PageWidth), num(PageHeight)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj(fmt.Sprintf("<< /Title (%s) /Author (%s) /Producer (GoMarket) >>", escape(d.Title), escape(d.Author)))
+
+	for _, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			len(offsets)+2))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.WriteTo(w)
+}
+
+// Bytes renders the document into memory.
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	_, _ = d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// escape turns s into the body of a PDF string literal.
+func escape(s string) string {
+	var b strings.Builder
+	for _, c := range winAnsi(s) {
+		switch c {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 32 || c > 126 {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// winAnsi maps s to the fonts' encoding; anything it can't show becomes "?".
+func winAnsi(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '€':
+			out = append(out, 0x80)
+		case r == '–':
+			out = append(out, 0x96)
+		case r == '—':
+			out = append(out, 0x97)
+		case r == '•':
+			out = append(out, 0x95)
+		case r >= 32 && r <= 126:
+			out = append(out, byte(r))
+		case r >= 0xa0 && r <= 0xff:
+			out = append(out, byte(r))
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// Glyph widths of ASCII 32-126 from the standard font metrics.
+var helveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = []int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
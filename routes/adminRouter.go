@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/GeorgiStoyanov05/GoMarket/controllers"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", middlewares.AuthMiddleware(), middlewares.RequireRole(models.RoleAdmin))
+
+	admin.GET("", controllers.GetAdminPage)
+	admin.GET("/users", controllers.GetAdminUsers)
+	admin.GET("/users/:id", controllers.GetAdminUser)
+	admin.POST("/users/:id/balance", controllers.PostAdminAdjustBalance)
+	admin.POST("/users/:id/disable", controllers.PostAdminDisableUser)
+	admin.POST("/users/:id/enable", controllers.PostAdminEnableUser)
+	admin.POST("/users/:id/logout", controllers.PostAdminForceLogout)
+	admin.POST("/users/:id/role", controllers.PostAdminSetRole)
+	admin.POST("/users/:id/fees", controllers.PostAdminSetFees)
+	admin.POST("/users/:id/margin", controllers.PostAdminSetMargin)
+	admin.GET("/transfers", controllers.GetAdminTransfers)
+	admin.POST("/transfers/:id/approve", controllers.PostAdminApproveTransfer)
+	admin.POST("/transfers/:id/reject", controllers.PostAdminRejectTransfer)
+	admin.GET("/fees", controllers.GetAdminFees)
+	admin.POST("/fees", controllers.PostAdminSaveFeeSchedule)
+	admin.POST("/fees/:id/delete", controllers.PostAdminDeleteFeeSchedule)
+}
@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/GeorgiStoyanov05/GoMarket/controllers"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/openapi"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
+	"github.com/gin-gonic/gin"
+)
+
+const apiBasePath = "/api/v1"
+
+// apiRouter registers a route on the gin group and documents it in the spec
+// in one go.
+type apiRouter struct {
+	group *gin.RouterGroup
+	spec  *openapi.Spec
+}
+
+func (a apiRouter) handle(op openapi.Operation, h gin.HandlerFunc) {
+	a.spec.Add(op)
+	a.group.Handle(op.Method, op.Path, middlewares.RequireScope(op.Scope), h)
+}
+
+func APIRoutes(r *gin.Engine) {
+	spec := openapi.New("GoMarket API", "1.0.0", apiBasePath)
+	api := apiRouter{
+		group: r.Group(apiBasePath, middlewares.APIAuth()),
+		spec:  spec,
+	}
+
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/account", Tag: "Account", Scope: models.ScopeRead,
+		Summary: "The authenticated user", Response: models.User{},
+	}, controllers.GetAPIAccount)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/accounts", Tag: "Account", Scope: models.ScopeRead,
+		Summary: "The user's accounts; send X-Account-ID to work in one other than the active account", Response: models.Account{}, List: true,
+	}, controllers.GetAPIAccounts)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/account/balance", Tag: "Account", Scope: models.ScopeRead,
+		Summary: "Cash balance of the account", Response: controllers.APIBalance{},
+	}, controllers.GetAPIBalance)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/account/margin", Tag: "Account", Scope: models.ScopeRead,
+		Summary: "Equity, margin requirements, buying power and any margin call", Response: services.MarginAccount{},
+	}, controllers.GetAPIMargin)
+
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/positions", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Open positions", Response: models.Position{}, List: true,
+	}, controllers.GetAPIPositions)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/positions/:symbol", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Open position for one symbol", Response: models.Position{},
+	}, controllers.GetAPIPosition)
+
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/portfolio/history", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Account value over time", Response: []services.HistoryPoint{},
+		Params: []openapi.Param{
+			{Name: "range", In: "query", Type: "string", Description: "1D, 1W, 1M (default), YTD or ALL."},
+		},
+	}, controllers.GetAPIPortfolioHistory)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/portfolio/performance", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Time- and money-weighted returns with a benchmark comparison", Response: services.Performance{},
+		Params: []openapi.Param{
+			{Name: "range", In: "query", Type: "string", Description: "1D, 1W, 1M (default), YTD or ALL."},
+		},
+	}, controllers.GetAPIPortfolioPerformance)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/portfolio/risk", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Volatility, beta, drawdown, Sharpe/Sortino and 1-day VaR", Response: services.PortfolioRiskReport{},
+		Params: []openapi.Param{
+			{Name: "window", In: "query", Type: "string", Description: "3M, 6M or 1Y (default)."},
+		},
+	}, controllers.GetAPIPortfolioRisk)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/portfolio/allocation", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Holdings grouped by sector, industry, country and asset type, plus a sector treemap", Response: services.Allocation{},
+	}, controllers.GetAPIPortfolioAllocation)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/export/:dataset", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Download orders, positions, alerts or cash movements as CSV or JSON (dataset: orders, positions, alerts, cash)",
+		Params: []openapi.Param{
+			{Name: "format", In: "query", Type: "string", Description: "csv (default) or json."},
+			{Name: "from", In: "query", Type: "string", Description: "First day to include, YYYY-MM-DD (UTC)."},
+			{Name: "to", In: "query", Type: "string", Description: "Last day to include, YYYY-MM-DD (UTC)."},
+		},
+	}, controllers.GetAPIExport)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/transfers", Tag: "Account", Scope: models.ScopeRead,
+		Summary: "Deposits and withdrawals, newest first", Response: []models.FundTransfer{},
+	}, controllers.GetAPITransfers)
+	api.handle(openapi.Operation{
+		Method: http.MethodPost, Path: "/transfers", Tag: "Account", Scope: models.ScopeTrade,
+		Summary: "Deposit or withdraw cash; large amounts stay pending until approved", Request: controllers.APITransferRequest{},
+		Response: models.FundTransfer{}, Status: http.StatusCreated,
+	}, controllers.PostAPITransfer)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/statements", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Monthly statements, newest first", Response: []models.Statement{},
+	}, controllers.GetAPIStatements)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/statements/:id/pdf", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Download a statement as PDF",
+	}, controllers.GetAPIStatementPDF)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/tax/:year", Tag: "Portfolio", Scope: models.ScopeRead,
+		Summary: "Realized gains of a calendar year: FIFO lots, short/long term and wash sales", Response: services.TaxReport{},
+		Params: []openapi.Param{
+			{Name: "part", In: "query", Type: "string", Description: "summary or lots to download that part as CSV instead of JSON."},
+		},
+	}, controllers.GetAPITaxReport)
+
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/orders", Tag: "Trading", Scope: models.ScopeRead,
+		Summary: "Order history, newest first", Response: models.Order{}, List: true,
+	}, controllers.GetAPIOrders)
+	api.handle(openapi.Operation{
+		Method: http.MethodPost, Path: "/orders", Tag: "Trading", Scope: models.ScopeTrade,
+		Summary: "Place a market order", Request: controllers.APIOrderRequest{},
+		Response: controllers.APIOrderResult{}, Status: http.StatusCreated,
+	}, controllers.PostAPIOrder)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/plans", Tag: "Trading", Scope: models.ScopeRead,
+		Summary: "Recurring investment plans", Response: []models.InvestmentPlan{},
+	}, controllers.GetAPIPlans)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/plans/:id/runs", Tag: "Trading", Scope: models.ScopeRead,
+		Summary: "A plan's latest runs, newest first", Response: []models.PlanRun{},
+	}, controllers.GetAPIPlanRuns)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/rebalance", Tag: "Trading", Scope: models.ScopeRead,
+		Summary: "Drift from the target allocation and the trades that would fix it", Response: services.RebalancePreview{},
+	}, controllers.GetAPIRebalance)
+	api.handle(openapi.Operation{
+		Method: http.MethodPost, Path: "/rebalance", Tag: "Trading", Scope: models.ScopeTrade,
+		Summary: "Rebalance to the target allocation now (sells before buys)",
+		Response: models.Rebalance{}, Status: http.StatusCreated,
+	}, controllers.PostAPIRebalance)
+
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/alerts", Tag: "Alerts", Scope: models.ScopeAlerts,
+		Summary: "Price alerts", Response: models.PriceAlert{}, List: true,
+	}, controllers.GetAPIAlerts)
+	api.handle(openapi.Operation{
+		Method: http.MethodPost, Path: "/alerts", Tag: "Alerts", Scope: models.ScopeAlerts,
+		Summary: "Create a price alert", Request: controllers.APIAlertRequest{},
+		Response: models.PriceAlert{}, Status: http.StatusCreated,
+	}, controllers.PostAPIAlert)
+	api.handle(openapi.Operation{
+		Method: http.MethodDelete, Path: "/alerts/:id", Tag: "Alerts", Scope: models.ScopeAlerts,
+		Summary: "Delete a price alert", Status: http.StatusNoContent,
+	}, controllers.DeleteAPIAlert)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/watchlists", Tag: "Alerts", Scope: models.ScopeAlerts,
+		Summary: "Alerts grouped by symbol", Response: controllers.AlertGroup{}, List: true,
+	}, controllers.GetAPIWatchlists)
+
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/quotes/:symbol", Tag: "Market data", Scope: models.ScopeRead,
+		Summary: "Latest quote", Response: services.Quote{},
+	}, controllers.GetAPIQuote)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/market/status", Tag: "Market data", Scope: models.ScopeRead,
+		Summary: "Trading session and next open/close of an exchange", Response: services.MarketStatus{},
+		Params: []openapi.Param{
+			{Name: "exchange", In: "query", Type: "string", Description: "Exchange code, e.g. XNYS (the default) or XLON."},
+			{Name: "symbol", In: "query", Type: "string", Description: "Use the exchange this symbol trades on instead."},
+		},
+	}, controllers.GetAPIMarketStatus)
+	api.handle(openapi.Operation{
+		Method: http.MethodGet, Path: "/symbols/search", Tag: "Market data", Scope: models.ScopeRead,
+		Summary: "Search symbols by name or ticker", Response: []services.FinnhubSearchItem{},
+		Params: []openapi.Param{
+			{Name: "q", In: "query", Type: "string", Required: true},
+			{Name: "limit", In: "query", Type: "integer", Description: "1-50, default 10."},
+		},
+	}, controllers.GetAPISymbolSearch)
+
+	doc := spec.Document()
+	r.GET(apiBasePath+"/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	})
+}
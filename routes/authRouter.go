@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/GeorgiStoyanov05/GoMarket/controllers"
+	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,4 +12,8 @@ func AuthRoutes(r *gin.Engine) {
 		r.GET("/login", controllers.GetLoginPage)
 		r.POST("/login", controllers.PostLoginPage)
 		r.GET("/logout", controllers.UserLogout)
+		r.GET("/auth/oidc/:provider", controllers.GetOIDCLogin)
+		r.GET("/auth/oidc/:provider/callback", controllers.GetOIDCCallback)
+		r.GET("/auth/oidc/:provider/link", middlewares.AuthMiddleware(), controllers.GetOIDCLink)
+		r.POST("/auth/oidc/:provider/unlink", middlewares.AuthMiddleware(), controllers.PostOIDCUnlink)
 }
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"github.com/GeorgiStoyanov05/GoMarket/controllers"
 	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
 	"github.com/gin-gonic/gin"
 )
@@ -15,4 +16,5 @@ func HomeRoutes(r *gin.Engine) {
 			"InitialPath": "/",
 		}))
 	})
+	r.GET("/market/status", controllers.GetMarketStatus)
 }
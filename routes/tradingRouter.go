@@ -12,5 +12,19 @@ func TradingRoutes(r *gin.Engine) {
 	r.POST("/trade/:symbol/sell", middlewares.AuthMiddleware(), controllers.PostMarketSell)
 	r.GET("/portfolio", middlewares.AuthMiddleware(), controllers.GetPortfolioPage)
 	r.GET("/portfolio/positions", middlewares.AuthMiddleware(), controllers.GetPortfolioPositions)
+	r.GET("/portfolio/account", middlewares.AuthMiddleware(), controllers.GetPortfolioAccount)
+	r.GET("/portfolio/history", middlewares.AuthMiddleware(), controllers.GetPortfolioHistory)
+	r.GET("/portfolio/performance", middlewares.AuthMiddleware(), controllers.GetPortfolioPerformance)
+	r.GET("/portfolio/risk", middlewares.AuthMiddleware(), controllers.GetPortfolioRisk)
+	r.GET("/portfolio/allocation", middlewares.AuthMiddleware(), controllers.GetPortfolioAllocation)
+	r.GET("/portfolio/rebalance", middlewares.AuthMiddleware(), controllers.GetPortfolioRebalance)
+	r.POST("/portfolio/rebalance/targets", middlewares.AuthMiddleware(), controllers.PostRebalanceTargets)
+	r.POST("/portfolio/rebalance/execute", middlewares.AuthMiddleware(), controllers.PostExecuteRebalance)
+	r.GET("/plans", middlewares.AuthMiddleware(), controllers.GetPlansPage)
+	r.POST("/plans", middlewares.AuthMiddleware(), controllers.PostCreatePlan)
+	r.POST("/plans/:id/pause", middlewares.AuthMiddleware(), controllers.PostPausePlan)
+	r.POST("/plans/:id/resume", middlewares.AuthMiddleware(), controllers.PostResumePlan)
+	r.POST("/plans/:id/delete", middlewares.AuthMiddleware(), controllers.PostDeletePlan)
+	r.GET("/plans/:id/runs", middlewares.AuthMiddleware(), controllers.GetPlanRuns)
 
 }
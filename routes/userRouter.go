@@ -3,6 +3,7 @@ package routes
 import (
 	"github.com/GeorgiStoyanov05/GoMarket/controllers"
 	"github.com/GeorgiStoyanov05/GoMarket/middlewares"
+	"github.com/GeorgiStoyanov05/GoMarket/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,6 +13,28 @@ func UserRoutes(r *gin.Engine) {
 	r.POST("/settings/email", middlewares.AuthMiddleware(), controllers.PostChangeEmail)
 	r.GET("/settings/password", middlewares.AuthMiddleware(), controllers.GetChangePassword)
 	r.POST("/settings/password", middlewares.AuthMiddleware(), controllers.PostChangePassword)
+	r.GET("/settings/api-keys", middlewares.AuthMiddleware(), controllers.GetAPIKeys)
+	r.POST("/settings/api-keys", middlewares.AuthMiddleware(), controllers.PostCreateAPIKey)
+	r.POST("/settings/api-keys/:id/revoke", middlewares.AuthMiddleware(), controllers.PostRevokeAPIKey)
+	r.GET("/settings/connections", middlewares.AuthMiddleware(), controllers.GetConnections)
+	r.GET("/settings/export", middlewares.AuthMiddleware(), controllers.GetExportPage)
+	r.GET("/export", middlewares.AuthMiddleware(), controllers.GetExport)
+	r.GET("/settings/import", middlewares.AuthMiddleware(), controllers.GetImportTrades)
+	r.POST("/settings/import", middlewares.AuthMiddleware(), controllers.PostImportTrades)
+	r.POST("/settings/import/:id/apply", middlewares.AuthMiddleware(), controllers.PostApplyImport)
+	r.GET("/settings/statements", middlewares.AuthMiddleware(), controllers.GetStatements)
+	r.POST("/settings/statements", middlewares.AuthMiddleware(), controllers.PostGenerateStatement)
+	r.GET("/statements/:id/pdf", middlewares.AuthMiddleware(), controllers.GetStatementPDF)
+	r.GET("/settings/tax", middlewares.AuthMiddleware(), controllers.GetTaxReport)
+	r.GET("/tax/:year/:file", middlewares.AuthMiddleware(), controllers.GetTaxReportCSV)
+	r.GET("/accounts", middlewares.AuthMiddleware(), controllers.GetAccounts)
+	r.POST("/accounts", middlewares.AuthMiddleware(), controllers.PostOpenAccount)
+	r.GET("/accounts/switcher", middlewares.AuthMiddleware(), controllers.GetAccountSwitcher)
+	r.POST("/accounts/:id/switch", middlewares.AuthMiddleware(), controllers.PostSwitchAccount)
 	r.GET("/funds", middlewares.AuthMiddleware(), controllers.GetFunds)
 	r.POST("/funds", middlewares.AuthMiddleware(), controllers.PostFunds)
+	r.GET("/settings/transfers", middlewares.AuthMiddleware(), controllers.GetTransfers)
+	r.GET("/notifications", middlewares.AuthMiddleware(), controllers.GetNotifications)
+	r.GET("/notifications/badge", middlewares.AuthMiddleware(), controllers.GetNotificationBadge)
+	r.POST(services.PaymentWebhookPath+":gateway", controllers.PostPaymentWebhook)
 }
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const accountsCollection = "accounts"
+
+const (
+	// the account every user starts with, and the one old data moves into
+	defaultAccountName = "Main"
+
+	maxAccountsPerUser = 10
+	maxAccountNameLen  = 40
+)
+
+var ErrAccountNotFound = errors.New("account not found")
+
+// accountScoped are the collections whose documents belong to one account,
+// through account_id.
+var accountScoped = []string{
+	"positions",
+	"orders",
+	alertsCollection,
+	cashTransactionsCollection,
+	fundTransfersCollection,
+	snapshotsCollection,
+	plansCollection,
+	planRunsCollection,
+	targetAllocationsCollection,
+	rebalancesCollection,
+	statementsCollection,
+	tradeImportsCollection,
+}
+
+func EnsureAccountIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(accountsCollection)
+
+	// Names tell a user's accounts apart in the switcher
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+}
+
+// ListAccounts returns a user's accounts, oldest (the default one) first.
+func ListAccounts(userID primitive.ObjectID) ([]models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(accountsCollection).Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Account, 0)
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetUserAccount returns one of a user's accounts.
+func GetUserAccount(userID, accountID primitive.ObjectID) (models.Account, error) {
+	a, ok := db.GetAccount(accountID)
+	if !ok || a.UserID != userID {
+		return models.Account{}, ErrAccountNotFound
+	}
+	return a, nil
+}
+
+// ActiveAccount is the account the user trades in: the one they switched
+// to last, else their oldest. A user without accounts gets the default one.
+func ActiveAccount(user models.User) (models.Account, error) {
+	if !user.ActiveAccountID.IsZero() {
+		if a, err := GetUserAccount(user.ID, user.ActiveAccountID); err == nil {
+			return a, nil
+		}
+	}
+	accounts, err := ListAccounts(user.ID)
+	if err != nil {
+		return models.Account{}, err
+	}
+	if len(accounts) > 0 {
+		return accounts[0], nil
+	}
+	return OpenDefaultAccount(user.ID)
+}
+
+// OpenDefaultAccount opens a user's first account, an empty cash account,
+// and makes it active.
+func OpenDefaultAccount(userID primitive.ObjectID) (models.Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	a := models.Account{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      defaultAccountName,
+		Type:      models.AccountCash,
+		Currency:  AccountCurrency,
+		Balance:   decimal.Zero,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	coll := db.Client.Database("gomarket").Collection(accountsCollection)
+	if _, err := coll.InsertOne(ctx, a); err != nil {
+		// opened meanwhile by another request
+		if mongo.IsDuplicateKeyError(err) {
+			err = coll.FindOne(ctx, bson.M{"user_id": userID, "name": defaultAccountName}).Decode(&a)
+		}
+		if err != nil {
+			return models.Account{}, err
+		}
+	}
+	_, _ = db.Client.Database("gomarket").Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "active_account_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"active_account_id": a.ID}})
+	return a, nil
+}
+
+// AccountInput is the form for opening an account.
+type AccountInput struct {
+	Name     string
+	Currency string
+	// StartingCapital is moved in from FundFrom, another of the user's
+	// accounts
+	StartingCapital decimal.Decimal
+	FundFrom        primitive.ObjectID
+}
+
+// OpenAccount opens another account for the user and switches to it. New
+// accounts are cash accounts; only an admin can turn on margin
+// (AdminSetMargin).
+func OpenAccount(userID primitive.ObjectID, in AccountInput) (models.Account, map[string]string) {
+	errs := map[string]string{}
+
+	name := strings.Join(strings.Fields(in.Name), " ")
+	switch {
+	case name == "":
+		errs["name"] = "Name your account."
+	case utf8.RuneCountInString(name) > maxAccountNameLen:
+		errs["name"] = fmt.Sprintf("Use at most %d characters.", maxAccountNameLen)
+	}
+	currency := strings.ToUpper(strings.TrimSpace(in.Currency))
+	if currency == "" {
+		currency = AccountCurrency
+	}
+	// quotes are only available in the account currency
+	if currency != AccountCurrency {
+		errs["currency"] = "Accounts can only be opened in " + AccountCurrency + " for now."
+	}
+	capital := money(in.StartingCapital)
+	var source models.Account
+	switch {
+	case capital.IsNegative():
+		errs["capital"] = "Starting capital can't be negative."
+	case capital.IsPositive():
+		var err error
+		if source, err = GetUserAccount(userID, in.FundFrom); err != nil {
+			errs["fund_from"] = "Choose the account to move the starting capital from."
+		}
+	}
+	if len(errs) > 0 {
+		return models.Account{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	accounts := d.Collection(accountsCollection)
+
+	n, err := accounts.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		errs["_form"] = "Could not open the account."
+		return models.Account{}, errs
+	}
+	if n >= maxAccountsPerUser {
+		errs["_form"] = fmt.Sprintf("You can have at most %d accounts.", maxAccountsPerUser)
+		return models.Account{}, errs
+	}
+
+	now := time.Now().UTC()
+	a := models.Account{
+		ID:              primitive.NewObjectID(),
+		UserID:          userID,
+		Name:            name,
+		Type:            models.AccountCash,
+		Currency:        currency,
+		StartingCapital: capital,
+		Balance:         decimal.Zero,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if !capital.IsPositive() {
+		if _, err := accounts.InsertOne(ctx, a); err != nil {
+			return models.Account{}, openAccountInsertErrors(err)
+		}
+	} else {
+		// the capital moves in a transaction where the server has them
+		res, ok := tryOpenAccountTxn(a, source)
+		if !ok {
+			res = openAccountNoTxn(a, source)
+		}
+		if len(res) > 0 {
+			return models.Account{}, res
+		}
+		a.Balance = capital
+
+		recordCashTransaction(ctx, models.CashTransaction{
+			UserID:    userID,
+			AccountID: source.ID,
+			Type:      models.CashAccountTransfer,
+			Amount:    capital.Neg(),
+			Note:      "Starting capital for " + a.Name,
+			Ref:       a.ID,
+			CreatedAt: now,
+		})
+		recordCashTransaction(ctx, models.CashTransaction{
+			UserID:    userID,
+			AccountID: a.ID,
+			Type:      models.CashAccountTransfer,
+			Amount:    capital,
+			Note:      "Starting capital from " + source.Name,
+			CreatedAt: now,
+		})
+	}
+
+	_, _ = d.Collection("users").UpdateOne(ctx, bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"active_account_id": a.ID, "updated_at": now}})
+	return a, nil
+}
+
+func openAccountInsertErrors(err error) map[string]string {
+	if mongo.IsDuplicateKeyError(err) {
+		return map[string]string{"name": "You already have an account with that name."}
+	}
+	return map[string]string{"_form": "Could not open the account."}
+}
+
+func notEnoughCapital(source models.Account) map[string]string {
+	return map[string]string{"capital": "You don't have that much available cash in " + source.Name + "."}
+}
+
+// tryOpenAccountTxn inserts a holding its starting capital and takes that
+// from source's available cash in one transaction. ok is false when
+// transactions aren't supported.
+func tryOpenAccountTxn(a, source models.Account) (errs map[string]string, ok bool) {
+	client := db.Client
+	sess, err := client.StartSession()
+	if err != nil {
+		return nil, false
+	}
+	defer sess.EndSession(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accounts := client.Database("gomarket").Collection(accountsCollection)
+	capital := a.StartingCapital
+	a.Balance = capital
+
+	_, txnErr := sess.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		if _, err := accounts.InsertOne(sc, a); err != nil {
+			return nil, err
+		}
+		res, err := accounts.UpdateOne(sc, availableCashFilter(source.ID, capital),
+			bson.M{"$inc": bson.M{"balance": capital.Neg()}, "$set": bson.M{"updated_at": a.CreatedAt}})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+		return nil, nil
+	})
+
+	switch {
+	case txnErr == nil:
+		return nil, true
+	case txnErr == mongo.ErrNoDocuments:
+		return notEnoughCapital(source), true
+	case mongo.IsDuplicateKeyError(txnErr):
+		return openAccountInsertErrors(txnErr), true
+	}
+	// treat any other txn error as "fallback"
+	return nil, false
+}
+
+// openAccountNoTxn is the same without a transaction: the account is
+// inserted empty, source debited and the account credited, and a failed
+// step undoes the ones before it.
+func openAccountNoTxn(a, source models.Account) map[string]string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accounts := db.Client.Database("gomarket").Collection(accountsCollection)
+	capital := a.StartingCapital
+
+	if _, err := accounts.InsertOne(ctx, a); err != nil {
+		return openAccountInsertErrors(err)
+	}
+
+	res, err := accounts.UpdateOne(ctx, availableCashFilter(source.ID, capital),
+		bson.M{"$inc": bson.M{"balance": capital.Neg()}, "$set": bson.M{"updated_at": a.CreatedAt}})
+	if err != nil || res.MatchedCount == 0 {
+		_, _ = accounts.DeleteOne(ctx, bson.M{"_id": a.ID})
+		if err != nil {
+			return map[string]string{"_form": "Could not open the account."}
+		}
+		return notEnoughCapital(source)
+	}
+
+	if _, err := accounts.UpdateOne(ctx, bson.M{"_id": a.ID},
+		bson.M{"$inc": bson.M{"balance": capital}}); err != nil {
+		log.Println("accounts: starting capital for", a.ID.Hex(), err)
+		// give the capital back to source
+		if _, err := accounts.UpdateOne(ctx, bson.M{"_id": source.ID},
+			bson.M{"$inc": bson.M{"balance": capital}, "$set": bson.M{"updated_at": time.Now().UTC()}}); err != nil {
+			log.Println("accounts: returning starting capital to", source.ID.Hex(), err)
+		}
+		_, _ = accounts.DeleteOne(ctx, bson.M{"_id": a.ID})
+		return map[string]string{"_form": "Could not open the account."}
+	}
+	return nil
+}
+
+// SwitchAccount makes one of the user's accounts the active one.
+func SwitchAccount(userID, accountID primitive.ObjectID) (models.Account, error) {
+	a, err := GetUserAccount(userID, accountID)
+	if err != nil {
+		return a, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = db.Client.Database("gomarket").Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"active_account_id": a.ID, "updated_at": time.Now().UTC()}})
+	return a, err
+}
+
+// defaultAccountID is the user's oldest account.
+func defaultAccountID(ctx context.Context, userID primitive.ObjectID) (primitive.ObjectID, error) {
+	var a models.Account
+	err := db.Client.Database("gomarket").Collection(accountsCollection).FindOne(ctx,
+		bson.M{"user_id": userID},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetProjection(bson.M{"_id": 1})).Decode(&a)
+	return a.ID, err
+}
+
+var legacyUserIndexes = map[string]string{
+	"positions":                 "user_id_1_symbol_1",
+	snapshotsCollection:         "user_id_1_day_1",
+	targetAllocationsCollection: "user_id_1",
+	statementsCollection:        "user_id_1_period_1",
+}
+
+// legacyUser holds the cash fields users had before accounts.
+type legacyUser struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Balance           decimal.Decimal    `bson:"balance"`
+	Reserved          decimal.Decimal    `bson:"reserved"`
+	MarginEnabled     bool               `bson:"margin_enabled"`
+	MarginCallAt      time.Time          `bson:"margin_call_at"`
+	InterestAccruedOn string             `bson:"margin_interest_accrued_on"`
+	CreatedAt         time.Time          `bson:"created_at"`
+}
+
+// MigrateAccounts gives every user from before accounts a default account
+// holding their cash, then files their positions, orders, alerts, cash
+// history and the rest under it. It only touches what hasn't been migrated,
+// so it is safe to run on every start; it must run before the indexes that
+// are unique per account are built.
+func MigrateAccounts() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	users := d.Collection("users")
+	accounts := d.Collection(accountsCollection)
+
+	cur, err := users.Find(ctx, bson.M{"active_account_id": bson.M{"$exists": false}})
+	if err != nil {
+		log.Println("migrate accounts:", err)
+		return
+	}
+	var legacy []legacyUser
+	if err := cur.All(ctx, &legacy); err != nil {
+		log.Println("migrate accounts:", err)
+		return
+	}
+
+	for _, u := range legacy {
+		id, err := defaultAccountID(ctx, u.ID)
+		if err == mongo.ErrNoDocuments {
+			a := models.Account{
+				ID:                primitive.NewObjectID(),
+				UserID:            u.ID,
+				Name:              defaultAccountName,
+				Type:              models.AccountCash,
+				Currency:          AccountCurrency,
+				Balance:           u.Balance,
+				Reserved:          u.Reserved,
+				MarginCallAt:      u.MarginCallAt,
+				InterestAccruedOn: u.InterestAccruedOn,
+				CreatedAt:         u.CreatedAt,
+				UpdatedAt:         time.Now().UTC(),
+			}
+			if u.MarginEnabled {
+				a.Type = models.AccountMargin
+			}
+			if a.CreatedAt.IsZero() {
+				a.CreatedAt = a.UpdatedAt
+			}
+			_, err = accounts.InsertOne(ctx, a)
+			id = a.ID
+		}
+		if err != nil {
+			log.Println("migrate accounts:", u.ID.Hex(), err)
+			continue
+		}
+		if _, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{
+			"$set": bson.M{"active_account_id": id},
+			"$unset": bson.M{
+				"balance": "", "reserved": "", "margin_enabled": "",
+				"margin_call_at": "", "margin_interest_accrued_on": "",
+			},
+		}); err != nil {
+			log.Println("migrate accounts:", u.ID.Hex(), err)
+		}
+	}
+
+	// Documents from before accounts go to their user's default account
+	for _, name := range accountScoped {
+		coll := d.Collection(name)
+		owners, err := coll.Distinct(ctx, "user_id", bson.M{"account_id": bson.M{"$exists": false}})
+		if err != nil {
+			log.Println("migrate accounts:", name, err)
+			continue
+		}
+		for _, owner := range owners {
+			userID, ok := owner.(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			id, err := defaultAccountID(ctx, userID)
+			if err != nil {
+				continue
+			}
+			if _, err := coll.UpdateMany(ctx,
+				bson.M{"user_id": userID, "account_id": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"account_id": id}}); err != nil {
+				log.Println("migrate accounts:", name, err)
+			}
+		}
+	}
+
+	// Unique per user before accounts; their per-account replacements are
+	// built by the Ensure*Indexes functions
+	for name, index := range legacyUserIndexes {
+		_, _ = d.Collection(name).Indexes().DropOne(ctx, index)
+	}
+}
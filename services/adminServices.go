@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const adminActionsCollection = "admin_actions"
+
+// BootstrapAdmin promotes the account registered with ADMIN_EMAIL, so a fresh
+// deployment has someone who can promote further admins from the console.
+// It only does so while there is no admin at all, and never for a user whose
+// role an admin has changed before, so a demotion sticks across restarts.
+// ADMIN_PASSWORD, which used to make registering with the right password an
+// admin, is no longer read.
+func BootstrapAdmin() {
+	if os.Getenv("ADMIN_PASSWORD") != "" {
+		log.Println("bootstrap admin: ADMIN_PASSWORD is no longer used and can be removed; set ADMIN_EMAIL to an existing account instead")
+	}
+	email := strings.TrimSpace(os.Getenv("ADMIN_EMAIL"))
+	if email == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	coll := d.Collection("users")
+
+	n, err := coll.CountDocuments(ctx, bson.M{"role": models.RoleAdmin}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Println("bootstrap admin:", err)
+		return
+	}
+	if n > 0 {
+		return
+	}
+
+	var u models.User
+	if err := coll.FindOne(ctx, bson.M{"email": email}).Decode(&u); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("bootstrap admin:", err)
+		}
+		return
+	}
+	changed, err := d.Collection(adminActionsCollection).CountDocuments(ctx,
+		bson.M{"user_id": u.ID, "action": "role"}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Println("bootstrap admin:", err)
+		return
+	}
+	if changed > 0 {
+		log.Println("bootstrap admin: not promoting", email, "since an admin changed their role before")
+		return
+	}
+
+	res, err := coll.UpdateOne(ctx,
+		bson.M{"_id": u.ID, "role": bson.M{"$ne": models.RoleAdmin}},
+		bson.M{"$set": bson.M{"role": models.RoleAdmin, "updated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		log.Println("bootstrap admin:", err)
+		return
+	}
+	if res.ModifiedCount > 0 {
+		log.Println("bootstrap admin: promoted", email)
+	}
+}
+
+func SearchUsers(query string, limit int64) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection("users")
+
+	filter := bson.M{}
+	q := strings.TrimSpace(query)
+	if q != "" {
+		re := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"email": re},
+			bson.M{"first_name": re},
+			bson.M{"last_name": re},
+		}
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	cur, err := coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.User, 0)
+	for cur.Next(ctx) {
+		var u models.User
+		if err := cur.Decode(&u); err != nil {
+			continue
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func ListAdminActions(userID primitive.ObjectID, limit int64) ([]models.AdminAction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(adminActionsCollection)
+
+	cur, err := coll.Find(ctx, bson.M{"user_id": userID}, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.AdminAction, 0)
+	for cur.Next(ctx) {
+		var a models.AdminAction
+		if err := cur.Decode(&a); err != nil {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func recordAdminAction(ctx context.Context, a models.AdminAction) primitive.ObjectID {
+	a.ID = primitive.NewObjectID()
+	a.CreatedAt = time.Now().UTC()
+	coll := db.Client.Database("gomarket").Collection(adminActionsCollection)
+	if _, err := coll.InsertOne(ctx, a); err != nil {
+		log.Println("admin audit:", err)
+	}
+	return a.ID
+}
+
+// AdminAdjustBalance credits (positive) or debits (negative) an account's
+// cash. Every adjustment needs a reason, which is kept in the audit log.
+func AdminAdjustBalance(adminID, accountID primitive.ObjectID, amount decimal.Decimal, reason string) (models.Account, map[string]string) {
+	errs := map[string]string{}
+
+	delta := money(amount)
+	reason = strings.TrimSpace(reason)
+	if delta.IsZero() {
+		errs["amount"] = "Amount must not be zero."
+	} else if delta.Abs().GreaterThan(maxAmount) {
+		errs["amount"] = tooLarge
+	}
+	if len(reason) < 3 {
+		errs["reason"] = "Please give a reason for the adjustment."
+	}
+	if len(errs) > 0 {
+		return models.Account{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(accountsCollection)
+
+	filter := bson.M{"_id": accountID}
+	if delta.IsNegative() {
+		// never push a balance below zero
+		filter["balance"] = bson.M{"$gte": delta.Neg()}
+	}
+
+	var a models.Account
+	err := coll.FindOneAndUpdate(ctx, filter,
+		bson.M{"$inc": bson.M{"balance": delta}, "$set": bson.M{"updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		errs["amount"] = "The balance is too low for this debit."
+		return models.Account{}, errs
+	}
+	if err != nil {
+		errs["_form"] = "There was a problem updating the balance."
+		return models.Account{}, errs
+	}
+
+	actionID := recordAdminAction(ctx, models.AdminAction{
+		AdminID:   adminID,
+		UserID:    a.UserID,
+		AccountID: a.ID,
+		Action:    "balance",
+		Amount:    delta,
+		Reason:    reason,
+	})
+	recordCashTransaction(ctx, models.CashTransaction{
+		UserID:    a.UserID,
+		AccountID: a.ID,
+		Type:      models.CashAdjustment,
+		Amount:    delta,
+		Note:      reason,
+		Ref:       actionID,
+	})
+	return a, nil
+}
+
+func AdminSetDisabled(adminID, userID primitive.ObjectID, disabled bool) (models.User, map[string]string) {
+	errs := map[string]string{}
+	if adminID == userID {
+		errs["_form"] = "You can't disable your own account."
+		return models.User{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection("users")
+
+	now := time.Now().UTC()
+	update := bson.M{"$set": bson.M{"disabled": disabled, "updated_at": now}}
+	action := "enable"
+	if disabled {
+		// disabling also kills every live session
+		update["$set"].(bson.M)["disabled_at"] = now
+		update["$inc"] = bson.M{"session_version": 1}
+		action = "disable"
+	} else {
+		update["$unset"] = bson.M{"disabled_at": ""}
+	}
+
+	var u models.User
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	if err != nil {
+		errs["_form"] = "There was a problem updating the account."
+		return models.User{}, errs
+	}
+
+	recordAdminAction(ctx, models.AdminAction{AdminID: adminID, UserID: userID, Action: action})
+	return u, nil
+}
+
+// AdminForceLogout invalidates every session the user currently has.
+func AdminForceLogout(adminID, userID primitive.ObjectID) (models.User, map[string]string) {
+	errs := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection("users")
+
+	var u models.User
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"session_version": 1}, "$set": bson.M{"updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	if err != nil {
+		errs["_form"] = "There was a problem logging the user out."
+		return models.User{}, errs
+	}
+
+	recordAdminAction(ctx, models.AdminAction{AdminID: adminID, UserID: userID, Action: "logout"})
+	return u, nil
+}
+
+func AdminSetRole(adminID, userID primitive.ObjectID, role string) (models.User, map[string]string) {
+	errs := map[string]string{}
+	if role != models.RoleUser && role != models.RoleAdmin {
+		errs["role"] = "Unknown role."
+	}
+	if adminID == userID {
+		errs["_form"] = "You can't change your own role."
+	}
+	if len(errs) > 0 {
+		return models.User{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection("users")
+
+	var u models.User
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"role": role, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	if err != nil {
+		errs["_form"] = "There was a problem updating the role."
+		return models.User{}, errs
+	}
+
+	recordAdminAction(ctx, models.AdminAction{AdminID: adminID, UserID: userID, Action: "role", Role: role})
+	return u, nil
+}
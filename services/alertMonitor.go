@@ -37,15 +37,21 @@ func runAlertTick() {
 		bySymbol[a.Symbol] = append(bySymbol[a.Symbol], a)
 	}
 
+	now := time.Now()
 	for sym, group := range bySymbol {
-		price, err := FetchCurrentPrice(sym)
+		// the price can't move while its exchange is shut
+		if CalendarForSymbol(sym).Session(now) == SessionClosed {
+			continue
+		}
+		quote, err := FetchCurrentPrice(sym)
 		if err != nil {
 			continue
 		}
+		price := priceFromFloat(quote)
 
 		for _, a := range group {
-			hit := (a.Condition == "above" && price >= a.TargetPrice) ||
-				(a.Condition == "below" && price <= a.TargetPrice)
+			hit := (a.Condition == "above" && price.Cmp(a.TargetPrice) >= 0) ||
+				(a.Condition == "below" && price.Cmp(a.TargetPrice) <= 0)
 
 			if !hit {
 				continue
@@ -2,11 +2,11 @@ package services
 
 import (
 	"context"
-	"math"
 	"strings"
 	"time"
 
 	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"github.com/GeorgiStoyanov05/GoMarket/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,16 +16,12 @@ import (
 
 const alertsCollection = "alerts"
 
-func roundToCents(v float64) float64 {
-	return math.Round(v*100) / 100
-}
-
-func CreatePriceAlert(userID primitive.ObjectID, symbol, condition string, targetPrice float64) (models.PriceAlert, map[string]string) {
+func CreatePriceAlert(acct models.Account, symbol, condition string, targetPrice decimal.Decimal) (models.PriceAlert, map[string]string) {
 	errs := map[string]string{}
 
 	sym := strings.ToUpper(strings.TrimSpace(symbol))
 	cond := strings.ToLower(strings.TrimSpace(condition))
-	target := roundToCents(targetPrice)
+	target := sharePrice(targetPrice)
 
 	if sym == "" {
 		errs["symbol"] = "Missing symbol."
@@ -33,8 +29,10 @@ func CreatePriceAlert(userID primitive.ObjectID, symbol, condition string, targe
 	if cond != "above" && cond != "below" {
 		errs["condition"] = "Condition must be 'above' or 'below'."
 	}
-	if target <= 0 {
+	if !target.IsPositive() {
 		errs["targetPrice"] = "Target price must be bigger than 0."
+	} else if target.GreaterThan(maxAmount) {
+		errs["targetPrice"] = tooLarge
 	}
 	if len(errs) > 0 {
 		return models.PriceAlert{}, errs
@@ -45,10 +43,10 @@ func CreatePriceAlert(userID primitive.ObjectID, symbol, condition string, targe
 
 	coll := db.Client.Database("gomarket").Collection(alertsCollection)
 
-	// Basic idempotency: block exact duplicates (same user + symbol + condition + target, still active).
+	// Basic idempotency: block exact duplicates (same account + symbol + condition + target, still active).
 	var existing models.PriceAlert
 	err := coll.FindOne(ctx, bson.M{
-		"user_id":      userID,
+		"account_id":   acct.ID,
 		"symbol":       sym,
 		"condition":    cond,
 		"target_price": target,
@@ -66,7 +64,8 @@ func CreatePriceAlert(userID primitive.ObjectID, symbol, condition string, targe
 	}
 
 	a := models.PriceAlert{
-		UserID:      userID,
+		UserID:      acct.UserID,
+		AccountID:   acct.ID,
 		Symbol:      sym,
 		Condition:   cond,
 		TargetPrice: target,
@@ -74,7 +73,7 @@ func CreatePriceAlert(userID primitive.ObjectID, symbol, condition string, targe
 		Active:         true,
 		Triggered:      false,
 		TriggeredAt:    time.Time{},
-		TriggeredPrice: 0,
+		TriggeredPrice: decimal.Zero,
 
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
@@ -90,7 +89,7 @@ func CreatePriceAlert(userID primitive.ObjectID, symbol, condition string, targe
 	return a, nil
 }
 
-func ListPriceAlerts(userID primitive.ObjectID, symbol string) ([]models.PriceAlert, error) {
+func ListPriceAlerts(accountID primitive.ObjectID, symbol string) ([]models.PriceAlert, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
@@ -98,8 +97,8 @@ func ListPriceAlerts(userID primitive.ObjectID, symbol string) ([]models.PriceAl
 	coll := db.Client.Database("gomarket").Collection(alertsCollection)
 
 	cur, err := coll.Find(ctx, bson.M{
-		"user_id": userID,
-		"symbol":  sym,
+		"account_id": accountID,
+		"symbol":     sym,
 	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
 	if err != nil {
 		return nil, err
@@ -117,12 +116,12 @@ func ListPriceAlerts(userID primitive.ObjectID, symbol string) ([]models.PriceAl
 	return out, nil
 }
 
-func DeletePriceAlert(userID primitive.ObjectID, alertID primitive.ObjectID) error {
+func DeletePriceAlert(accountID primitive.ObjectID, alertID primitive.ObjectID) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
 	coll := db.Client.Database("gomarket").Collection(alertsCollection)
-	_, err := coll.DeleteOne(ctx, bson.M{"_id": alertID, "user_id": userID})
+	_, err := coll.DeleteOne(ctx, bson.M{"_id": alertID, "account_id": accountID})
 	return err
 }
 
@@ -154,7 +153,7 @@ func ListActiveAlerts() ([]models.PriceAlert, error) {
 	return out, nil
 }
 
-func MarkAlertTriggered(alertID primitive.ObjectID, triggerPrice float64) error {
+func MarkAlertTriggered(alertID primitive.ObjectID, triggerPrice decimal.Decimal) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
@@ -167,21 +166,21 @@ func MarkAlertTriggered(alertID primitive.ObjectID, triggerPrice float64) error
 			"active":          false,
 			"triggered":       true,
 			"triggered_at":    time.Now().UTC(),
-			"triggered_price": roundToCents(triggerPrice),
+			"triggered_price": triggerPrice,
 			"updated_at":      time.Now().UTC(),
 		}},
 	)
 	return err
 }
 
-func ListAllUserAlerts(userID primitive.ObjectID) ([]models.PriceAlert, error) {
+func ListAccountAlerts(accountID primitive.ObjectID) ([]models.PriceAlert, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
 
 	coll := db.Client.Database("gomarket").Collection(alertsCollection)
 
 	cur, err := coll.Find(ctx,
-		bson.M{"user_id": userID},
+		bson.M{"account_id": accountID},
 		options.Find().SetSort(bson.D{
 			{Key: "symbol", Value: 1},
 			{Key: "created_at", Value: -1},
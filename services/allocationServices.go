@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+)
+
+// Allocation dimensions.
+const (
+	BySector    = "sector"
+	ByIndustry  = "industry"
+	ByCountry   = "country"
+	ByAssetType = "asset_type"
+)
+
+var AllocationDimensions = []string{BySector, ByIndustry, ByCountry, ByAssetType}
+
+var ErrUnknownDimension = errors.New("unknown dimension")
+
+// AllocationSlice is one pie slice. Pct is of the invested value (cash is
+// reported separately on Allocation).
+type AllocationSlice struct {
+	Label   string          `json:"label"`
+	Value   decimal.Decimal `json:"value"`
+	Pct     float64         `json:"pct"`
+	Symbols []string        `json:"symbols"`
+}
+
+// AllocationNode is the sector -> industry -> symbol tree for a treemap.
+type AllocationNode struct {
+	Label    string           `json:"label"`
+	Value    decimal.Decimal  `json:"value"`
+	Pct      float64          `json:"pct"`
+	Children []AllocationNode `json:"children,omitempty"`
+}
+
+type AllocationHolding struct {
+	Symbol string          `json:"symbol"`
+	Qty    decimal.Decimal `json:"qty"`
+	Price  decimal.Decimal `json:"price"`
+	Value  decimal.Decimal `json:"value"`
+	Pct    float64         `json:"pct"`
+	Info   models.Symbol   `json:"profile"`
+}
+
+type Allocation struct {
+	Cash          decimal.Decimal `json:"cash"`
+	CashPct       float64         `json:"cash_pct"` // of the total account value
+	InvestedValue decimal.Decimal `json:"invested_value"`
+	ShortValue    decimal.Decimal `json:"short_value"` // owed on short positions; not in the breakdowns
+	TotalValue    decimal.Decimal `json:"total_value"`
+
+	Holdings  []AllocationHolding          `json:"holdings"`
+	Breakdown map[string][]AllocationSlice `json:"breakdown"` // keyed by dimension
+	Treemap   []AllocationNode             `json:"treemap"`
+}
+
+// PortfolioAllocation values every open position (live quote, falling back
+// to average cost) and groups the long ones by profile data. Shorts only
+// count against the total value.
+func PortfolioAllocation(acct models.Account) (Allocation, error) {
+	positions, err := ListAccountPositions(acct.ID)
+	if err != nil {
+		return Allocation{}, err
+	}
+	return buildAllocation(acct.Balance, positions)
+}
+
+func buildAllocation(cash decimal.Decimal, positions []models.Position) (Allocation, error) {
+	a := Allocation{
+		Cash:      cash,
+		Holdings:  make([]AllocationHolding, 0, len(positions)),
+		Breakdown: map[string][]AllocationSlice{},
+		Treemap:   []AllocationNode{},
+	}
+
+	for _, p := range positions {
+		price := p.AvgCost
+		if q, err := FetchCurrentPrice(p.Symbol); err == nil && q > 0 {
+			price = priceFromFloat(q)
+		}
+		if p.Qty.IsNegative() {
+			a.ShortValue = a.ShortValue.Sub(costOf(price, p.Qty))
+			continue
+		}
+		info, err := GetSymbolProfile(p.Symbol)
+		if err != nil {
+			info = unknownSymbol(p.Symbol)
+		}
+		h := AllocationHolding{
+			Symbol: p.Symbol,
+			Qty:    p.Qty,
+			Price:  price,
+			Value:  costOf(price, p.Qty),
+			Info:   info,
+		}
+		a.Holdings = append(a.Holdings, h)
+		a.InvestedValue = a.InvestedValue.Add(h.Value)
+	}
+	a.TotalValue = a.InvestedValue.Add(a.Cash).Sub(a.ShortValue)
+	a.CashPct = pctOf(a.Cash, a.TotalValue)
+
+	sort.Slice(a.Holdings, func(i, j int) bool { return a.Holdings[i].Value.GreaterThan(a.Holdings[j].Value) })
+	for i := range a.Holdings {
+		a.Holdings[i].Pct = pctOf(a.Holdings[i].Value, a.InvestedValue)
+	}
+
+	for _, dim := range AllocationDimensions {
+		a.Breakdown[dim] = groupHoldings(a.Holdings, a.InvestedValue, func(h AllocationHolding) string {
+			return allocationLabel(h.Info, dim)
+		})
+	}
+	a.Treemap = allocationTree(a.Holdings, a.InvestedValue)
+	return a, nil
+}
+
+func allocationLabel(s models.Symbol, dim string) string {
+	var v string
+	switch dim {
+	case BySector:
+		v = s.Sector
+	case ByIndustry:
+		v = s.Industry
+	case ByCountry:
+		v = s.Country
+	case ByAssetType:
+		v = s.AssetType
+	}
+	if strings.TrimSpace(v) == "" {
+		return UnknownLabel
+	}
+	return v
+}
+
+func groupHoldings(holdings []AllocationHolding, total decimal.Decimal, key func(AllocationHolding) string) []AllocationSlice {
+	idx := map[string]int{}
+	out := []AllocationSlice{}
+	for _, h := range holdings {
+		k := key(h)
+		i, ok := idx[k]
+		if !ok {
+			i = len(out)
+			idx[k] = i
+			out = append(out, AllocationSlice{Label: k, Symbols: []string{}})
+		}
+		out[i].Value = out[i].Value.Add(h.Value)
+		out[i].Symbols = append(out[i].Symbols, h.Symbol)
+	}
+	for i := range out {
+		out[i].Pct = pctOf(out[i].Value, total)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Value.GreaterThan(out[j].Value) })
+	return out
+}
+
+func allocationTree(holdings []AllocationHolding, total decimal.Decimal) []AllocationNode {
+	sectors := []AllocationNode{}
+	for _, s := range groupHoldings(holdings, total, func(h AllocationHolding) string {
+		return allocationLabel(h.Info, BySector)
+	}) {
+		node := AllocationNode{Label: s.Label, Value: s.Value, Pct: s.Pct}
+
+		var inSector []AllocationHolding
+		for _, h := range holdings {
+			if allocationLabel(h.Info, BySector) == s.Label {
+				inSector = append(inSector, h)
+			}
+		}
+		for _, ind := range groupHoldings(inSector, total, func(h AllocationHolding) string {
+			return allocationLabel(h.Info, ByIndustry)
+		}) {
+			child := AllocationNode{Label: ind.Label, Value: ind.Value, Pct: ind.Pct}
+			for _, h := range inSector {
+				if allocationLabel(h.Info, ByIndustry) == ind.Label {
+					child.Children = append(child.Children, AllocationNode{Label: h.Symbol, Value: h.Value, Pct: h.Pct})
+				}
+			}
+			node.Children = append(node.Children, child)
+		}
+		sectors = append(sectors, node)
+	}
+	return sectors
+}
+
+// pctOf is v as a percentage of total, to two decimals.
+func pctOf(v, total decimal.Decimal) float64 {
+	if !total.IsPositive() {
+		return 0
+	}
+	return roundPct(v.Float64() / total.Float64())
+}
+
+// ValidAllocationDimension normalises a dimension from a query string.
+func ValidAllocationDimension(dim string) (string, error) {
+	d := strings.ToLower(strings.TrimSpace(dim))
+	for _, v := range AllocationDimensions {
+		if v == d {
+			return d, nil
+		}
+	}
+	return "", ErrUnknownDimension
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	apiKeysCollection = "api_keys"
+	apiKeyTokenPrefix = "gmk_"
+	maxAPIKeysPerUser = 20
+)
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func EnsureAPIKeyIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys := db.Client.Database("gomarket").Collection(apiKeysCollection)
+
+	_, _ = keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	_, _ = keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+}
+
+// CreateAPIKey returns the stored key together with the plain token.
+// The token is never stored and can't be shown again.
+func CreateAPIKey(userID primitive.ObjectID, name string, scopes []string) (models.APIKey, string, map[string]string) {
+	errs := map[string]string{}
+
+	name = strings.TrimSpace(name)
+	if len(name) < 2 || len(name) > 50 {
+		errs["name"] = "Name should be between 2 and 50 characters."
+	}
+
+	clean := make([]string, 0, len(scopes))
+	for _, allowed := range models.APIKeyScopes {
+		for _, s := range scopes {
+			if s == allowed {
+				clean = append(clean, s)
+				break
+			}
+		}
+	}
+	if len(clean) == 0 {
+		errs["scopes"] = "Pick at least one scope."
+	}
+	if len(errs) > 0 {
+		return models.APIKey{}, "", errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(apiKeysCollection)
+
+	n, err := coll.CountDocuments(ctx, bson.M{"user_id": userID, "revoked": false})
+	if err != nil {
+		errs["_form"] = "Could not create the API key."
+		return models.APIKey{}, "", errs
+	}
+	if n >= maxAPIKeysPerUser {
+		errs["_form"] = "You have too many active API keys. Revoke one first."
+		return models.APIKey{}, "", errs
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		errs["_form"] = "Could not create the API key."
+		return models.APIKey{}, "", errs
+	}
+	token := apiKeyTokenPrefix + hex.EncodeToString(b)
+
+	k := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:len(apiKeyTokenPrefix)+8],
+		Hash:      hashAPIKey(token),
+		Scopes:    clean,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	res, err := coll.InsertOne(ctx, k)
+	if err != nil {
+		errs["_form"] = "Could not create the API key."
+		return models.APIKey{}, "", errs
+	}
+	k.ID = res.InsertedID.(primitive.ObjectID)
+	return k, token, nil
+}
+
+func ListAPIKeys(userID primitive.ObjectID) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(apiKeysCollection)
+
+	cur, err := coll.Find(ctx, bson.M{"user_id": userID, "revoked": false},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.APIKey, 0)
+	for cur.Next(ctx) {
+		var k models.APIKey
+		if err := cur.Decode(&k); err != nil {
+			continue
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+func RevokeAPIKey(userID, keyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(apiKeysCollection)
+	_, err := coll.UpdateOne(ctx,
+		bson.M{"_id": keyID, "user_id": userID},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// AuthenticateAPIKey resolves a bearer token to its key and owner and
+// records when and from where it was last used.
+func AuthenticateAPIKey(token, ip string) (models.APIKey, models.User, error) {
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, apiKeyTokenPrefix) {
+		return models.APIKey{}, models.User{}, ErrInvalidAPIKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(apiKeysCollection)
+
+	var k models.APIKey
+	err := coll.FindOneAndUpdate(ctx,
+		bson.M{"hash": hashAPIKey(token), "revoked": false},
+		bson.M{"$set": bson.M{"last_used_at": time.Now().UTC(), "last_used_ip": ip}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&k)
+	if err != nil {
+		return models.APIKey{}, models.User{}, ErrInvalidAPIKey
+	}
+
+	u, ok := db.GetUser(k.UserID)
+	if !ok || u.Disabled {
+		return models.APIKey{}, models.User{}, ErrInvalidAPIKey
+	}
+	return k, u, nil
+}
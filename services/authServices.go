@@ -5,6 +5,7 @@ import (
 	//"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	db "github.com/GeorgiStoyanov05/GoMarket/database"
@@ -35,20 +36,12 @@ func RegisterUser(user *models.RegisterModel) (models.User, map[string]string) {
 		return models.User{}, errs
 	}
 
-	var role string
-	if user.Email == os.Getenv("ADMIN_EMAIL") && user.Password == os.Getenv("ADMIN_PASSWORD") {
-		role = "Admin"
-	} else {
-		role = "User"
-	}
-
 	u := models.User{
 		FirstName:    user.First_Name,
 		LastName:     user.Last_Name,
 		Email:        user.Email,
 		PasswordHash: string(hash),
-		Role:         role,
-		Balance:      0,
+		Role:         models.RoleUser,
 
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
@@ -62,6 +55,9 @@ func RegisterUser(user *models.RegisterModel) (models.User, map[string]string) {
 		return models.User{}, errs
 	}
 	u.ID = res.InsertedID.(primitive.ObjectID)
+	if a, err := OpenDefaultAccount(u.ID); err == nil {
+		u.ActiveAccountID = a.ID
+	}
 	return u, nil
 }
 
@@ -87,6 +83,11 @@ func LoginUser(user *models.LoginModel) (models.User, map[string]string){
         return models.User{}, errs
     }
 
+    if u.Disabled {
+        errs["_form"] = "This account has been disabled."
+        return models.User{}, errs
+    }
+
     return u, nil
 }
 
@@ -94,19 +95,27 @@ func CreateAndSignJWT(user *models.User, ttl int64) (string,error){
 	token:=jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"userID": user.ID.Hex(),
 		"ttl":	ttl,
+		"sv":	user.SessionVersion,
 	})
 
 	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
+// CookieSecure reports whether cookies should carry the Secure flag.
+// Set COOKIE_SECURE=true when GoMarket is served over HTTPS.
+func CookieSecure() bool {
+	v, _ := strconv.ParseBool(os.Getenv("COOKIE_SECURE"))
+	return v
+}
+
 func SetCookie(c *gin.Context, token string, ttl int64){
 	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("Auth", token, int(ttl), "/", "", false, true)
+	c.SetCookie("Auth", token, int(ttl), "/", "", CookieSecure(), true)
 }
 
 func ClearAuthCookie(c *gin.Context) {
 	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("Auth", "", -1, "/", "", false, true)
+	c.SetCookie("Auth", "", -1, "/", "", CookieSecure(), true)
 }
 
 func ChangeUserEmail(oldEmail string, newEmail string) (models.User, map[string]string){
@@ -167,21 +176,3 @@ func ChangeUserPassword(id, password string) (models.User, map[string]string){
     user.PasswordHash = string(hash)
     return user, nil
 }
-
-func ChangeUserBalance(id primitive.ObjectID, change float64) (models.User, map[string]string){
-	errs := map[string]string{}
-    coll := db.Client.Database("gomarket").Collection("users")
-
-    _, err:=coll.UpdateOne(nil, bson.M{"_id": id}, bson.M{"$inc":bson.M{"balance": change}})
-    if err!=nil{
-    	errs["_form"] = "There was a problem updating the amount"
-     	return models.User{}, errs
-    }
-
-    var user models.User
-        if err := coll.FindOne(nil, bson.M{"_id": id}).Decode(&user); err != nil {
-            errs["_form"] = "Updated, but failed to load user."
-            return models.User{}, errs
-        }
-    return user, nil
-}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // sessions are kept in each exchange's time zone
+)
+
+// PrimaryExchange is the calendar for symbols without a listed suffix, and
+// the one the schedulers and the home page follow.
+const PrimaryExchange = "XNYS"
+
+// Market sessions.
+const (
+	SessionClosed  = "closed"
+	SessionPre     = "pre"
+	SessionRegular = "regular"
+	SessionPost    = "post"
+)
+
+var ErrUnknownExchange = errors.New("unknown exchange")
+
+// calendarFile is the JSON layout of calendars/<CODE>.json. Times are
+// "HH:MM" in the exchange's time zone; pre_market and after_hours are
+// optional.
+type calendarFile struct {
+	Code       string   `json:"code"`
+	Name       string   `json:"name"`
+	Timezone   string   `json:"timezone"`
+	PreMarket  string   `json:"pre_market"`
+	Open       string   `json:"open"`
+	Close      string   `json:"close"`
+	AfterHours string   `json:"after_hours"`
+	Suffixes   []string `json:"suffixes"` // symbol suffixes listed here, e.g. ".L"
+	Holidays   []struct {
+		Date string `json:"date"`
+		Name string `json:"name"`
+	} `json:"holidays"`
+	EarlyCloses []struct {
+		Date       string `json:"date"`
+		Close      string `json:"close"`
+		AfterHours string `json:"after_hours"`
+		Name       string `json:"name"`
+	} `json:"early_closes"`
+}
+
+// MarketCalendar knows an exchange's trading days and session times.
+// Weekends are always closed.
+type MarketCalendar struct {
+	Code     string
+	Name     string
+	Location *time.Location
+
+	preMarket  time.Duration // zero: no pre-market session
+	open       time.Duration // since local midnight
+	close      time.Duration
+	afterHours time.Duration // zero: no after-hours session
+
+	holidays    map[string]string // "2006-01-02" -> name
+	earlyCloses map[string]earlyClose
+}
+
+type earlyClose struct {
+	close      time.Duration
+	afterHours time.Duration
+	name       string
+}
+
+// tradingDay is one local day's sessions, as absolute times.
+type tradingDay struct {
+	trading   bool
+	holiday   string
+	early     string // early close name
+	preOpen   time.Time
+	open      time.Time
+	close     time.Time
+	postClose time.Time
+}
+
+// MarketStatus is an exchange's session at a point in time.
+type MarketStatus struct {
+	Exchange   string    `json:"exchange"`
+	Name       string    `json:"name"`
+	Session    string    `json:"session"`               // "pre" | "regular" | "post" | "closed"
+	Open       bool      `json:"open"`                  // regular session
+	Holiday    string    `json:"holiday,omitempty"`     // closed today for this holiday
+	EarlyClose string    `json:"early_close,omitempty"` // closes early today for this
+	NextOpen   time.Time `json:"next_open"`
+	NextClose  time.Time `json:"next_close"`
+}
+
+var (
+	calendarsMu     sync.RWMutex
+	calendars       = map[string]*MarketCalendar{}
+	calendarSuffix  = map[string]string{} // ".L" -> "XLON"
+	defaultCalendar = sync.OnceValue(func() *MarketCalendar {
+		// weekdays only, for when calendars/XNYS.json is missing
+		loc, _ := time.LoadLocation("America/New_York")
+		return &MarketCalendar{
+			Code: PrimaryExchange, Name: "New York Stock Exchange", Location: loc,
+			preMarket: 4 * time.Hour, open: 9*time.Hour + 30*time.Minute,
+			close: 16 * time.Hour, afterHours: 20 * time.Hour,
+		}
+	})
+)
+
+// LoadMarketCalendars reads every calendars/*.json under dir. Files that
+// don't parse are logged and skipped.
+func LoadMarketCalendars(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Println("calendars:", err)
+		return
+	}
+	for _, f := range files {
+		raw, err := os.ReadFile(f)
+		if err != nil {
+			log.Println("calendars:", err)
+			continue
+		}
+		c, suffixes, err := parseMarketCalendar(raw)
+		if err != nil {
+			log.Println("calendars:", f+":", err)
+			continue
+		}
+		RegisterMarketCalendar(c, suffixes...)
+	}
+	if _, ok := marketCalendar(PrimaryExchange); !ok {
+		log.Println("calendars: no " + PrimaryExchange + " calendar, using weekdays without holidays")
+	}
+}
+
+// RegisterMarketCalendar adds or replaces a calendar and routes symbols
+// ending in any of suffixes to it.
+func RegisterMarketCalendar(c *MarketCalendar, suffixes ...string) {
+	calendarsMu.Lock()
+	defer calendarsMu.Unlock()
+	calendars[c.Code] = c
+	for _, s := range suffixes {
+		calendarSuffix[strings.ToUpper(s)] = c.Code
+	}
+}
+
+func marketCalendar(code string) (*MarketCalendar, bool) {
+	calendarsMu.RLock()
+	defer calendarsMu.RUnlock()
+	c, ok := calendars[code]
+	return c, ok
+}
+
+// ExchangeCalendar returns the calendar for an exchange code.
+func ExchangeCalendar(code string) (*MarketCalendar, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if c, ok := marketCalendar(code); ok {
+		return c, nil
+	}
+	if code == PrimaryExchange {
+		return defaultCalendar(), nil
+	}
+	return nil, ErrUnknownExchange
+}
+
+// PrimaryCalendar is the primary exchange's calendar.
+func PrimaryCalendar() *MarketCalendar {
+	c, _ := ExchangeCalendar(PrimaryExchange)
+	return c
+}
+
+// CalendarForSymbol picks the calendar by the symbol's suffix ("VOD.L"),
+// falling back to the primary exchange.
+func CalendarForSymbol(symbol string) *MarketCalendar {
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+	if i := strings.LastIndex(sym, "."); i > 0 {
+		calendarsMu.RLock()
+		code, ok := calendarSuffix[sym[i:]]
+		calendarsMu.RUnlock()
+		if ok {
+			if c, ok := marketCalendar(code); ok {
+				return c
+			}
+		}
+	}
+	return PrimaryCalendar()
+}
+
+func parseMarketCalendar(raw []byte) (*MarketCalendar, []string, error) {
+	var f calendarFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(f.Code) == "" {
+		return nil, nil, errors.New("missing code")
+	}
+	loc, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &MarketCalendar{
+		Code:        strings.ToUpper(strings.TrimSpace(f.Code)),
+		Name:        f.Name,
+		Location:    loc,
+		holidays:    map[string]string{},
+		earlyCloses: map[string]earlyClose{},
+	}
+	var ok bool
+	if c.open, ok = parseClock(f.Open); !ok {
+		return nil, nil, errors.New("invalid open")
+	}
+	if c.close, ok = parseClock(f.Close); !ok || c.close <= c.open {
+		return nil, nil, errors.New("invalid close")
+	}
+	if f.PreMarket != "" {
+		if c.preMarket, ok = parseClock(f.PreMarket); !ok || c.preMarket >= c.open {
+			return nil, nil, errors.New("invalid pre_market")
+		}
+	}
+	if f.AfterHours != "" {
+		if c.afterHours, ok = parseClock(f.AfterHours); !ok || c.afterHours <= c.close {
+			return nil, nil, errors.New("invalid after_hours")
+		}
+	}
+
+	for _, h := range f.Holidays {
+		if _, err := time.Parse("2006-01-02", h.Date); err != nil {
+			return nil, nil, errors.New("invalid holiday " + h.Date)
+		}
+		c.holidays[h.Date] = h.Name
+	}
+	for _, e := range f.EarlyCloses {
+		if _, err := time.Parse("2006-01-02", e.Date); err != nil {
+			return nil, nil, errors.New("invalid early close " + e.Date)
+		}
+		ec := earlyClose{name: e.Name}
+		if ec.close, ok = parseClock(e.Close); !ok || ec.close <= c.open {
+			return nil, nil, errors.New("invalid early close " + e.Date)
+		}
+		if e.AfterHours != "" {
+			if ec.afterHours, ok = parseClock(e.AfterHours); !ok || ec.afterHours <= ec.close {
+				return nil, nil, errors.New("invalid early close " + e.Date)
+			}
+		}
+		c.earlyCloses[e.Date] = ec
+	}
+	return c, f.Suffixes, nil
+}
+
+// parseClock reads "HH:MM" as the time since midnight.
+func parseClock(v string) (time.Duration, bool) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+}
+
+// day returns the sessions on the local date of t.
+func (c *MarketCalendar) day(t time.Time) tradingDay {
+	local := t.In(c.Location)
+	key := local.Format("2006-01-02")
+	at := func(d time.Duration) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day(),
+			int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, c.Location)
+	}
+
+	if wd := local.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return tradingDay{}
+	}
+	if name, ok := c.holidays[key]; ok {
+		return tradingDay{holiday: name}
+	}
+
+	d := tradingDay{trading: true, open: at(c.open), close: at(c.close)}
+	d.preOpen, d.postClose = d.open, d.close
+	if c.preMarket > 0 {
+		d.preOpen = at(c.preMarket)
+	}
+	post := c.afterHours
+	if ec, ok := c.earlyCloses[key]; ok {
+		d.early = ec.name
+		if d.early == "" {
+			d.early = "Early close"
+		}
+		d.close = at(ec.close)
+		d.postClose = d.close
+		post = ec.afterHours
+	}
+	if post > 0 {
+		d.postClose = at(post)
+	}
+	return d
+}
+
+// Session is the session in progress at t.
+func (c *MarketCalendar) Session(t time.Time) string {
+	d := c.day(t)
+	switch {
+	case !d.trading:
+		return SessionClosed
+	case !t.Before(d.open) && t.Before(d.close):
+		return SessionRegular
+	case !t.Before(d.preOpen) && t.Before(d.open):
+		return SessionPre
+	case !t.Before(d.close) && t.Before(d.postClose):
+		return SessionPost
+	}
+	return SessionClosed
+}
+
+// IsOpen reports whether the regular session is in progress at t.
+func (c *MarketCalendar) IsOpen(t time.Time) bool {
+	return c.Session(t) == SessionRegular
+}
+
+// IsTradingDay reports whether the exchange trades on t's local date.
+func (c *MarketCalendar) IsTradingDay(t time.Time) bool {
+	return c.day(t).trading
+}
+
+// NextOpen is the next regular-session open after t. It is zero if there is
+// none within the next month, which only a broken calendar file causes.
+func (c *MarketCalendar) NextOpen(t time.Time) time.Time {
+	local := t.In(c.Location)
+	for i := 0; i <= 31; i++ {
+		d := c.day(local.AddDate(0, 0, i))
+		if d.trading && d.open.After(t) {
+			return d.open
+		}
+	}
+	return time.Time{}
+}
+
+// NextClose is the close of the session in progress at t, or of the next
+// one.
+func (c *MarketCalendar) NextClose(t time.Time) time.Time {
+	local := t.In(c.Location)
+	for i := 0; i <= 31; i++ {
+		d := c.day(local.AddDate(0, 0, i))
+		if d.trading && d.close.After(t) {
+			return d.close
+		}
+	}
+	return time.Time{}
+}
+
+// Status sums up the calendar at t.
+func (c *MarketCalendar) Status(t time.Time) MarketStatus {
+	d := c.day(t)
+	s := c.Session(t)
+	return MarketStatus{
+		Exchange:   c.Code,
+		Name:       c.Name,
+		Session:    s,
+		Open:       s == SessionRegular,
+		Holiday:    d.holiday,
+		EarlyClose: d.early,
+		NextOpen:   c.NextOpen(t),
+		NextClose:  c.NextClose(t),
+	}
+}
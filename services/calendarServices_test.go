@@ -0,0 +1,125 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+const testCalendarJSON = `{
+	"code": "xtst",
+	"name": "Test Exchange",
+	"timezone": "America/New_York",
+	"pre_market": "04:00",
+	"open": "09:30",
+	"close": "16:00",
+	"after_hours": "20:00",
+	"holidays": [
+		{ "date": "2026-07-03", "name": "Independence Day (observed)" }
+	],
+	"early_closes": [
+		{ "date": "2026-11-27", "close": "13:00", "after_hours": "17:00", "name": "Day after Thanksgiving" },
+		{ "date": "2026-12-24", "close": "13:00" }
+	]
+}`
+
+func testCalendar(t *testing.T) *MarketCalendar {
+	t.Helper()
+	c, _, err := parseMarketCalendar([]byte(testCalendarJSON))
+	if err != nil {
+		t.Fatalf("parse test calendar: %v", err)
+	}
+	return c
+}
+
+func TestCalendarDay(t *testing.T) {
+	c := testCalendar(t)
+	ny := c.Location
+
+	tests := []struct {
+		date    string
+		trading bool
+		holiday string
+		early   string
+		close   string // local
+		post    string
+	}{
+		{"2026-03-04", true, "", "", "16:00", "20:00"},
+		{"2026-03-07", false, "", "", "", ""}, // Saturday
+		{"2026-07-03", false, "Independence Day (observed)", "", "", ""},
+		{"2026-11-27", true, "", "Day after Thanksgiving", "13:00", "17:00"},
+		{"2026-12-24", true, "", "Early close", "13:00", "13:00"}, // no after-hours given
+	}
+	for _, tt := range tests {
+		day, _ := time.ParseInLocation("2006-01-02 15:04", tt.date+" 12:00", ny)
+		d := c.day(day)
+		if d.trading != tt.trading || d.holiday != tt.holiday || d.early != tt.early {
+			t.Errorf("%s: trading %v holiday %q early %q; want %v %q %q",
+				tt.date, d.trading, d.holiday, d.early, tt.trading, tt.holiday, tt.early)
+			continue
+		}
+		if !d.trading {
+			continue
+		}
+		if got := d.close.In(ny).Format("15:04"); got != tt.close {
+			t.Errorf("%s: close %s, want %s", tt.date, got, tt.close)
+		}
+		if got := d.postClose.In(ny).Format("15:04"); got != tt.post {
+			t.Errorf("%s: after hours end %s, want %s", tt.date, got, tt.post)
+		}
+	}
+}
+
+func TestCalendarSession(t *testing.T) {
+	c := testCalendar(t)
+
+	tests := []struct {
+		at   string // UTC
+		want string
+	}{
+		{"2026-03-04T07:59:00Z", SessionClosed}, // 02:59 EST
+		{"2026-03-04T09:00:00Z", SessionPre},
+		{"2026-03-04T14:30:00Z", SessionRegular}, // the open is inclusive
+		{"2026-03-04T20:59:59Z", SessionRegular},
+		{"2026-03-04T21:00:00Z", SessionPost}, // the close is not
+		{"2026-03-05T01:00:00Z", SessionClosed},
+		{"2026-03-07T15:00:00Z", SessionClosed}, // Saturday
+		{"2026-07-03T15:00:00Z", SessionClosed}, // holiday
+		{"2026-11-27T17:59:00Z", SessionRegular},
+		{"2026-11-27T18:00:00Z", SessionPost}, // 13:00 early close
+		{"2026-11-27T22:00:00Z", SessionClosed},
+		{"2026-12-24T18:30:00Z", SessionClosed}, // early close without after hours
+		// DST began on 2026-03-08: the open moves from 14:30 to 13:30 UTC
+		{"2026-03-06T13:45:00Z", SessionPre},
+		{"2026-03-09T13:45:00Z", SessionRegular},
+		// and ended on 2026-11-01
+		{"2026-10-30T13:45:00Z", SessionRegular},
+		{"2026-11-02T13:45:00Z", SessionPre},
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := c.Session(at); got != tt.want {
+			t.Errorf("Session(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestCalendarNextOpen(t *testing.T) {
+	c := testCalendar(t)
+
+	tests := []struct {
+		at, want string // UTC
+	}{
+		{"2026-03-04T12:00:00Z", "2026-03-04T14:30:00Z"},
+		{"2026-03-04T14:30:00Z", "2026-03-05T14:30:00Z"}, // strictly after
+		{"2026-03-06T21:00:00Z", "2026-03-09T13:30:00Z"}, // over the weekend and into DST
+		{"2026-07-02T21:00:00Z", "2026-07-06T13:30:00Z"}, // past the holiday
+		{"2026-10-30T21:00:00Z", "2026-11-02T14:30:00Z"}, // out of DST
+		{"2026-11-27T19:00:00Z", "2026-11-30T14:30:00Z"},
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := c.NextOpen(at).UTC().Format(time.RFC3339); got != tt.want {
+			t.Errorf("NextOpen(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}
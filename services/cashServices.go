@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const cashTransactionsCollection = "cash_transactions"
+
+func EnsureCashIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(cashTransactionsCollection)
+
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "ref", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"ref": bson.M{"$exists": true}}),
+	})
+}
+
+// recordCashTransaction logs an external cash flow. The balance itself has
+// already been updated by the caller, so failures are only logged.
+func recordCashTransaction(ctx context.Context, t models.CashTransaction) {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	coll := db.Client.Database("gomarket").Collection(cashTransactionsCollection)
+	if _, err := coll.InsertOne(ctx, t); err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Println("cash ledger:", err)
+	}
+}
+
+// BackfillCashTransactions copies admin balance adjustments made before the
+// ledger existed. Deposits from that time were never stored; for those users
+// the first portfolio snapshot acts as the opening value.
+func BackfillCashTransactions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	cur, err := d.Collection(adminActionsCollection).Find(ctx, bson.M{"action": "balance"})
+	if err != nil {
+		log.Println("cash ledger backfill:", err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var a models.AdminAction
+		if err := cur.Decode(&a); err != nil {
+			continue
+		}
+		accountID := a.AccountID
+		if accountID.IsZero() {
+			if accountID, err = defaultAccountID(ctx, a.UserID); err != nil {
+				continue
+			}
+		}
+		recordCashTransaction(ctx, models.CashTransaction{
+			UserID:    a.UserID,
+			AccountID: accountID,
+			Type:      models.CashAdjustment,
+			Amount:    a.Amount,
+			Note:      a.Reason,
+			Ref:       a.ID,
+			CreatedAt: a.CreatedAt,
+		})
+	}
+}
+
+// ListCashTransactions returns an account's external cash flows in (from, to],
+// oldest first. A zero from means "since the beginning". Charges (fees,
+// borrow fees and margin interest) are left out: they are part of the
+// return, not money leaving the account.
+func ListCashTransactions(accountID primitive.ObjectID, from, to time.Time) ([]models.CashTransaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(cashTransactionsCollection)
+
+	cur, err := coll.Find(ctx,
+		bson.M{
+			"account_id": accountID,
+			"type":       bson.M{"$nin": models.ChargeTypes},
+			"created_at": bson.M{"$gt": from, "$lte": to},
+		},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.CashTransaction, 0)
+	for cur.Next(ctx) {
+		var t models.CashTransaction
+		if err := cur.Decode(&t); err != nil {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Exportable datasets.
+const (
+	ExportOrders    = "orders"
+	ExportPositions = "positions"
+	ExportAlerts    = "alerts"
+	ExportCash      = "cash"
+)
+
+// Export formats.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var ExportDatasets = []string{ExportOrders, ExportPositions, ExportAlerts, ExportCash}
+var ExportFormats = []string{FormatCSV, FormatJSON}
+
+var (
+	ErrUnknownDataset = errors.New("unknown dataset")
+	ErrUnknownFormat  = errors.New("unknown format")
+)
+
+const exportBatchSize = 500
+
+// exportSpec describes one dataset. Columns are part of the file format:
+// only ever append new ones, never rename or reorder, so old files can still
+// be re-imported. The same names are used as JSON keys.
+type exportSpec struct {
+	collection string
+	timeField  string // filtered by the date range; "" exports everything
+	columns    []string
+	row        func(raw bson.Raw) ([]any, error)
+}
+
+var exportSpecs = map[string]exportSpec{
+	ExportOrders: {
+		collection: "orders",
+		timeField:  "created_at",
+		columns:    []string{"id", "created_at", "symbol", "side", "qty", "price", "total", "source", "fees"},
+		row: func(raw bson.Raw) ([]any, error) {
+			var o models.Order
+			if err := bson.Unmarshal(raw, &o); err != nil {
+				return nil, err
+			}
+			return []any{o.ID, o.CreatedAt, o.Symbol, o.Side, o.Qty, o.Price, costOf(o.Price, o.Qty), o.Source, o.Fees()}, nil
+		},
+	},
+	// Positions are the current holdings, so the range doesn't apply
+	ExportPositions: {
+		collection: "positions",
+		columns:    []string{"symbol", "qty", "avg_cost", "cost_basis", "opened_at", "updated_at"},
+		row: func(raw bson.Raw) ([]any, error) {
+			var p models.Position
+			if err := bson.Unmarshal(raw, &p); err != nil {
+				return nil, err
+			}
+			if p.Qty.IsZero() {
+				return nil, nil
+			}
+			return []any{p.Symbol, p.Qty, p.AvgCost, costOf(p.AvgCost, p.Qty), p.CreatedAt, p.UpdatedAt}, nil
+		},
+	},
+	ExportAlerts: {
+		collection: alertsCollection,
+		timeField:  "created_at",
+		columns: []string{"id", "created_at", "symbol", "condition", "target_price",
+			"active", "triggered", "triggered_at", "triggered_price"},
+		row: func(raw bson.Raw) ([]any, error) {
+			var a models.PriceAlert
+			if err := bson.Unmarshal(raw, &a); err != nil {
+				return nil, err
+			}
+			return []any{a.ID, a.CreatedAt, a.Symbol, a.Condition, a.TargetPrice,
+				a.Active, a.Triggered, a.TriggeredAt, a.TriggeredPrice}, nil
+		},
+	},
+	ExportCash: {
+		collection: cashTransactionsCollection,
+		timeField:  "created_at",
+		columns:    []string{"id", "created_at", "type", "amount", "note"},
+		row: func(raw bson.Raw) ([]any, error) {
+			var t models.CashTransaction
+			if err := bson.Unmarshal(raw, &t); err != nil {
+				return nil, err
+			}
+			return []any{t.ID, t.CreatedAt, t.Type, t.Amount, t.Note}, nil
+		},
+	},
+}
+
+// ExportColumns returns the column names of a dataset.
+func ExportColumns(dataset string) ([]string, error) {
+	spec, ok := exportSpecs[dataset]
+	if !ok {
+		return nil, ErrUnknownDataset
+	}
+	return append([]string(nil), spec.columns...), nil
+}
+
+// ParseExportRange reads an inclusive YYYY-MM-DD range. Either end may be
+// empty. The returned to is exclusive (the day after).
+func ParseExportRange(from, to string) (time.Time, time.Time, map[string]string) {
+	errs := map[string]string{}
+	var start, end time.Time
+
+	if v := strings.TrimSpace(from); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			errs["from"] = "Use the YYYY-MM-DD format."
+		}
+		start = t
+	}
+	if v := strings.TrimSpace(to); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			errs["to"] = "Use the YYYY-MM-DD format."
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+	if len(errs) == 0 && !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		errs["to"] = "The end date must not be before the start date."
+	}
+	return start, end, errs
+}
+
+// ExportFilename is the suggested download name, e.g.
+// gomarket-orders-2024-01-01_2024-12-31.csv.
+func ExportFilename(dataset, format string, from, to time.Time) string {
+	name := "gomarket-" + dataset
+	if !from.IsZero() || !to.IsZero() {
+		a, b := "start", time.Now().UTC().Format("2006-01-02")
+		if !from.IsZero() {
+			a = from.Format("2006-01-02")
+		}
+		if !to.IsZero() {
+			b = to.AddDate(0, 0, -1).Format("2006-01-02")
+		}
+		name += "-" + a + "_" + b
+	}
+	return name + "." + format
+}
+
+// ExportContentType is the MIME type for a format.
+func ExportContentType(format string) string {
+	if format == FormatJSON {
+		return "application/json; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ValidateExport checks dataset and format before anything is written.
+func ValidateExport(dataset, format string) (string, string, error) {
+	dataset = strings.ToLower(strings.TrimSpace(dataset))
+	format = strings.ToLower(strings.TrimSpace(format))
+	if _, ok := exportSpecs[dataset]; !ok {
+		return "", "", ErrUnknownDataset
+	}
+	if format != FormatCSV && format != FormatJSON {
+		return "", "", ErrUnknownFormat
+	}
+	return dataset, format, nil
+}
+
+// StreamExport writes the account's rows of a dataset to w, oldest first,
+// reading them from a cursor in batches so large histories are never held
+// in memory. CSV gets a header row; JSON is an array of objects keyed by the
+// same column names.
+func StreamExport(ctx context.Context, accountID primitive.ObjectID, dataset, format string, from, to time.Time, w io.Writer) error {
+	spec, ok := exportSpecs[dataset]
+	if !ok {
+		return ErrUnknownDataset
+	}
+
+	filter := bson.M{"account_id": accountID}
+	sortField := "_id"
+	if spec.timeField != "" {
+		sortField = spec.timeField
+		rng := bson.M{}
+		if !from.IsZero() {
+			rng["$gte"] = from
+		}
+		if !to.IsZero() {
+			rng["$lt"] = to
+		}
+		if len(rng) > 0 {
+			filter[spec.timeField] = rng
+		}
+	}
+
+	coll := db.Client.Database("gomarket").Collection(spec.collection)
+	cur, err := coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: sortField, Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(exportBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	var out rowWriter
+	switch format {
+	case FormatCSV:
+		out = newCSVRowWriter(w, spec.columns)
+	case FormatJSON:
+		out = newJSONRowWriter(w, spec.columns)
+	default:
+		return ErrUnknownFormat
+	}
+
+	if err := out.begin(); err != nil {
+		return err
+	}
+	if err := streamRows(ctx, cur, spec, out); err != nil {
+		return err
+	}
+	return out.end()
+}
+
+func streamRows(ctx context.Context, cur *mongo.Cursor, spec exportSpec, out rowWriter) error {
+	for cur.Next(ctx) {
+		vals, err := spec.row(cur.Current)
+		if err != nil {
+			return err
+		}
+		if vals == nil {
+			continue
+		}
+		if err := out.write(vals); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+type rowWriter interface {
+	begin() error
+	write(vals []any) error
+	end() error
+}
+
+type csvRowWriter struct {
+	w       *csv.Writer
+	columns []string
+	rows    int
+}
+
+func newCSVRowWriter(w io.Writer, columns []string) *csvRowWriter {
+	return &csvRowWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+func (c *csvRowWriter) begin() error {
+	return c.w.Write(c.columns)
+}
+
+func (c *csvRowWriter) write(vals []any) error {
+	rec := make([]string, len(vals))
+	for i, v := range vals {
+		rec[i] = exportText(v)
+	}
+	if err := c.w.Write(rec); err != nil {
+		return err
+	}
+	// flush now and then so the download makes progress
+	c.rows++
+	if c.rows%exportBatchSize == 0 {
+		c.w.Flush()
+		return c.w.Error()
+	}
+	return nil
+}
+
+func (c *csvRowWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonRowWriter struct {
+	w       io.Writer
+	columns []string
+	rows    int
+}
+
+func newJSONRowWriter(w io.Writer, columns []string) *jsonRowWriter {
+	return &jsonRowWriter{w: w, columns: columns}
+}
+
+func (j *jsonRowWriter) begin() error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+// write emits one object with the keys in column order (a map would sort
+// them).
+func (j *jsonRowWriter) write(vals []any) error {
+	var b strings.Builder
+	if j.rows > 0 {
+		b.WriteString(",")
+	}
+	b.WriteString("\n{")
+	for i, v := range vals {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, _ := json.Marshal(j.columns[i])
+		val, err := json.Marshal(exportJSONValue(v))
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(val)
+	}
+	b.WriteString("}")
+	j.rows++
+	_, err := io.WriteString(j.w, b.String())
+	return err
+}
+
+func (j *jsonRowWriter) end() error {
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+// exportText formats a value for CSV: RFC 3339 UTC times (empty when
+// unset), plain decimals and hex ids.
+func exportText(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case decimal.Decimal:
+		return x.String()
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		return x.UTC().Format(time.RFC3339)
+	case primitive.ObjectID:
+		return x.Hex()
+	}
+	return fmt.Sprint(v)
+}
+
+// exportJSONValue keeps numbers and booleans typed but formats times and
+// ids like the CSV does (null for an unset time).
+func exportJSONValue(v any) any {
+	switch x := v.(type) {
+	case time.Time:
+		if x.IsZero() {
+			return nil
+		}
+		return x.UTC().Format(time.RFC3339)
+	case primitive.ObjectID:
+		return x.Hex()
+	}
+	return v
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const feeSchedulesCollection = "fee_schedules"
+
+var ErrFeeScheduleNotFound = errors.New("fee schedule not found")
+
+// AccountTiers are the tiers an admin can put a user on.
+var AccountTiers = []string{models.TierStandard, models.TierPro}
+
+// defaultFeeSchedules are created when there are none yet: per-share
+// pricing with a minimum ticket, and US sell-side fees (the SEC fee on the
+// proceeds and FINRA's capped per-share fee).
+var defaultFeeSchedules = []models.FeeSchedule{
+	{
+		Name: "Standard", Tier: models.TierStandard,
+		PerShare: decimal.MustParse("0.005"), Min: decimal.MustParse("1"),
+		RegPercent: decimal.MustParse("0.00278"), RegPerShare: decimal.MustParse("0.000166"), RegPerShareMax: decimal.MustParse("8.30"),
+	},
+	{
+		Name: "Pro", Tier: models.TierPro,
+		PerShare: decimal.MustParse("0.0035"), Min: decimal.MustParse("0.35"),
+		RegPercent: decimal.MustParse("0.00278"), RegPerShare: decimal.MustParse("0.000166"), RegPerShareMax: decimal.MustParse("8.30"),
+	},
+}
+
+// FeeQuote is what a fill costs on top of the trade.
+type FeeQuote struct {
+	Commission decimal.Decimal
+	RegFee     decimal.Decimal
+}
+
+func (q FeeQuote) Total() decimal.Decimal {
+	return q.Commission.Add(q.RegFee)
+}
+
+// FeeScheduleInput is the admin form for a schedule; amounts are strings
+// as typed, empty meaning zero.
+type FeeScheduleInput struct {
+	Name           string
+	Tier           string
+	Flat           string
+	PerShare       string
+	Percent        string
+	Min            string
+	Max            string
+	RegPercent     string
+	RegPerShare    string
+	RegPerShareMax string
+}
+
+func EnsureFeeIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(feeSchedulesCollection)
+
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	// at most one default schedule per tier
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tier", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"tier": bson.M{"$type": "string"}}),
+	})
+
+	n, err := coll.CountDocuments(ctx, bson.M{})
+	if err != nil || n > 0 {
+		return
+	}
+	now := time.Now().UTC()
+	for _, s := range defaultFeeSchedules {
+		s.UpdatedAt = now
+		if _, err := coll.InsertOne(ctx, s); err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Println("fees: seed", s.Name, err)
+		}
+	}
+}
+
+// ListFeeSchedules returns every schedule by name.
+func ListFeeSchedules() ([]models.FeeSchedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(feeSchedulesCollection)
+	cur, err := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.FeeSchedule, 0)
+	for cur.Next(ctx) {
+		var s models.FeeSchedule
+		if err := cur.Decode(&s); err != nil {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// SaveFeeSchedule creates the schedule, or updates the one with the same
+// name.
+func SaveFeeSchedule(in FeeScheduleInput) (models.FeeSchedule, map[string]string) {
+	errs := map[string]string{}
+
+	s := models.FeeSchedule{Name: strings.TrimSpace(in.Name), Tier: strings.TrimSpace(in.Tier)}
+	if s.Name == "" || len(s.Name) > 40 {
+		errs["name"] = "Give the schedule a name of up to 40 characters."
+	}
+	if s.Tier != "" && !containsString(AccountTiers, s.Tier) {
+		errs["tier"] = "Unknown tier."
+	}
+	fields := []struct {
+		key string
+		raw string
+		dst *decimal.Decimal
+	}{
+		{"flat", in.Flat, &s.Flat},
+		{"per_share", in.PerShare, &s.PerShare},
+		{"percent", in.Percent, &s.Percent},
+		{"min", in.Min, &s.Min},
+		{"max", in.Max, &s.Max},
+		{"reg_percent", in.RegPercent, &s.RegPercent},
+		{"reg_per_share", in.RegPerShare, &s.RegPerShare},
+		{"reg_per_share_max", in.RegPerShareMax, &s.RegPerShareMax},
+	}
+	for _, f := range fields {
+		if strings.TrimSpace(f.raw) == "" {
+			continue
+		}
+		v, err := decimal.Parse(f.raw)
+		if err != nil || v.IsNegative() {
+			errs[f.key] = "Enter zero or a positive number."
+			continue
+		}
+		if max := feeFieldMax(f.key); v.GreaterThan(max) {
+			errs[f.key] = "Enter at most " + max.String() + "."
+			continue
+		}
+		*f.dst = v
+	}
+	if len(errs) == 0 && s.Max.IsPositive() && s.Min.GreaterThan(s.Max) {
+		errs["max"] = "The maximum can't be below the minimum."
+	}
+	if len(errs) > 0 {
+		return s, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(feeSchedulesCollection)
+	s.UpdatedAt = time.Now().UTC()
+	set := bson.M{
+		"flat": s.Flat, "per_share": s.PerShare, "percent": s.Percent, "min": s.Min, "max": s.Max,
+		"reg_percent": s.RegPercent, "reg_per_share": s.RegPerShare, "reg_per_share_max": s.RegPerShareMax,
+		"updated_at": s.UpdatedAt,
+	}
+	update := bson.M{"$set": set}
+	if s.Tier != "" {
+		set["tier"] = s.Tier
+	} else {
+		update["$unset"] = bson.M{"tier": ""}
+	}
+
+	err := coll.FindOneAndUpdate(ctx, bson.M{"name": s.Name}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&s)
+	if mongo.IsDuplicateKeyError(err) {
+		errs["tier"] = "Another schedule is already the default for this tier."
+		return s, errs
+	}
+	if err != nil {
+		errs["_form"] = "Could not save the fee schedule."
+		return s, errs
+	}
+	return s, nil
+}
+
+// feeFieldMax bounds a schedule field so computeFees stays in range for
+// any order up to maxAmount and maxOrderQty.
+func feeFieldMax(key string) decimal.Decimal {
+	switch key {
+	case "percent", "reg_percent", "per_share", "reg_per_share":
+		return decimal.New(100)
+	}
+	return maxAmount
+}
+
+// DeleteFeeSchedule removes a schedule. Users it was set on fall back to
+// their tier's default.
+func DeleteFeeSchedule(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	res, err := d.Collection(feeSchedulesCollection).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrFeeScheduleNotFound
+	}
+	_, err = d.Collection("users").UpdateMany(ctx, bson.M{"fee_schedule_id": id},
+		bson.M{"$unset": bson.M{"fee_schedule_id": ""}})
+	return err
+}
+
+// AdminSetFees puts a user on a tier and, optionally, a schedule of its own
+// (nil id: the tier's default).
+func AdminSetFees(adminID, userID primitive.ObjectID, tier string, scheduleID primitive.ObjectID) (models.User, map[string]string) {
+	errs := map[string]string{}
+	if !containsString(AccountTiers, tier) {
+		errs["tier"] = "Unknown tier."
+		return models.User{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	reason := tier
+	update := bson.M{"$set": bson.M{"tier": tier, "updated_at": time.Now().UTC()}}
+	if scheduleID.IsZero() {
+		update["$unset"] = bson.M{"fee_schedule_id": ""}
+	} else {
+		var s models.FeeSchedule
+		if err := d.Collection(feeSchedulesCollection).FindOne(ctx, bson.M{"_id": scheduleID}).Decode(&s); err != nil {
+			errs["fee_schedule"] = "Unknown fee schedule."
+			return models.User{}, errs
+		}
+		update["$set"].(bson.M)["fee_schedule_id"] = scheduleID
+		reason += ", " + s.Name
+	}
+
+	var u models.User
+	err := d.Collection("users").FindOneAndUpdate(ctx, bson.M{"_id": userID}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&u)
+	if err != nil {
+		errs["_form"] = "There was a problem updating the fees."
+		return models.User{}, errs
+	}
+
+	recordAdminAction(ctx, models.AdminAction{AdminID: adminID, UserID: userID, Action: "fees", Reason: reason})
+	return u, nil
+}
+
+// FeeScheduleFor is the schedule a user's fills are priced with: their own
+// if an admin set one, otherwise their tier's default. ok is false when
+// neither exists, and fills are free.
+func FeeScheduleFor(u models.User) (models.FeeSchedule, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(feeSchedulesCollection)
+
+	var s models.FeeSchedule
+	if !u.FeeScheduleID.IsZero() {
+		if err := coll.FindOne(ctx, bson.M{"_id": u.FeeScheduleID}).Decode(&s); err == nil {
+			return s, true
+		}
+	}
+	if err := coll.FindOne(ctx, bson.M{"tier": u.AccountTier()}).Decode(&s); err == nil {
+		return s, true
+	}
+	return models.FeeSchedule{}, false
+}
+
+// accountFeeSchedule is the schedule of the account's owner; a missing
+// owner trades free here and fails later where the balance is checked.
+func accountFeeSchedule(acct models.Account) (models.FeeSchedule, bool) {
+	u, ok := db.GetUser(acct.UserID)
+	if !ok {
+		return models.FeeSchedule{}, false
+	}
+	return FeeScheduleFor(u)
+}
+
+// computeFees prices a fill of qty at price on schedule s. Sell fees never
+// exceed the proceeds.
+func computeFees(s models.FeeSchedule, side string, qty, price decimal.Decimal) FeeQuote {
+	value := price.Mul(qty)
+	hundred := decimal.New(100)
+
+	c := s.Flat.Add(s.PerShare.Mul(qty)).Add(value.Mul(s.Percent).Div(hundred))
+	c = decimal.Max(c, s.Min)
+	if s.Max.IsPositive() {
+		c = decimal.Min(c, s.Max)
+	}
+	q := FeeQuote{Commission: money(c)}
+
+	if side == "sell" {
+		perShare := s.RegPerShare.Mul(qty)
+		if s.RegPerShareMax.IsPositive() {
+			perShare = decimal.Min(perShare, s.RegPerShareMax)
+		}
+		q.RegFee = money(value.Mul(s.RegPercent).Div(hundred).Add(perShare))
+
+		proceeds := money(value)
+		if q.Total().GreaterThan(proceeds) {
+			q.Commission = decimal.Min(q.Commission, proceeds)
+			q.RegFee = proceeds.Sub(q.Commission)
+		}
+	}
+	return q
+}
+
+// recordFeeCash puts a fill's fees in the cash history.
+func recordFeeCash(ctx context.Context, o models.Order) {
+	fees := o.Fees()
+	if !fees.IsPositive() {
+		return
+	}
+	recordCashTransaction(ctx, models.CashTransaction{
+		UserID:    o.UserID,
+		AccountID: o.AccountID,
+		Type:      models.CashFee,
+		Amount:    fees.Neg(),
+		Note:      fmt.Sprintf("Fees on %s %s %s", o.Side, o.Qty, o.Symbol),
+		Ref:       o.ID,
+		CreatedAt: o.CreatedAt,
+	})
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+)
+
+func TestComputeFees(t *testing.T) {
+	d := decimal.MustParse
+	perShare := models.FeeSchedule{PerShare: d("0.005"), Min: d("1"), Max: d("10")}
+	percent := models.FeeSchedule{Percent: d("0.1"), Min: d("0.99")}
+	withReg := models.FeeSchedule{
+		Flat:           d("2"),
+		RegPercent:     d("0.00278"),
+		RegPerShare:    d("0.000166"),
+		RegPerShareMax: d("8.30"),
+	}
+
+	tests := []struct {
+		name       string
+		s          models.FeeSchedule
+		side       string
+		qty, price string
+		commission string
+		regFee     string
+	}{
+		{"free", models.FeeSchedule{}, "buy", "10", "100", "0", "0"},
+		{"per share", perShare, "buy", "1000", "10", "5", "0"},
+		{"per share min", perShare, "buy", "10", "10", "1", "0"},
+		{"per share max", perShare, "buy", "5000", "10", "10", "0"},
+		{"percent", percent, "buy", "10", "150", "1.5", "0"},
+		{"percent min", percent, "buy", "1", "50", "0.99", "0"},
+		{"percent rounds to cents", percent, "buy", "1", "1234.56", "1.23", "0"},
+		{"percent rounds half up", percent, "buy", "1", "1005", "1.01", "0"},
+		{"no reg fee on buys", withReg, "buy", "100", "50", "2", "0"},
+		{"reg fee on sells", withReg, "sell", "100", "50", "2", "0.16"},
+		{"reg per share cap", withReg, "sell", "100000", "1", "2", "11.08"},
+		{"sell capped at proceeds", withReg, "sell", "1", "0.5", "0.5", "0"},
+		{"reg fee fills the rest", models.FeeSchedule{Flat: d("1"), RegPerShare: d("1")}, "sell", "2", "1", "1", "1"},
+		{"fractional qty", perShare, "sell", "0.25", "400", "1", "0"},
+	}
+	for _, tt := range tests {
+		q := computeFees(tt.s, tt.side, d(tt.qty), d(tt.price))
+		if q.Commission.String() != tt.commission || q.RegFee.String() != tt.regFee {
+			t.Errorf("%s: commission %s, reg fee %s; want %s, %s",
+				tt.name, q.Commission, q.RegFee, tt.commission, tt.regFee)
+		}
+		if tt.side == "sell" && q.Total().GreaterThan(costOf(d(tt.price), d(tt.qty))) {
+			t.Errorf("%s: fees %s above the proceeds", tt.name, q.Total())
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+)
+
+var ErrMarketClosed = errors.New("market closed")
+
+// FillRequest is a market order about to be filled against a quote.
+type FillRequest struct {
+	Symbol string
+	Side   string // "buy" | "sell"
+	Qty    decimal.Decimal
+	Quote  Quote
+	Time   time.Time
+}
+
+// FillModel decides when market orders fill and at what price. The app
+// uses the one named by FILL_MODEL; tests can register a deterministic one.
+type FillModel interface {
+	Name() string
+	// Open reports whether market orders in symbol fill at t. An empty
+	// symbol means the primary exchange.
+	Open(symbol string, t time.Time) bool
+	// Price is the fill price for r, rounded to pricePlaces.
+	Price(r FillRequest) (decimal.Decimal, error)
+}
+
+var (
+	fillModelsMu sync.RWMutex
+	fillModels   = map[string]FillModel{}
+
+	// built on first use, once .env has been loaded
+	marketFillModel = sync.OnceValue(func() FillModel {
+		return NewMarketFillModel(CurrentFillConfig())
+	})
+)
+
+// RegisterFillModel adds a model, or replaces a built-in one ("market",
+// "last") of the same name.
+func RegisterFillModel(m FillModel) {
+	fillModelsMu.Lock()
+	defer fillModelsMu.Unlock()
+	fillModels[m.Name()] = m
+}
+
+// FillModelName reads FILL_MODEL; empty means "market".
+func FillModelName() string {
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("FILL_MODEL"))); v != "" {
+		return v
+	}
+	return "market"
+}
+
+// ActiveFillModel is the configured model. Unknown names get the market
+// model.
+func ActiveFillModel() FillModel {
+	name := FillModelName()
+	fillModelsMu.RLock()
+	m, ok := fillModels[name]
+	fillModelsMu.RUnlock()
+	if ok {
+		return m
+	}
+	if name == "last" {
+		return LastPriceFillModel{}
+	}
+	return marketFillModel()
+}
+
+// MarketOpen reports whether market orders on the primary exchange fill
+// at t.
+func MarketOpen(t time.Time) bool {
+	return ActiveFillModel().Open("", t)
+}
+
+// marketsOpen reports whether market orders on every one of symbols fill
+// at t.
+func marketsOpen(symbols []string, t time.Time) bool {
+	m := ActiveFillModel()
+	for _, sym := range symbols {
+		if !m.Open(sym, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// marketFill quotes sym and prices a market order of qty on it. Orders by
+// amount pass a zero qty and are sized from the quote.
+func marketFill(sym, side string, qty, amount decimal.Decimal) (decimal.Decimal, map[string]string) {
+	m := ActiveFillModel()
+	now := time.Now().UTC()
+	if !m.Open(sym, now) {
+		return decimal.Zero, map[string]string{"_form": "The market is closed. Market orders fill during regular trading hours."}
+	}
+
+	q, err := FetchQuote(sym)
+	if err != nil || q.Current <= 0 {
+		return decimal.Zero, map[string]string{"_form": "Could not fetch current price."}
+	}
+	if qty.IsZero() {
+		last, err := decimal.FromFloat(q.Current)
+		if err == nil {
+			qty, err = amount.CheckedDiv(last)
+		}
+		if err != nil {
+			return decimal.Zero, map[string]string{"amount": tooLarge}
+		}
+	}
+	price, err := m.Price(FillRequest{Symbol: sym, Side: side, Qty: qty, Quote: q, Time: now})
+	if err == ErrMarketClosed {
+		return decimal.Zero, map[string]string{"_form": "The market is closed. Market orders fill during regular trading hours."}
+	}
+	if err != nil || !price.IsPositive() {
+		return decimal.Zero, map[string]string{"_form": "Could not fetch current price."}
+	}
+	return price, nil
+}
+
+// LastPriceFillModel fills at the last trade price at any hour. It is
+// deterministic, which makes it the one to use in tests.
+type LastPriceFillModel struct{}
+
+func (LastPriceFillModel) Name() string                { return "last" }
+func (LastPriceFillModel) Open(string, time.Time) bool { return true }
+
+func (LastPriceFillModel) Price(r FillRequest) (decimal.Decimal, error) {
+	return priceFromFloat(r.Quote.Current), nil
+}
+
+// FillConfig tunes the market model. Spreads and slippage are in basis
+// points of the price.
+type FillConfig struct {
+	SpreadBps      float64 // synthetic bid/ask spread when no real one is available
+	ImpactBps      float64 // slippage on an order worth ImpactNotional
+	ImpactNotional float64
+	MaxSlippageBps float64
+}
+
+const (
+	defaultSpreadBps      = 10
+	defaultImpactBps      = 5
+	defaultImpactNotional = 100000
+	defaultMaxSlippageBps = 100
+)
+
+// CurrentFillConfig reads FILL_SPREAD_BPS, FILL_IMPACT_BPS,
+// FILL_IMPACT_NOTIONAL and FILL_MAX_SLIPPAGE_BPS.
+func CurrentFillConfig() FillConfig {
+	return FillConfig{
+		SpreadBps:      fillEnv("FILL_SPREAD_BPS", defaultSpreadBps),
+		ImpactBps:      fillEnv("FILL_IMPACT_BPS", defaultImpactBps),
+		ImpactNotional: fillEnv("FILL_IMPACT_NOTIONAL", defaultImpactNotional),
+		MaxSlippageBps: fillEnv("FILL_MAX_SLIPPAGE_BPS", defaultMaxSlippageBps),
+	}
+}
+
+func fillEnv(name string, def float64) float64 {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		log.Println("fills: invalid " + name + ", using default")
+		return def
+	}
+	return f
+}
+
+// MarketFillModel fills buys at the ask and sells at the bid, then moves the
+// price against the order by a slippage that grows with the square root of
+// its size. It only fills during the regular session of the symbol's
+// exchange.
+type MarketFillModel struct {
+	Config FillConfig
+	// BidAsk looks up the real spread; nil or !ok uses the synthetic one.
+	BidAsk func(symbol string) (bid, ask float64, ok bool)
+}
+
+func NewMarketFillModel(c FillConfig) *MarketFillModel {
+	return &MarketFillModel{Config: c, BidAsk: FetchBidAsk}
+}
+
+func (m *MarketFillModel) Name() string { return "market" }
+
+func (m *MarketFillModel) Open(symbol string, t time.Time) bool {
+	return CalendarForSymbol(symbol).IsOpen(t)
+}
+
+func (m *MarketFillModel) Price(r FillRequest) (decimal.Decimal, error) {
+	if !m.Open(r.Symbol, r.Time) {
+		return decimal.Zero, ErrMarketClosed
+	}
+
+	bid, ask, ok := 0.0, 0.0, false
+	if m.BidAsk != nil {
+		bid, ask, ok = m.BidAsk(r.Symbol)
+	}
+	if !ok {
+		half := r.Quote.Current * m.Config.SpreadBps / 2 / 10000
+		bid, ask = r.Quote.Current-half, r.Quote.Current+half
+	}
+
+	base := ask
+	if r.Side == "sell" {
+		base = bid
+	}
+	slip := m.slippageBps(base*r.Qty.Float64()) / 10000
+	if r.Side == "sell" {
+		slip = -slip
+	}
+	return priceFromFloat(base * (1 + slip)), nil
+}
+
+// slippageBps is the price impact of an order worth value.
+func (m *MarketFillModel) slippageBps(value float64) float64 {
+	c := m.Config
+	if c.ImpactNotional <= 0 || value <= 0 {
+		return 0
+	}
+	return math.Min(c.ImpactBps*math.Sqrt(value/c.ImpactNotional), c.MaxSlippageBps)
+}
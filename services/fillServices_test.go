@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+)
+
+// a Wednesday, 10:00 in New York
+var fillOpen = time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC)
+
+func TestLastPriceFillModel(t *testing.T) {
+	tests := []struct {
+		side  string
+		quote float64
+		at    time.Time
+		want  string
+	}{
+		{"buy", 187.23, fillOpen, "187.23"},
+		{"sell", 187.23, fillOpen, "187.23"},
+		{"buy", 0.0042, fillOpen, "0.0042"},
+		{"buy", 50, time.Date(2026, 3, 7, 15, 0, 0, 0, time.UTC), "50"}, // Saturday
+	}
+	m := LastPriceFillModel{}
+	for _, tt := range tests {
+		if !m.Open("ACME", tt.at) {
+			t.Errorf("%s @ %v: closed at %s", tt.side, tt.quote, tt.at)
+		}
+		got, err := m.Price(FillRequest{Symbol: "ACME", Side: tt.side, Qty: decimal.New(1000000), Quote: Quote{Current: tt.quote}, Time: tt.at})
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s @ %v: got %s, %v; want %s", tt.side, tt.quote, got, err, tt.want)
+		}
+	}
+}
+
+func TestMarketFillModel(t *testing.T) {
+	spread := FillConfig{SpreadBps: 10}
+	impact := FillConfig{ImpactBps: 5, ImpactNotional: 100000, MaxSlippageBps: 20}
+	bidAsk := func(string) (float64, float64, bool) { return 99.9, 100.2, true }
+	noBidAsk := func(string) (float64, float64, bool) { return 0, 0, false }
+
+	tests := []struct {
+		name   string
+		c      FillConfig
+		bidAsk func(string) (float64, float64, bool)
+		side   string
+		qty    string
+		quote  float64
+		want   string
+	}{
+		{"synthetic spread buys at the ask", spread, nil, "buy", "1", 100, "100.05"},
+		{"synthetic spread sells at the bid", spread, nil, "sell", "1", 100, "99.95"},
+		{"no real spread falls back", spread, noBidAsk, "buy", "1", 100, "100.05"},
+		{"real spread buy", spread, bidAsk, "buy", "1", 100, "100.2"},
+		{"real spread sell", spread, bidAsk, "sell", "1", 100, "99.9"},
+		{"slippage at the impact notional", impact, nil, "buy", "1000", 100, "100.05"},
+		{"slippage against a sell", impact, nil, "sell", "1000", 100, "99.95"},
+		{"four times the size, twice the slippage", impact, nil, "buy", "4000", 100, "100.1"},
+		{"slippage is capped", impact, nil, "buy", "1000000", 100, "100.2"},
+		{"capped on sells too", impact, nil, "sell", "1000000", 100, "99.8"},
+		{"sub-dollar price", FillConfig{}, nil, "buy", "1000", 0.0042, "0.0042"},
+	}
+	for _, tt := range tests {
+		m := &MarketFillModel{Config: tt.c, BidAsk: tt.bidAsk}
+		got, err := m.Price(FillRequest{Symbol: "ACME", Side: tt.side, Qty: decimal.MustParse(tt.qty), Quote: Quote{Current: tt.quote}, Time: fillOpen})
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s: got %s, %v; want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMarketFillModelHours(t *testing.T) {
+	tests := []struct {
+		at   string // UTC
+		open bool
+	}{
+		{"2026-03-04T14:29:00Z", false}, // pre-market
+		{"2026-03-04T14:30:00Z", true},
+		{"2026-03-04T20:59:00Z", true},
+		{"2026-03-04T21:00:00Z", false}, // after hours
+		{"2026-03-07T15:00:00Z", false}, // Saturday
+	}
+	m := &MarketFillModel{Config: FillConfig{SpreadBps: 10}}
+	for _, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := m.Open("ACME", at); got != tt.open {
+			t.Errorf("Open(%s) = %v, want %v", tt.at, got, tt.open)
+		}
+		price, err := m.Price(FillRequest{Symbol: "ACME", Side: "buy", Qty: decimal.New(1), Quote: Quote{Current: 100}, Time: at})
+		if tt.open && (err != nil || !price.IsPositive()) {
+			t.Errorf("Price at %s: %s, %v", tt.at, price, err)
+		}
+		if !tt.open && err != ErrMarketClosed {
+			t.Errorf("Price at %s: err %v, want ErrMarketClosed", tt.at, err)
+		}
+	}
+}
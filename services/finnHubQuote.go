@@ -4,45 +4,130 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type finnhubQuoteResp struct {
-	Current float64 `json:"c"`
+	Current   float64 `json:"c"`
+	Change    float64 `json:"d"`
+	ChangePct float64 `json:"dp"`
+	High      float64 `json:"h"`
+	Low       float64 `json:"l"`
+	Open      float64 `json:"o"`
+	PrevClose float64 `json:"pc"`
+	Time      int64   `json:"t"`
 }
 
-func FetchCurrentPrice(symbol string) (float64, error) {
+type Quote struct {
+	Symbol    string    `json:"symbol"`
+	Current   float64   `json:"current"`
+	Change    float64   `json:"change"`
+	ChangePct float64   `json:"change_pct"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Open      float64   `json:"open"`
+	PrevClose float64   `json:"prev_close"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func FetchQuote(symbol string) (Quote, error) {
 	token := os.Getenv("FINNHUB_API_KEY")
 	if token == "" {
-		return 0, errors.New("FINNHUB_API_KEY missing")
+		return Quote{}, errors.New("FINNHUB_API_KEY missing")
 	}
 
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
 	client := &http.Client{Timeout: 6 * time.Second}
 
 	req, err := http.NewRequest("GET",
-		"https://finnhub.io/api/v1/quote?symbol="+symbol+"&token="+token, nil)
+		"https://finnhub.io/api/v1/quote?symbol="+url.QueryEscape(sym)+"&token="+token, nil)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return 0, errors.New("quote request failed")
+		return Quote{}, errors.New("quote request failed")
 	}
 
 	var q finnhubQuoteResp
 	if err := json.NewDecoder(resp.Body).Decode(&q); err != nil {
-		return 0, err
+		return Quote{}, err
 	}
 	if q.Current <= 0 {
-		return 0, errors.New("invalid quote price")
+		return Quote{}, errors.New("invalid quote price")
 	}
 
+	return Quote{
+		Symbol:    sym,
+		Current:   q.Current,
+		Change:    q.Change,
+		ChangePct: q.ChangePct,
+		High:      q.High,
+		Low:       q.Low,
+		Open:      q.Open,
+		PrevClose: q.PrevClose,
+		Timestamp: time.Unix(q.Time, 0).UTC(),
+	}, nil
+}
+
+func FetchCurrentPrice(symbol string) (float64, error) {
+	q, err := FetchQuote(symbol)
+	if err != nil {
+		return 0, err
+	}
 	return q.Current, nil
 }
+
+type finnhubBidAskResp struct {
+	Ask float64 `json:"a"`
+	Bid float64 `json:"b"`
+}
+
+// bidAskUnavailable is set once Finnhub refuses the bid/ask endpoint (it
+// needs a paid plan), so later fills don't wait on a request that will fail.
+var bidAskUnavailable atomic.Bool
+
+// FetchBidAsk returns the last bid and ask for a symbol. ok is false when
+// they aren't available.
+func FetchBidAsk(symbol string) (bid, ask float64, ok bool) {
+	token := os.Getenv("FINNHUB_API_KEY")
+	if token == "" || bidAskUnavailable.Load() {
+		return 0, 0, false
+	}
+
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+	client := &http.Client{Timeout: 3 * time.Second}
+
+	resp, err := client.Get("https://finnhub.io/api/v1/stock/bidask?symbol=" + url.QueryEscape(sym) + "&token=" + token)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		bidAskUnavailable.Store(true)
+		return 0, 0, false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, 0, false
+	}
+
+	var q finnhubBidAskResp
+	if err := json.NewDecoder(resp.Body).Decode(&q); err != nil {
+		return 0, 0, false
+	}
+	if q.Bid <= 0 || q.Ask < q.Bid {
+		return 0, 0, false
+	}
+	return q.Bid, q.Ask, true
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+)
+
+type finnhubCandleResp struct {
+	Close  []float64 `json:"c"`
+	Time   []int64   `json:"t"`
+	Status string    `json:"s"` // "ok" | "no_data"
+}
+
+// FetchDailyCandles loads daily closes between from and to from Finnhub.
+func FetchDailyCandles(symbol string, from, to time.Time) ([]models.DailyPrice, error) {
+	token := os.Getenv("FINNHUB_API_KEY")
+	if token == "" {
+		return nil, errors.New("FINNHUB_API_KEY missing")
+	}
+
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+	client := &http.Client{Timeout: 8 * time.Second}
+
+	q := url.Values{}
+	q.Set("symbol", sym)
+	q.Set("resolution", "D")
+	q.Set("from", strconv.FormatInt(from.Unix(), 10))
+	q.Set("to", strconv.FormatInt(to.Unix(), 10))
+	q.Set("token", token)
+
+	resp, err := client.Get("https://finnhub.io/api/v1/stock/candle?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("finnhub candles failed: status %d", resp.StatusCode)
+	}
+
+	var c finnhubCandleResp
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return nil, err
+	}
+	if c.Status == "no_data" {
+		return []models.DailyPrice{}, nil
+	}
+	if c.Status != "ok" || len(c.Close) != len(c.Time) {
+		return nil, errors.New("finnhub candles: unexpected response")
+	}
+
+	out := make([]models.DailyPrice, 0, len(c.Close))
+	for i := range c.Close {
+		t := time.Unix(c.Time[i], 0).UTC()
+		day := t.Format("2006-01-02")
+		date, _ := time.Parse("2006-01-02", day)
+		out = append(out, models.DailyPrice{Symbol: sym, Day: day, Date: date, Close: c.Close[i]})
+	}
+	return out, nil
+}
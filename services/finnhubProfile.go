@@ -0,0 +1,51 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type FinnhubProfile struct {
+	Country         string  `json:"country"`
+	Currency        string  `json:"currency"`
+	Exchange        string  `json:"exchange"`
+	FinnhubIndustry string  `json:"finnhubIndustry"`
+	MarketCap       float64 `json:"marketCapitalization"` // millions
+	Name            string  `json:"name"`
+	Ticker          string  `json:"ticker"`
+	Logo            string  `json:"logo"`
+}
+
+// FetchCompanyProfile loads Finnhub's company profile. Funds and ETFs
+// usually come back empty, which is not an error.
+func FetchCompanyProfile(symbol string) (FinnhubProfile, error) {
+	token := os.Getenv("FINNHUB_API_KEY")
+	if token == "" {
+		return FinnhubProfile{}, errors.New("FINNHUB_API_KEY missing")
+	}
+
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+	client := &http.Client{Timeout: 6 * time.Second}
+
+	resp, err := client.Get("https://finnhub.io/api/v1/stock/profile2?symbol=" + url.QueryEscape(sym) + "&token=" + url.QueryEscape(token))
+	if err != nil {
+		return FinnhubProfile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return FinnhubProfile{}, fmt.Errorf("finnhub profile failed: status %d", resp.StatusCode)
+	}
+
+	var p FinnhubProfile
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return FinnhubProfile{}, err
+	}
+	return p, nil
+}
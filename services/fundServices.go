@@ -0,0 +1,527 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	fundTransfersCollection = "fund_transfers"
+	// one counter per user, direction and UTC day for the daily limit
+	transferDaysCollection = "transfer_days"
+)
+
+var (
+	defaultMaxTransfer       = decimal.New(50000)
+	defaultDailyTransfer     = decimal.New(100000)
+	defaultApprovalThreshold = decimal.New(10000)
+)
+
+var ErrTransferNotPending = errors.New("transfer is not pending")
+
+// FundLimits bound what a user can move. Zero means no limit; with a zero
+// ApprovalThreshold every transfer completes at once.
+type FundLimits struct {
+	PerTransfer       decimal.Decimal `json:"per_transfer"`
+	Daily             decimal.Decimal `json:"daily"` // per direction and UTC day
+	ApprovalThreshold decimal.Decimal `json:"approval_threshold"`
+}
+
+// CurrentFundLimits reads FUNDS_MAX_TRANSFER, FUNDS_DAILY_LIMIT and
+// FUNDS_APPROVAL_THRESHOLD.
+func CurrentFundLimits() FundLimits {
+	return FundLimits{
+		PerTransfer:       fundLimitEnv("FUNDS_MAX_TRANSFER", defaultMaxTransfer),
+		Daily:             fundLimitEnv("FUNDS_DAILY_LIMIT", defaultDailyTransfer),
+		ApprovalThreshold: fundLimitEnv("FUNDS_APPROVAL_THRESHOLD", defaultApprovalThreshold),
+	}
+}
+
+func fundLimitEnv(name string, def decimal.Decimal) decimal.Decimal {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	d, err := decimal.Parse(v)
+	if err != nil || d.IsNegative() || d.GreaterThan(maxAmount) {
+		log.Println("funds: invalid " + name + ", using default")
+		return def
+	}
+	return money(d)
+}
+
+func EnsureFundIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(fundTransfersCollection)
+
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+
+	// Day counters are only needed for their day
+	_, _ = db.Client.Database("gomarket").Collection(transferDaysCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+// BackfillFundTransfers turns deposits and withdrawals made before
+// transfers existed into completed transfers (reusing the ledger entry's id),
+// so the history looks the same for old and new money.
+func BackfillFundTransfers() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	cur, err := d.Collection(cashTransactionsCollection).Find(ctx, bson.M{
+		"type": bson.M{"$in": bson.A{models.CashDeposit, models.CashWithdrawal}},
+		"ref":  bson.M{"$exists": false},
+	})
+	if err != nil {
+		log.Println("funds backfill:", err)
+		return
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var ct models.CashTransaction
+		if err := cur.Decode(&ct); err != nil {
+			continue
+		}
+		t := models.FundTransfer{
+			ID:          ct.ID,
+			UserID:      ct.UserID,
+			AccountID:   ct.AccountID,
+			Type:        models.TransferDeposit,
+			Amount:      ct.Amount,
+			Status:      models.TransferCompleted,
+			CreatedAt:   ct.CreatedAt,
+			CompletedAt: ct.CreatedAt,
+		}
+		if ct.Amount.IsNegative() {
+			t.Type = models.TransferWithdrawal
+			t.Amount = ct.Amount.Neg()
+		}
+		if _, err := d.Collection(fundTransfersCollection).InsertOne(ctx, t); err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Println("funds backfill:", err)
+			continue
+		}
+		_, _ = d.Collection(cashTransactionsCollection).UpdateOne(ctx,
+			bson.M{"_id": ct.ID}, bson.M{"$set": bson.M{"ref": t.ID}})
+	}
+}
+
+// availableCashFilter matches an account whose unreserved cash covers
+// amount.
+func availableCashFilter(accountID primitive.ObjectID, amount decimal.Decimal) bson.M {
+	return bson.M{
+		"_id": accountID,
+		"$expr": bson.M{"$gte": bson.A{
+			bson.M{"$subtract": bson.A{"$balance", bson.M{"$ifNull": bson.A{"$reserved", 0}}}},
+			amount,
+		}},
+	}
+}
+
+// RequestTransfer starts a deposit or withdrawal. Withdrawals can never take
+// more than the available cash, nor the margin that positions need.
+// Amounts above the approval threshold stay
+// pending until an admin decides (a withdrawal's amount is reserved
+// meanwhile); the rest complete immediately. With a payment gateway, a
+// deposit waits for its payment instead and the user is sent to
+// CheckoutURL. The daily limit counts all of the user's accounts, and is
+// claimed before any money moves (reserveDailyTransfer).
+func RequestTransfer(acct models.Account, typ string, amount decimal.Decimal) (models.FundTransfer, map[string]string) {
+	errs := map[string]string{}
+	limits := CurrentFundLimits()
+
+	amount = money(amount)
+	if typ != models.TransferDeposit && typ != models.TransferWithdrawal {
+		errs["type"] = "Choose a deposit or a withdrawal."
+	}
+	switch {
+	case !amount.IsPositive():
+		errs["amount"] = "Amount must be bigger than zero!"
+	case limits.PerTransfer.IsPositive() && amount.GreaterThan(limits.PerTransfer):
+		errs["amount"] = "The most you can move at once is " + formatAmount(limits.PerTransfer) + "."
+	case amount.GreaterThan(maxAmount):
+		errs["amount"] = tooLarge
+	}
+	if len(errs) > 0 {
+		return models.FundTransfer{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+
+	if typ == models.TransferWithdrawal {
+		if left, ok := marginWithdrawable(acct.ID); ok && amount.GreaterThan(left) {
+			errs["amount"] = "Your positions need the rest of your equity as margin; you can withdraw up to " + formatAmount(decimal.Max(decimal.Zero, left)) + "."
+			return models.FundTransfer{}, errs
+		}
+	}
+
+	now := time.Now().UTC()
+	t := models.FundTransfer{
+		ID:        primitive.NewObjectID(),
+		UserID:    acct.UserID,
+		AccountID: acct.ID,
+		Type:      typ,
+		Amount:    amount,
+		Status:    models.TransferCompleted,
+		CreatedAt: now,
+	}
+	if limits.ApprovalThreshold.IsPositive() && amount.GreaterThan(limits.ApprovalThreshold) {
+		t.Status = models.TransferPending
+	} else {
+		t.CompletedAt = now
+	}
+
+	reserved := false
+	if limits.Daily.IsPositive() {
+		left, ok, err := reserveDailyTransfer(ctx, t, limits.Daily)
+		if err != nil {
+			errs["_form"] = "Could not check your daily limit."
+			return models.FundTransfer{}, errs
+		}
+		if !ok {
+			errs["amount"] = "This exceeds your daily limit; " + formatAmount(left) + " left today."
+			return models.FundTransfer{}, errs
+		}
+		reserved = true
+	}
+	// anything that stops the transfer below gives its amount back to the
+	// day's limit
+	fail := func(field, msg string) (models.FundTransfer, map[string]string) {
+		if reserved {
+			releaseDailyTransfer(ctx, t)
+		}
+		return models.FundTransfer{}, map[string]string{field: msg}
+	}
+
+	if g, ok := ActivePaymentGateway(); ok && typ == models.TransferDeposit {
+		if err := startGatewayDeposit(ctx, g, &t); err != nil {
+			return fail("_form", "The payment provider is not available right now.")
+		}
+	}
+
+	// Move (or reserve) the money first, so a failed insert can be undone
+	var undo bson.M
+	accounts := d.Collection(accountsCollection)
+	switch {
+	case typ == models.TransferWithdrawal:
+		field := "balance"
+		if t.Status == models.TransferPending {
+			field = "reserved"
+		}
+		inc := amount
+		if field == "balance" {
+			inc = amount.Neg()
+		}
+		res, err := accounts.UpdateOne(ctx, availableCashFilter(acct.ID, amount),
+			bson.M{"$inc": bson.M{field: inc}, "$set": bson.M{"updated_at": now}})
+		if err != nil {
+			return fail("_form", "There was a problem updating the amount")
+		}
+		if res.MatchedCount == 0 {
+			return fail("amount", "You can't withdraw more than your available cash.")
+		}
+		undo = bson.M{"$inc": bson.M{field: inc.Neg()}}
+	case t.Status == models.TransferCompleted:
+		if _, err := accounts.UpdateOne(ctx, bson.M{"_id": acct.ID},
+			bson.M{"$inc": bson.M{"balance": amount}, "$set": bson.M{"updated_at": now}}); err != nil {
+			return fail("_form", "There was a problem updating the amount")
+		}
+		undo = bson.M{"$inc": bson.M{"balance": amount.Neg()}}
+	}
+
+	if _, err := d.Collection(fundTransfersCollection).InsertOne(ctx, t); err != nil {
+		if undo != nil {
+			if _, uerr := accounts.UpdateOne(ctx, bson.M{"_id": acct.ID}, undo); uerr != nil {
+				log.Println("funds: could not undo balance change:", uerr)
+			}
+		}
+		return fail("_form", "There was a problem saving the transfer.")
+	}
+
+	if t.Status == models.TransferCompleted {
+		recordTransferCash(ctx, t)
+	}
+	return t, nil
+}
+
+// transferDayKey names the counter of the user's transfers of one
+// direction on t's UTC day.
+func transferDayKey(userID primitive.ObjectID, typ string, t time.Time) string {
+	return userID.Hex() + ":" + typ + ":" + t.UTC().Format("2006-01-02")
+}
+
+// reserveDailyTransfer counts t toward its day's limit, or reports how much
+// is left if it doesn't fit. The counter is only raised while it stays
+// within limit, in one update, so two requests at once can't both slip
+// under it.
+func reserveDailyTransfer(ctx context.Context, t models.FundTransfer, limit decimal.Decimal) (decimal.Decimal, bool, error) {
+	coll := db.Client.Database("gomarket").Collection(transferDaysCollection)
+	key := transferDayKey(t.UserID, t.Type, t.CreatedAt)
+
+	// the day's first transfer starts the counter from the transfers
+	// already made, e.g. before an upgrade
+	var day struct {
+		Total decimal.Decimal `bson:"total"`
+	}
+	err := coll.FindOne(ctx, bson.M{"_id": key}).Decode(&day)
+	if err == mongo.ErrNoDocuments {
+		sum, err := transferredToday(ctx, t.UserID, t.Type)
+		if err != nil {
+			return decimal.Zero, false, err
+		}
+		_, err = coll.InsertOne(ctx, bson.M{"_id": key, "total": sum, "expires_at": t.CreatedAt.Add(48 * time.Hour)})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return decimal.Zero, false, err
+		}
+	} else if err != nil {
+		return decimal.Zero, false, err
+	}
+
+	res, err := coll.UpdateOne(ctx,
+		bson.M{"_id": key, "total": bson.M{"$lte": limit.Sub(t.Amount)}},
+		bson.M{"$inc": bson.M{"total": t.Amount}})
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+	if res.MatchedCount == 0 {
+		_ = coll.FindOne(ctx, bson.M{"_id": key}).Decode(&day)
+		return decimal.Max(decimal.Zero, limit.Sub(day.Total)), false, nil
+	}
+	return decimal.Zero, true, nil
+}
+
+// releaseDailyTransfer gives a transfer that didn't go through, or was
+// rejected, back to its day's limit.
+func releaseDailyTransfer(ctx context.Context, t models.FundTransfer) {
+	if _, err := db.Client.Database("gomarket").Collection(transferDaysCollection).UpdateOne(ctx,
+		bson.M{"_id": transferDayKey(t.UserID, t.Type, t.CreatedAt)},
+		bson.M{"$inc": bson.M{"total": t.Amount.Neg()}}); err != nil {
+		log.Println("funds: release daily limit", t.ID.Hex(), err)
+	}
+}
+
+// transferredToday sums the user's non-rejected transfers of one direction
+// since UTC midnight.
+func transferredToday(ctx context.Context, userID primitive.ObjectID, typ string) (decimal.Decimal, error) {
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	cur, err := db.Client.Database("gomarket").Collection(fundTransfersCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"user_id":    userID,
+			"type":       typ,
+			"status":     bson.M{"$ne": models.TransferRejected},
+			"created_at": bson.M{"$gte": midnight},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer cur.Close(ctx)
+
+	var rows []struct {
+		Total decimal.Decimal `bson:"total"`
+	}
+	if err := cur.All(ctx, &rows); err != nil || len(rows) == 0 {
+		return decimal.Zero, err
+	}
+	return rows[0].Total, nil
+}
+
+// recordTransferCash puts a completed transfer in the cash ledger.
+func recordTransferCash(ctx context.Context, t models.FundTransfer) {
+	ct := models.CashTransaction{
+		UserID:    t.UserID,
+		AccountID: t.AccountID,
+		Type:      models.CashDeposit,
+		Amount:    t.Amount,
+		Ref:       t.ID,
+		CreatedAt: t.CompletedAt,
+	}
+	if t.Type == models.TransferWithdrawal {
+		ct.Type = models.CashWithdrawal
+		ct.Amount = t.Amount.Neg()
+	}
+	recordCashTransaction(ctx, ct)
+}
+
+// ListFundTransfers returns an account's transfers, newest first.
+func ListFundTransfers(accountID primitive.ObjectID, limit int64) ([]models.FundTransfer, error) {
+	return findFundTransfers(bson.M{"account_id": accountID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit))
+}
+
+// ListPendingTransfers returns transfers waiting for approval, oldest first.
+func ListPendingTransfers(limit int64) ([]models.FundTransfer, error) {
+	return findFundTransfers(bson.M{"status": models.TransferPending},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit))
+}
+
+func findFundTransfers(filter bson.M, opts *options.FindOptions) ([]models.FundTransfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(fundTransfersCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.FundTransfer, 0)
+	for cur.Next(ctx) {
+		var t models.FundTransfer
+		if err := cur.Decode(&t); err != nil {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// AdminApproveTransfer completes a pending transfer: a deposit is credited,
+// a withdrawal leaves the balance together with its reservation. A deposit
+// paid through a gateway can only be approved once the payment settled.
+func AdminApproveTransfer(adminID, transferID primitive.ObjectID) (models.FundTransfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": transferID, "$or": bson.A{
+		bson.M{"gateway": bson.M{"$exists": false}},
+		bson.M{"payment_status": models.PaymentSucceeded},
+	}}
+	var current models.FundTransfer
+	if err := db.Client.Database("gomarket").Collection(fundTransfersCollection).FindOne(ctx,
+		bson.M{"_id": transferID}).Decode(&current); err == nil &&
+		current.Status == models.TransferPending && current.Gateway != "" &&
+		current.PaymentStatus != models.PaymentSucceeded {
+		return current, ErrPaymentNotSettled
+	}
+
+	now := time.Now().UTC()
+	t, err := claimPendingTransfer(ctx, filter, bson.M{
+		"status":       models.TransferCompleted,
+		"reviewed_by":  adminID,
+		"completed_at": now,
+	})
+	if err != nil {
+		return t, err
+	}
+
+	inc := bson.M{"balance": t.Amount}
+	if t.Type == models.TransferWithdrawal {
+		inc = bson.M{"balance": t.Amount.Neg(), "reserved": t.Amount.Neg()}
+	}
+	if _, err := db.Client.Database("gomarket").Collection(accountsCollection).UpdateOne(ctx,
+		bson.M{"_id": t.AccountID}, bson.M{"$inc": inc, "$set": bson.M{"updated_at": now}}); err != nil {
+		log.Println("funds: approve", t.ID.Hex(), err)
+		return t, err
+	}
+
+	recordTransferCash(ctx, t)
+	recordAdminAction(ctx, models.AdminAction{
+		AdminID:   adminID,
+		UserID:    t.UserID,
+		AccountID: t.AccountID,
+		Action:    "transfer_approve",
+		Amount:    t.Amount,
+		Reason:    t.Type,
+	})
+	return t, nil
+}
+
+// AdminRejectTransfer declines a pending transfer and releases a
+// withdrawal's reservation. A deposit that was already paid through a
+// gateway is refunded.
+func AdminRejectTransfer(adminID, transferID primitive.ObjectID, reason string) (models.FundTransfer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	reason = strings.TrimSpace(reason)
+	now := time.Now().UTC()
+	set := bson.M{
+		"status":      models.TransferRejected,
+		"reviewed_by": adminID,
+		"rejected_at": now,
+	}
+	if reason != "" {
+		set["review_reason"] = reason
+	}
+	t, err := claimPendingTransfer(ctx, bson.M{"_id": transferID}, set)
+	if err != nil {
+		return t, err
+	}
+	releaseDailyTransfer(ctx, t)
+
+	if t.Gateway != "" && t.PaymentStatus == models.PaymentSucceeded {
+		if g, ok := paymentGateway(t.Gateway); ok {
+			if err := g.Refund(ctx, t.PaymentID); err != nil {
+				log.Println("payments: refund", t.PaymentID, err)
+			}
+		} else {
+			log.Println("payments: cannot refund", t.PaymentID, "- gateway", t.Gateway, "not available")
+		}
+	}
+
+	if t.Type == models.TransferWithdrawal {
+		if _, err := db.Client.Database("gomarket").Collection(accountsCollection).UpdateOne(ctx,
+			bson.M{"_id": t.AccountID},
+			bson.M{"$inc": bson.M{"reserved": t.Amount.Neg()}, "$set": bson.M{"updated_at": now}}); err != nil {
+			log.Println("funds: release reservation", t.ID.Hex(), err)
+			return t, err
+		}
+	}
+
+	recordAdminAction(ctx, models.AdminAction{
+		AdminID:   adminID,
+		UserID:    t.UserID,
+		AccountID: t.AccountID,
+		Action:    "transfer_reject",
+		Amount:    t.Amount,
+		Reason:    strings.TrimSpace(t.Type + " " + reason),
+	})
+	return t, nil
+}
+
+// claimPendingTransfer moves a pending transfer matching filter out of
+// pending exactly once, so two admins (or an admin and a webhook) deciding
+// at the same time can't both apply it.
+func claimPendingTransfer(ctx context.Context, filter bson.M, set bson.M) (models.FundTransfer, error) {
+	filter["status"] = models.TransferPending
+
+	var t models.FundTransfer
+	err := db.Client.Database("gomarket").Collection(fundTransfersCollection).FindOneAndUpdate(ctx,
+		filter,
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return t, ErrTransferNotPending
+	}
+	return t, err
+}
@@ -0,0 +1,653 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	tradeImportsCollection = "trade_imports"
+
+	// unconfirmed previews are dropped after a day
+	importPreviewTTL = 24 * time.Hour
+
+	MaxImportRows = 5000
+
+	// how far down the file to look for the header row (some brokers put
+	// account details above it)
+	importHeaderScan = 20
+
+	LayoutCustom = "custom"
+)
+
+var ErrImportNotFound = errors.New("import not found")
+
+// set by replayImport, so they are recomputed on every replay
+const (
+	errImportOversold = "Sells more shares than were held at the time."
+	errImportTooLarge = "Takes the position or the net cost past what can be held."
+)
+
+// ImportLayout is a known broker export format.
+type ImportLayout struct {
+	Key     string
+	Name    string
+	Mapping models.ImportMapping
+}
+
+var ImportLayouts = []ImportLayout{
+	{Key: "generic", Name: "Generic (date, symbol, side, quantity, price)",
+		Mapping: models.ImportMapping{Date: "date", Symbol: "symbol", Side: "side", Qty: "quantity", Price: "price"}},
+	{Key: "ibkr", Name: "Interactive Brokers (trades)",
+		Mapping: models.ImportMapping{Date: "Date/Time", Symbol: "Symbol", Qty: "Quantity", Price: "T. Price"}},
+	{Key: "schwab", Name: "Charles Schwab",
+		Mapping: models.ImportMapping{Date: "Date", Symbol: "Symbol", Side: "Action", Qty: "Quantity", Price: "Price"}},
+	{Key: "fidelity", Name: "Fidelity",
+		Mapping: models.ImportMapping{Date: "Run Date", Symbol: "Symbol", Side: "Action", Qty: "Quantity", Price: "Price ($)"}},
+	{Key: "robinhood", Name: "Robinhood",
+		Mapping: models.ImportMapping{Date: "Activity Date", Symbol: "Instrument", Side: "Trans Code", Qty: "Quantity", Price: "Price"}},
+	{Key: LayoutCustom, Name: "Custom column mapping"},
+}
+
+// US brokers write month/day.
+var importDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02, 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006/01/02",
+	"01/02/2006",
+	"1/2/2006",
+	"01/02/06",
+	"1/2/06",
+	"01/02/2006 15:04:05",
+}
+
+// ImportHolding is a position as it will look after the import.
+type ImportHolding struct {
+	Symbol  string
+	Qty     decimal.Decimal
+	AvgCost decimal.Decimal
+	Change  decimal.Decimal // shares added (or removed) by the import
+}
+
+type ImportSummary struct {
+	Trades   int
+	Skipped  int
+	Errors   int
+	NetCost  decimal.Decimal // bought minus sold, at the imported prices
+	Holdings []ImportHolding
+}
+
+func EnsureImportIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(tradeImportsCollection)
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	// Applied imports have no expires_at and are kept
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+func GetImportLayout(key string) (ImportLayout, bool) {
+	for _, l := range ImportLayouts {
+		if l.Key == key {
+			return l, true
+		}
+	}
+	return ImportLayout{}, false
+}
+
+// PreviewTradeImport parses an uploaded file, checks every row (symbol
+// lookup, enough shares for each sell) and stores the result so the user can
+// review it before anything is written to their account.
+func PreviewTradeImport(acct models.Account, filename, layout string, custom models.ImportMapping, r io.Reader) (models.TradeImport, ImportSummary, map[string]string) {
+	errs := map[string]string{}
+
+	l, ok := GetImportLayout(layout)
+	if !ok {
+		errs["layout"] = "Choose a file layout."
+		return models.TradeImport{}, ImportSummary{}, errs
+	}
+	mapping := l.Mapping
+	if l.Key == LayoutCustom {
+		mapping = trimMapping(custom)
+		if mapping.Date == "" {
+			errs["map_date"] = "Required."
+		}
+		if mapping.Symbol == "" {
+			errs["map_symbol"] = "Required."
+		}
+		if mapping.Qty == "" {
+			errs["map_qty"] = "Required."
+		}
+		if mapping.Price == "" {
+			errs["map_price"] = "Required."
+		}
+		if len(errs) > 0 {
+			return models.TradeImport{}, ImportSummary{}, errs
+		}
+	}
+
+	trades, err := parseImportCSV(r, mapping)
+	if err != nil {
+		errs["file"] = err.Error()
+		return models.TradeImport{}, ImportSummary{}, errs
+	}
+
+	validateImportSymbols(trades)
+
+	start, err := currentHoldings(acct.ID)
+	if err != nil {
+		errs["_form"] = "Could not load your positions."
+		return models.TradeImport{}, ImportSummary{}, errs
+	}
+	summary := replayImport(trades, start)
+	if summary.Trades == 0 {
+		errs["file"] = "No importable trades were found in this file."
+	}
+
+	now := time.Now().UTC()
+	imp := models.TradeImport{
+		ID:        primitive.NewObjectID(),
+		UserID:    acct.UserID,
+		AccountID: acct.ID,
+		Filename:  filename,
+		Layout:    l.Key,
+		Mapping:   mapping,
+		Trades:    trades,
+		Status:    models.ImportPreview,
+		CreatedAt: now,
+		ExpiresAt: now.Add(importPreviewTTL),
+	}
+	if len(errs) > 0 {
+		return imp, summary, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	if _, err := db.Client.Database("gomarket").Collection(tradeImportsCollection).InsertOne(ctx, imp); err != nil {
+		errs["_form"] = "Could not save the preview."
+		return imp, summary, errs
+	}
+	return imp, summary, nil
+}
+
+// ApplyTradeImport replays a previewed import as historical orders and
+// rebuilds the affected positions. Prices come from the file, never from
+// live quotes, and the cash balance is left alone: the shares were paid for
+// at the old broker. The value brought in is logged as a transfer so
+// performance figures don't count it as a gain.
+func ApplyTradeImport(acct models.Account, importID primitive.ObjectID) (ImportSummary, map[string]string) {
+	errs := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	imports := d.Collection(tradeImportsCollection)
+
+	// claim the preview so a double submit can't apply it twice
+	var imp models.TradeImport
+	err := imports.FindOneAndUpdate(ctx,
+		bson.M{"_id": importID, "account_id": acct.ID, "status": models.ImportPreview},
+		bson.M{"$set": bson.M{"status": models.ImportApplying}},
+	).Decode(&imp)
+	if err == mongo.ErrNoDocuments {
+		errs["_form"] = "This import was already applied or has expired. Upload the file again."
+		return ImportSummary{}, errs
+	}
+	if err != nil {
+		errs["_form"] = "Database error."
+		return ImportSummary{}, errs
+	}
+	release := func() {
+		_, _ = imports.UpdateOne(ctx, bson.M{"_id": imp.ID}, bson.M{"$set": bson.M{"status": models.ImportPreview}})
+	}
+
+	// positions may have changed since the preview; check again
+	start, err := currentHoldings(acct.ID)
+	if err != nil {
+		release()
+		errs["_form"] = "Could not load your positions."
+		return ImportSummary{}, errs
+	}
+	summary := replayImport(imp.Trades, start)
+	if summary.Trades == 0 {
+		release()
+		errs["_form"] = "None of the trades can be imported any more."
+		return summary, errs
+	}
+
+	orders := make([]any, 0, summary.Trades)
+	firstTrade := map[string]time.Time{}
+	for _, t := range imp.Trades {
+		if t.Skipped || t.Error != "" {
+			continue
+		}
+		orders = append(orders, models.Order{
+			UserID:    acct.UserID,
+			AccountID: acct.ID,
+			Symbol:    t.Symbol,
+			Side:      t.Side,
+			Qty:       t.Qty,
+			Price:     t.Price,
+			Source:    models.OrderSourceImport,
+			ImportID:  imp.ID,
+			CreatedAt: t.Date,
+		})
+		if f, ok := firstTrade[t.Symbol]; !ok || t.Date.Before(f) {
+			firstTrade[t.Symbol] = t.Date
+		}
+	}
+	if _, err := d.Collection("orders").InsertMany(ctx, orders); err != nil {
+		release()
+		errs["_form"] = "Could not save the imported orders."
+		return summary, errs
+	}
+
+	now := time.Now().UTC()
+	posColl := d.Collection("positions")
+	for _, h := range summary.Holdings {
+		if h.Qty.IsZero() {
+			_, err = posColl.DeleteOne(ctx, bson.M{"account_id": acct.ID, "symbol": h.Symbol})
+		} else {
+			update := bson.M{
+				"$set": bson.M{"qty": h.Qty, "avg_cost": h.AvgCost, "updated_at": now},
+				"$setOnInsert": bson.M{
+					"user_id":    acct.UserID,
+					"account_id": acct.ID,
+					"symbol":     h.Symbol,
+					"created_at": firstTrade[h.Symbol],
+				},
+			}
+			// bought back a short: its borrow fees stop
+			if h.Qty.IsPositive() {
+				update["$unset"] = bson.M{"borrow_accrued_on": ""}
+			}
+			_, err = posColl.UpdateOne(ctx,
+				bson.M{"account_id": acct.ID, "symbol": h.Symbol}, update,
+				options.Update().SetUpsert(true),
+			)
+		}
+		if err != nil {
+			// orders are in; the position can be fixed by hand from them
+			log.Println("import: position", h.Symbol, err)
+			errs["_form"] = "Orders were imported, but some positions could not be updated."
+		}
+	}
+
+	recordCashTransaction(ctx, models.CashTransaction{
+		UserID:    acct.UserID,
+		AccountID: acct.ID,
+		Type:      models.CashTransfer,
+		Amount:    summary.NetCost,
+		Note:      fmt.Sprintf("Imported %d trades from %s", summary.Trades, imp.Filename),
+		Ref:       imp.ID,
+		CreatedAt: now,
+	})
+
+	_, _ = imports.UpdateOne(ctx, bson.M{"_id": imp.ID}, bson.M{
+		"$set":   bson.M{"status": models.ImportApplied, "applied_at": now, "trades": imp.Trades},
+		"$unset": bson.M{"expires_at": ""},
+	})
+
+	if len(errs) > 0 {
+		return summary, errs
+	}
+	return summary, nil
+}
+
+// GetTradeImport loads one of the account's imports.
+func GetTradeImport(accountID, importID primitive.ObjectID) (models.TradeImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var imp models.TradeImport
+	err := db.Client.Database("gomarket").Collection(tradeImportsCollection).
+		FindOne(ctx, bson.M{"_id": importID, "account_id": accountID}).Decode(&imp)
+	if err == mongo.ErrNoDocuments {
+		return models.TradeImport{}, ErrImportNotFound
+	}
+	return imp, err
+}
+
+func trimMapping(m models.ImportMapping) models.ImportMapping {
+	return models.ImportMapping{
+		Date:   strings.TrimSpace(m.Date),
+		Symbol: strings.TrimSpace(m.Symbol),
+		Side:   strings.TrimSpace(m.Side),
+		Qty:    strings.TrimSpace(m.Qty),
+		Price:  strings.TrimSpace(m.Price),
+	}
+}
+
+// parseImportCSV finds the header row and turns each following row into a
+// trade. Problems with a single row are recorded on it; only an unreadable
+// file or a missing header is an error.
+func parseImportCSV(r io.Reader, m models.ImportMapping) ([]models.ImportedTrade, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	want := []string{m.Date, m.Symbol, m.Qty, m.Price}
+	if m.Side != "" {
+		want = append(want, m.Side)
+	}
+
+	var cols map[string]int
+	line := 0
+	for cols == nil {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("Couldn't find the columns %s in the file.", strings.Join(want, ", "))
+		}
+		if err != nil {
+			return nil, errors.New("The file is not a valid CSV.")
+		}
+		line++
+		if line > importHeaderScan {
+			return nil, fmt.Errorf("Couldn't find the columns %s in the file.", strings.Join(want, ", "))
+		}
+		cols = matchHeader(rec, want)
+	}
+
+	trades := []models.ImportedTrade{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			trades = append(trades, models.ImportedTrade{Row: line, Error: "Unreadable line."})
+			continue
+		}
+		if isBlankRecord(rec) {
+			continue
+		}
+		if len(trades) >= MaxImportRows {
+			return nil, fmt.Errorf("Files are limited to %d rows.", MaxImportRows)
+		}
+		trades = append(trades, parseImportRow(line, rec, cols, m))
+	}
+	return trades, nil
+}
+
+func matchHeader(rec []string, want []string) map[string]int {
+	idx := map[string]int{}
+	for i, h := range rec {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if _, dup := idx[h]; !dup {
+			idx[h] = i
+		}
+	}
+	cols := map[string]int{}
+	for _, w := range want {
+		i, ok := idx[strings.ToLower(w)]
+		if !ok {
+			return nil
+		}
+		cols[w] = i
+	}
+	return cols
+}
+
+func isBlankRecord(rec []string) bool {
+	for _, v := range rec {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseImportRow(line int, rec []string, cols map[string]int, m models.ImportMapping) models.ImportedTrade {
+	field := func(name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	t := models.ImportedTrade{Row: line, Symbol: strings.ToUpper(field(m.Symbol))}
+
+	qty, qtyErr := parseImportNumber(field(m.Qty))
+
+	if m.Side != "" {
+		t.Side = parseImportSide(field(m.Side))
+	} else if qtyErr == nil && qty.IsNegative() {
+		t.Side = "sell"
+	} else if qtyErr == nil && qty.IsPositive() {
+		t.Side = "buy"
+	}
+	// dividends, fees, transfers, summary lines...
+	if t.Symbol == "" || t.Side == "" || field(m.Date) == "" {
+		t.Skipped = true
+		return t
+	}
+
+	date, err := parseImportDate(field(m.Date))
+	if err != nil {
+		t.Error = "Unrecognised date."
+		return t
+	}
+	t.Date = date
+
+	if qtyErr != nil || qty.IsZero() {
+		t.Error = "Invalid quantity."
+		return t
+	}
+	t.Qty = qty.Abs()
+	if msg := checkQty(t.Qty); msg != "" {
+		t.Error = msg
+		return t
+	}
+
+	price, err := parseImportNumber(field(m.Price))
+	if err != nil || !price.IsPositive() {
+		t.Error = "Invalid price."
+		return t
+	}
+	t.Price = sharePrice(price)
+	if _, ok := orderValue(t.Price, t.Qty); !ok {
+		t.Error = "Trades are limited to " + maxAmount.StringFixed(2) + "."
+		return t
+	}
+
+	if t.Date.After(time.Now().UTC()) {
+		t.Error = "Date is in the future."
+	}
+	return t
+}
+
+func parseImportSide(v string) string {
+	s := strings.ToLower(v)
+	switch {
+	case s == "buy", s == "b", s == "bot", strings.HasPrefix(s, "buy "), strings.Contains(s, "bought"), strings.Contains(s, "reinvest"):
+		return "buy"
+	case s == "sell", s == "s", s == "sld", strings.HasPrefix(s, "sell "), strings.Contains(s, "sold"):
+		return "sell"
+	}
+	return ""
+}
+
+// parseImportNumber accepts "$1,234.50", "(12)" and "-12".
+func parseImportNumber(v string) (decimal.Decimal, error) {
+	s := strings.TrimSpace(v)
+	neg := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		neg = true
+		s = s[1 : len(s)-1]
+	}
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(s)
+	d, err := decimal.Parse(s)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if neg {
+		d = d.Neg()
+	}
+	return d, nil
+}
+
+func parseImportDate(v string) (time.Time, error) {
+	s := strings.TrimSpace(v)
+	// Schwab: "01/02/2024 as of 12/29/2023"
+	if i := strings.Index(strings.ToLower(s), " as of "); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognised date")
+}
+
+// validateImportSymbols looks every distinct symbol up once.
+func validateImportSymbols(trades []models.ImportedTrade) {
+	known := map[string]string{} // symbol -> error ("" = fine)
+	for i := range trades {
+		t := &trades[i]
+		if t.Skipped || t.Error != "" {
+			continue
+		}
+		msg, ok := known[t.Symbol]
+		if !ok {
+			msg = checkImportSymbol(t.Symbol)
+			known[t.Symbol] = msg
+		}
+		t.Error = msg
+	}
+}
+
+func checkImportSymbol(sym string) string {
+	results, err := SearchSymbols(sym, 20)
+	if err != nil {
+		return "Could not check the symbol right now."
+	}
+	for _, r := range results {
+		if strings.EqualFold(r.Symbol, sym) || strings.EqualFold(r.DisplaySymbol, sym) {
+			return ""
+		}
+	}
+	return "Unknown symbol."
+}
+
+type importPosition struct {
+	qty     decimal.Decimal
+	avgCost decimal.Decimal
+}
+
+func currentHoldings(accountID primitive.ObjectID) (map[string]importPosition, error) {
+	positions, err := ListAccountPositions(accountID)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]importPosition{}
+	for _, p := range positions {
+		out[strings.ToUpper(p.Symbol)] = importPosition{qty: p.Qty, avgCost: p.AvgCost}
+	}
+	return out, nil
+}
+
+// replayImport applies the valid trades in date order on top of the current
+// holdings, using the same average-cost rules as live orders (buys
+// re-average, sells keep the average). Sells of more shares than held are
+// marked as errors. Row errors from earlier runs are cleared first.
+func replayImport(trades []models.ImportedTrade, start map[string]importPosition) ImportSummary {
+	order := make([]int, 0, len(trades))
+	for i := range trades {
+		if trades[i].Error == errImportOversold || trades[i].Error == errImportTooLarge {
+			trades[i].Error = ""
+		}
+		order = append(order, i)
+	}
+	sort.SliceStable(order, func(a, b int) bool { return trades[order[a]].Date.Before(trades[order[b]].Date) })
+
+	held := map[string]importPosition{}
+	for sym, p := range start {
+		held[sym] = p
+	}
+
+	s := ImportSummary{}
+	netCost := decimal.Zero
+	touched := map[string]bool{}
+	for _, i := range order {
+		t := &trades[i]
+		if t.Skipped {
+			s.Skipped++
+			continue
+		}
+		if t.Error != "" {
+			s.Errors++
+			continue
+		}
+
+		p := held[t.Symbol]
+		// rows are each within maxAmount, but thousands of them can still
+		// add up past decimal's range
+		value := costOf(t.Price, t.Qty)
+		if t.Side == "sell" {
+			value = value.Neg()
+		}
+		next, err := netCost.CheckedAdd(value)
+		if _, ok := orderValue(p.avgCost, p.qty); err != nil || !ok {
+			t.Error = errImportTooLarge
+			s.Errors++
+			continue
+		}
+		if t.Side == "sell" {
+			if t.Qty.GreaterThan(p.qty) {
+				t.Error = errImportOversold
+				s.Errors++
+				continue
+			}
+			p.qty = p.qty.Sub(t.Qty)
+			if p.qty.IsZero() {
+				p.avgCost = decimal.Zero
+			}
+		} else {
+			p.qty, p.avgCost, _ = applyFill(p.qty, p.avgCost, t.Qty, t.Price)
+		}
+		netCost = next
+		held[t.Symbol] = p
+		touched[t.Symbol] = true
+		s.Trades++
+	}
+	s.NetCost = netCost
+
+	for sym := range touched {
+		p := held[sym]
+		s.Holdings = append(s.Holdings, ImportHolding{
+			Symbol:  sym,
+			Qty:     p.qty,
+			AvgCost: p.avgCost,
+			Change:  p.qty.Sub(start[sym].qty),
+		})
+	}
+	sort.Slice(s.Holdings, func(i, j int) bool { return s.Holdings[i].Symbol < s.Holdings[j].Symbol })
+	return s
+}
@@ -0,0 +1,660 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MarginRules are the requirements on margin accounts and short positions.
+// Margins are fractions of the market value of the positions they cover.
+type MarginRules struct {
+	Initial     decimal.Decimal // equity needed after opening or adding to a position
+	Maintenance decimal.Decimal // equity below which the account gets a margin call
+
+	// Longs priced below MinPrice can't be borrowed against; they need
+	// their full value as equity.
+	MinPrice decimal.Decimal
+
+	BorrowRate   decimal.Decimal // yearly fee on the value of borrowed shares
+	InterestRate decimal.Decimal // yearly interest on a debit (negative) balance
+
+	// How long a margin call can stay unmet before positions are sold
+	CallGrace time.Duration
+}
+
+var (
+	defaultInitialMargin     = decimal.MustParse("0.5")
+	defaultMaintenanceMargin = decimal.MustParse("0.3")
+	defaultMarginMinPrice    = decimal.New(5)
+	defaultBorrowRate        = decimal.MustParse("0.03")
+	defaultMarginInterest    = decimal.MustParse("0.08")
+
+	// borrow fees and margin interest accrue daily on a 360-day year
+	borrowDayCount = decimal.New(360)
+
+	// a margin call sells a little past the maintenance margin, so fees
+	// don't leave the account just short of it
+	liquidationCushion = decimal.MustParse("1.05")
+)
+
+const (
+	defaultMarginCallGrace = 48 * time.Hour
+	marginCheckInterval    = 15 * time.Minute
+)
+
+// CurrentMarginRules reads MARGIN_INITIAL, MARGIN_MAINTENANCE,
+// MARGIN_MIN_PRICE, MARGIN_INTEREST_RATE, MARGIN_CALL_GRACE and
+// SHORT_BORROW_RATE.
+func CurrentMarginRules() MarginRules {
+	r := MarginRules{
+		Initial:      marginEnv("MARGIN_INITIAL", defaultInitialMargin),
+		Maintenance:  marginEnv("MARGIN_MAINTENANCE", defaultMaintenanceMargin),
+		MinPrice:     marginEnv("MARGIN_MIN_PRICE", defaultMarginMinPrice),
+		BorrowRate:   marginEnv("SHORT_BORROW_RATE", defaultBorrowRate),
+		InterestRate: marginEnv("MARGIN_INTEREST_RATE", defaultMarginInterest),
+		CallGrace:    defaultMarginCallGrace,
+	}
+	if !r.Initial.IsPositive() || r.Initial.GreaterThan(decimal.New(1)) {
+		log.Println("margin: MARGIN_INITIAL must be above 0 and at most 1, using default")
+		r.Initial = defaultInitialMargin
+	}
+	if r.Maintenance.GreaterThan(r.Initial) {
+		log.Println("margin: MARGIN_MAINTENANCE is above MARGIN_INITIAL, using the initial margin")
+		r.Maintenance = r.Initial
+	}
+	if v := strings.TrimSpace(os.Getenv("MARGIN_CALL_GRACE")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			r.CallGrace = d
+		} else {
+			log.Println("margin: invalid MARGIN_CALL_GRACE, using default")
+		}
+	}
+	return r
+}
+
+func marginEnv(name string, def decimal.Decimal) decimal.Decimal {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	d, err := decimal.Parse(v)
+	if err != nil || d.IsNegative() || d.GreaterThan(maxAmount) {
+		log.Println("margin: invalid " + name + ", using default")
+		return def
+	}
+	return d
+}
+
+// MarginAccount is an account marked to market, for the margin rules.
+type MarginAccount struct {
+	Margin bool `json:"margin"` // a margin account: it can borrow and sell short
+
+	Cash       decimal.Decimal `json:"cash"`     // negative: a debit balance
+	Borrowed   decimal.Decimal `json:"borrowed"` // the debit balance, as a positive amount
+	Reserved   decimal.Decimal `json:"reserved"`
+	LongValue  decimal.Decimal `json:"long_value"`
+	ShortValue decimal.Decimal `json:"short_value"` // what buying back every short costs
+	Equity     decimal.Decimal `json:"equity"`      // cash + long value - short value
+
+	Initial     decimal.Decimal `json:"initial"`     // equity the positions need before adding to them
+	Maintenance decimal.Decimal `json:"maintenance"` // equity the positions need to stay open
+	Excess      decimal.Decimal `json:"excess"`      // equity above Initial, net of reserved cash
+
+	// What the account can spend on marginable stock now: the excess
+	// leveraged by the initial margin, or the available cash of a cash
+	// account.
+	BuyingPower decimal.Decimal `json:"buying_power"`
+
+	MarginCall   bool      `json:"margin_call"`
+	MarginCallAt time.Time `json:"margin_call_at,omitempty"` // when the call was made
+	LiquidateAt  time.Time `json:"liquidate_at,omitempty"`   // positions are sold from then on
+}
+
+// GetMarginAccount values an account's positions at current quotes,
+// falling back to average cost.
+func GetMarginAccount(acct models.Account) (MarginAccount, error) {
+	positions, err := ListAccountPositions(acct.ID)
+	if err != nil {
+		return MarginAccount{}, err
+	}
+	r := CurrentMarginRules()
+	a := buildMarginAccount(acct, acct.Balance, positions, quoteOrAvgCost, r)
+	if !acct.MarginCallAt.IsZero() {
+		a.MarginCallAt = acct.MarginCallAt
+		a.LiquidateAt = acct.MarginCallAt.Add(r.CallGrace)
+	}
+	return a, nil
+}
+
+func quoteOrAvgCost(p models.Position) decimal.Decimal {
+	q, err := FetchCurrentPrice(p.Symbol)
+	if err != nil || q <= 0 {
+		return p.AvgCost
+	}
+	price, err := decimal.FromFloat(q)
+	if err != nil {
+		return p.AvgCost
+	}
+	return price
+}
+
+// marginRates are the initial and maintenance margins on a position at
+// price. A cash account's longs are paid in full and need none.
+func marginRates(margin bool, qty, price decimal.Decimal, r MarginRules) (initial, maintenance decimal.Decimal) {
+	switch {
+	case qty.IsNegative():
+		return r.Initial, r.Maintenance
+	case !margin:
+		return decimal.Zero, decimal.Zero
+	case price.LessThan(r.MinPrice):
+		return decimal.New(1), decimal.New(1)
+	}
+	return r.Initial, r.Maintenance
+}
+
+func buildMarginAccount(acct models.Account, cash decimal.Decimal, positions []models.Position, priceOf func(models.Position) decimal.Decimal, r MarginRules) MarginAccount {
+	a := MarginAccount{
+		Margin:   acct.Margin(),
+		Cash:     cash,
+		Reserved: acct.Reserved,
+	}
+	for _, p := range positions {
+		price := priceOf(p)
+		value := costOf(price, p.Qty)
+		if value.IsNegative() {
+			a.ShortValue = a.ShortValue.Sub(value)
+		} else {
+			a.LongValue = a.LongValue.Add(value)
+		}
+		initial, maintenance := marginRates(a.Margin, p.Qty, price, r)
+		a.Initial = a.Initial.Add(value.Abs().Mul(initial))
+		a.Maintenance = a.Maintenance.Add(value.Abs().Mul(maintenance))
+	}
+	a.Borrowed = decimal.Max(decimal.Zero, a.Cash.Neg())
+	a.Equity = a.Cash.Add(a.LongValue).Sub(a.ShortValue)
+	a.Initial = money(a.Initial)
+	a.Maintenance = money(a.Maintenance)
+	a.Excess = a.Equity.Sub(a.Reserved).Sub(a.Initial)
+
+	if a.Margin {
+		a.BuyingPower = money(decimal.Max(decimal.Zero, a.Excess.Div(r.Initial)))
+	} else {
+		a.BuyingPower = decimal.Max(decimal.Zero, a.Cash.Sub(a.Reserved))
+	}
+	a.MarginCall = a.Maintenance.IsPositive() && a.Equity.LessThan(a.Maintenance)
+	return a
+}
+
+// marginAfterFill is the account as it would be after a fill of qty
+// (negative for a sell) of sym at price, moving cashDelta, with sym valued
+// at the fill price.
+func marginAfterFill(acct models.Account, positions []models.Position, sym string, qty, price, cashDelta decimal.Decimal, r MarginRules) MarginAccount {
+	after := make([]models.Position, 0, len(positions)+1)
+	found := false
+	for _, p := range positions {
+		if p.Symbol == sym {
+			p.Qty = p.Qty.Add(qty)
+			found = true
+		}
+		after = append(after, p)
+	}
+	if !found {
+		after = append(after, models.Position{Symbol: sym, Qty: qty})
+	}
+	priceOf := func(p models.Position) decimal.Decimal {
+		if p.Symbol == sym {
+			return price
+		}
+		return quoteOrAvgCost(p)
+	}
+	return buildMarginAccount(acct, acct.Balance.Add(cashDelta), after, priceOf, r)
+}
+
+// checkBuyingPower is run before a margin account buys qty of sym at price
+// for debit, fees included. The buy must leave equity above the initial
+// margin, unless it only buys back part of a short, which lowers what the
+// account needs. Cash accounts are held to their available cash by
+// buyingPowerFilter instead.
+func checkBuyingPower(acct models.Account, sym string, qty, price, debit decimal.Decimal) map[string]string {
+	if !acct.Margin() {
+		return nil
+	}
+	errs := map[string]string{}
+	positions, err := ListAccountPositions(acct.ID)
+	if err != nil {
+		errs["_form"] = "Could not check your buying power."
+		return errs
+	}
+	for _, p := range positions {
+		if p.Symbol == sym && p.Qty.IsNegative() && qty.Cmp(p.Qty.Abs()) <= 0 {
+			return nil
+		}
+	}
+
+	if a := marginAfterFill(acct, positions, sym, qty, price, debit.Neg(), CurrentMarginRules()); a.Excess.IsNegative() {
+		errs["balance"] = "Not enough buying power for this purchase."
+		return errs
+	}
+	return nil
+}
+
+// buyingPowerFilter matches the account while a debit fits its buying
+// power. For a cash account that is its available cash. A margin account's
+// buying power depends on quotes, so checkBuyingPower works it out from
+// acct as read, and the filter only matches while the balance is unchanged.
+func buyingPowerFilter(acct models.Account, debit decimal.Decimal) bson.M {
+	if !acct.Margin() {
+		return availableCashFilter(acct.ID, debit)
+	}
+	return bson.M{"_id": acct.ID, "balance": acct.Balance}
+}
+
+// marginWithdrawable is how much cash can leave a margin account, or one
+// with short positions, while its equity still covers the initial margin.
+// ok is false for other accounts.
+func marginWithdrawable(accountID primitive.ObjectID) (decimal.Decimal, bool) {
+	acct, found := db.GetAccount(accountID)
+	if !found {
+		return decimal.Zero, false
+	}
+	a, err := GetMarginAccount(acct)
+	if err != nil || (!a.Margin && !a.ShortValue.IsPositive()) {
+		return decimal.Zero, false
+	}
+	return a.Excess, true
+}
+
+// checkShortSale is run before a sell of qty at price that the account's
+// long shares don't cover. Only margin accounts can sell short, and only while
+// their equity after the sale covers the initial margin.
+func checkShortSale(acct models.Account, sym string, qty, price decimal.Decimal, fees FeeQuote) map[string]string {
+	errs := map[string]string{}
+	if !acct.Margin() {
+		errs["qty"] = "You don't have enough shares to sell."
+		return errs
+	}
+	positions, err := ListAccountPositions(acct.ID)
+	if err != nil {
+		errs["_form"] = "Database error while selling."
+		return errs
+	}
+
+	r := CurrentMarginRules()
+	a := marginAfterFill(acct, positions, sym, qty.Neg(), price, costOf(price, qty).Sub(fees.Total()), r)
+	if a.Excess.IsNegative() {
+		errs["_form"] = fmt.Sprintf("Not enough equity to sell short: the account would have %.2f and its positions need %.2f.",
+			a.Equity.Sub(a.Reserved), a.Initial)
+		return errs
+	}
+	return nil
+}
+
+// AdminSetMargin makes an account a margin account, or a cash account
+// again. Turning margin off keeps open shorts and any debit balance; the
+// user can only reduce them.
+func AdminSetMargin(adminID, accountID primitive.ObjectID, enabled bool) (models.Account, map[string]string) {
+	errs := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	typ := models.AccountCash
+	if enabled {
+		typ = models.AccountMargin
+	}
+	var a models.Account
+	err := db.Client.Database("gomarket").Collection(accountsCollection).FindOneAndUpdate(ctx,
+		bson.M{"_id": accountID},
+		bson.M{"$set": bson.M{"type": typ, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&a)
+	if err != nil {
+		errs["_form"] = "There was a problem updating the account."
+		return models.Account{}, errs
+	}
+
+	reason := "disabled"
+	if enabled {
+		reason = "enabled"
+	}
+	recordAdminAction(ctx, models.AdminAction{AdminID: adminID, UserID: a.UserID, AccountID: a.ID, Action: "margin", Reason: reason})
+	return a, nil
+}
+
+// StartMarginScheduler charges borrow fees and margin interest once a day
+// and checks margin accounts every 15 minutes.
+func StartMarginScheduler(ctx context.Context) {
+	ticker := time.NewTicker(marginCheckInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		runMarginTick(time.Now().UTC())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runMarginTick(now.UTC())
+			}
+		}
+	}()
+}
+
+func runMarginTick(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	r := CurrentMarginRules()
+	chargeBorrowFees(ctx, now, r)
+	chargeMarginInterest(ctx, now, r)
+	checkMarginCalls(ctx, now, r)
+}
+
+// accrualDays is how many days have passed since the day accruedOn.
+func accrualDays(accruedOn string, now time.Time) int {
+	from, err := time.Parse("2006-01-02", accruedOn)
+	if err != nil {
+		return 0
+	}
+	return int(now.Truncate(24*time.Hour).Sub(from).Hours() / 24)
+}
+
+// chargeBorrowFees charges every short for the days since it was last
+// charged.
+func chargeBorrowFees(ctx context.Context, now time.Time, r MarginRules) {
+	today := now.Format("2006-01-02")
+	d := db.Client.Database("gomarket")
+	posColl := d.Collection("positions")
+
+	cur, err := posColl.Find(ctx, bson.M{"qty": bson.M{"$lt": 0}, "borrow_accrued_on": bson.M{"$lt": today}})
+	if err != nil {
+		log.Println("borrow fees:", err)
+		return
+	}
+	var shorts []models.Position
+	if err := cur.All(ctx, &shorts); err != nil {
+		log.Println("borrow fees:", err)
+		return
+	}
+
+	prices := map[string]decimal.Decimal{}
+	for _, p := range shorts {
+		days := accrualDays(p.BorrowAccruedOn, now)
+		if days <= 0 {
+			continue
+		}
+
+		// Claim the days by moving borrow_accrued_on to today; whoever
+		// moves it charges them.
+		res, err := posColl.UpdateOne(ctx,
+			bson.M{"_id": p.ID, "borrow_accrued_on": p.BorrowAccruedOn},
+			bson.M{"$set": bson.M{"borrow_accrued_on": today}})
+		if err != nil || res.ModifiedCount == 0 {
+			continue
+		}
+
+		price, ok := prices[p.Symbol]
+		if !ok {
+			price = quoteOrAvgCost(p)
+			prices[p.Symbol] = price
+		}
+		fee := money(costOf(price, p.Qty.Abs()).Mul(r.BorrowRate).Mul(decimal.New(int64(days))).Div(borrowDayCount))
+		if !fee.IsPositive() {
+			continue
+		}
+		if _, err := d.Collection(accountsCollection).UpdateOne(ctx, bson.M{"_id": p.AccountID},
+			bson.M{"$inc": bson.M{"balance": fee.Neg()}, "$set": bson.M{"updated_at": now}}); err != nil {
+			log.Println("borrow fees:", err)
+			continue
+		}
+		recordCashTransaction(ctx, models.CashTransaction{
+			UserID:    p.UserID,
+			AccountID: p.AccountID,
+			Type:      models.CashBorrowFee,
+			Amount:    fee.Neg(),
+			Note:      fmt.Sprintf("Borrow fee on %s %s short, %d day(s)", p.Qty.Abs(), p.Symbol, days),
+			CreatedAt: now,
+		})
+	}
+}
+
+// chargeMarginInterest charges interest on debit balances for the days
+// since it was last charged. The clock starts on the first check that finds
+// the balance negative and stops once it is paid back.
+func chargeMarginInterest(ctx context.Context, now time.Time, r MarginRules) {
+	today := now.Format("2006-01-02")
+	accounts := db.Client.Database("gomarket").Collection(accountsCollection)
+
+	if _, err := accounts.UpdateMany(ctx,
+		bson.M{"balance": bson.M{"$gte": 0}, "margin_interest_accrued_on": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"margin_interest_accrued_on": ""}}); err != nil {
+		log.Println("margin interest:", err)
+	}
+	if _, err := accounts.UpdateMany(ctx,
+		bson.M{"balance": bson.M{"$lt": 0}, "margin_interest_accrued_on": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"margin_interest_accrued_on": today}}); err != nil {
+		log.Println("margin interest:", err)
+	}
+
+	cur, err := accounts.Find(ctx,
+		bson.M{"balance": bson.M{"$lt": 0}, "margin_interest_accrued_on": bson.M{"$lt": today}},
+		options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1, "balance": 1, "margin_interest_accrued_on": 1}))
+	if err != nil {
+		log.Println("margin interest:", err)
+		return
+	}
+	var due []models.Account
+	if err := cur.All(ctx, &due); err != nil {
+		log.Println("margin interest:", err)
+		return
+	}
+
+	for _, a := range due {
+		days := accrualDays(a.InterestAccruedOn, now)
+		if days <= 0 {
+			continue
+		}
+		interest := money(a.Balance.Abs().Mul(r.InterestRate).Mul(decimal.New(int64(days))).Div(borrowDayCount))
+
+		// claimed and charged in one update
+		set := bson.M{"margin_interest_accrued_on": today, "updated_at": now}
+		res, err := accounts.UpdateOne(ctx,
+			bson.M{"_id": a.ID, "margin_interest_accrued_on": a.InterestAccruedOn},
+			bson.M{"$inc": bson.M{"balance": interest.Neg()}, "$set": set})
+		if err != nil || res.ModifiedCount == 0 || !interest.IsPositive() {
+			continue
+		}
+		recordCashTransaction(ctx, models.CashTransaction{
+			UserID:    a.UserID,
+			AccountID: a.ID,
+			Type:      models.CashMarginInterest,
+			Amount:    interest.Neg(),
+			Note:      fmt.Sprintf("Margin interest on %s, %d day(s)", a.Balance.Abs().StringFixed(2), days),
+			CreatedAt: now,
+		})
+	}
+}
+
+// checkMarginCalls looks at every account that borrows, holds shorts or has
+// a margin call open. An account below its maintenance margin gets a call;
+// one that is met again has it lifted. Calls still open after the grace
+// period are met by selling positions while the market is open.
+func checkMarginCalls(ctx context.Context, now time.Time, r MarginRules) {
+	d := db.Client.Database("gomarket")
+	accountsColl := d.Collection(accountsCollection)
+
+	shortAccounts, err := d.Collection("positions").Distinct(ctx, "account_id", bson.M{"qty": bson.M{"$lt": 0}})
+	if err != nil {
+		log.Println("margin calls:", err)
+		return
+	}
+	cur, err := accountsColl.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"balance": bson.M{"$lt": 0}},
+		bson.M{"margin_call_at": bson.M{"$exists": true}},
+		bson.M{"_id": bson.M{"$in": shortAccounts}},
+	}})
+	if err != nil {
+		log.Println("margin calls:", err)
+		return
+	}
+	var accounts []models.Account
+	if err := cur.All(ctx, &accounts); err != nil {
+		log.Println("margin calls:", err)
+		return
+	}
+
+	for _, acct := range accounts {
+		a, err := GetMarginAccount(acct)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case !a.MarginCall:
+			if acct.MarginCallAt.IsZero() {
+				continue
+			}
+			res, err := accountsColl.UpdateOne(ctx,
+				bson.M{"_id": acct.ID, "margin_call_at": acct.MarginCallAt},
+				bson.M{"$unset": bson.M{"margin_call_at": ""}})
+			if err == nil && res.ModifiedCount > 0 {
+				Notify(ctx, models.Notification{
+					UserID: acct.UserID, Kind: "margin_call_met", Link: "/portfolio",
+					Title: "Margin call met",
+					Body:  "Your " + acct.Name + " account is back above its maintenance margin.",
+				})
+			}
+
+		case acct.MarginCallAt.IsZero():
+			res, err := accountsColl.UpdateOne(ctx,
+				bson.M{"_id": acct.ID, "margin_call_at": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"margin_call_at": now}})
+			if err != nil || res.ModifiedCount == 0 {
+				continue
+			}
+			Notify(ctx, models.Notification{
+				UserID: acct.UserID, Kind: "margin_call", Link: "/portfolio",
+				Title: "Margin call on " + acct.Name,
+				Body: fmt.Sprintf("Your equity of %.2f is below the %.2f your positions require. Deposit %.2f or reduce your positions by %s, or positions will be sold.",
+					a.Equity, a.Maintenance, a.Maintenance.Sub(a.Equity), now.Add(r.CallGrace).Format("2006-01-02 15:04 MST")),
+			})
+
+		case !now.Before(acct.MarginCallAt.Add(r.CallGrace)) && MarketOpen(now):
+			// Claim the liquidation by moving margin_call_at; it stays
+			// past the grace period, so an unmet call is retried next check.
+			res, err := accountsColl.UpdateOne(ctx,
+				bson.M{"_id": acct.ID, "margin_call_at": acct.MarginCallAt},
+				bson.M{"$set": bson.M{"margin_call_at": now.Add(-r.CallGrace)}})
+			if err != nil || res.ModifiedCount == 0 {
+				continue
+			}
+			liquidateForMarginCall(ctx, acct, now)
+		}
+	}
+}
+
+// liquidationOrder is the order a margin call sells positions in: longs
+// before shorts, each by market value, largest first, then by symbol.
+func liquidationOrder(positions []models.Position, priceOf func(models.Position) decimal.Decimal) []models.Position {
+	out := append([]models.Position(nil), positions...)
+	value := map[string]decimal.Decimal{}
+	for _, p := range out {
+		value[p.Symbol] = costOf(priceOf(p), p.Qty).Abs()
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Qty.IsNegative() != b.Qty.IsNegative() {
+			return !a.Qty.IsNegative()
+		}
+		if c := value[a.Symbol].Cmp(value[b.Symbol]); c != 0 {
+			return c > 0
+		}
+		return a.Symbol < b.Symbol
+	})
+	return out
+}
+
+// liquidateForMarginCall sells longs through MarketSell, then buys back
+// shorts through MarketBuy, in liquidationOrder, each just enough to bring
+// the account back above its maintenance margin, until it is.
+func liquidateForMarginCall(ctx context.Context, acct models.Account, now time.Time) {
+	positions, err := ListAccountPositions(acct.ID)
+	if err != nil {
+		log.Println("margin calls:", acct.ID.Hex(), err)
+		return
+	}
+	r := CurrentMarginRules()
+
+	var done, failed []string
+	for _, p := range liquidationOrder(positions, quoteOrAvgCost) {
+		fresh, ok := db.GetAccount(acct.ID)
+		if !ok {
+			return
+		}
+		a, err := GetMarginAccount(fresh)
+		if err != nil || !a.MarginCall {
+			break
+		}
+
+		price := quoteOrAvgCost(p)
+		_, maintenance := marginRates(fresh.Margin(), p.Qty, price, r)
+		if !price.IsPositive() || !maintenance.IsPositive() {
+			continue
+		}
+		// selling (or buying back) value v lowers the requirement by
+		// maintenance * v and leaves the equity as it is, fees aside
+		need := a.Maintenance.Sub(a.Equity).Mul(liquidationCushion).Div(maintenance.Mul(price))
+		qty := decimal.Min(ceilQty(need), p.Qty.Abs())
+
+		var errs map[string]string
+		side := "sell"
+		if p.Qty.IsNegative() {
+			side = "buy"
+			_, errs = MarketBuy(acct.ID, p.Symbol, qty)
+		} else {
+			_, errs = MarketSell(acct.ID, p.Symbol, qty)
+		}
+		if len(errs) > 0 {
+			log.Println("margin calls:", acct.ID.Hex(), side, p.Symbol, firstPlanError(errs))
+			failed = append(failed, fmt.Sprintf("%s %s %s", side, qty, p.Symbol))
+			continue
+		}
+		done = append(done, fmt.Sprintf("%s %s %s", side, qty, p.Symbol))
+	}
+	if len(done) == 0 && len(failed) == 0 {
+		return
+	}
+
+	body := "To meet the margin call on " + acct.Name + " we placed: " + strings.Join(done, ", ") + "."
+	if len(done) == 0 {
+		body = "We could not sell positions to meet the margin call on " + acct.Name + "."
+	}
+	if len(failed) > 0 {
+		body += " Failed: " + strings.Join(failed, ", ") + "."
+	}
+	Notify(ctx, models.Notification{
+		UserID: acct.UserID, Kind: "margin_liquidation", Link: "/portfolio",
+		Title: "Positions sold for a margin call", Body: body, CreatedAt: now,
+	})
+}
+
+// ceilQty rounds a share count up to the finest tradable fraction.
+func ceilQty(d decimal.Decimal) decimal.Decimal {
+	t := d.Truncate(QtyPlaces)
+	if t.LessThan(d) {
+		t = t.Add(decimal.MustParse("0.000001"))
+	}
+	return t
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// numberTypes are the BSON types older documents stored numbers as.
+var numberTypes = bson.A{"int", "long", "double"}
+
+// toDecimal converts a number expression to Decimal128 rounded to places.
+// Doubles convert with 15 significant digits, which drops the binary noise
+// (0.30000000000000004) before rounding.
+func toDecimal(expr string, places int) bson.M {
+	return bson.M{"$round": bson.A{bson.M{"$toDecimal": expr}, places}}
+}
+
+// migrateField rewrites field as Decimal128 wherever it is still a plain
+// number. Documents already converted don't match, so it is safe to run on
+// every start.
+func migrateField(ctx context.Context, collection, field string, places int) {
+	coll := db.Client.Database("gomarket").Collection(collection)
+	_, err := coll.UpdateMany(ctx,
+		bson.M{field: bson.M{"$type": numberTypes}},
+		bson.A{bson.M{"$set": bson.M{field: toDecimal("$"+field, places)}}})
+	if err != nil {
+		log.Printf("migrate %s.%s: %v", collection, field, err)
+	}
+}
+
+// migrateArrayField does the same for field inside each element of array.
+func migrateArrayField(ctx context.Context, collection, array, field string, places int) {
+	coll := db.Client.Database("gomarket").Collection(collection)
+	_, err := coll.UpdateMany(ctx,
+		bson.M{array + "." + field: bson.M{"$type": numberTypes}},
+		bson.A{bson.M{"$set": bson.M{array: bson.M{"$map": bson.M{
+			"input": "$" + array,
+			"as":    "e",
+			"in": bson.M{"$mergeObjects": bson.A{"$$e", bson.M{
+				field: bson.M{"$cond": bson.A{
+					bson.M{"$isNumber": "$$e." + field},
+					toDecimal("$$e."+field, places),
+					"$$e." + field,
+				}},
+			}}},
+		}}}}})
+	if err != nil {
+		log.Printf("migrate %s.%s.%s: %v", collection, array, field, err)
+	}
+}
+
+// MigrateDecimalQuantities converts share quantities stored as integers,
+// from before fractional shares, to Decimal128.
+func MigrateDecimalQuantities() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	migrateField(ctx, "orders", "qty", QtyPlaces)
+	migrateField(ctx, "positions", "qty", QtyPlaces)
+	migrateArrayField(ctx, snapshotsCollection, "positions", "qty", QtyPlaces)
+	migrateArrayField(ctx, planRunsCollection, "orders", "qty", QtyPlaces)
+	migrateArrayField(ctx, rebalancesCollection, "orders", "qty", QtyPlaces)
+	migrateArrayField(ctx, tradeImportsCollection, "trades", "qty", QtyPlaces)
+}
+
+// MigrateDecimalMoney converts balances, prices, average costs and the
+// amounts of the cash ledger, transfers, plans, rebalances, snapshots and
+// statements stored as doubles to Decimal128, rounded to the account
+// currency's minor unit. Prices keep pricePlaces and average costs
+// avgCostPlaces.
+func MigrateDecimalMoney() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	places := RuleForCurrency(AccountCurrency).Places
+	migrateField(ctx, "users", "balance", places)
+	migrateField(ctx, "users", "reserved", places)
+	migrateField(ctx, "orders", "price", pricePlaces)
+	migrateField(ctx, "positions", "avg_cost", avgCostPlaces)
+	migrateField(ctx, alertsCollection, "target_price", pricePlaces)
+	migrateField(ctx, alertsCollection, "triggered_price", pricePlaces)
+	migrateArrayField(ctx, tradeImportsCollection, "trades", "price", pricePlaces)
+
+	migrateField(ctx, cashTransactionsCollection, "amount", places)
+	migrateField(ctx, fundTransfersCollection, "amount", places)
+	migrateField(ctx, adminActionsCollection, "amount", places)
+	migrateField(ctx, plansCollection, "amount", places)
+	migrateArrayField(ctx, plansCollection, "items", "amount", places)
+	migrateField(ctx, planRunsCollection, "invested", places)
+	migrateArrayField(ctx, planRunsCollection, "orders", "price", pricePlaces)
+	for _, f := range []string{"amount", "cost"} {
+		migrateArrayField(ctx, planRunsCollection, "orders", f, places)
+	}
+	migrateArrayField(ctx, rebalancesCollection, "orders", "price", pricePlaces)
+	migrateArrayField(ctx, snapshotsCollection, "positions", "price", pricePlaces)
+	migrateArrayField(ctx, rebalancesCollection, "orders", "value", places)
+	migrateArrayField(ctx, snapshotsCollection, "positions", "value", places)
+	for _, f := range []string{"cash", "positions_value", "total_value"} {
+		migrateField(ctx, snapshotsCollection, f, places)
+	}
+	for _, f := range []string{
+		"opening_cash", "closing_cash", "opening_value", "closing_value",
+		"deposits", "withdrawals", "bought", "sold", "fees", "realized_pnl", "unrealized_pnl",
+	} {
+		migrateField(ctx, statementsCollection, f, places)
+	}
+}
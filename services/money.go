@@ -0,0 +1,137 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+)
+
+// AccountCurrency is the currency balances, prices and costs are kept in.
+const AccountCurrency = "USD"
+
+// CurrencyRule says how amounts in a currency are rounded: to Places
+// decimals (its minor unit), half away from zero unless HalfEven.
+type CurrencyRule struct {
+	Places   int
+	HalfEven bool
+}
+
+var currencyRules = map[string]CurrencyRule{
+	"USD": {Places: 2},
+	"EUR": {Places: 2},
+	"GBP": {Places: 2},
+	"CHF": {Places: 2},
+	"CAD": {Places: 2},
+	"AUD": {Places: 2},
+	"BGN": {Places: 2},
+	"JPY": {Places: 0},
+	"KRW": {Places: 0},
+	"BHD": {Places: 3},
+	"KWD": {Places: 3},
+	"JOD": {Places: 3},
+}
+
+// RuleForCurrency returns the rounding rule for an ISO 4217 code; unknown
+// currencies round to cents.
+func RuleForCurrency(code string) CurrencyRule {
+	if r, ok := currencyRules[strings.ToUpper(code)]; ok {
+		return r
+	}
+	return CurrencyRule{Places: 2}
+}
+
+// RoundCurrency rounds v to the minor unit of the currency.
+func RoundCurrency(v decimal.Decimal, code string) decimal.Decimal {
+	r := RuleForCurrency(code)
+	if r.HalfEven {
+		return v.RoundBank(r.Places)
+	}
+	return v.Round(r.Places)
+}
+
+// money rounds an amount in the account currency.
+func money(v decimal.Decimal) decimal.Decimal {
+	return RoundCurrency(v, AccountCurrency)
+}
+
+// pricePlaces is the scale of a share price. Stocks under a dollar quote in
+// fractions of a cent, so prices keep more places than cash and are only
+// rounded to cents once they are multiplied into an amount.
+const pricePlaces = 6
+
+// sharePrice rounds a share price to pricePlaces.
+func sharePrice(v decimal.Decimal) decimal.Decimal {
+	return v.Round(pricePlaces)
+}
+
+// priceFromFloat brings a float quote into a share price.
+func priceFromFloat(v float64) decimal.Decimal {
+	return sharePrice(decimal.NewFromFloat(v))
+}
+
+// Amounts and quantities from forms, the API and imports are capped so that
+// price * qty, the fees on it and a re-averaged cost stay well inside
+// decimal's range (±92 billion); past it the arithmetic would panic.
+var (
+	maxAmount   = decimal.New(100_000_000)
+	maxOrderQty = decimal.New(100_000_000)
+)
+
+// tooLarge is the error for an amount over maxAmount.
+var tooLarge = "Amounts are limited to " + maxAmount.StringFixed(2) + "."
+
+// orderValue is price * qty in the account currency; !ok if the order is
+// worth more than maxAmount.
+func orderValue(price, qty decimal.Decimal) (decimal.Decimal, bool) {
+	v, err := price.CheckedMul(qty)
+	if err != nil || v.Abs().GreaterThan(maxAmount) {
+		return decimal.Zero, false
+	}
+	return money(v), true
+}
+
+// costOf is price * qty in the account currency.
+func costOf(price, qty decimal.Decimal) decimal.Decimal {
+	return money(price.Mul(qty))
+}
+
+// avgCostPlaces keeps the average cost finer than cents, so adding to a
+// position many times doesn't drift its cost basis.
+const avgCostPlaces = 6
+
+// averageCost re-averages a position after buying qty more at price. It
+// rounds half to even, like $round in the live buy's position update.
+func averageCost(heldQty, avgCost, qty, price decimal.Decimal) decimal.Decimal {
+	total := heldQty.Add(qty)
+	if !total.IsPositive() {
+		return decimal.Zero
+	}
+	return heldQty.Mul(avgCost).Add(qty.Mul(price)).Div(total).RoundBank(avgCostPlaces)
+}
+
+// applyFill moves a position of heldQty at avgCost by a fill of qty at
+// price, where a negative qty is a sell and a negative heldQty a short.
+// Adding to either side re-averages; reducing keeps the average and
+// realizes the difference on the closed shares; crossing zero opens the
+// other side at price.
+func applyFill(heldQty, avgCost, qty, price decimal.Decimal) (newQty, newAvg, realized decimal.Decimal) {
+	newQty = heldQty.Add(qty)
+	if heldQty.IsZero() || heldQty.Sign() == qty.Sign() {
+		return newQty, averageCost(heldQty.Abs(), avgCost, qty.Abs(), price), decimal.Zero
+	}
+
+	closed := decimal.Min(qty.Abs(), heldQty.Abs())
+	realized = costOf(price.Sub(avgCost), closed)
+	if heldQty.IsNegative() {
+		realized = realized.Neg()
+	}
+	switch {
+	case newQty.IsZero():
+		newAvg = decimal.Zero
+	case newQty.Sign() == heldQty.Sign():
+		newAvg = avgCost
+	default:
+		newAvg = price
+	}
+	return newQty, newAvg, realized
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+)
+
+func TestAverageCost(t *testing.T) {
+	tests := []struct {
+		held, avg, qty, price string
+		want                  string
+	}{
+		{"0", "0", "10", "100", "100"},
+		{"10", "100", "10", "110", "105"},
+		{"3", "10", "1", "11", "10.25"},
+		{"3", "10", "3", "10.000001", "10"},        // 10.0000005 rounds half to even
+		{"3", "10", "3", "10.000003", "10.000002"}, // 10.0000015 as well
+		{"1", "1", "2", "1", "1"},
+		{"0.5", "200", "0.25", "260", "220"},
+		{"0", "0", "0", "100", "0"},
+	}
+	for _, tt := range tests {
+		d := decimal.MustParse
+		got := averageCost(d(tt.held), d(tt.avg), d(tt.qty), d(tt.price))
+		if got.String() != tt.want {
+			t.Errorf("averageCost(%s @ %s + %s @ %s) = %s, want %s",
+				tt.held, tt.avg, tt.qty, tt.price, got, tt.want)
+		}
+	}
+}
+
+func TestApplyFill(t *testing.T) {
+	tests := []struct {
+		name                  string
+		held, avg, qty, price string
+		newQty, newAvg, pnl   string
+	}{
+		{"open long", "0", "0", "10", "50", "10", "50", "0"},
+		{"add to long", "10", "50", "10", "60", "20", "55", "0"},
+		{"reduce long", "10", "50", "-4", "60", "6", "50", "40"},
+		{"close long", "10", "50", "-10", "45", "0", "0", "-50"},
+		{"long to short", "10", "50", "-15", "60", "-5", "60", "100"},
+		{"open short", "0", "0", "-10", "50", "-10", "50", "0"},
+		{"add to short", "-10", "50", "-10", "40", "-20", "45", "0"},
+		{"cover short", "-10", "50", "4", "40", "-6", "50", "40"},
+		{"close short at a loss", "-10", "50", "10", "55", "0", "0", "-50"},
+		{"short to long", "-10", "50", "12", "45", "2", "45", "50"},
+		{"fractional close", "0.5", "100.10", "-0.5", "100.15", "0", "0", "0.03"},
+	}
+	for _, tt := range tests {
+		d := decimal.MustParse
+		qty, avg, pnl := applyFill(d(tt.held), d(tt.avg), d(tt.qty), d(tt.price))
+		if qty.String() != tt.newQty || avg.String() != tt.newAvg || pnl.String() != tt.pnl {
+			t.Errorf("%s: got qty %s avg %s realized %s; want %s %s %s",
+				tt.name, qty, avg, pnl, tt.newQty, tt.newAvg, tt.pnl)
+		}
+	}
+}
+
+func TestPriceFromFloat(t *testing.T) {
+	tests := []struct {
+		quote float64
+		want  string
+	}{
+		{187.23, "187.23"},
+		{0.0042, "0.0042"}, // would be 0 in cents
+		{0.1 + 0.2, "0.3"},
+		{12.3456789, "12.345679"},
+		{0.0000004, "0"},
+	}
+	for _, tt := range tests {
+		if got := priceFromFloat(tt.quote); got.String() != tt.want {
+			t.Errorf("priceFromFloat(%v) = %s, want %s", tt.quote, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	notificationsCollection = "notifications"
+	notificationTTL         = 90 * 24 * time.Hour
+)
+
+func EnsureNotificationIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(notificationsCollection)
+
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "read", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(notificationTTL.Seconds())),
+	})
+}
+
+// Notify stores a notification. It is best effort: failures are logged.
+func Notify(ctx context.Context, n models.Notification) {
+	n.ID = primitive.NewObjectID()
+	n.Read = false
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now().UTC()
+	}
+	if _, err := db.Client.Database("gomarket").Collection(notificationsCollection).InsertOne(ctx, n); err != nil {
+		log.Println("notify:", err)
+	}
+}
+
+// ListNotifications returns the newest notifications first.
+func ListNotifications(userID primitive.ObjectID, limit int64) ([]models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(notificationsCollection).Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.Notification, 0)
+	for cur.Next(ctx) {
+		var n models.Notification
+		if err := cur.Decode(&n); err != nil {
+			continue
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func CountUnreadNotifications(userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return db.Client.Database("gomarket").Collection(notificationsCollection).CountDocuments(ctx,
+		bson.M{"user_id": userID, "read": false})
+}
+
+func MarkNotificationsRead(userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.Client.Database("gomarket").Collection(notificationsCollection).UpdateMany(ctx,
+		bson.M{"user_id": userID, "read": false},
+		bson.M{"$set": bson.M{"read": true}})
+	return err
+}
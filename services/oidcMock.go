@@ -0,0 +1,253 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The mock issuer is a tiny, in-memory OpenID Connect provider for local
+// development. Enable it with OIDC_MOCK=true: it is mounted under
+// MockOIDCPath and shows up as "Mock OIDC" on the login page. Its sign-in
+// form lets you pick any email, name and verified flag, so every linking
+// path can be exercised without a real provider.
+const (
+	MockOIDCPath         = "/mock-oidc"
+	mockOIDCClientID     = "gomarket-dev"
+	mockOIDCClientSecret = "gomarket-dev-secret"
+	mockOIDCCodeTTL      = 2 * time.Minute
+)
+
+type mockOIDCCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	email       string
+	verified    bool
+	givenName   string
+	familyName  string
+	expires     time.Time
+}
+
+type MockOIDCIssuer struct {
+	issuer string
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]mockOIDCCode
+}
+
+var mockOIDCLoginPage = template.Must(template.New("mock").Parse(`<!doctype html>
+<html lang="en" data-bs-theme="dark">
+<head>
+<meta charset="UTF-8" />
+<title>Mock OIDC sign-in</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.8/dist/css/bootstrap.min.css" />
+</head>
+<body class="d-flex align-items-center justify-content-center min-vh-100">
+<form method="POST" class="card p-4" style="width: 360px">
+  <h1 class="h5 mb-3">Mock OIDC sign-in</h1>
+  {{ range $k, $v := .Params }}<input type="hidden" name="{{ $k }}" value="{{ $v }}" />{{ end }}
+  <label class="form-label">Email</label>
+  <input class="form-control mb-2" name="email" value="dev@example.com" />
+  <label class="form-label">First name</label>
+  <input class="form-control mb-2" name="given_name" value="Dev" />
+  <label class="form-label">Last name</label>
+  <input class="form-control mb-2" name="family_name" value="User" />
+  <div class="form-check mb-3">
+    <input class="form-check-input" type="checkbox" id="verified" name="email_verified" value="true" checked />
+    <label class="form-check-label" for="verified">Email verified</label>
+  </div>
+  <button class="btn btn-primary w-100" type="submit">Sign in</button>
+</form>
+</body>
+</html>`))
+
+func NewMockOIDCIssuer(issuer string) *MockOIDCIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return &MockOIDCIssuer{
+		issuer: strings.TrimRight(issuer, "/"),
+		key:    key,
+		kid:    oidcRandom(8),
+		codes:  map[string]mockOIDCCode{},
+	}
+}
+
+// ServeHTTP expects paths relative to the issuer (strip MockOIDCPath first).
+func (m *MockOIDCIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		m.discovery(w)
+	case "/jwks":
+		m.jwks(w)
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *MockOIDCIssuer) discovery(w http.ResponseWriter) {
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + "/authorize",
+		"token_endpoint":                        m.issuer + "/token",
+		"jwks_uri":                              m.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (m *MockOIDCIssuer) jwks(w http.ResponseWriter) {
+	pub := m.key.PublicKey
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"keys": []any{map[string]any{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": m.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *MockOIDCIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	params := map[string]string{}
+	for _, k := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method", "response_type"} {
+		params[k] = r.Form.Get(k)
+	}
+
+	if params["client_id"] != mockOIDCClientID || params["response_type"] != "code" {
+		http.Error(w, "invalid client or response type", http.StatusBadRequest)
+		return
+	}
+	if params["code_challenge"] == "" || params["code_challenge_method"] != "S256" {
+		http.Error(w, "PKCE (S256) is required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = mockOIDCLoginPage.Execute(w, map[string]any{"Params": params})
+		return
+	}
+
+	code := oidcRandom(24)
+	m.mu.Lock()
+	m.codes[code] = mockOIDCCode{
+		clientID:    params["client_id"],
+		redirectURI: params["redirect_uri"],
+		nonce:       params["nonce"],
+		challenge:   params["code_challenge"],
+		email:       strings.ToLower(strings.TrimSpace(r.Form.Get("email"))),
+		verified:    r.Form.Get("email_verified") == "true",
+		givenName:   r.Form.Get("given_name"),
+		familyName:  r.Form.Get("family_name"),
+		expires:     time.Now().Add(mockOIDCCodeTTL),
+	}
+	m.mu.Unlock()
+
+	q := url.Values{}
+	q.Set("code", code)
+	q.Set("state", params["state"])
+	http.Redirect(w, r, params["redirect_uri"]+"?"+q.Encode(), http.StatusFound)
+}
+
+func (m *MockOIDCIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+	if clientID != mockOIDCClientID || secret != mockOIDCClientSecret {
+		writeMockJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.Form.Get("code")
+	m.mu.Lock()
+	c, found := m.codes[code]
+	delete(m.codes, code) // codes are single use
+	m.mu.Unlock()
+
+	if r.Form.Get("grant_type") != "authorization_code" || !found || time.Now().After(c.expires) ||
+		c.clientID != clientID || c.redirectURI != r.Form.Get("redirect_uri") {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	// stable subject per email, like a real provider's user id
+	subSum := sha256.Sum256([]byte(c.email))
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            m.issuer,
+		"aud":            clientID,
+		"sub":            hex.EncodeToString(subSum[:12]),
+		"iat":            now.Unix(),
+		"exp":            now.Add(10 * time.Minute).Unix(),
+		"nonce":          c.nonce,
+		"email":          c.email,
+		"email_verified": c.verified,
+		"given_name":     c.givenName,
+		"family_name":    c.familyName,
+	})
+	idToken.Header["kid"] = m.kid
+
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		writeMockJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, map[string]any{
+		"access_token": oidcRandom(24),
+		"token_type":   "Bearer",
+		"expires_in":   600,
+		"id_token":     signed,
+	})
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,637 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const identitiesCollection = "user_identities"
+
+// OIDCProvider is one "Sign in with …" button. Providers are configured with
+//
+//	OIDC_PROVIDERS=google,okta
+//	OIDC_GOOGLE_ISSUER=https://accounts.google.com
+//	OIDC_GOOGLE_CLIENT_ID=...
+//	OIDC_GOOGLE_CLIENT_SECRET=...
+//	OIDC_GOOGLE_DISPLAY_NAME=Google   (optional)
+//	OIDC_GOOGLE_SCOPES=openid email profile   (optional)
+//
+// and OIDC_REDIRECT_BASE_URL=http://localhost:3000 for the callback URLs.
+type OIDCProvider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+func (p OIDCProvider) RedirectURL() string {
+	return strings.TrimRight(oidcBaseURL(), "/") + "/auth/oidc/" + p.Name + "/callback"
+}
+
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt time.Time
+}
+
+type oidcIDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	AZP           string `json:"azp"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"` // some issuers send "true"
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Name          string `json:"name"`
+}
+
+var (
+	ErrOIDCUnknownProvider = errors.New("unknown sign-in provider")
+	ErrOIDCEmailUnverified = errors.New("the provider did not confirm your email address")
+	ErrOIDCIdentityTaken   = errors.New("this account is already linked to another GoMarket user")
+	ErrOIDCProviderLinked  = errors.New("another account from this provider is already linked")
+	ErrOIDCDisabled        = errors.New("this account has been disabled")
+
+	oidcHTTP = &http.Client{Timeout: 8 * time.Second}
+
+	oidcProvidersOnce sync.Once
+	oidcProviders     []OIDCProvider
+
+	oidcCacheMu   sync.Mutex
+	oidcDiscCache = map[string]oidcDiscovery{}
+	oidcKeyCache  = map[string]map[string]*rsa.PublicKey{} // jwks_uri -> kid -> key
+)
+
+func oidcBaseURL() string {
+	if v := strings.TrimSpace(os.Getenv("OIDC_REDIRECT_BASE_URL")); v != "" {
+		return v
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	return "http://localhost:" + port
+}
+
+// OIDCProviders lists configured providers, plus the built-in mock issuer
+// when OIDC_MOCK=true.
+func OIDCProviders() []OIDCProvider {
+	oidcProvidersOnce.Do(func() {
+		for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			env := func(k string) string {
+				return strings.TrimSpace(os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + k))
+			}
+			p := OIDCProvider{
+				Name:         name,
+				DisplayName:  env("DISPLAY_NAME"),
+				Issuer:       strings.TrimRight(env("ISSUER"), "/"),
+				ClientID:     env("CLIENT_ID"),
+				ClientSecret: env("CLIENT_SECRET"),
+				Scopes:       strings.Fields(env("SCOPES")),
+			}
+			if p.Issuer == "" || p.ClientID == "" {
+				continue
+			}
+			if p.DisplayName == "" {
+				p.DisplayName = strings.ToUpper(name[:1]) + name[1:]
+			}
+			if len(p.Scopes) == 0 {
+				p.Scopes = []string{"openid", "email", "profile"}
+			}
+			oidcProviders = append(oidcProviders, p)
+		}
+
+		if OIDCMockEnabled() {
+			oidcProviders = append(oidcProviders, OIDCProvider{
+				Name:         "mock",
+				DisplayName:  "Mock OIDC",
+				Issuer:       MockOIDCIssuerURL(),
+				ClientID:     mockOIDCClientID,
+				ClientSecret: mockOIDCClientSecret,
+				Scopes:       []string{"openid", "email", "profile"},
+			})
+		}
+	})
+	return oidcProviders
+}
+
+func GetOIDCProvider(name string) (OIDCProvider, error) {
+	for _, p := range OIDCProviders() {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return OIDCProvider{}, ErrOIDCUnknownProvider
+}
+
+func OIDCMockEnabled() bool {
+	v, _ := strconv.ParseBool(os.Getenv("OIDC_MOCK"))
+	return v
+}
+
+func MockOIDCIssuerURL() string {
+	return strings.TrimRight(oidcBaseURL(), "/") + MockOIDCPath
+}
+
+func oidcRandom(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewOIDCAuthRequest returns fresh state, nonce and PKCE verifier values.
+func NewOIDCAuthRequest() (state, nonce, verifier string) {
+	return oidcRandom(24), oidcRandom(24), oidcRandom(48)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func oidcDiscover(p OIDCProvider) (oidcDiscovery, error) {
+	oidcCacheMu.Lock()
+	d, ok := oidcDiscCache[p.Issuer]
+	oidcCacheMu.Unlock()
+	if ok && time.Since(d.fetchedAt) < time.Hour {
+		return d, nil
+	}
+
+	resp, err := oidcHTTP.Get(p.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return oidcDiscovery{}, fmt.Errorf("oidc discovery failed: status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return oidcDiscovery{}, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return oidcDiscovery{}, errors.New("oidc discovery: issuer mismatch")
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return oidcDiscovery{}, errors.New("oidc discovery: incomplete document")
+	}
+
+	d.fetchedAt = time.Now()
+	oidcCacheMu.Lock()
+	oidcDiscCache[p.Issuer] = d
+	oidcCacheMu.Unlock()
+	return d, nil
+}
+
+// OIDCAuthURL is where the browser goes to sign in with the provider.
+func OIDCAuthURL(p OIDCProvider, state, nonce, verifier string) (string, error) {
+	d, err := oidcDiscover(p)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL())
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// OIDCExchangeCode trades the authorization code for an ID token and
+// returns its verified claims.
+func OIDCExchangeCode(p OIDCProvider, code, verifier, nonce string) (OIDCClaims, error) {
+	d, err := oidcDiscover(p)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL())
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	resp, err := oidcHTTP.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return OIDCClaims{}, err
+	}
+	if resp.StatusCode != http.StatusOK || tok.IDToken == "" {
+		return OIDCClaims{}, fmt.Errorf("oidc token exchange failed: %s", tok.Error)
+	}
+
+	return verifyOIDCIDToken(p, d, tok.IDToken, nonce)
+}
+
+func verifyOIDCIDToken(p OIDCProvider, d oidcDiscovery, raw, nonce string) (OIDCClaims, error) {
+	var claims oidcIDTokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return oidcKey(d.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return OIDCClaims{}, errors.New("oidc: nonce mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AZP != p.ClientID {
+		return OIDCClaims{}, errors.New("oidc: unexpected authorized party")
+	}
+	if claims.Subject == "" {
+		return OIDCClaims{}, errors.New("oidc: missing subject")
+	}
+
+	out := OIDCClaims{
+		Subject:   claims.Subject,
+		Email:     strings.ToLower(strings.TrimSpace(claims.Email)),
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+	}
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		out.EmailVerified = v
+	case string:
+		out.EmailVerified, _ = strconv.ParseBool(v)
+	}
+	if out.FirstName == "" && out.LastName == "" && claims.Name != "" {
+		parts := strings.Fields(claims.Name)
+		out.FirstName = parts[0]
+		out.LastName = strings.Join(parts[1:], " ")
+	}
+	return out, nil
+}
+
+// oidcKey finds a signing key by kid, refetching the JWKS once when the kid
+// is unknown (the provider may have rotated keys).
+func oidcKey(jwksURI, kid string) (*rsa.PublicKey, error) {
+	oidcCacheMu.Lock()
+	keys := oidcKeyCache[jwksURI]
+	oidcCacheMu.Unlock()
+
+	if k := pickOIDCKey(keys, kid); k != nil {
+		return k, nil
+	}
+
+	keys, err := fetchOIDCKeys(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	oidcCacheMu.Lock()
+	oidcKeyCache[jwksURI] = keys
+	oidcCacheMu.Unlock()
+
+	if k := pickOIDCKey(keys, kid); k != nil {
+		return k, nil
+	}
+	return nil, errors.New("oidc: signing key not found")
+}
+
+func pickOIDCKey(keys map[string]*rsa.PublicKey, kid string) *rsa.PublicKey {
+	if kid != "" {
+		return keys[kid]
+	}
+	if len(keys) == 1 {
+		for _, k := range keys {
+			return k
+		}
+	}
+	return nil
+}
+
+func fetchOIDCKeys(jwksURI string) (map[string]*rsa.PublicKey, error) {
+	resp, err := oidcHTTP.Get(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	out := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		out[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return out, nil
+}
+
+func EnsureIdentityIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(identitiesCollection)
+
+	// One GoMarket user per external account
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	// And at most one account per provider per user
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "provider", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+func ListUserIdentities(userID primitive.ObjectID) ([]models.UserIdentity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(identitiesCollection)
+
+	cur, err := coll.Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "provider", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.UserIdentity, 0)
+	for cur.Next(ctx) {
+		var i models.UserIdentity
+		if err := cur.Decode(&i); err != nil {
+			continue
+		}
+		out = append(out, i)
+	}
+	return out, nil
+}
+
+// LinkIdentity attaches an external account to an existing user. A user has
+// at most one account per provider: linking a different one needs the old
+// one unlinked first.
+func LinkIdentity(userID primitive.ObjectID, provider string, claims OIDCClaims) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(identitiesCollection)
+
+	var existing models.UserIdentity
+	err := coll.FindOne(ctx, bson.M{"provider": provider, "subject": claims.Subject}).Decode(&existing)
+	if err == nil {
+		if existing.UserID != userID {
+			return ErrOIDCIdentityTaken
+		}
+		return nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = coll.InsertOne(ctx, models.UserIdentity{
+		UserID:      userID,
+		Provider:    provider,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LinkedAt:    now,
+		LastLoginAt: now,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// the user already has another account from this provider, or the
+		// subject was linked to someone else in the meantime
+		n, _ := coll.CountDocuments(ctx, bson.M{"user_id": userID, "provider": provider})
+		if n > 0 {
+			return ErrOIDCProviderLinked
+		}
+		return ErrOIDCIdentityTaken
+	}
+	return err
+}
+
+// UnlinkIdentity refuses to remove the last way into a password-less account.
+func UnlinkIdentity(user models.User, provider string) map[string]string {
+	errs := map[string]string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(identitiesCollection)
+
+	if user.PasswordHash == "" {
+		n, err := coll.CountDocuments(ctx, bson.M{"user_id": user.ID})
+		if err != nil {
+			errs["_form"] = "Could not unlink the account."
+			return errs
+		}
+		if n <= 1 {
+			errs["_form"] = "Set a password before unlinking your only sign-in method."
+			return errs
+		}
+	}
+
+	if _, err := coll.DeleteOne(ctx, bson.M{"user_id": user.ID, "provider": provider}); err != nil {
+		errs["_form"] = "Could not unlink the account."
+		return errs
+	}
+	return nil
+}
+
+// LoginWithOIDC resolves the external identity to a user: an already linked
+// user first, then an existing user with the same verified email (which gets
+// linked), and finally a brand-new password-less user.
+func LoginWithOIDC(provider string, claims OIDCClaims) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	identities := d.Collection(identitiesCollection)
+	users := d.Collection("users")
+
+	var ident models.UserIdentity
+	err := identities.FindOne(ctx, bson.M{"provider": provider, "subject": claims.Subject}).Decode(&ident)
+	if err == nil {
+		u, ok := db.GetUser(ident.UserID)
+		if !ok {
+			return models.User{}, errors.New("linked user no longer exists")
+		}
+		if u.Disabled {
+			return models.User{}, ErrOIDCDisabled
+		}
+		_, _ = identities.UpdateOne(ctx, bson.M{"_id": ident.ID},
+			bson.M{"$set": bson.M{"last_login_at": time.Now().UTC(), "email": claims.Email}})
+		return u, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return models.User{}, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return models.User{}, ErrOIDCEmailUnverified
+	}
+
+	var u models.User
+	err = users.FindOne(ctx, bson.M{"email": claims.Email}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		first, last := claims.FirstName, claims.LastName
+		if first == "" {
+			first = strings.Split(claims.Email, "@")[0]
+		}
+		u = models.User{
+			FirstName: first,
+			LastName:  last,
+			Email:     claims.Email,
+			Role:      models.RoleUser,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+		}
+		res, err := users.InsertOne(ctx, u)
+		if err != nil {
+			return models.User{}, err
+		}
+		u.ID = res.InsertedID.(primitive.ObjectID)
+		if a, err := OpenDefaultAccount(u.ID); err == nil {
+			u.ActiveAccountID = a.ID
+		}
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	if u.Disabled {
+		return models.User{}, ErrOIDCDisabled
+	}
+	if err := LinkIdentity(u.ID, provider, claims); err != nil {
+		return models.User{}, err
+	}
+	return u, nil
+}
+
+// OIDCState is what we remember between redirecting to the provider and
+// handling its callback. It travels in a short-lived signed cookie.
+type OIDCState struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	LinkUser string // user id when linking from settings, empty for sign-in
+}
+
+const oidcStateTTL = 10 * time.Minute
+
+func SignOIDCState(s OIDCState) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"provider": s.Provider,
+		"state":    s.State,
+		"nonce":    s.Nonce,
+		"verifier": s.Verifier,
+		"link":     s.LinkUser,
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+func ParseOIDCState(raw string) (OIDCState, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return OIDCState{}, err
+	}
+
+	str := func(k string) string {
+		v, _ := claims[k].(string)
+		return v
+	}
+	return OIDCState{
+		Provider: str("provider"),
+		State:    str("state"),
+		Nonce:    str("nonce"),
+		Verifier: str("verifier"),
+		LinkUser: str("link"),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// mockOIDCProvider serves a fresh mock issuer for the test.
+func mockOIDCProvider(t *testing.T) OIDCProvider {
+	t.Helper()
+	var m *MockOIDCIssuer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	m = NewMockOIDCIssuer(srv.URL)
+	return OIDCProvider{
+		Name:         "mock",
+		Issuer:       srv.URL,
+		ClientID:     mockOIDCClientID,
+		ClientSecret: mockOIDCClientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// mockOIDCSignIn submits the mock's sign-in form and returns the query the
+// browser would bring back to the callback.
+func mockOIDCSignIn(t *testing.T, authURL string) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.PostForm(authURL, url.Values{
+		"email":          {"Ada@Example.com"},
+		"email_verified": {"true"},
+		"given_name":     {"Ada"},
+		"family_name":    {"Lovelace"},
+	})
+	if err != nil {
+		t.Fatalf("sign in: %v", err)
+	}
+	resp.Body.Close()
+	loc, err := resp.Location()
+	if err != nil {
+		t.Fatalf("sign in: status %d, no redirect", resp.StatusCode)
+	}
+	return loc.Query()
+}
+
+func TestOIDCCallback(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	p := mockOIDCProvider(t)
+
+	tests := []struct {
+		name    string
+		tamper  string // what goes wrong on the way back, if anything
+		wantErr bool
+	}{
+		{"signs in", "", false},
+		{"state doesn't match the cookie", "state", true},
+		{"tampered cookie", "cookie", true},
+		{"wrong PKCE verifier", "verifier", true},
+		{"wrong nonce", "nonce", true},
+		{"code used twice", "reuse", true},
+	}
+	for _, tt := range tests {
+		state, nonce, verifier := NewOIDCAuthRequest()
+		cookie, err := SignOIDCState(OIDCState{Provider: p.Name, State: state, Nonce: nonce, Verifier: verifier})
+		if err != nil {
+			t.Fatalf("%s: sign state: %v", tt.name, err)
+		}
+		authURL, err := OIDCAuthURL(p, state, nonce, verifier)
+		if err != nil {
+			t.Fatalf("%s: auth url: %v", tt.name, err)
+		}
+		q := mockOIDCSignIn(t, authURL)
+		switch tt.tamper {
+		case "state":
+			q.Set("state", q.Get("state")+"x")
+		case "cookie":
+			cookie += "x"
+		}
+
+		// what GetOIDCCallback does with the cookie and the query
+		var claims OIDCClaims
+		st, err := ParseOIDCState(cookie)
+		if err == nil && (st.Provider != p.Name || st.State != q.Get("state")) {
+			err = errors.New("state mismatch")
+		}
+		if err == nil {
+			switch tt.tamper {
+			case "verifier":
+				st.Verifier = verifier + "x"
+			case "nonce":
+				st.Nonce = nonce + "x"
+			}
+			claims, err = OIDCExchangeCode(p, q.Get("code"), st.Verifier, st.Nonce)
+		}
+		if err == nil && tt.tamper == "reuse" {
+			_, err = OIDCExchangeCode(p, q.Get("code"), st.Verifier, st.Nonce)
+		}
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Subject == "" ||
+			claims.FirstName != "Ada" || claims.LastName != "Lovelace") {
+			t.Errorf("%s: claims %+v", tt.name, claims)
+		}
+	}
+}
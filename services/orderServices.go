@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListAccountOrders returns the most recent orders first. limit <= 0 means no limit.
+func ListAccountOrders(accountID primitive.ObjectID, limit int64) ([]models.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection("orders")
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cur, err := coll.Find(ctx, bson.M{"account_id": accountID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.Order, 0)
+	for cur.Next(ctx) {
+		var o models.Order
+		if err := cur.Decode(&o); err != nil {
+			continue
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// ListAccountOrdersPage is ListAccountOrders with offset pagination and a total count.
+func ListAccountOrdersPage(accountID primitive.ObjectID, skip, limit int64) ([]models.Order, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection("orders")
+	filter := bson.M{"account_id": accountID}
+
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cur, err := coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.Order, 0)
+	for cur.Next(ctx) {
+		var o models.Order
+		if err := cur.Decode(&o); err != nil {
+			continue
+		}
+		out = append(out, o)
+	}
+	return out, total, nil
+}
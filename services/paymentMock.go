@@ -0,0 +1,327 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+)
+
+// The mock gateway simulates a card processor for local development. Enable
+// it with PAYMENT_GATEWAY=mock: deposits then send the user to a checkout
+// page under MockPaymentsPath where the outcome can be picked. The result
+// comes back like a real gateway's would, as signed webhooks delivered (at
+// least once, with retries) to PaymentWebhookPath + "mock". Intents live in
+// memory only.
+const (
+	MockPaymentsPath        = "/mock-pay"
+	mockPaymentSigHeader    = "X-Mock-Signature"
+	mockPaymentSigTolerance = 5 * time.Minute
+	mockPaymentRetries      = 5
+)
+
+// Payment methods accepted on the mock checkout page.
+const (
+	MockMethodSuccess = "mock_success"
+	MockMethodDecline = "mock_decline"
+	MockMethodDelayed = "mock_delayed"
+)
+
+type MockPaymentGateway struct {
+	baseURL     string
+	secret      []byte
+	settleDelay time.Duration
+	client      *http.Client
+
+	mu      sync.Mutex
+	intents map[string]*PaymentIntent
+}
+
+type mockWebhook struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		IntentID string          `json:"intent_id"`
+		Amount   decimal.Decimal `json:"amount"`
+		Currency string          `json:"currency"`
+	} `json:"data"`
+}
+
+var mockCheckoutPage = template.Must(template.New("checkout").Parse(`<!doctype html>
+<html lang="en" data-bs-theme="dark">
+<head>
+<meta charset="UTF-8" />
+<title>Mock checkout</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.8/dist/css/bootstrap.min.css" />
+</head>
+<body class="d-flex align-items-center justify-content-center min-vh-100">
+<form method="POST" class="card p-4" style="width: 380px">
+  <h1 class="h5 mb-1">Mock checkout</h1>
+  <div class="text-muted small mb-3">{{ .ID }}</div>
+  <div class="fs-3 fw-semibold mb-3">{{ printf "%.2f" .Amount }} {{ .Currency }}</div>
+  {{ if .Open }}
+  <button class="btn btn-success w-100 mb-2" name="method" value="mock_success">Pay</button>
+  <button class="btn btn-outline-light w-100 mb-2" name="method" value="mock_delayed">Pay, settle later</button>
+  <button class="btn btn-outline-danger w-100" name="method" value="mock_decline">Decline</button>
+  {{ else }}
+  <div class="alert alert-secondary">This payment is {{ .Status }}.</div>
+  <a class="btn btn-primary w-100" href="{{ .Return }}">Back to GoMarket</a>
+  {{ end }}
+</form>
+</body>
+</html>`))
+
+func PaymentMockEnabled() bool {
+	return PaymentGatewayName() == "mock"
+}
+
+// NewMockPaymentGateway signs webhooks with PAYMENT_MOCK_SECRET and settles
+// "later" payments after PAYMENT_MOCK_SETTLE_DELAY (default 30s).
+func NewMockPaymentGateway(baseURL string) *MockPaymentGateway {
+	secret := strings.TrimSpace(os.Getenv("PAYMENT_MOCK_SECRET"))
+	if secret == "" {
+		secret = "gomarket-dev-webhook-secret"
+	}
+	delay := 30 * time.Second
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("PAYMENT_MOCK_SETTLE_DELAY"))); err == nil && d >= 0 {
+		delay = d
+	}
+	return &MockPaymentGateway{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		secret:      []byte(secret),
+		settleDelay: delay,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		intents:     map[string]*PaymentIntent{},
+	}
+}
+
+// PaymentBaseURL is the app's public address, used for checkout links and
+// webhook delivery (PAYMENT_BASE_URL, else the OIDC redirect base).
+func PaymentBaseURL() string {
+	if v := strings.TrimSpace(os.Getenv("PAYMENT_BASE_URL")); v != "" {
+		return v
+	}
+	return oidcBaseURL()
+}
+
+func (m *MockPaymentGateway) Name() string { return "mock" }
+
+func (m *MockPaymentGateway) CreateIntent(ctx context.Context, amount decimal.Decimal, currency, reference string) (PaymentIntent, error) {
+	id := "pi_mock_" + oidcRandom(12)
+	in := &PaymentIntent{
+		ID:          id,
+		Status:      models.PaymentRequiresAction,
+		Amount:      amount,
+		Currency:    currency,
+		Reference:   reference,
+		CheckoutURL: m.baseURL + MockPaymentsPath + "/checkout/" + id,
+	}
+
+	m.mu.Lock()
+	m.intents[id] = in
+	m.mu.Unlock()
+	return *in, nil
+}
+
+// Confirm settles the intent according to the payment method: at once, after
+// the settle delay, or declined.
+func (m *MockPaymentGateway) Confirm(ctx context.Context, intentID, method string) (PaymentIntent, error) {
+	m.mu.Lock()
+	in, ok := m.intents[intentID]
+	if !ok {
+		m.mu.Unlock()
+		return PaymentIntent{}, errors.New("no such intent")
+	}
+	if in.Status != models.PaymentRequiresAction {
+		m.mu.Unlock()
+		return *in, errors.New("intent already confirmed")
+	}
+
+	var events []string
+	delay := time.Duration(0)
+	switch method {
+	case MockMethodSuccess:
+		in.Status = models.PaymentSucceeded
+		events = []string{PaymentEventSucceeded}
+	case MockMethodDelayed:
+		in.Status = models.PaymentProcessing
+		events = []string{PaymentEventProcessing, PaymentEventSucceeded}
+		delay = m.settleDelay
+	case MockMethodDecline:
+		in.Status = models.PaymentFailed
+		events = []string{PaymentEventFailed}
+	default:
+		m.mu.Unlock()
+		return *in, errors.New("unknown payment method")
+	}
+	out := *in
+	m.mu.Unlock()
+
+	go func() {
+		m.deliver(events[0], out)
+		if len(events) > 1 {
+			time.Sleep(delay)
+			m.mu.Lock()
+			in.Status = models.PaymentSucceeded
+			m.mu.Unlock()
+			m.deliver(events[1], out)
+		}
+	}()
+	return out, nil
+}
+
+func (m *MockPaymentGateway) Refund(ctx context.Context, intentID string) error {
+	m.mu.Lock()
+	in, ok := m.intents[intentID]
+	if !ok || in.Status != models.PaymentSucceeded {
+		m.mu.Unlock()
+		return errors.New("nothing to refund")
+	}
+	in.Status = models.PaymentRefunded
+	out := *in
+	m.mu.Unlock()
+
+	go m.deliver(PaymentEventRefunded, out)
+	return nil
+}
+
+// ParseWebhook checks the "t=<unix>,v1=<hex hmac>" header, signed over
+// "<t>.<body>", and rejects stale timestamps.
+func (m *MockPaymentGateway) ParseWebhook(header http.Header, body []byte) (PaymentEvent, error) {
+	var ts, sig string
+	for _, part := range strings.Split(header.Get(mockPaymentSigHeader), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return PaymentEvent{}, errors.New("missing signature")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > mockPaymentSigTolerance || age < -mockPaymentSigTolerance {
+		return PaymentEvent{}, errors.New("stale signature")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, m.sign(ts, body)) {
+		return PaymentEvent{}, errors.New("bad signature")
+	}
+
+	var w mockWebhook
+	if err := json.Unmarshal(body, &w); err != nil || w.ID == "" || w.Data.IntentID == "" {
+		return PaymentEvent{}, errors.New("bad payload")
+	}
+	return PaymentEvent{ID: w.ID, Type: w.Type, IntentID: w.Data.IntentID, Amount: w.Data.Amount, Currency: w.Data.Currency}, nil
+}
+
+func (m *MockPaymentGateway) sign(ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// deliver posts one event, retrying with backoff until it is accepted. Like
+// real gateways it may deliver twice: the event is sent once more after it
+// went through, which the receiver has to ignore.
+func (m *MockPaymentGateway) deliver(typ string, in PaymentIntent) {
+	var w mockWebhook
+	w.ID = "evt_mock_" + oidcRandom(12)
+	w.Type = typ
+	w.Data.IntentID = in.ID
+	w.Data.Amount = in.Amount
+	w.Data.Currency = in.Currency
+	body, _ := json.Marshal(w)
+
+	url := m.baseURL + PaymentWebhookPath + m.Name()
+	backoff := time.Second
+	for attempt := 1; attempt <= mockPaymentRetries; attempt++ {
+		err := m.post(url, body)
+		if err == nil {
+			_ = m.post(url, body) // duplicate delivery
+			return
+		}
+		log.Printf("mock payments: %s attempt %d: %v", w.ID, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	log.Println("mock payments: giving up on", w.ID)
+}
+
+func (m *MockPaymentGateway) post(url string, body []byte) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(mockPaymentSigHeader, "t="+ts+",v1="+hex.EncodeToString(m.sign(ts, body)))
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// ServeHTTP serves the checkout page; strip MockPaymentsPath first.
+func (m *MockPaymentGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutPrefix(r.URL.Path, "/checkout/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	in, found := m.intents[id]
+	var view PaymentIntent
+	if found {
+		view = *in
+	}
+	m.mu.Unlock()
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if _, err := m.Confirm(r.Context(), id, r.FormValue("method")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/settings/transfers", http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = mockCheckoutPage.Execute(w, map[string]any{
+		"ID":       view.ID,
+		"Amount":   view.Amount,
+		"Currency": view.Currency,
+		"Status":   view.Status,
+		"Open":     view.Status == models.PaymentRequiresAction,
+		"Return":   "/settings/transfers",
+	})
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	paymentEventsCollection = "payment_events"
+
+	// PaymentWebhookPath is where gateways post events, followed by the
+	// gateway's name. It is called server to server, so it has no session
+	// or CSRF token; the signature is checked instead.
+	PaymentWebhookPath = "/payments/webhook/"
+)
+
+// Webhook event types.
+const (
+	PaymentEventProcessing = "payment.processing"
+	PaymentEventSucceeded  = "payment.succeeded"
+	PaymentEventFailed     = "payment.failed"
+	PaymentEventRefunded   = "payment.refunded"
+)
+
+var (
+	ErrUnknownGateway     = errors.New("unknown payment gateway")
+	ErrInvalidWebhook     = errors.New("invalid webhook")
+	ErrPaymentNotSettled  = errors.New("payment has not settled")
+	ErrPaymentUnavailable = errors.New("payment gateway unavailable")
+)
+
+// PaymentIntent is a gateway's record of one payment.
+type PaymentIntent struct {
+	ID          string
+	Status      string // models.Payment*
+	Amount      decimal.Decimal
+	Currency    string
+	Reference   string // our transfer id
+	CheckoutURL string // where the user completes the payment
+}
+
+// PaymentEvent is a decoded, verified webhook. Gateways deliver at least
+// once, so the same ID can arrive several times.
+type PaymentEvent struct {
+	ID       string
+	Type     string
+	IntentID string
+	Amount   decimal.Decimal
+	Currency string
+}
+
+// PaymentGateway takes deposits from outside the app. Payments settle
+// asynchronously: the outcome arrives as a signed webhook at
+// PaymentWebhookPath + Name(), and the balance is only credited then.
+type PaymentGateway interface {
+	Name() string
+	CreateIntent(ctx context.Context, amount decimal.Decimal, currency, reference string) (PaymentIntent, error)
+	// Confirm submits a payment method for the intent.
+	Confirm(ctx context.Context, intentID, method string) (PaymentIntent, error)
+	Refund(ctx context.Context, intentID string) error
+	// ParseWebhook verifies the signature and decodes the event.
+	ParseWebhook(header http.Header, body []byte) (PaymentEvent, error)
+}
+
+var (
+	paymentGatewaysMu sync.RWMutex
+	paymentGateways   = map[string]PaymentGateway{}
+)
+
+func RegisterPaymentGateway(g PaymentGateway) {
+	paymentGatewaysMu.Lock()
+	defer paymentGatewaysMu.Unlock()
+	paymentGateways[g.Name()] = g
+}
+
+func paymentGateway(name string) (PaymentGateway, bool) {
+	paymentGatewaysMu.RLock()
+	defer paymentGatewaysMu.RUnlock()
+	g, ok := paymentGateways[name]
+	return g, ok
+}
+
+// PaymentGatewayName reads PAYMENT_GATEWAY; empty means deposits are
+// credited directly (paper money).
+func PaymentGatewayName() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("PAYMENT_GATEWAY")))
+}
+
+// ActivePaymentGateway is the configured gateway, once registered.
+func ActivePaymentGateway() (PaymentGateway, bool) {
+	name := PaymentGatewayName()
+	if name == "" {
+		return nil, false
+	}
+	return paymentGateway(name)
+}
+
+func EnsurePaymentIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = db.Client.Database("gomarket").Collection(fundTransfersCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "gateway", Value: 1}, {Key: "payment_id", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"payment_id": bson.M{"$exists": true}}),
+	})
+}
+
+// startGatewayDeposit opens a payment for a new deposit. The transfer stays
+// pending until the gateway reports the outcome.
+func startGatewayDeposit(ctx context.Context, g PaymentGateway, t *models.FundTransfer) error {
+	intent, err := g.CreateIntent(ctx, t.Amount, AccountCurrency, t.ID.Hex())
+	if err != nil {
+		log.Println("payments: create intent:", err)
+		return ErrPaymentUnavailable
+	}
+	t.Status = models.TransferPending
+	t.CompletedAt = time.Time{}
+	t.Gateway = g.Name()
+	t.PaymentID = intent.ID
+	t.PaymentStatus = intent.Status
+	t.CheckoutURL = intent.CheckoutURL
+	return nil
+}
+
+// HandlePaymentWebhook verifies and applies one webhook delivery. Each event
+// is recorded once; a retry of an event that was already applied is
+// acknowledged without doing anything. If applying fails the record is
+// dropped again so the gateway's next retry gets another go.
+func HandlePaymentWebhook(gateway string, header http.Header, body []byte) error {
+	g, ok := paymentGateway(gateway)
+	if !ok {
+		return ErrUnknownGateway
+	}
+	ev, err := g.ParseWebhook(header, body)
+	if err != nil {
+		log.Println("payments: rejected webhook:", err)
+		return ErrInvalidWebhook
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events := db.Client.Database("gomarket").Collection(paymentEventsCollection)
+	key := g.Name() + ":" + ev.ID
+	_, err = events.InsertOne(ctx, bson.M{
+		"_id":         key,
+		"gateway":     g.Name(),
+		"type":        ev.Type,
+		"intent_id":   ev.IntentID,
+		"received_at": time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := applyPaymentEvent(ctx, g, ev); err != nil {
+		_, _ = events.DeleteOne(ctx, bson.M{"_id": key})
+		return err
+	}
+	return nil
+}
+
+// applyPaymentEvent moves the deposit along. Every step only matches the
+// state it comes from, so applying an event twice changes nothing.
+func applyPaymentEvent(ctx context.Context, g PaymentGateway, ev PaymentEvent) error {
+	transfers := db.Client.Database("gomarket").Collection(fundTransfersCollection)
+
+	var t models.FundTransfer
+	err := transfers.FindOne(ctx, bson.M{"gateway": g.Name(), "payment_id": ev.IntentID}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		log.Println("payments: event", ev.ID, "for unknown payment", ev.IntentID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	open := bson.M{"$in": bson.A{models.PaymentRequiresAction, models.PaymentProcessing}}
+	now := time.Now().UTC()
+
+	switch ev.Type {
+	case PaymentEventProcessing:
+		_, err := transfers.UpdateOne(ctx,
+			bson.M{"_id": t.ID, "payment_status": models.PaymentRequiresAction},
+			bson.M{"$set": bson.M{"payment_status": models.PaymentProcessing}})
+		return err
+
+	case PaymentEventFailed:
+		res, err := transfers.UpdateOne(ctx,
+			bson.M{"_id": t.ID, "status": models.TransferPending, "payment_status": open},
+			bson.M{"$set": bson.M{
+				"status":         models.TransferRejected,
+				"payment_status": models.PaymentFailed,
+				"review_reason":  "The payment failed.",
+				"rejected_at":    now,
+			}})
+		if err == nil && res.ModifiedCount > 0 {
+			releaseDailyTransfer(ctx, t)
+		}
+		return err
+
+	case PaymentEventRefunded:
+		// the returned document is the one from before the update, so only
+		// the first delivery sees a status other than refunded
+		err := transfers.FindOneAndUpdate(ctx,
+			bson.M{"_id": t.ID, "payment_status": bson.M{"$ne": models.PaymentRefunded}},
+			bson.M{"$set": bson.M{"payment_status": models.PaymentRefunded}},
+		).Decode(&t)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if t.Status != models.TransferCompleted {
+			return nil
+		}
+		return reverseGatewayDeposit(ctx, t)
+
+	case PaymentEventSucceeded:
+		if ev.Amount.Cmp(t.Amount) != 0 || !strings.EqualFold(ev.Currency, AccountCurrency) {
+			log.Println("payments: event", ev.ID, "paid", ev.Amount, ev.Currency, "for a deposit of", t.Amount, AccountCurrency)
+			res, err := transfers.UpdateOne(ctx,
+				bson.M{"_id": t.ID, "status": models.TransferPending, "payment_status": open},
+				bson.M{"$set": bson.M{
+					"status":         models.TransferRejected,
+					"payment_status": models.PaymentSucceeded,
+					"review_reason":  "The payment didn't match the deposit.",
+					"rejected_at":    now,
+				}})
+			if err != nil {
+				return err
+			}
+			if res.ModifiedCount > 0 {
+				releaseDailyTransfer(ctx, t)
+				if err := g.Refund(ctx, t.PaymentID); err != nil {
+					log.Println("payments: refund", t.PaymentID, err)
+				}
+			}
+			return nil
+		}
+
+		err := transfers.FindOneAndUpdate(ctx,
+			bson.M{"_id": t.ID, "status": models.TransferPending, "payment_status": open},
+			bson.M{"$set": bson.M{"payment_status": models.PaymentSucceeded}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&t)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// large deposits still wait for an admin, now that the money is in
+		limits := CurrentFundLimits()
+		if limits.ApprovalThreshold.IsPositive() && t.Amount.GreaterThan(limits.ApprovalThreshold) {
+			return nil
+		}
+		return completeGatewayDeposit(ctx, t.ID)
+	}
+
+	log.Println("payments: ignoring event type", ev.Type)
+	return nil
+}
+
+// completeGatewayDeposit credits a paid deposit exactly once.
+func completeGatewayDeposit(ctx context.Context, id primitive.ObjectID) error {
+	t, err := claimPendingTransfer(ctx,
+		bson.M{"_id": id, "payment_status": models.PaymentSucceeded},
+		bson.M{"status": models.TransferCompleted, "completed_at": time.Now().UTC()})
+	if err == ErrTransferNotPending {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Client.Database("gomarket").Collection(accountsCollection).UpdateOne(ctx,
+		bson.M{"_id": t.AccountID},
+		bson.M{"$inc": bson.M{"balance": t.Amount}, "$set": bson.M{"updated_at": time.Now().UTC()}}); err != nil {
+		log.Println("payments: credit", t.ID.Hex(), err)
+		return err
+	}
+	recordTransferCash(ctx, t)
+	return nil
+}
+
+// reverseGatewayDeposit takes back a credited deposit the gateway refunded
+// or charged back. The balance may go negative if the money was already
+// spent.
+func reverseGatewayDeposit(ctx context.Context, t models.FundTransfer) error {
+	if _, err := db.Client.Database("gomarket").Collection(accountsCollection).UpdateOne(ctx,
+		bson.M{"_id": t.AccountID},
+		bson.M{"$inc": bson.M{"balance": t.Amount.Neg()}, "$set": bson.M{"updated_at": time.Now().UTC()}}); err != nil {
+		log.Println("payments: reverse", t.ID.Hex(), err)
+		// let the redelivered event try again
+		_, _ = db.Client.Database("gomarket").Collection(fundTransfersCollection).UpdateOne(ctx,
+			bson.M{"_id": t.ID}, bson.M{"$set": bson.M{"payment_status": t.PaymentStatus}})
+		return err
+	}
+	_, _ = db.Client.Database("gomarket").Collection(fundTransfersCollection).UpdateOne(ctx,
+		bson.M{"_id": t.ID}, bson.M{"$set": bson.M{"review_reason": "The payment was refunded."}})
+
+	// no Ref: the deposit's own ledger row already uses the transfer id
+	recordCashTransaction(ctx, models.CashTransaction{
+		UserID:    t.UserID,
+		AccountID: t.AccountID,
+		Type:      models.CashWithdrawal,
+		Amount:    t.Amount.Neg(),
+		Note:      "Deposit refunded",
+		CreatedAt: time.Now().UTC(),
+	})
+	return nil
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Performance summarises how an account did over a range. Returns are in
+// percent, like PnLPct on the portfolio page.
+type Performance struct {
+	Range string    `json:"range"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+
+	StartValue decimal.Decimal `json:"start_value"`
+	EndValue   decimal.Decimal `json:"end_value"`
+	NetFlows   decimal.Decimal `json:"net_flows"` // deposits minus withdrawals in the range
+
+	// Time-weighted: the return of the investments themselves, with the
+	// effect of deposits and withdrawals taken out.
+	HasTWR bool    `json:"has_twr"`
+	TWRPct float64 `json:"twr_pct"`
+
+	// Money-weighted (XIRR): the return on the money actually put in, so
+	// the timing of deposits matters. Shown for the period and annualised.
+	HasMWR           bool    `json:"has_mwr"`
+	MWRPct           float64 `json:"mwr_pct"`
+	MWRAnnualisedPct float64 `json:"mwr_annualised_pct"`
+
+	Benchmark          string  `json:"benchmark"`
+	HasBenchmark       bool    `json:"has_benchmark"`
+	BenchmarkReturnPct float64 `json:"benchmark_return_pct"`
+	ExcessReturnPct    float64 `json:"excess_return_pct"` // TWR minus benchmark
+}
+
+type valuation struct {
+	t     time.Time
+	value decimal.Decimal
+}
+
+type cashFlow struct {
+	t      time.Time
+	amount float64 // from the investor's point of view: paid in < 0, taken out > 0
+}
+
+// PortfolioPerformance computes TWR, MWR and the benchmark return for the
+// range from stored snapshots, cash flows and daily prices.
+func PortfolioPerformance(accountID primitive.ObjectID, r string) (Performance, error) {
+	now := time.Now().UTC()
+	from, err := portfolioRangeStart(r, now)
+	if err != nil {
+		return Performance{}, err
+	}
+
+	perf := Performance{Range: strings.ToUpper(r), Benchmark: BenchmarkSymbol()}
+
+	points, err := loadValuations(accountID, from)
+	if err != nil {
+		return Performance{}, err
+	}
+	if len(points) < 2 {
+		return perf, nil
+	}
+
+	start, end := points[0], points[len(points)-1]
+	perf.From, perf.To = start.t, end.t
+	perf.StartValue, perf.EndValue = start.value, end.value
+
+	txs, err := ListCashTransactions(accountID, start.t, end.t)
+	if err != nil {
+		return Performance{}, err
+	}
+	for _, t := range txs {
+		perf.NetFlows = perf.NetFlows.Add(t.Amount)
+	}
+
+	twr, ok := timeWeightedReturn(points, txs)
+	if ok {
+		perf.HasTWR, perf.TWRPct = true, roundPct(twr)
+	}
+
+	flows := []cashFlow{{t: start.t, amount: -start.value.Float64()}}
+	for _, t := range txs {
+		flows = append(flows, cashFlow{t: t.CreatedAt, amount: -t.Amount.Float64()})
+	}
+	flows = append(flows, cashFlow{t: end.t, amount: end.value.Float64()})
+	if annual, ok := xirr(flows); ok {
+		years := end.t.Sub(start.t).Hours() / 24 / 365
+		perf.HasMWR = true
+		perf.MWRAnnualisedPct = roundPct(annual)
+		perf.MWRPct = roundPct(math.Pow(1+annual, years) - 1)
+	}
+
+	if ret, ok := benchmarkReturn(perf.Benchmark, start.t, end.t); ok {
+		perf.HasBenchmark, perf.BenchmarkReturnPct = true, roundPct(ret)
+		if perf.HasTWR {
+			perf.ExcessReturnPct = roundPct(twr - ret)
+		}
+	}
+	return perf, nil
+}
+
+func roundPct(fraction float64) float64 {
+	return math.Round(fraction*10000) / 100
+}
+
+// loadValuations returns the account value at the start of the range (the
+// last snapshot before it, or the first one inside it), each daily close
+// after that, and the latest snapshot.
+func loadValuations(accountID primitive.ObjectID, from time.Time) ([]valuation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(snapshotsCollection)
+	proj := bson.M{"taken_at": 1, "total_value": 1}
+
+	var first models.PortfolioSnapshot
+	err := coll.FindOne(ctx, bson.M{"account_id": accountID, "taken_at": bson.M{"$lte": from}},
+		options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: -1}}).SetProjection(proj)).Decode(&first)
+	if err == mongo.ErrNoDocuments {
+		err = coll.FindOne(ctx, bson.M{"account_id": accountID, "taken_at": bson.M{"$gt": from}},
+			options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: 1}}).SetProjection(proj)).Decode(&first)
+	}
+	if err == mongo.ErrNoDocuments {
+		return []valuation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []valuation{{t: first.TakenAt, value: first.TotalValue}}
+
+	cur, err := coll.Find(ctx,
+		bson.M{"account_id": accountID, "kind": models.SnapshotDaily, "taken_at": bson.M{"$gt": first.TakenAt}},
+		options.Find().SetSort(bson.D{{Key: "taken_at", Value: 1}}).SetProjection(proj))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var s models.PortfolioSnapshot
+		if err := cur.Decode(&s); err != nil {
+			continue
+		}
+		out = append(out, valuation{t: s.TakenAt, value: s.TotalValue})
+	}
+
+	var latest models.PortfolioSnapshot
+	err = coll.FindOne(ctx, bson.M{"account_id": accountID},
+		options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: -1}}).SetProjection(proj)).Decode(&latest)
+	if err == nil && latest.TakenAt.After(out[len(out)-1].t) {
+		out = append(out, valuation{t: latest.TakenAt, value: latest.TotalValue})
+	}
+	return out, nil
+}
+
+// timeWeightedReturn chains the sub-period returns between valuations.
+// Flows are assumed to arrive at the start of their sub-period, which suits
+// deposits that are invested straight away.
+func timeWeightedReturn(points []valuation, txs []models.CashTransaction) (float64, bool) {
+	growth := 1.0
+	used := false
+	j := 0
+
+	for i := 1; i < len(points); i++ {
+		flow := decimal.Zero
+		for j < len(txs) && !txs[j].CreatedAt.After(points[i].t) {
+			if txs[j].CreatedAt.After(points[i-1].t) {
+				flow = flow.Add(txs[j].Amount)
+			}
+			j++
+		}
+
+		base := points[i-1].value.Add(flow)
+		if !base.IsPositive() {
+			continue // nothing invested in this sub-period
+		}
+		growth *= points[i].value.Float64() / base.Float64()
+		used = true
+	}
+	return growth - 1, used
+}
+
+// xirr solves for the annual rate that makes the flows' net present value
+// zero, using Newton's method with a bisection fallback.
+func xirr(flows []cashFlow) (float64, bool) {
+	if len(flows) < 2 {
+		return 0, false
+	}
+	hasIn, hasOut := false, false
+	for _, f := range flows {
+		if f.amount < 0 {
+			hasIn = true
+		}
+		if f.amount > 0 {
+			hasOut = true
+		}
+	}
+	if !hasIn || !hasOut {
+		return 0, false
+	}
+
+	t0 := flows[0].t
+	years := make([]float64, len(flows))
+	for i, f := range flows {
+		years[i] = f.t.Sub(t0).Hours() / 24 / 365
+	}
+	if years[len(years)-1] <= 0 {
+		return 0, false
+	}
+
+	npv := func(rate float64) (v, dv float64) {
+		for i, f := range flows {
+			d := math.Pow(1+rate, years[i])
+			v += f.amount / d
+			dv -= years[i] * f.amount / (d * (1 + rate))
+		}
+		return v, dv
+	}
+
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		v, dv := npv(rate)
+		if math.Abs(v) < 1e-7 {
+			return rate, true
+		}
+		if dv == 0 || math.IsNaN(dv) {
+			break
+		}
+		next := rate - v/dv
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < 1e-10 {
+			return next, true
+		}
+		rate = next
+	}
+
+	lo, hi := -0.9999, 1e6
+	vlo, _ := npv(lo)
+	vhi, _ := npv(hi)
+	if math.IsNaN(vlo) || math.IsNaN(vhi) || vlo*vhi > 0 {
+		return 0, false
+	}
+	for i := 0; i < 300; i++ {
+		mid := (lo + hi) / 2
+		vmid, _ := npv(mid)
+		if math.Abs(vmid) < 1e-7 || hi-lo < 1e-10 {
+			return mid, true
+		}
+		if vlo*vmid < 0 {
+			hi = mid
+		} else {
+			lo, vlo = mid, vmid
+		}
+	}
+	return (lo + hi) / 2, true
+}
+
+// benchmarkReturn is the price return of symbol between the closes on or
+// before from and to.
+func benchmarkReturn(symbol string, from, to time.Time) (float64, bool) {
+	closes, err := DailyCloses(symbol, from.AddDate(0, 0, -7), to)
+	if err != nil || len(closes) < 2 {
+		return 0, false
+	}
+
+	startDay := from.UTC().Format("2006-01-02")
+	start := closes[0]
+	for _, c := range closes {
+		if c.Day > startDay {
+			break
+		}
+		start = c
+	}
+	end := closes[len(closes)-1]
+	if start.Close <= 0 || end.Day <= start.Day {
+		return 0, false
+	}
+	return end.Close/start.Close - 1, true
+}
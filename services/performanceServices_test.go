@@ -0,0 +1,81 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+)
+
+var perfStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func perfDay(n int) time.Time { return perfStart.AddDate(0, 0, n) }
+
+func TestTimeWeightedReturn(t *testing.T) {
+	vals := func(vs ...string) []valuation {
+		out := make([]valuation, len(vs))
+		for i, v := range vs {
+			out[i] = valuation{t: perfDay(i * 10), value: decimal.MustParse(v)}
+		}
+		return out
+	}
+	tx := func(day int, amount string) models.CashTransaction {
+		return models.CashTransaction{Amount: decimal.MustParse(amount), CreatedAt: perfDay(day)}
+	}
+
+	tests := []struct {
+		name   string
+		points []valuation
+		txs    []models.CashTransaction
+		want   float64
+		ok     bool
+	}{
+		{"no points", nil, nil, 0, false},
+		{"one point", vals("100"), nil, 0, false},
+		{"growth", vals("100", "110", "121"), nil, 0.21, true},
+		{"loss", vals("100", "80"), nil, -0.2, true},
+		{"deposit is not a return", vals("100", "220"), []models.CashTransaction{tx(5, "100")}, 0.1, true},
+		{"nor a withdrawal", vals("200", "110"), []models.CashTransaction{tx(5, "-100")}, 0.1, true},
+		{"flow on a valuation counts toward the period ending there",
+			vals("100", "220", "242"), []models.CashTransaction{tx(10, "100")}, 0.21, true},
+		{"flows outside the range are ignored",
+			vals("100", "110"), []models.CashTransaction{tx(0, "500"), tx(11, "500")}, 0.1, true},
+		{"empty start skips the period", vals("0", "100", "105"), []models.CashTransaction{tx(10, "100")}, 0.05, true},
+		{"nothing ever invested", vals("0", "0"), nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := timeWeightedReturn(tt.points, tt.txs)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestXIRR(t *testing.T) {
+	flow := func(day int, amount float64) cashFlow { return cashFlow{t: perfDay(day), amount: amount} }
+
+	tests := []struct {
+		name  string
+		flows []cashFlow
+		want  float64
+		ok    bool
+	}{
+		{"one year", []cashFlow{flow(0, -1000), flow(365, 1100)}, 0.1, true},
+		{"two years", []cashFlow{flow(0, -1000), flow(730, 1210)}, 0.1, true},
+		{"loss", []cashFlow{flow(0, -1000), flow(365, 900)}, -0.1, true},
+		{"two deposits", []cashFlow{flow(0, -1000), flow(365, -1000), flow(730, 2310)}, 0.1, true},
+		{"withdrawal midway", []cashFlow{flow(0, -1000), flow(365, 600), flow(730, 550)}, 0.1, true},
+		{"a fifth of a year", []cashFlow{flow(0, -1000), flow(73, 1100)}, 0.61051, true},
+		{"one flow", []cashFlow{flow(0, -1000)}, 0, false},
+		{"only deposits", []cashFlow{flow(0, -1000), flow(365, -1000)}, 0, false},
+		{"no time passes", []cashFlow{flow(0, -1000), flow(0, 1100)}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := xirr(tt.flows)
+		if ok != tt.ok || (ok && math.Abs(got-tt.want) > 1e-4) {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
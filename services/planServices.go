@@ -0,0 +1,561 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	plansCollection    = "investment_plans"
+	planRunsCollection = "plan_runs"
+
+	// Plans run once a day at this UTC hour, when US markets are open.
+	planRunHour      = 15
+	maxPlanItems     = 20
+	maxPlansPerUser  = 20
+	planDateLayout   = "2006-01-02"
+	planRunsPageSize = 50
+
+	// How long a due run waits for all of its markets to be open.
+	planMarketWait = 24 * time.Hour
+)
+
+var ErrPlanNotFound = errors.New("plan not found")
+
+// PlanInput is the plan form as submitted. Items has one "SYMBOL number"
+// per line: a percentage in weights mode, an amount in amounts mode.
+type PlanInput struct {
+	Name     string
+	Mode     string
+	Amount   string
+	Items    string
+	Schedule string
+	Start    string
+	End      string
+}
+
+func EnsurePlanIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+
+	_, _ = d.Collection(plansCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	_, _ = d.Collection(plansCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_run_at", Value: 1}},
+	})
+	_, _ = d.Collection(planRunsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "plan_id", Value: 1}, {Key: "scheduled_for", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+func CreateInvestmentPlan(acct models.Account, in PlanInput) (models.InvestmentPlan, map[string]string) {
+	now := time.Now().UTC()
+	p, errs := parsePlanInput(in, now)
+	if len(errs) > 0 {
+		return models.InvestmentPlan{}, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(plansCollection)
+	n, err := coll.CountDocuments(ctx, bson.M{"user_id": acct.UserID, "status": bson.M{"$ne": models.PlanEnded}})
+	if err != nil {
+		return models.InvestmentPlan{}, map[string]string{"_form": "Could not create the plan."}
+	}
+	if n >= maxPlansPerUser {
+		return models.InvestmentPlan{}, map[string]string{"_form": fmt.Sprintf("You can have at most %d plans.", maxPlansPerUser)}
+	}
+
+	for _, it := range p.Items {
+		if msg := checkImportSymbol(it.Symbol); msg != "" {
+			return models.InvestmentPlan{}, map[string]string{"items": it.Symbol + ": " + msg}
+		}
+	}
+
+	p.UserID = acct.UserID
+	p.AccountID = acct.ID
+	p.Status = models.PlanActive
+	p.NextRunAt = nextPlanRun(p, now)
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	if p.NextRunAt.IsZero() {
+		return models.InvestmentPlan{}, map[string]string{"end": "The plan would never run."}
+	}
+
+	res, err := coll.InsertOne(ctx, p)
+	if err != nil {
+		return models.InvestmentPlan{}, map[string]string{"_form": "Could not create the plan."}
+	}
+	p.ID = res.InsertedID.(primitive.ObjectID)
+	return p, nil
+}
+
+func parsePlanInput(in PlanInput, now time.Time) (models.InvestmentPlan, map[string]string) {
+	errs := map[string]string{}
+	p := models.InvestmentPlan{
+		Name:     strings.TrimSpace(in.Name),
+		Mode:     strings.TrimSpace(in.Mode),
+		Schedule: strings.TrimSpace(in.Schedule),
+	}
+
+	if p.Name == "" {
+		errs["name"] = "Give the plan a name."
+	} else if len(p.Name) > 60 {
+		errs["name"] = "Keep the name under 60 characters."
+	}
+
+	switch p.Schedule {
+	case models.PlanDaily, models.PlanWeekly, models.PlanMonthly:
+	default:
+		errs["schedule"] = "Choose a schedule."
+	}
+
+	if p.Mode != models.PlanModeWeights && p.Mode != models.PlanModeAmounts {
+		errs["mode"] = "Choose weights or fixed amounts."
+		return p, errs
+	}
+
+	items, msg := parsePlanItems(in.Items)
+	if msg != "" {
+		errs["items"] = msg
+	}
+	p.Items = items
+
+	if p.Mode == models.PlanModeWeights {
+		amount, err := decimal.Parse(strings.TrimSpace(in.Amount))
+		p.Amount = money(amount)
+		if err != nil || !p.Amount.IsPositive() {
+			errs["amount"] = "Enter an amount greater than 0."
+		} else if p.Amount.GreaterThan(maxAmount) {
+			errs["amount"] = tooLarge
+		}
+
+		total := 0.0
+		for i := range p.Items {
+			p.Items[i].Weight = p.Items[i].Amount.Float64()
+			p.Items[i].Amount = decimal.Zero
+			total += p.Items[i].Weight
+		}
+		if msg == "" && math.Abs(total-100) > 0.01 {
+			errs["items"] = fmt.Sprintf("Weights must add up to 100 (they add up to %g).", total)
+		}
+	} else {
+		p.Amount = decimal.Zero
+		for i := range p.Items {
+			p.Items[i].Amount = money(p.Items[i].Amount)
+			p.Amount = p.Amount.Add(p.Items[i].Amount)
+		}
+		if p.Amount.GreaterThan(maxAmount) {
+			errs["items"] = tooLarge
+		}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	start, err := time.Parse(planDateLayout, strings.TrimSpace(in.Start))
+	if err != nil {
+		errs["start"] = "Enter a start date."
+	} else if start.Before(today) {
+		errs["start"] = "The start date can't be in the past."
+	}
+	p.StartDate = start
+
+	if v := strings.TrimSpace(in.End); v != "" {
+		end, err := time.Parse(planDateLayout, v)
+		if err != nil {
+			errs["end"] = "Enter a valid end date."
+		} else if !start.IsZero() && end.Before(start) {
+			errs["end"] = "The end date must be after the start date."
+		}
+		p.EndDate = end
+	}
+
+	return p, errs
+}
+
+// parsePlanItems reads "SYMBOL number" lines; commas and colons work as
+// separators too. The number is stored in Amount until the mode is known.
+func parsePlanItems(text string) ([]models.PlanItem, string) {
+	items := []models.PlanItem{}
+	seen := map[string]bool{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ':' || r == '%'
+		})
+		if len(fields) != 2 {
+			return items, fmt.Sprintf("Line %d: use \"SYMBOL number\".", i+1)
+		}
+		sym := strings.ToUpper(fields[0])
+		v, err := decimal.Parse(fields[1])
+		if err != nil || !v.IsPositive() {
+			return items, fmt.Sprintf("Line %d: %q is not a positive number.", i+1, fields[1])
+		}
+		if seen[sym] {
+			return items, sym + " is listed twice."
+		}
+		seen[sym] = true
+		items = append(items, models.PlanItem{Symbol: sym, Amount: v})
+	}
+	if len(items) == 0 {
+		return items, "Add at least one symbol."
+	}
+	if len(items) > maxPlanItems {
+		return items, fmt.Sprintf("A plan can hold at most %d symbols.", maxPlanItems)
+	}
+	return items, ""
+}
+
+// nextPlanRun is the first scheduled run strictly after `after`, or zero
+// once the plan is past its end date. Monthly plans run on the start date's
+// day of the month, or the month's last day if it is shorter.
+func nextPlanRun(p models.InvestmentPlan, after time.Time) time.Time {
+	first := time.Date(p.StartDate.Year(), p.StartDate.Month(), p.StartDate.Day(), planRunHour, 0, 0, 0, time.UTC)
+
+	var next time.Time
+	switch p.Schedule {
+	case models.PlanDaily, models.PlanWeekly:
+		step := 24 * time.Hour
+		if p.Schedule == models.PlanWeekly {
+			step *= 7
+		}
+		next = first
+		if !next.After(after) {
+			next = first.Add((after.Sub(first)/step + 1) * step)
+		}
+	case models.PlanMonthly:
+		i := (after.Year()-first.Year())*12 + int(after.Month()-first.Month())
+		if i < 0 {
+			i = 0
+		}
+		for ; ; i++ {
+			next = monthlyPlanRun(first, i)
+			if next.After(after) {
+				break
+			}
+		}
+	default:
+		return time.Time{}
+	}
+
+	if !p.EndDate.IsZero() && !next.Before(p.EndDate.AddDate(0, 0, 1)) {
+		return time.Time{}
+	}
+	return next
+}
+
+func monthlyPlanRun(first time.Time, months int) time.Time {
+	m := time.Date(first.Year(), first.Month()+time.Month(months), 1, planRunHour, 0, 0, 0, time.UTC)
+	last := m.AddDate(0, 1, -1).Day()
+	day := first.Day()
+	if day > last {
+		day = last
+	}
+	return m.AddDate(0, 0, day-1)
+}
+
+func ListInvestmentPlans(accountID primitive.ObjectID) ([]models.InvestmentPlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(plansCollection).Find(ctx,
+		bson.M{"account_id": accountID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.InvestmentPlan, 0)
+	for cur.Next(ctx) {
+		var p models.InvestmentPlan
+		if err := cur.Decode(&p); err != nil {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func getInvestmentPlan(ctx context.Context, userID, planID primitive.ObjectID) (models.InvestmentPlan, error) {
+	var p models.InvestmentPlan
+	err := db.Client.Database("gomarket").Collection(plansCollection).
+		FindOne(ctx, bson.M{"_id": planID, "user_id": userID}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return p, ErrPlanNotFound
+	}
+	return p, err
+}
+
+func PauseInvestmentPlan(userID, planID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := db.Client.Database("gomarket").Collection(plansCollection).UpdateOne(ctx,
+		bson.M{"_id": planID, "user_id": userID, "status": models.PlanActive},
+		bson.M{"$set": bson.M{"status": models.PlanPaused, "updated_at": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrPlanNotFound
+	}
+	return nil
+}
+
+// ResumeInvestmentPlan picks the schedule up from now; runs missed while
+// paused are not made up.
+func ResumeInvestmentPlan(userID, planID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := getInvestmentPlan(ctx, userID, planID)
+	if err != nil {
+		return err
+	}
+	if p.Status != models.PlanPaused {
+		return ErrPlanNotFound
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"status": models.PlanActive, "updated_at": now}
+	if next := nextPlanRun(p, now); next.IsZero() {
+		set["status"] = models.PlanEnded
+	} else {
+		set["next_run_at"] = next
+	}
+
+	_, err = db.Client.Database("gomarket").Collection(plansCollection).UpdateOne(ctx,
+		bson.M{"_id": planID, "user_id": userID, "status": models.PlanPaused},
+		bson.M{"$set": set})
+	return err
+}
+
+// DeleteInvestmentPlan removes the plan and its run history. The orders it
+// placed stay in the ledger.
+func DeleteInvestmentPlan(userID, planID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+	res, err := d.Collection(plansCollection).DeleteOne(ctx, bson.M{"_id": planID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrPlanNotFound
+	}
+	_, _ = d.Collection(planRunsCollection).DeleteMany(ctx, bson.M{"plan_id": planID})
+	return nil
+}
+
+// ListPlanRuns returns a plan's most recent runs, newest first.
+func ListPlanRuns(userID, planID primitive.ObjectID) ([]models.PlanRun, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(planRunsCollection).Find(ctx,
+		bson.M{"plan_id": planID, "user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "scheduled_for", Value: -1}}).SetLimit(planRunsPageSize))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.PlanRun, 0)
+	for cur.Next(ctx) {
+		var r models.PlanRun
+		if err := cur.Decode(&r); err != nil {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// StartInvestmentPlanScheduler executes due plans once a minute.
+func StartInvestmentPlanScheduler(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runPlanTick(now.UTC())
+			}
+		}
+	}()
+}
+
+func runPlanTick(now time.Time) {
+	// due runs wait for the market to open
+	if !MarketOpen(now) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(plansCollection)
+	cur, err := coll.Find(ctx, bson.M{"status": models.PlanActive, "next_run_at": bson.M{"$lte": now}})
+	if err != nil {
+		log.Println("plans:", err)
+		return
+	}
+	var due []models.InvestmentPlan
+	if err := cur.All(ctx, &due); err != nil {
+		log.Println("plans:", err)
+		return
+	}
+
+	for _, p := range due {
+		// A basket that spans exchanges waits until all of them are
+		// open, but no longer than planMarketWait so a plan whose markets
+		// never overlap still runs; orders on a closed market then fail.
+		if now.Sub(p.NextRunAt) < planMarketWait && !marketsOpen(planSymbols(p), now) {
+			continue
+		}
+
+		// Claim the run by moving next_run_at on; whoever moves it runs
+		// the plan. A plan that was down for several periods runs once.
+		set := bson.M{"last_run_at": now, "updated_at": now}
+		if next := nextPlanRun(p, now); next.IsZero() {
+			set["status"] = models.PlanEnded
+		} else {
+			set["next_run_at"] = next
+		}
+		res, err := coll.UpdateOne(ctx,
+			bson.M{"_id": p.ID, "status": models.PlanActive, "next_run_at": p.NextRunAt},
+			bson.M{"$set": set})
+		if err != nil || res.ModifiedCount == 0 {
+			continue
+		}
+		executePlanRun(p, now)
+	}
+}
+
+func planSymbols(p models.InvestmentPlan) []string {
+	syms := make([]string, 0, len(p.Items))
+	for _, it := range p.Items {
+		syms = append(syms, it.Symbol)
+	}
+	return syms
+}
+
+// executePlanRun buys the plan's basket at market. If the available cash
+// doesn't cover the whole run nothing is bought and the user is notified.
+// Each symbol is bought by amount, in fractional shares.
+func executePlanRun(p models.InvestmentPlan, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	run := models.PlanRun{
+		PlanID:       p.ID,
+		UserID:       p.UserID,
+		AccountID:    p.AccountID,
+		ScheduledFor: p.NextRunAt,
+		RanAt:        now,
+	}
+
+	acct, ok := db.GetAccount(p.AccountID)
+	switch {
+	case !ok:
+		run.Status = models.PlanRunFailed
+		run.Note = "Could not load the account."
+	case acct.Available().LessThan(p.Amount):
+		run.Status = models.PlanRunSkipped
+		run.Note = fmt.Sprintf("Available cash %.2f in %s is less than the %.2f this run needs.", acct.Available(), acct.Name, p.Amount)
+	default:
+		bought := 0
+		for _, it := range p.Items {
+			o := placePlanOrder(p, it)
+			if o.Error == "" {
+				bought++
+				run.Invested = run.Invested.Add(o.Cost)
+			}
+			run.Orders = append(run.Orders, o)
+		}
+		switch bought {
+		case len(p.Items):
+			run.Status = models.PlanRunCompleted
+		case 0:
+			run.Status = models.PlanRunFailed
+		default:
+			run.Status = models.PlanRunPartial
+		}
+	}
+
+	if _, err := db.Client.Database("gomarket").Collection(planRunsCollection).InsertOne(ctx, run); err != nil {
+		log.Println("plans: record run", p.ID.Hex(), err)
+	}
+
+	if run.Status == models.PlanRunCompleted {
+		return
+	}
+	n := models.Notification{UserID: p.UserID, Kind: "plan_" + run.Status, Link: "/plans"}
+	switch run.Status {
+	case models.PlanRunSkipped:
+		n.Title = "Investment plan \"" + p.Name + "\" was skipped"
+		n.Body = run.Note + " Deposit funds to keep the plan running."
+	case models.PlanRunPartial:
+		n.Title = "Investment plan \"" + p.Name + "\" ran partially"
+		n.Body = "Some orders could not be placed. See the run history for details."
+	default:
+		n.Title = "Investment plan \"" + p.Name + "\" failed"
+		n.Body = "No orders could be placed. See the run history for details."
+	}
+	Notify(ctx, n)
+}
+
+func placePlanOrder(p models.InvestmentPlan, it models.PlanItem) models.PlanRunOrder {
+	o := models.PlanRunOrder{Symbol: it.Symbol, Amount: it.Amount}
+	if p.Mode == models.PlanModeWeights {
+		o.Amount = money(p.Amount.Mul(decimal.NewFromFloat(it.Weight)).Div(decimal.New(100)))
+	}
+
+	res, errs := MarketBuyAmount(p.AccountID, it.Symbol, o.Amount)
+	if len(errs) > 0 {
+		o.Error = firstPlanError(errs)
+		return o
+	}
+	o.Qty = res.Qty
+	o.Price = res.FillPrice
+	o.Cost = res.Cost
+	return o
+}
+
+func firstPlanError(errs map[string]string) string {
+	for _, k := range []string{"_form", "balance", "symbol", "qty", "amount"} {
+		if msg, ok := errs[k]; ok {
+			return msg
+		}
+	}
+	for _, msg := range errs {
+		return msg
+	}
+	return "The order failed."
+}
@@ -12,13 +12,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func ListUserPositions(userID primitive.ObjectID) ([]models.Position, error) {
+// ListAccountPositions returns an account's open positions by symbol.
+func ListAccountPositions(accountID primitive.ObjectID) ([]models.Position, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
 
 	coll := db.Client.Database("gomarket").Collection("positions")
 
-	cur, err := coll.Find(ctx, bson.M{"user_id": userID, "qty": bson.M{"$gt": 0}},
+	cur, err := coll.Find(ctx, bson.M{"account_id": accountID, "qty": bson.M{"$ne": 0}},
 		options.Find().SetSort(bson.D{{Key: "symbol", Value: 1}}))
 	if err != nil {
 		return nil, err
@@ -12,7 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func GetUserPosition(userID primitive.ObjectID, symbol string) (*models.Position, error) {
+func GetAccountPosition(accountID primitive.ObjectID, symbol string) (*models.Position, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
@@ -20,7 +20,7 @@ func GetUserPosition(userID primitive.ObjectID, symbol string) (*models.Position
 	coll := db.Client.Database("gomarket").Collection("positions")
 
 	var p models.Position
-	err := coll.FindOne(ctx, bson.M{"user_id": userID, "symbol": sym}).Decode(&p)
+	err := coll.FindOne(ctx, bson.M{"account_id": accountID, "symbol": sym}).Decode(&p)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	dailyPricesCollection = "daily_prices"
+	defaultBenchmark      = "SPY"
+
+	// don't ask the market data API for the same symbol more often
+	candleRefetchEvery = time.Hour
+)
+
+var (
+	candleFetchMu sync.Mutex
+	candleFetched = map[string]time.Time{}
+)
+
+// BenchmarkSymbol is what performance is compared against (BENCHMARK_SYMBOL,
+// SPY by default).
+func BenchmarkSymbol() string {
+	if v := strings.ToUpper(strings.TrimSpace(os.Getenv("BENCHMARK_SYMBOL"))); v != "" {
+		return v
+	}
+	return defaultBenchmark
+}
+
+func EnsurePriceHistoryIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(dailyPricesCollection)
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "day", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+func storeDailyPrices(ctx context.Context, prices []models.DailyPrice) {
+	if len(prices) == 0 {
+		return
+	}
+	coll := db.Client.Database("gomarket").Collection(dailyPricesCollection)
+
+	writes := make([]mongo.WriteModel, 0, len(prices))
+	for _, p := range prices {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"symbol": p.Symbol, "day": p.Day}).
+			SetUpdate(bson.M{"$set": p}).
+			SetUpsert(true))
+	}
+	if _, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Println("daily prices:", err)
+	}
+}
+
+// recordDailyClose stores today's price for a symbol; the last write of the
+// day wins, so the value settles on the close.
+func recordDailyClose(ctx context.Context, symbol string, price float64, now time.Time) {
+	if price <= 0 {
+		return
+	}
+	day := now.UTC().Format("2006-01-02")
+	date, _ := time.Parse("2006-01-02", day)
+	storeDailyPrices(ctx, []models.DailyPrice{{Symbol: symbol, Day: day, Date: date, Close: price}})
+}
+
+// DailyCloses returns stored closes for symbol between from and to, oldest
+// first. When the stored history doesn't reach back to from, it is filled in
+// from the market data API first.
+func DailyCloses(symbol string, from, to time.Time) ([]models.DailyPrice, error) {
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+
+	out, err := loadDailyCloses(sym, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// a few days of slack for weekends and holidays
+	if len(out) > 0 && !out[0].Date.After(from.AddDate(0, 0, 4)) {
+		return out, nil
+	}
+
+	candleFetchMu.Lock()
+	last := candleFetched[sym]
+	recent := time.Since(last) < candleRefetchEvery
+	if !recent {
+		candleFetched[sym] = time.Now()
+	}
+	candleFetchMu.Unlock()
+	if recent {
+		return out, nil
+	}
+
+	fetched, err := FetchDailyCandles(sym, from.AddDate(0, 0, -7), to)
+	if err != nil {
+		// stored data is still better than nothing
+		log.Println("daily prices:", sym, err)
+		return out, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	storeDailyPrices(ctx, fetched)
+
+	return loadDailyCloses(sym, from, to)
+}
+
+func loadDailyCloses(sym string, from, to time.Time) ([]models.DailyPrice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(dailyPricesCollection)
+
+	cur, err := coll.Find(ctx,
+		bson.M{"symbol": sym, "day": bson.M{
+			"$gte": from.UTC().Format("2006-01-02"),
+			"$lte": to.UTC().Format("2006-01-02"),
+		}},
+		options.Find().SetSort(bson.D{{Key: "day", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.DailyPrice, 0)
+	for cur.Next(ctx) {
+		var p models.DailyPrice
+		if err := cur.Decode(&p); err != nil {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
@@ -0,0 +1,651 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	targetAllocationsCollection = "target_allocations"
+	rebalancesCollection        = "rebalances"
+
+	// CashSymbol stands for the cash share in a target allocation.
+	CashSymbol = "CASH"
+
+	defaultDriftBand = 5.0
+	rebalanceLockTTL = 5 * time.Minute
+
+	// How long a scheduled rebalance waits for all of its markets to be
+	// open before that period's run is given up.
+	rebalanceMarketWait = 24 * time.Hour
+)
+
+var (
+	ErrNoTargetAllocation  = errors.New("no target allocation")
+	ErrRebalanceNotNeeded  = errors.New("portfolio is within its drift bands")
+	ErrRebalanceInProgress = errors.New("a rebalance is already running")
+	ErrRebalanceShorts     = errors.New("short positions can't be rebalanced")
+)
+
+var RebalanceSchedules = []string{models.RebalanceMonthly, models.RebalanceQuarterly, models.RebalanceYearly}
+
+// RebalanceRow compares one holding (or a target not held yet) with its
+// target. DriftPct is in percentage points of the account value.
+type RebalanceRow struct {
+	Symbol     string          `json:"symbol"`
+	Qty        decimal.Decimal `json:"qty"`
+	Price      decimal.Decimal `json:"price"`
+	Value      decimal.Decimal `json:"value"`
+	CurrentPct float64         `json:"current_pct"`
+	TargetPct  float64         `json:"target_pct"`
+	DriftPct   float64         `json:"drift_pct"`
+	OutOfBand  bool            `json:"out_of_band"`
+}
+
+// ProposedTrade is an order a rebalance would place at current quotes.
+// Price is the estimated fill price, spread and slippage included.
+type ProposedTrade struct {
+	Symbol   string          `json:"symbol"`
+	Side     string          `json:"side"`
+	Qty      decimal.Decimal `json:"qty"`
+	Price    decimal.Decimal `json:"price"`
+	EstValue decimal.Decimal `json:"est_value"`
+	EstFees  decimal.Decimal `json:"est_fees"`
+}
+
+// RebalancePreview is what a rebalance would do right now. When any row or
+// cash is outside the drift band, every holding is traded back to its
+// target, in fractional shares; otherwise there are no trades.
+type RebalancePreview struct {
+	Target models.TargetAllocation `json:"target"`
+
+	TotalValue decimal.Decimal `json:"total_value"`
+	Cash       decimal.Decimal `json:"cash"` // available cash
+	CashPct    float64         `json:"cash_pct"`
+	CashDrift  float64         `json:"cash_drift"`
+	Rows       []RebalanceRow  `json:"rows"`
+
+	Due       bool            `json:"due"`
+	Trades    []ProposedTrade `json:"trades"` // sells first
+	EstSells  decimal.Decimal `json:"est_sells"`
+	EstBuys   decimal.Decimal `json:"est_buys"`
+	EstFees   decimal.Decimal `json:"est_fees"`
+	CashAfter decimal.Decimal `json:"cash_after"`
+}
+
+// rebalanceEstimate prices a proposed trade of qty at the last price as it
+// would fill, and its fees.
+type rebalanceEstimate func(sym, side string, qty, last decimal.Decimal) (decimal.Decimal, FeeQuote)
+
+type rebalanceHolding struct {
+	Symbol string
+	Qty    decimal.Decimal
+	Price  decimal.Decimal
+}
+
+func EnsureRebalanceIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+
+	_, _ = d.Collection(targetAllocationsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "account_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	_, _ = d.Collection(targetAllocationsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "next_run_at", Value: 1}},
+	})
+	_, _ = d.Collection(rebalancesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+}
+
+func GetTargetAllocation(accountID primitive.ObjectID) (models.TargetAllocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var t models.TargetAllocation
+	err := db.Client.Database("gomarket").Collection(targetAllocationsCollection).
+		FindOne(ctx, bson.M{"account_id": accountID}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return t, ErrNoTargetAllocation
+	}
+	return t, err
+}
+
+// FormatAllocationTargets writes targets back in the form's "SYMBOL pct"
+// line format.
+func FormatAllocationTargets(t models.TargetAllocation) string {
+	var b strings.Builder
+	for _, x := range t.Targets {
+		fmt.Fprintf(&b, "%s %g\n", x.Symbol, x.Pct)
+	}
+	if t.CashPct > 0 {
+		fmt.Fprintf(&b, "%s %g\n", CashSymbol, t.CashPct)
+	}
+	return b.String()
+}
+
+// SaveTargetAllocation parses "SYMBOL pct" lines (CASH for the cash share)
+// and replaces the account's target allocation.
+func SaveTargetAllocation(acct models.Account, targets, band, schedule string) (models.TargetAllocation, map[string]string) {
+	errs := map[string]string{}
+	t := models.TargetAllocation{UserID: acct.UserID, AccountID: acct.ID, DriftBand: defaultDriftBand}
+
+	items, msg := parsePlanItems(targets)
+	if msg != "" {
+		errs["targets"] = msg
+	}
+	total := 0.0
+	for _, it := range items {
+		total += it.Weight
+		if it.Symbol == CashSymbol {
+			t.CashPct = it.Weight
+			continue
+		}
+		t.Targets = append(t.Targets, models.AllocationTarget{Symbol: it.Symbol, Pct: it.Weight})
+	}
+	if msg == "" && math.Abs(total-100) > 0.01 {
+		errs["targets"] = fmt.Sprintf("Targets must add up to 100 (they add up to %g).", total)
+	}
+
+	if v := strings.TrimSpace(band); v != "" {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil || b < 0.5 || b > 50 {
+			errs["band"] = "Enter a band between 0.5 and 50 percentage points."
+		}
+		t.DriftBand = b
+	}
+
+	t.Schedule = strings.TrimSpace(schedule)
+	if t.Schedule != "" && !containsString(RebalanceSchedules, t.Schedule) {
+		errs["schedule"] = "Choose a schedule."
+	}
+
+	if len(errs) > 0 {
+		return t, errs
+	}
+
+	for _, x := range t.Targets {
+		if msg := checkImportSymbol(x.Symbol); msg != "" {
+			return t, map[string]string{"targets": x.Symbol + ": " + msg}
+		}
+	}
+
+	now := time.Now().UTC()
+	t.UpdatedAt = now
+	set := bson.M{
+		"targets":    t.Targets,
+		"cash_pct":   t.CashPct,
+		"drift_band": t.DriftBand,
+		"updated_at": now,
+	}
+	unset := bson.M{}
+	if t.Schedule != "" {
+		t.NextRunAt = nextRebalanceRun(t.Schedule, now)
+		set["schedule"] = t.Schedule
+		set["next_run_at"] = t.NextRunAt
+	} else {
+		unset["schedule"] = ""
+		unset["next_run_at"] = ""
+	}
+	update := bson.M{"$set": set, "$setOnInsert": bson.M{"user_id": acct.UserID}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.Client.Database("gomarket").Collection(targetAllocationsCollection).UpdateOne(ctx,
+		bson.M{"account_id": acct.ID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return t, map[string]string{"_form": "Could not save the target allocation."}
+	}
+	return t, nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRebalanceRun is the first day of the next month, quarter or year at
+// the plans' run hour.
+func nextRebalanceRun(schedule string, after time.Time) time.Time {
+	step := 1
+	switch schedule {
+	case models.RebalanceQuarterly:
+		step = 3
+	case models.RebalanceYearly:
+		step = 12
+	}
+	m := int(after.Month()) - 1
+	next := time.Date(after.Year(), time.Month(m-m%step+1), 1, planRunHour, 0, 0, 0, time.UTC)
+	for !next.After(after) {
+		next = next.AddDate(0, step, 0)
+	}
+	return next
+}
+
+// PreviewRebalance values the portfolio at current quotes and works out
+// the trades that bring it back to the target allocation. Accounts with
+// short positions can't be rebalanced.
+func PreviewRebalance(acct models.Account) (RebalancePreview, error) {
+	target, err := GetTargetAllocation(acct.ID)
+	if err != nil {
+		return RebalancePreview{}, err
+	}
+	positions, err := ListAccountPositions(acct.ID)
+	if err != nil {
+		return RebalancePreview{}, err
+	}
+
+	holdings := make([]rebalanceHolding, 0, len(positions)+len(target.Targets))
+	held := map[string]bool{}
+	for _, p := range positions {
+		if p.Qty.IsNegative() {
+			return RebalancePreview{}, ErrRebalanceShorts
+		}
+		held[p.Symbol] = true
+		holdings = append(holdings, rebalanceHolding{Symbol: p.Symbol, Qty: p.Qty, Price: rebalanceQuote(p.Symbol, p.AvgCost)})
+	}
+	for _, x := range target.Targets {
+		if !held[x.Symbol] {
+			holdings = append(holdings, rebalanceHolding{Symbol: x.Symbol, Price: rebalanceQuote(x.Symbol, decimal.Zero)})
+		}
+	}
+	return buildRebalancePreview(acct.Available(), holdings, target, rebalanceEstimator(acct)), nil
+}
+
+// rebalanceEstimator prices trades with the active fill model, at the next
+// open when the market is closed now, and the account's fee schedule.
+func rebalanceEstimator(acct models.Account) rebalanceEstimate {
+	sched, hasFees := accountFeeSchedule(acct)
+	m := ActiveFillModel()
+	now := time.Now().UTC()
+
+	return func(sym, side string, qty, last decimal.Decimal) (decimal.Decimal, FeeQuote) {
+		at := now
+		if !m.Open(sym, at) {
+			at = CalendarForSymbol(sym).NextOpen(now)
+		}
+		price, err := m.Price(FillRequest{Symbol: sym, Side: side, Qty: qty, Quote: Quote{Symbol: sym, Current: last.Float64()}, Time: at})
+		if err != nil || !price.IsPositive() {
+			price = last
+		}
+		var fees FeeQuote
+		if hasFees {
+			fees = computeFees(sched, side, qty, price)
+		}
+		return price, fees
+	}
+}
+
+// rebalanceQty is how many shares value buys at price, truncated to the
+// finest tradable fraction.
+func rebalanceQty(value, price decimal.Decimal) decimal.Decimal {
+	return money(value).Div(price).Truncate(QtyPlaces)
+}
+
+func rebalanceQuote(sym string, fallback decimal.Decimal) decimal.Decimal {
+	price, err := FetchCurrentPrice(sym)
+	if err != nil || price <= 0 {
+		return sharePrice(fallback)
+	}
+	return priceFromFloat(price)
+}
+
+// buildRebalancePreview sizes the trades at the last prices and costs them
+// with estimate; a nil estimate fills at the last price without fees.
+func buildRebalancePreview(cash decimal.Decimal, holdings []rebalanceHolding, target models.TargetAllocation, estimate rebalanceEstimate) RebalancePreview {
+	if estimate == nil {
+		estimate = func(_, _ string, _, last decimal.Decimal) (decimal.Decimal, FeeQuote) { return last, FeeQuote{} }
+	}
+	cash = money(cash)
+	pv := RebalancePreview{
+		Target: target,
+		Cash:   cash,
+		Rows:   make([]RebalanceRow, 0, len(holdings)),
+		Trades: []ProposedTrade{},
+	}
+
+	targetPct := map[string]float64{}
+	for _, x := range target.Targets {
+		targetPct[x.Symbol] = x.Pct
+	}
+
+	total := cash
+	for _, h := range holdings {
+		total = total.Add(costOf(h.Price, h.Qty))
+	}
+	pv.TotalValue = total
+	if !total.IsPositive() {
+		return pv
+	}
+	hundred := decimal.New(100)
+
+	for _, h := range holdings {
+		r := RebalanceRow{
+			Symbol:    h.Symbol,
+			Qty:       h.Qty,
+			Price:     h.Price,
+			Value:     costOf(h.Price, h.Qty),
+			TargetPct: targetPct[h.Symbol],
+		}
+		r.CurrentPct = roundPct(r.Value.Float64() / total.Float64())
+		r.DriftPct = roundPct((r.CurrentPct - r.TargetPct) / 100)
+		r.OutOfBand = math.Abs(r.DriftPct) > target.DriftBand
+		pv.Due = pv.Due || (r.OutOfBand && h.Price.IsPositive())
+		pv.Rows = append(pv.Rows, r)
+	}
+	sort.Slice(pv.Rows, func(i, j int) bool {
+		if pv.Rows[i].TargetPct != pv.Rows[j].TargetPct {
+			return pv.Rows[i].TargetPct > pv.Rows[j].TargetPct
+		}
+		return pv.Rows[i].Symbol < pv.Rows[j].Symbol
+	})
+
+	pv.CashPct = roundPct(cash.Float64() / total.Float64())
+	pv.CashDrift = roundPct((pv.CashPct - target.CashPct) / 100)
+	if math.Abs(pv.CashDrift) > target.DriftBand {
+		pv.Due = true
+	}
+	pv.CashAfter = pv.Cash
+	if !pv.Due {
+		return pv
+	}
+
+	var sells, buys []ProposedTrade
+	for _, r := range pv.Rows {
+		if !r.Price.IsPositive() {
+			continue
+		}
+		diff := total.Mul(decimal.NewFromFloat(r.TargetPct)).Div(hundred).Sub(r.Value)
+		switch {
+		case r.TargetPct == 0 && r.Qty.IsPositive():
+			sells = append(sells, ProposedTrade{Symbol: r.Symbol, Side: "sell", Qty: r.Qty, Price: r.Price})
+		case diff.IsNegative():
+			if q := decimal.Min(rebalanceQty(diff.Neg(), r.Price), r.Qty); q.IsPositive() {
+				sells = append(sells, ProposedTrade{Symbol: r.Symbol, Side: "sell", Qty: q, Price: r.Price})
+			}
+		case diff.IsPositive():
+			if q := rebalanceQty(diff, r.Price); q.IsPositive() {
+				buys = append(buys, ProposedTrade{Symbol: r.Symbol, Side: "buy", Qty: q, Price: r.Price})
+			}
+		}
+	}
+
+	costTrade := func(t *ProposedTrade, last decimal.Decimal) {
+		price, fees := estimate(t.Symbol, t.Side, t.Qty, last)
+		t.Price = price
+		t.EstValue = costOf(price, t.Qty)
+		t.EstFees = fees.Total()
+	}
+	for i := range sells {
+		costTrade(&sells[i], sells[i].Price)
+		pv.EstSells = pv.EstSells.Add(sells[i].EstValue)
+		pv.EstFees = pv.EstFees.Add(sells[i].EstFees)
+	}
+
+	// Spread, slippage, fees and rounding can leave the buys over the cash
+	// there will be; scale their shares down to fit what is left after the
+	// fees, keeping a cent per order for rounding. Fees and slippage only
+	// shrink with fewer shares, so the smaller orders still fit.
+	budget := cash.Add(pv.EstSells).Sub(pv.EstFees)
+	last := make([]decimal.Decimal, len(buys))
+	spend, buyFees := decimal.Zero, decimal.Zero
+	for i := range buys {
+		last[i] = buys[i].Price
+		costTrade(&buys[i], last[i])
+		spend = spend.Add(buys[i].EstValue)
+		buyFees = buyFees.Add(buys[i].EstFees)
+	}
+	if spend.Add(buyFees).GreaterThan(budget) {
+		slack := decimal.MustParse("0.01").Mul(decimal.New(int64(len(buys))))
+		factor := decimal.Zero
+		if spend.IsPositive() {
+			factor = decimal.Max(budget.Sub(buyFees).Sub(slack), decimal.Zero).Div(spend)
+		}
+		for i := range buys {
+			buys[i].Qty = buys[i].Qty.Mul(factor).Truncate(QtyPlaces)
+			if buys[i].Qty.IsPositive() {
+				costTrade(&buys[i], last[i])
+			}
+		}
+	}
+	for _, b := range buys {
+		if b.Qty.IsPositive() {
+			pv.EstBuys = pv.EstBuys.Add(b.EstValue)
+			pv.EstFees = pv.EstFees.Add(b.EstFees)
+		}
+	}
+
+	pv.Trades = append(pv.Trades, sells...)
+	for _, t := range buys {
+		if t.Qty.IsPositive() {
+			pv.Trades = append(pv.Trades, t)
+		}
+	}
+	pv.CashAfter = cash.Add(pv.EstSells).Sub(pv.EstBuys).Sub(pv.EstFees)
+	return pv
+}
+
+// ExecuteRebalance recomputes the preview and places its orders through the
+// trading service, sells first so their proceeds fund the buys. Each order
+// stands on its own: a failed one is recorded and the rest still go ahead.
+func ExecuteRebalance(acct models.Account, trigger string) (models.Rebalance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	now := time.Now().UTC()
+	if !MarketOpen(now) {
+		return models.Rebalance{}, ErrMarketClosed
+	}
+
+	coll := db.Client.Database("gomarket").Collection(targetAllocationsCollection)
+	res, err := coll.UpdateOne(ctx,
+		bson.M{"account_id": acct.ID, "$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"locked_until": now.Add(rebalanceLockTTL)}})
+	if err != nil {
+		return models.Rebalance{}, err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := GetTargetAllocation(acct.ID); err != nil {
+			return models.Rebalance{}, err
+		}
+		return models.Rebalance{}, ErrRebalanceInProgress
+	}
+	defer func() {
+		_, _ = coll.UpdateOne(context.Background(), bson.M{"account_id": acct.ID},
+			bson.M{"$unset": bson.M{"locked_until": ""}})
+	}()
+
+	pv, err := PreviewRebalance(acct)
+	if err != nil {
+		return models.Rebalance{}, err
+	}
+	if !pv.Due || len(pv.Trades) == 0 {
+		return models.Rebalance{}, ErrRebalanceNotNeeded
+	}
+	// Don't start while any of the markets is closed: a rebalance half
+	// done because of that leaves the portfolio further off. Orders can
+	// still fail one by one below.
+	syms := make([]string, 0, len(pv.Trades))
+	for _, t := range pv.Trades {
+		syms = append(syms, t.Symbol)
+	}
+	if !marketsOpen(syms, now) {
+		return models.Rebalance{}, ErrMarketClosed
+	}
+
+	rb := models.Rebalance{UserID: acct.UserID, AccountID: acct.ID, Trigger: trigger, CreatedAt: now}
+	for _, t := range pv.Trades {
+		o := models.RebalanceOrder{Symbol: t.Symbol, Side: t.Side, Qty: t.Qty}
+		if t.Side == "sell" {
+			r, errs := MarketSell(acct.ID, t.Symbol, t.Qty)
+			if len(errs) > 0 {
+				o.Error = firstPlanError(errs)
+			} else {
+				o.Price, o.Value = r.FillPrice, r.Proceeds
+			}
+		} else {
+			r, errs := MarketBuy(acct.ID, t.Symbol, t.Qty)
+			if len(errs) > 0 {
+				o.Error = firstPlanError(errs)
+			} else {
+				o.Price, o.Value = r.FillPrice, r.Cost
+			}
+		}
+		rb.Orders = append(rb.Orders, o)
+	}
+
+	ins, err := db.Client.Database("gomarket").Collection(rebalancesCollection).InsertOne(ctx, rb)
+	if err != nil {
+		log.Println("rebalance: record", acct.ID.Hex(), err)
+	} else {
+		rb.ID = ins.InsertedID.(primitive.ObjectID)
+	}
+	return rb, nil
+}
+
+// ListRebalances returns the latest executed rebalances, newest first.
+func ListRebalances(accountID primitive.ObjectID, limit int64) ([]models.Rebalance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(rebalancesCollection).Find(ctx,
+		bson.M{"account_id": accountID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.Rebalance, 0)
+	for cur.Next(ctx) {
+		var r models.Rebalance
+		if err := cur.Decode(&r); err != nil {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// StartRebalanceScheduler runs scheduled rebalances. A scheduled run that
+// finds the portfolio within its bands does nothing.
+func StartRebalanceScheduler(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runRebalanceTick(now.UTC())
+			}
+		}
+	}()
+}
+
+func runRebalanceTick(now time.Time) {
+	// due rebalances wait for the market to open
+	if !MarketOpen(now) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(targetAllocationsCollection)
+	cur, err := coll.Find(ctx, bson.M{"next_run_at": bson.M{"$lte": now}})
+	if err != nil {
+		log.Println("rebalance:", err)
+		return
+	}
+	var due []models.TargetAllocation
+	if err := cur.All(ctx, &due); err != nil {
+		log.Println("rebalance:", err)
+		return
+	}
+
+	for _, t := range due {
+		next := nextRebalanceRun(t.Schedule, now)
+		res, err := coll.UpdateOne(ctx,
+			bson.M{"_id": t.ID, "next_run_at": t.NextRunAt},
+			bson.M{"$set": bson.M{"next_run_at": next}})
+		if err != nil || res.ModifiedCount == 0 {
+			continue
+		}
+		user, ok := db.GetUser(t.UserID)
+		if !ok || user.Disabled {
+			continue
+		}
+		acct, ok := db.GetAccount(t.AccountID)
+		if !ok {
+			continue
+		}
+		rb, err := ExecuteRebalance(acct, models.RebalanceScheduled)
+		if err == ErrRebalanceNotNeeded {
+			continue
+		}
+		if err == ErrMarketClosed && now.Sub(t.NextRunAt) < rebalanceMarketWait {
+			// Some of the trades are on an exchange that is closed: hand
+			// the run back so a later tick tries again.
+			_, _ = coll.UpdateOne(ctx,
+				bson.M{"_id": t.ID, "next_run_at": next},
+				bson.M{"$set": bson.M{"next_run_at": t.NextRunAt}})
+			continue
+		}
+
+		n := models.Notification{UserID: t.UserID, Kind: "rebalance", Link: "/portfolio"}
+		if err == ErrMarketClosed {
+			n.Title = "Scheduled rebalance skipped"
+			n.Body = "The markets of the holdings to trade were not open at the same time. Rebalance from the Rebalance tab."
+		} else if err != nil {
+			log.Println("rebalance:", t.UserID.Hex(), err)
+			n.Title = "Scheduled rebalance failed"
+			n.Body = "The portfolio could not be rebalanced. Try again from the Rebalance tab."
+		} else {
+			failed := 0
+			for _, o := range rb.Orders {
+				if o.Error != "" {
+					failed++
+				}
+			}
+			n.Title = "Portfolio rebalanced"
+			n.Body = fmt.Sprintf("%d orders placed.", len(rb.Orders)-failed)
+			if failed > 0 {
+				n.Body += fmt.Sprintf(" %d could not be placed.", failed)
+			}
+		}
+		Notify(ctx, n)
+	}
+}
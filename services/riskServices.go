@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Look-back windows accepted by PortfolioRisk.
+const (
+	Window3M = "3M"
+	Window6M = "6M"
+	Window1Y = "1Y"
+)
+
+var RiskWindows = []string{Window3M, Window6M, Window1Y}
+
+var ErrUnknownWindow = errors.New("unknown window")
+
+const (
+	tradingDaysPerYear  = 252
+	minRiskObservations = 20
+)
+
+// PortfolioRiskReport holds risk statistics of the account's daily returns.
+// Percentages are in percent; VaR is also given in money.
+type PortfolioRiskReport struct {
+	Window       string    `json:"window"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Observations int       `json:"observations"`
+	HasData      bool      `json:"has_data"`
+
+	VolatilityPct float64 `json:"volatility_pct"` // annualised
+	Benchmark     string  `json:"benchmark"`
+	Beta          float64 `json:"beta"`
+
+	MaxDrawdownPct float64   `json:"max_drawdown_pct"` // negative or zero
+	DrawdownPeak   time.Time `json:"drawdown_peak"`
+	DrawdownTrough time.Time `json:"drawdown_trough"`
+
+	RiskFreeRatePct float64 `json:"risk_free_rate_pct"`
+	Sharpe          float64 `json:"sharpe"`
+	Sortino         float64 `json:"sortino"`
+
+	VaR95Pct float64         `json:"var_95_pct"` // 1-day historical, as a loss
+	VaR95    decimal.Decimal `json:"var_95"`     // the same loss in money at today's value
+
+	CurrentValue decimal.Decimal `json:"current_value"`
+}
+
+// riskFreeRate reads RISK_FREE_RATE as an annual fraction (0.04 = 4%).
+func riskFreeRate() float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("RISK_FREE_RATE")), 64)
+	if err != nil || v < 0 || v > 1 {
+		return 0
+	}
+	return v
+}
+
+func riskWindowStart(w string, now time.Time) (time.Time, error) {
+	switch strings.ToUpper(w) {
+	case Window3M:
+		return now.AddDate(0, -3, 0), nil
+	case Window6M:
+		return now.AddDate(0, -6, 0), nil
+	case Window1Y:
+		return now.AddDate(-1, 0, 0), nil
+	}
+	return time.Time{}, ErrUnknownWindow
+}
+
+// holdings is what the account held going into a trading day.
+type holdings struct {
+	day       string
+	cash      decimal.Decimal
+	positions map[string]decimal.Decimal
+}
+
+// PortfolioRisk rebuilds daily portfolio returns from position history
+// (daily snapshots, falling back to today's positions before the first one)
+// and stored daily closes, and derives the risk statistics from them.
+func PortfolioRisk(accountID primitive.ObjectID, window string) (PortfolioRiskReport, error) {
+	now := time.Now().UTC()
+	from, err := riskWindowStart(window, now)
+	if err != nil {
+		return PortfolioRiskReport{}, err
+	}
+
+	rep := PortfolioRiskReport{
+		Window:          strings.ToUpper(window),
+		Benchmark:       BenchmarkSymbol(),
+		RiskFreeRatePct: roundPct(riskFreeRate()),
+	}
+
+	timeline, err := holdingsTimeline(accountID, from)
+	if err != nil {
+		return PortfolioRiskReport{}, err
+	}
+
+	// the benchmark's trading days are the calendar
+	bench, err := DailyCloses(rep.Benchmark, from, now)
+	if err != nil {
+		return PortfolioRiskReport{}, err
+	}
+	if len(bench) < 2 {
+		return rep, nil
+	}
+
+	symbols := map[string]bool{}
+	for _, h := range timeline {
+		for sym := range h.positions {
+			symbols[sym] = true
+		}
+	}
+	closes := map[string]map[string]float64{}
+	for sym := range symbols {
+		list, err := DailyCloses(sym, from, now)
+		if err != nil {
+			return PortfolioRiskReport{}, err
+		}
+		m := map[string]float64{}
+		for _, p := range list {
+			m[p.Day] = p.Close
+		}
+		closes[sym] = m
+	}
+
+	// carry the last known close forward over gaps
+	last := map[string]float64{}
+	priceOn := func(sym, day string) float64 {
+		if p, ok := closes[sym][day]; ok && p > 0 {
+			last[sym] = p
+		}
+		return last[sym]
+	}
+	for sym := range symbols {
+		priceOn(sym, bench[0].Day)
+	}
+
+	var portRets, benchRets []float64
+	dates := []time.Time{bench[0].Date}
+	hi := 0
+	for i := 1; i < len(bench); i++ {
+		day := bench[i].Day
+
+		for hi+1 < len(timeline) && timeline[hi+1].day < day {
+			hi++
+		}
+		h := timeline[hi]
+
+		prev := map[string]float64{}
+		for sym := range h.positions {
+			prev[sym] = last[sym]
+		}
+
+		base, change := h.cash.Float64(), 0.0
+		for sym := range symbols {
+			p := priceOn(sym, day)
+			qty, held := h.positions[sym]
+			if !held || prev[sym] <= 0 || p <= 0 {
+				continue
+			}
+			base += qty.Float64() * prev[sym]
+			change += qty.Float64() * (p - prev[sym])
+		}
+
+		if base <= 0 || bench[i-1].Close <= 0 {
+			continue
+		}
+		portRets = append(portRets, change/base)
+		benchRets = append(benchRets, bench[i].Close/bench[i-1].Close-1)
+		dates = append(dates, bench[i].Date)
+	}
+
+	rep.From, rep.To = bench[0].Date, bench[len(bench)-1].Date
+	rep.Observations = len(portRets)
+
+	cur := timeline[len(timeline)-1]
+	rep.CurrentValue = cur.cash
+	for sym, qty := range cur.positions {
+		rep.CurrentValue = rep.CurrentValue.Add(costOf(priceFromFloat(last[sym]), qty))
+	}
+
+	if rep.Observations < minRiskObservations {
+		return rep, nil
+	}
+	rep.HasData = true
+
+	rfDaily := riskFreeRate() / tradingDaysPerYear
+	sd := stdDev(portRets)
+
+	rep.VolatilityPct = roundPct(sd * math.Sqrt(tradingDaysPerYear))
+	rep.Beta = round3(beta(portRets, benchRets))
+	rep.Sharpe = round3(sharpe(portRets, rfDaily))
+	rep.Sortino = round3(sortino(portRets, rfDaily))
+
+	dd, peak, trough := maxDrawdown(portRets)
+	rep.MaxDrawdownPct = roundPct(dd)
+	// returns[i] covers dates[i] -> dates[i+1]
+	rep.DrawdownPeak, rep.DrawdownTrough = dates[peak], dates[trough]
+
+	v := historicalVaR(portRets, 0.95)
+	rep.VaR95Pct = roundPct(v)
+	rep.VaR95 = money(rep.CurrentValue.Mul(decimal.NewFromFloat(v)))
+	return rep, nil
+}
+
+// holdingsTimeline lists what the account held at the end of each daily
+// snapshot from the one before from onwards, ending with the live positions.
+// The first entry covers everything before the earliest snapshot.
+func holdingsTimeline(accountID primitive.ObjectID, from time.Time) ([]holdings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(snapshotsCollection)
+	proj := bson.M{"day": 1, "cash": 1, "positions": 1, "taken_at": 1}
+
+	snaps := []models.PortfolioSnapshot{}
+
+	var before models.PortfolioSnapshot
+	err := coll.FindOne(ctx,
+		bson.M{"account_id": accountID, "kind": models.SnapshotDaily, "taken_at": bson.M{"$lte": from}},
+		options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: -1}}).SetProjection(proj)).Decode(&before)
+	if err == nil {
+		snaps = append(snaps, before)
+	}
+
+	cur, err := coll.Find(ctx,
+		bson.M{"account_id": accountID, "kind": models.SnapshotDaily, "taken_at": bson.M{"$gt": from}},
+		options.Find().SetSort(bson.D{{Key: "taken_at", Value: 1}}).SetProjection(proj))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var s models.PortfolioSnapshot
+		if err := cur.Decode(&s); err != nil {
+			continue
+		}
+		snaps = append(snaps, s)
+	}
+
+	out := make([]holdings, 0, len(snaps)+1)
+	for _, s := range snaps {
+		h := holdings{day: s.Day, cash: s.Cash, positions: map[string]decimal.Decimal{}}
+		for _, p := range s.Positions {
+			h.positions[p.Symbol] = h.positions[p.Symbol].Add(p.Qty)
+		}
+		out = append(out, h)
+	}
+
+	// today's positions, so a new account still gets a risk profile of what
+	// it holds now
+	live := holdings{day: time.Now().UTC().Format("2006-01-02"), positions: map[string]decimal.Decimal{}}
+	if a, ok := db.GetAccount(accountID); ok {
+		live.cash = a.Balance
+	}
+	positions, err := ListAccountPositions(accountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range positions {
+		live.positions[p.Symbol] = live.positions[p.Symbol].Add(p.Qty)
+	}
+
+	if len(out) == 0 {
+		live.day = ""
+		return []holdings{live}, nil
+	}
+	out[0].day = ""
+	out = append(out, live)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].day < out[j].day })
+	return out, nil
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := 0.0
+	for _, x := range xs {
+		s += x
+	}
+	return s / float64(len(xs))
+}
+
+// stdDev is the sample standard deviation.
+func stdDev(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	m := mean(xs)
+	s := 0.0
+	for _, x := range xs {
+		s += (x - m) * (x - m)
+	}
+	return math.Sqrt(s / float64(len(xs)-1))
+}
+
+func beta(port, bench []float64) float64 {
+	if len(port) != len(bench) || len(port) < 2 {
+		return 0
+	}
+	mp, mb := mean(port), mean(bench)
+	cov, varB := 0.0, 0.0
+	for i := range port {
+		cov += (port[i] - mp) * (bench[i] - mb)
+		varB += (bench[i] - mb) * (bench[i] - mb)
+	}
+	if varB == 0 {
+		return 0
+	}
+	return cov / varB
+}
+
+// sharpe and sortino are annualised from daily returns.
+func sharpe(rets []float64, rfDaily float64) float64 {
+	sd := stdDev(rets)
+	if sd == 0 {
+		return 0
+	}
+	return (mean(rets) - rfDaily) / sd * math.Sqrt(tradingDaysPerYear)
+}
+
+func sortino(rets []float64, rfDaily float64) float64 {
+	if len(rets) == 0 {
+		return 0
+	}
+	down := 0.0
+	for _, r := range rets {
+		if d := r - rfDaily; d < 0 {
+			down += d * d
+		}
+	}
+	dd := math.Sqrt(down / float64(len(rets)))
+	if dd == 0 {
+		return 0
+	}
+	return (mean(rets) - rfDaily) / dd * math.Sqrt(tradingDaysPerYear)
+}
+
+// maxDrawdown returns the worst peak-to-trough fall of the compounded
+// returns, with the indexes (0 = before the first return) of the peak and
+// trough.
+func maxDrawdown(rets []float64) (dd float64, peakIdx, troughIdx int) {
+	level, peak := 1.0, 1.0
+	curPeak := 0
+	for i, r := range rets {
+		level *= 1 + r
+		if level > peak {
+			peak, curPeak = level, i+1
+			continue
+		}
+		if d := level/peak - 1; d < dd {
+			dd, peakIdx, troughIdx = d, curPeak, i+1
+		}
+	}
+	return dd, peakIdx, troughIdx
+}
+
+// historicalVaR is the loss not exceeded on the given share of days,
+// returned as a positive fraction.
+func historicalVaR(rets []float64, confidence float64) float64 {
+	if len(rets) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), rets...)
+	sort.Float64s(sorted)
+
+	idx := int(math.Floor((1 - confidence) * float64(len(sorted))))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	if v := -sorted[idx]; v > 0 {
+		return v
+	}
+	return 0
+}
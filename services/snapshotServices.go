@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	snapshotsCollection = "portfolio_snapshots"
+
+	// intraday points only feed the 1D/1W charts
+	intradaySnapshotTTL = 8 * 24 * time.Hour
+
+	defaultSnapshotInterval = 15 * time.Minute
+	defaultSnapshotDailyUTC = 21 // after the US close
+)
+
+// Chart ranges accepted by PortfolioHistory.
+const (
+	Range1D  = "1D"
+	Range1W  = "1W"
+	Range1M  = "1M"
+	RangeYTD = "YTD"
+	RangeAll = "ALL"
+)
+
+var PortfolioRanges = []string{Range1D, Range1W, Range1M, RangeYTD, RangeAll}
+
+var ErrUnknownRange = errors.New("unknown range")
+
+type HistoryPoint struct {
+	Time  time.Time       `json:"time"`
+	Value decimal.Decimal `json:"value"`
+}
+
+// snapshotInterval reads PORTFOLIO_SNAPSHOT_INTERVAL (a Go duration such as
+// "15m"); "0" turns intraday snapshots off.
+func snapshotInterval() time.Duration {
+	v := strings.TrimSpace(os.Getenv("PORTFOLIO_SNAPSHOT_INTERVAL"))
+	if v == "" {
+		return defaultSnapshotInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Println("snapshots: invalid PORTFOLIO_SNAPSHOT_INTERVAL, using default")
+		return defaultSnapshotInterval
+	}
+	if d > 0 && d < time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+// snapshotDailyHour reads PORTFOLIO_SNAPSHOT_DAILY_HOUR, the UTC hour after
+// which the day's closing snapshot is taken.
+func snapshotDailyHour() int {
+	v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("PORTFOLIO_SNAPSHOT_DAILY_HOUR")))
+	if err != nil || v < 0 || v > 23 {
+		return defaultSnapshotDailyUTC
+	}
+	return v
+}
+
+func EnsureSnapshotIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(snapshotsCollection)
+
+	// Chart queries
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "taken_at", Value: 1}},
+	})
+	// One daily snapshot per account per day
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "day", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"kind": models.SnapshotDaily}),
+	})
+	// Intraday snapshots carry expires_at, daily ones don't
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+// StartPortfolioSnapshotter takes an intraday snapshot of every account each
+// PORTFOLIO_SNAPSHOT_INTERVAL and a daily one once per day.
+func StartPortfolioSnapshotter(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	interval := snapshotInterval()
+	dailyHour := snapshotDailyHour()
+
+	go func() {
+		defer ticker.Stop()
+
+		var lastIntraday time.Time
+		lastDaily := ""
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				now = now.UTC()
+				day := now.Format("2006-01-02")
+
+				if now.Hour() >= dailyHour && lastDaily != day {
+					if err := runSnapshotTick(models.SnapshotDaily, now); err != nil {
+						log.Println("snapshots: daily:", err)
+					} else {
+						lastDaily = day
+					}
+				}
+				// intraday values only change while the market trades
+				if interval > 0 && now.Sub(lastIntraday) >= interval && PrimaryCalendar().IsOpen(now) {
+					if err := runSnapshotTick(models.SnapshotIntraday, now); err != nil {
+						log.Println("snapshots: intraday:", err)
+					}
+					lastIntraday = now
+				}
+			}
+		}
+	}()
+}
+
+func runSnapshotTick(kind string, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	d := db.Client.Database("gomarket")
+
+	// Positions of everyone, grouped by account
+	posCur, err := d.Collection("positions").Find(ctx, bson.M{"qty": bson.M{"$ne": 0}})
+	if err != nil {
+		return err
+	}
+	byAccount := map[primitive.ObjectID][]models.Position{}
+	for posCur.Next(ctx) {
+		var p models.Position
+		if err := posCur.Decode(&p); err != nil {
+			continue
+		}
+		p.Symbol = strings.ToUpper(strings.TrimSpace(p.Symbol))
+		byAccount[p.AccountID] = append(byAccount[p.AccountID], p)
+	}
+	posCur.Close(ctx)
+
+	// 1 quote per symbol per tick
+	prices := map[string]float64{}
+	for _, list := range byAccount {
+		for _, p := range list {
+			if _, ok := prices[p.Symbol]; ok {
+				continue
+			}
+			price, err := FetchCurrentPrice(p.Symbol)
+			if err != nil || price <= 0 {
+				price = 0
+			}
+			prices[p.Symbol] = price
+		}
+	}
+
+	// keep the daily price history (and the benchmark) current
+	if _, ok := prices[BenchmarkSymbol()]; !ok {
+		if price, err := FetchCurrentPrice(BenchmarkSymbol()); err == nil {
+			prices[BenchmarkSymbol()] = price
+		}
+	}
+	for sym, price := range prices {
+		recordDailyClose(ctx, sym, price, now)
+	}
+
+	disabled, err := d.Collection("users").Distinct(ctx, "_id", bson.M{"disabled": true})
+	if err != nil {
+		return err
+	}
+	acctCur, err := d.Collection(accountsCollection).Find(ctx, bson.M{"user_id": bson.M{"$nin": disabled}},
+		options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1, "balance": 1}))
+	if err != nil {
+		return err
+	}
+	defer acctCur.Close(ctx)
+
+	coll := d.Collection(snapshotsCollection)
+	for acctCur.Next(ctx) {
+		var a models.Account
+		if err := acctCur.Decode(&a); err != nil {
+			continue
+		}
+		s := buildSnapshot(a, byAccount[a.ID], prices, kind, now)
+		if err := saveSnapshot(ctx, coll, s); err != nil {
+			log.Println("snapshots: save:", err)
+		}
+	}
+	return nil
+}
+
+// buildSnapshot marks positions to market, falling back to average cost when
+// there is no quote (the same rule as the portfolio page).
+func buildSnapshot(a models.Account, positions []models.Position, prices map[string]float64, kind string, now time.Time) models.PortfolioSnapshot {
+	s := models.PortfolioSnapshot{
+		UserID:    a.UserID,
+		AccountID: a.ID,
+		Kind:      kind,
+		Cash:      a.Balance,
+		Positions: make([]models.SnapshotPosition, 0, len(positions)),
+		TakenAt:   now,
+	}
+	if kind == models.SnapshotDaily {
+		s.Day = now.Format("2006-01-02")
+	} else {
+		s.ExpiresAt = now.Add(intradaySnapshotTTL)
+	}
+
+	for _, p := range positions {
+		price := p.AvgCost
+		if q := prices[p.Symbol]; q > 0 {
+			price = priceFromFloat(q)
+		}
+		value := costOf(price, p.Qty)
+		s.Positions = append(s.Positions, models.SnapshotPosition{
+			Symbol: p.Symbol,
+			Qty:    p.Qty,
+			Price:  price,
+			Value:  value,
+		})
+		s.PositionsValue = s.PositionsValue.Add(value)
+	}
+	s.TotalValue = s.Cash.Add(s.PositionsValue)
+	return s
+}
+
+func saveSnapshot(ctx context.Context, coll *mongo.Collection, s models.PortfolioSnapshot) error {
+	if s.Kind != models.SnapshotDaily {
+		_, err := coll.InsertOne(ctx, s)
+		return err
+	}
+	// first daily snapshot of the day wins (restarts don't overwrite it)
+	_, err := coll.UpdateOne(ctx,
+		bson.M{"account_id": s.AccountID, "kind": models.SnapshotDaily, "day": s.Day},
+		bson.M{"$setOnInsert": s},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func portfolioRangeStart(r string, now time.Time) (time.Time, error) {
+	switch strings.ToUpper(r) {
+	case Range1D:
+		return now.Add(-24 * time.Hour), nil
+	case Range1W:
+		return now.AddDate(0, 0, -7), nil
+	case Range1M:
+		return now.AddDate(0, -1, 0), nil
+	case RangeYTD:
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	case RangeAll:
+		return time.Time{}, nil
+	}
+	return time.Time{}, ErrUnknownRange
+}
+
+// PortfolioHistory returns total account value over the range, oldest first.
+// Short ranges use intraday snapshots too, longer ones only the daily closes.
+func PortfolioHistory(accountID primitive.ObjectID, r string) ([]HistoryPoint, error) {
+	now := time.Now().UTC()
+	from, err := portfolioRangeStart(r, now)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"account_id": accountID, "taken_at": bson.M{"$gte": from}}
+	switch strings.ToUpper(r) {
+	case Range1D, Range1W:
+	default:
+		filter["kind"] = models.SnapshotDaily
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(snapshotsCollection)
+	cur, err := coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "taken_at", Value: 1}}).
+		SetProjection(bson.M{"taken_at": 1, "total_value": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]HistoryPoint, 0)
+	for cur.Next(ctx) {
+		var s models.PortfolioSnapshot
+		if err := cur.Decode(&s); err != nil {
+			continue
+		}
+		out = append(out, HistoryPoint{Time: s.TakenAt, Value: s.TotalValue})
+	}
+	return out, nil
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"github.com/GeorgiStoyanov05/GoMarket/pdf"
+)
+
+const (
+	stmtMarginX   = 50.0
+	stmtTop       = 60.0
+	stmtBottom    = 790.0
+	stmtRowHeight = 15.0
+	stmtBodySize  = 9.0
+)
+
+// stmtColumn is a table column; X is the left edge, or the right edge when
+// Right is set (numbers).
+type stmtColumn struct {
+	Title string
+	X     float64
+	Right bool
+}
+
+// stmtWriter lays statement content out top to bottom, starting new pages as
+// they fill up.
+type stmtWriter struct {
+	doc    *pdf.Document
+	page   *pdf.Page
+	y      float64
+	footer string
+}
+
+func newStmtWriter(title, footer string) *stmtWriter {
+	w := &stmtWriter{doc: pdf.New(), footer: footer}
+	w.doc.Title = title
+	w.doc.Author = "GoMarket"
+	w.newPage()
+	return w
+}
+
+func (w *stmtWriter) newPage() {
+	w.page = w.doc.AddPage()
+	w.y = stmtTop
+}
+
+// ensure starts a new page unless h points still fit.
+func (w *stmtWriter) ensure(h float64) {
+	if w.y+h > stmtBottom {
+		w.newPage()
+	}
+}
+
+func (w *stmtWriter) heading(s string) {
+	w.ensure(40)
+	w.y += 14
+	w.page.Text(stmtMarginX, w.y, 12, pdf.Bold, s)
+	w.y += 6
+	w.page.Line(stmtMarginX, w.y, pdf.PageWidth-stmtMarginX, w.y, 0.75)
+	w.y += 14
+}
+
+func (w *stmtWriter) note(s string) {
+	w.ensure(stmtRowHeight)
+	w.page.TextGray(stmtMarginX, w.y, stmtBodySize, pdf.Regular, 0.4, s)
+	w.y += stmtRowHeight
+}
+
+func (w *stmtWriter) tableHeader(cols []stmtColumn) {
+	w.page.FillRect(stmtMarginX, w.y-10, pdf.PageWidth-2*stmtMarginX, 14, 0.92)
+	w.cells(cols, nil, pdf.Bold)
+}
+
+// table writes the rows, repeating the header on every page.
+func (w *stmtWriter) table(cols []stmtColumn, rows [][]string) {
+	w.ensure(2 * stmtRowHeight)
+	w.tableHeader(cols)
+	for _, r := range rows {
+		if w.y+stmtRowHeight > stmtBottom {
+			w.newPage()
+			w.tableHeader(cols)
+		}
+		w.cells(cols, r, pdf.Regular)
+	}
+	w.y += 4
+}
+
+func (w *stmtWriter) cells(cols []stmtColumn, vals []string, f pdf.Font) {
+	for i, c := range cols {
+		s := c.Title
+		if vals != nil {
+			s = vals[i]
+		}
+		if c.Right {
+			w.page.TextRight(c.X, w.y, stmtBodySize, f, s)
+		} else {
+			w.page.Text(c.X, w.y, stmtBodySize, f, s)
+		}
+	}
+	w.y += stmtRowHeight
+}
+
+// pairs writes label/value lines in two columns.
+func (w *stmtWriter) pairs(left, right [][2]string) {
+	n := max(len(left), len(right))
+	w.ensure(float64(n) * stmtRowHeight)
+	for i := 0; i < n; i++ {
+		if i < len(left) {
+			w.page.Text(stmtMarginX, w.y, stmtBodySize+1, pdf.Regular, left[i][0])
+			w.page.TextRight(270, w.y, stmtBodySize+1, pdf.Bold, left[i][1])
+		}
+		if i < len(right) {
+			w.page.Text(310, w.y, stmtBodySize+1, pdf.Regular, right[i][0])
+			w.page.TextRight(pdf.PageWidth-stmtMarginX, w.y, stmtBodySize+1, pdf.Bold, right[i][1])
+		}
+		w.y += stmtRowHeight + 1
+	}
+}
+
+// bytes adds the footers ("page x of n") and renders the file.
+func (w *stmtWriter) bytes() []byte {
+	pages := w.doc.Pages()
+	for i, p := range pages {
+		p.TextGray(stmtMarginX, 815, 7.5, pdf.Regular, 0.45, w.footer)
+		label := "Page " + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(pages))
+		p.TextRight(pdf.PageWidth-stmtMarginX, 815, 7.5, pdf.Regular, label)
+	}
+	return w.doc.Bytes()
+}
+
+// renderStatementPDF lays out the statement: header, summary, cash
+// movements, fills and closing holdings.
+func renderStatementPDF(sd statementData) []byte {
+	month := sd.PeriodStart.Format("January 2006")
+	lastDay := sd.PeriodEnd.AddDate(0, 0, -1)
+	if sd.Partial {
+		lastDay = sd.ValuedAt
+	}
+
+	w := newStmtWriter("GoMarket statement "+sd.Period,
+		"Average-cost basis. Amounts in the account currency. Generated "+time.Now().UTC().Format("2006-01-02 15:04")+" UTC.")
+
+	// Header
+	w.page.Text(stmtMarginX, w.y, 20, pdf.Bold, "GoMarket")
+	w.page.TextRight(pdf.PageWidth-stmtMarginX, w.y, 14, pdf.Bold, "Account statement")
+	w.y += 18
+	period := sd.PeriodStart.Format("2 Jan 2006") + " – " + lastDay.Format("2 Jan 2006")
+	if sd.Partial {
+		period += " (to date)"
+	}
+	w.page.TextRight(pdf.PageWidth-stmtMarginX, w.y, 10, pdf.Regular, month)
+	name := strings.TrimSpace(sd.User.FirstName + " " + sd.User.LastName)
+	w.page.Text(stmtMarginX, w.y, 10, pdf.Regular, name)
+	w.y += 14
+	w.page.TextRight(pdf.PageWidth-stmtMarginX, w.y, 9, pdf.Regular, period)
+	w.page.TextGray(stmtMarginX, w.y, 9, pdf.Regular, 0.4, sd.User.Email)
+	w.y += 14
+	w.page.TextGray(stmtMarginX, w.y, 9, pdf.Regular, 0.4, "Account: "+sd.Account.Name)
+	w.y += 14
+
+	// Summary
+	w.heading("Summary")
+	w.pairs(
+		[][2]string{
+			{"Opening cash", formatAmount(sd.OpeningCash)},
+			{"Deposits", formatAmount(sd.Deposits)},
+			{"Withdrawals", formatAmount(sd.Withdrawals.Neg())},
+			{"Bought", formatAmount(sd.Bought.Neg())},
+			{"Sold", formatAmount(sd.Sold)},
+			{"Fees", formatAmount(sd.Fees.Neg())},
+			{"Closing cash", formatAmount(sd.ClosingCash)},
+		},
+		[][2]string{
+			{"Opening account value", formatAmount(sd.OpeningValue)},
+			{"Closing account value", formatAmount(sd.ClosingValue)},
+			{"Realized P&L", formatAmount(sd.RealizedPnL)},
+			{"Unrealized P&L", formatAmount(sd.UnrealizedPnL)},
+			{"Fills", strconv.Itoa(sd.Fills)},
+		},
+	)
+
+	// Cash movements
+	w.heading("Cash movements")
+	if len(sd.Movements) == 0 {
+		w.note("No deposits or withdrawals in this period.")
+	} else {
+		cols := []stmtColumn{{Title: "Date", X: 50}, {Title: "Type", X: 130}, {Title: "Note", X: 210}, {Title: "Amount", X: 545, Right: true}}
+		rows := make([][]string, 0, len(sd.Movements))
+		for _, t := range sd.Movements {
+			rows = append(rows, []string{
+				t.CreatedAt.Format("2006-01-02"),
+				t.Type,
+				truncateText(t.Note, 260, stmtBodySize),
+				formatAmount(t.Amount),
+			})
+		}
+		w.table(cols, rows)
+	}
+
+	// Fills
+	w.heading("Fills")
+	if len(sd.Trades) == 0 {
+		w.note("No trades in this period.")
+	} else {
+		cols := []stmtColumn{
+			{Title: "Date", X: 50}, {Title: "Side", X: 140}, {Title: "Symbol", X: 185},
+			{Title: "Qty", X: 300, Right: true}, {Title: "Price", X: 370, Right: true},
+			{Title: "Amount", X: 455, Right: true}, {Title: "Realized P&L", X: 545, Right: true},
+		}
+		rows := make([][]string, 0, len(sd.Trades))
+		for _, f := range sd.Trades {
+			side := f.Side
+			if f.Source == models.OrderSourceImport {
+				side += "*"
+			}
+			realized := ""
+			if f.HasRealized {
+				realized = formatAmount(f.Realized)
+			}
+			rows = append(rows, []string{
+				f.CreatedAt.Format("2006-01-02 15:04"),
+				side,
+				f.Symbol,
+				f.Qty.String(),
+				formatAmount(f.Price),
+				formatAmount(f.Amount),
+				realized,
+			})
+		}
+		w.table(cols, rows)
+		if hasImported(sd.Trades) {
+			w.note("* Imported from another broker; no cash moved in this account.")
+		}
+	}
+
+	// Holdings
+	w.heading("Holdings at " + lastDay.Format("2 Jan 2006"))
+	if len(sd.Holdings) == 0 {
+		w.note("No open positions.")
+	} else {
+		cols := []stmtColumn{
+			{Title: "Symbol", X: 50}, {Title: "Qty", X: 190, Right: true},
+			{Title: "Avg cost", X: 270, Right: true}, {Title: "Price", X: 350, Right: true},
+			{Title: "Value", X: 445, Right: true}, {Title: "Unrealized P&L", X: 545, Right: true},
+		}
+		rows := make([][]string, 0, len(sd.Holdings))
+		for _, h := range sd.Holdings {
+			rows = append(rows, []string{
+				h.Symbol,
+				h.Qty.String(),
+				formatAmount(h.AvgCost),
+				formatAmount(h.Price),
+				formatAmount(h.Value),
+				formatAmount(h.PnL),
+			})
+		}
+		w.table(cols, rows)
+		if sd.Partial {
+			w.note("Valued at current quotes.")
+		} else {
+			w.note("Valued at the last close of the period.")
+		}
+	}
+
+	return w.bytes()
+}
+
+func hasImported(fills []statementFill) bool {
+	for _, f := range fills {
+		if f.Source == models.OrderSourceImport {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateText shortens s to fit width points.
+func truncateText(s string, width, size float64) string {
+	if pdf.TextWidth(s, size, pdf.Regular) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && pdf.TextWidth(string(r)+"...", size, pdf.Regular) > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "..."
+}
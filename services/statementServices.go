@@ -0,0 +1,456 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	statementsCollection  = "statements"
+	statementPeriodLayout = "2006-01"
+)
+
+var (
+	ErrInvalidPeriod     = errors.New("invalid period")
+	ErrStatementNotFound = errors.New("statement not found")
+	ErrStatementTooEarly = errors.New("period ends before the account was opened")
+)
+
+type statementHolding struct {
+	Symbol  string
+	Qty     decimal.Decimal
+	AvgCost decimal.Decimal
+	Price   decimal.Decimal
+	Value   decimal.Decimal
+	PnL     decimal.Decimal
+}
+
+type statementFill struct {
+	models.Order
+	Amount      decimal.Decimal
+	Realized    decimal.Decimal
+	HasRealized bool
+}
+
+// statementData is everything that goes into one statement.
+type statementData struct {
+	models.Statement
+	User      models.User
+	Account   models.Account
+	Movements []models.CashTransaction
+	Trades    []statementFill
+	Holdings  []statementHolding
+	ValuedAt  time.Time
+}
+
+func EnsureStatementIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(statementsCollection)
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "account_id", Value: 1}, {Key: "period", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+// ParseStatementPeriod reads "2006-01" and returns the month as [start, end).
+// The current month is allowed (a statement to date); later ones are not.
+func ParseStatementPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	start, err := time.Parse(statementPeriodLayout, strings.TrimSpace(period))
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	if start.After(now) {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// GenerateStatement builds an account's statement for a month, renders the
+// PDF and stores it, replacing an earlier one for the same month.
+func GenerateStatement(user models.User, acct models.Account, period string) (models.Statement, error) {
+	now := time.Now().UTC()
+	start, end, err := ParseStatementPeriod(period, now)
+	if err != nil {
+		return models.Statement{}, err
+	}
+	if !acct.CreatedAt.IsZero() && !acct.CreatedAt.Before(end) {
+		return models.Statement{}, ErrStatementTooEarly
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	sd, err := buildStatement(ctx, user, acct, start, end, now)
+	if err != nil {
+		return models.Statement{}, err
+	}
+	s := sd.Statement
+	s.PDF = renderStatementPDF(sd)
+	s.Size = len(s.PDF)
+	s.CreatedAt = now
+
+	var saved models.Statement
+	err = db.Client.Database("gomarket").Collection(statementsCollection).FindOneAndReplace(ctx,
+		bson.M{"account_id": acct.ID, "period": s.Period},
+		s,
+		options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&saved)
+	if err != nil {
+		return models.Statement{}, err
+	}
+	return saved, nil
+}
+
+// ListStatements returns the account's statements, newest month first,
+// without the PDF bodies.
+func ListStatements(accountID primitive.ObjectID) ([]models.Statement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	cur, err := db.Client.Database("gomarket").Collection(statementsCollection).Find(ctx,
+		bson.M{"account_id": accountID},
+		options.Find().SetSort(bson.D{{Key: "period", Value: -1}}).SetProjection(bson.M{"pdf": 0}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]models.Statement, 0)
+	for cur.Next(ctx) {
+		var s models.Statement
+		if err := cur.Decode(&s); err != nil {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// GetStatement loads one statement including its PDF.
+func GetStatement(userID, id primitive.ObjectID) (models.Statement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	var s models.Statement
+	err := db.Client.Database("gomarket").Collection(statementsCollection).
+		FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return models.Statement{}, ErrStatementNotFound
+	}
+	return s, err
+}
+
+// StatementFilename is the download name, e.g. gomarket-statement-2024-05.pdf.
+func StatementFilename(s models.Statement) string {
+	return "gomarket-statement-" + s.Period + ".pdf"
+}
+
+// StartStatementScheduler issues last month's statement to every account
+// that doesn't have one yet. It checks hourly, so a restart on the 1st only
+// delays statements instead of skipping them.
+func StartStatementScheduler(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+
+	go func() {
+		defer ticker.Stop()
+
+		runStatementTick(time.Now().UTC())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				runStatementTick(now.UTC())
+			}
+		}
+	}()
+}
+
+func runStatementTick(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	prev := thisMonth.AddDate(0, -1, 0)
+	period := prev.Format(statementPeriodLayout)
+
+	d := db.Client.Database("gomarket")
+
+	// a statement asked for before the month ended is partial and gets
+	// replaced by the full one
+	done := map[primitive.ObjectID]bool{}
+	cur, err := d.Collection(statementsCollection).Find(ctx,
+		bson.M{"period": period, "partial": false}, options.Find().SetProjection(bson.M{"account_id": 1}))
+	if err != nil {
+		log.Println("statements:", err)
+		return
+	}
+	for cur.Next(ctx) {
+		var s models.Statement
+		if err := cur.Decode(&s); err == nil {
+			done[s.AccountID] = true
+		}
+	}
+	cur.Close(ctx)
+
+	acctCur, err := d.Collection(accountsCollection).Find(ctx, bson.M{"created_at": bson.M{"$lt": thisMonth}})
+	if err != nil {
+		log.Println("statements:", err)
+		return
+	}
+	var accounts []models.Account
+	for acctCur.Next(ctx) {
+		var a models.Account
+		if err := acctCur.Decode(&a); err == nil && !done[a.ID] {
+			accounts = append(accounts, a)
+		}
+	}
+	acctCur.Close(ctx)
+
+	for _, a := range accounts {
+		u, ok := db.GetUser(a.UserID)
+		if !ok || u.Disabled {
+			continue
+		}
+		if _, err := GenerateStatement(u, a, period); err != nil && err != ErrStatementTooEarly {
+			log.Println("statements:", a.ID.Hex(), period, err)
+		}
+	}
+}
+
+type statementPosition struct {
+	qty     decimal.Decimal
+	avgCost decimal.Decimal
+}
+
+// buildStatement works the period out from the order and cash ledgers.
+// Holdings are replayed from every order with the average-cost rules used
+// for live trades, so cost basis and P&L match the portfolio page. Cash is
+// worked backwards from today's balance, which keeps opening and closing
+// cash consistent with the fills and movements listed in between. Imported
+// trades and transfers are listed but never moved cash.
+func buildStatement(ctx context.Context, user models.User, acct models.Account, start, end, now time.Time) (statementData, error) {
+	sd := statementData{User: user, Account: acct}
+	sd.UserID = user.ID
+	sd.AccountID = acct.ID
+	sd.Period = start.Format(statementPeriodLayout)
+	sd.PeriodStart, sd.PeriodEnd = start, end
+
+	cutoff := end
+	if now.Before(end) {
+		cutoff = now
+		sd.Partial = true
+	}
+	sd.ValuedAt = cutoff
+
+	d := db.Client.Database("gomarket")
+
+	// 1) Orders: replay holdings, collect the period's fills
+	cur, err := d.Collection("orders").Find(ctx, bson.M{"account_id": acct.ID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return sd, err
+	}
+	defer cur.Close(ctx)
+
+	held := map[string]statementPosition{}
+	var opening map[string]statementPosition
+	laterCash := decimal.Zero // cash moved by trades after the period
+
+	for cur.Next(ctx) {
+		var o models.Order
+		if err := cur.Decode(&o); err != nil {
+			continue
+		}
+		o.Symbol = strings.ToUpper(o.Symbol)
+		amount := costOf(o.Price, o.Qty)
+		live := o.Source != models.OrderSourceImport
+
+		if !o.CreatedAt.Before(cutoff) {
+			if live && o.Side == "sell" {
+				laterCash = laterCash.Add(amount)
+			} else if live {
+				laterCash = laterCash.Sub(amount)
+			}
+			continue
+		}
+		if opening == nil && !o.CreatedAt.Before(start) {
+			opening = copyStatementPositions(held)
+		}
+
+		p := held[o.Symbol]
+		fill := statementFill{Order: o, Amount: amount}
+		qty := o.Qty
+		if o.Side == "sell" {
+			qty = qty.Neg()
+		}
+		// sells, and buys that cover a short, realize P&L
+		covers := o.Side == "buy" && p.qty.IsNegative()
+		var realized decimal.Decimal
+		p.qty, p.avgCost, realized = applyFill(p.qty, p.avgCost, qty, o.Price)
+		if covers || o.Side == "sell" {
+			fill.Realized = realized
+			fill.HasRealized = true
+		}
+		held[o.Symbol] = p
+
+		if o.CreatedAt.Before(start) {
+			continue
+		}
+		sd.Trades = append(sd.Trades, fill)
+		sd.RealizedPnL = sd.RealizedPnL.Add(fill.Realized)
+		if live && o.Side == "sell" {
+			sd.Sold = sd.Sold.Add(amount)
+		} else if live {
+			sd.Bought = sd.Bought.Add(amount)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return sd, err
+	}
+	if opening == nil {
+		opening = copyStatementPositions(held)
+	}
+	sd.Fills = len(sd.Trades)
+
+	// 2) Cash movements in the period and after it
+	cashCur, err := d.Collection(cashTransactionsCollection).Find(ctx,
+		bson.M{"account_id": acct.ID, "created_at": bson.M{"$gte": start}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return sd, err
+	}
+	defer cashCur.Close(ctx)
+
+	laterFlows := decimal.Zero
+	for cashCur.Next(ctx) {
+		var t models.CashTransaction
+		if err := cashCur.Decode(&t); err != nil {
+			continue
+		}
+		moved := t.Type != models.CashTransfer
+		if !t.CreatedAt.Before(cutoff) {
+			if moved {
+				laterFlows = laterFlows.Add(t.Amount)
+			}
+			continue
+		}
+		if t.IsCharge() {
+			sd.Fees = sd.Fees.Sub(t.Amount)
+			continue
+		}
+		sd.Movements = append(sd.Movements, t)
+		switch {
+		case !moved:
+		case t.Amount.IsPositive():
+			sd.Deposits = sd.Deposits.Add(t.Amount)
+		default:
+			sd.Withdrawals = sd.Withdrawals.Sub(t.Amount)
+		}
+	}
+
+	balance := acct.Balance
+	if a, ok := db.GetAccount(acct.ID); ok {
+		balance = a.Balance
+	}
+	sd.ClosingCash = balance.Sub(laterFlows).Sub(laterCash)
+	sd.OpeningCash = sd.ClosingCash.Sub(sd.Deposits).Add(sd.Withdrawals).Add(sd.Bought).Sub(sd.Sold).Add(sd.Fees)
+
+	// 3) Valuations
+	sd.OpeningValue = sd.OpeningCash
+	for sym, p := range opening {
+		if !p.qty.IsZero() {
+			price := statementClose(sym, start.AddDate(0, 0, -1), p.avgCost)
+			sd.OpeningValue = sd.OpeningValue.Add(costOf(price, p.qty))
+		}
+	}
+
+	sd.ClosingValue = sd.ClosingCash
+	for sym, p := range held {
+		if p.qty.IsZero() {
+			continue
+		}
+		var price decimal.Decimal
+		if sd.Partial {
+			// same rule as the portfolio page
+			price = p.avgCost
+			if q, err := FetchCurrentPrice(sym); err == nil && q > 0 {
+				price = priceFromFloat(q)
+			}
+		} else {
+			price = statementClose(sym, cutoff.AddDate(0, 0, -1), p.avgCost)
+		}
+		h := statementHolding{
+			Symbol:  sym,
+			Qty:     p.qty,
+			AvgCost: p.avgCost,
+			Price:   price,
+			Value:   costOf(price, p.qty),
+			PnL:     costOf(price.Sub(p.avgCost), p.qty),
+		}
+		sd.Holdings = append(sd.Holdings, h)
+		sd.ClosingValue = sd.ClosingValue.Add(h.Value)
+		sd.UnrealizedPnL = sd.UnrealizedPnL.Add(h.PnL)
+	}
+	sort.Slice(sd.Holdings, func(i, j int) bool { return sd.Holdings[i].Symbol < sd.Holdings[j].Symbol })
+	return sd, nil
+}
+
+// statementClose is the last stored close on or before day, falling back to
+// the average cost like the portfolio page does without a quote.
+func statementClose(sym string, day time.Time, fallback decimal.Decimal) decimal.Decimal {
+	closes, err := DailyCloses(sym, day.AddDate(0, 0, -10), day)
+	if err != nil {
+		return fallback
+	}
+	last := day.Format("2006-01-02")
+	for i := len(closes) - 1; i >= 0; i-- {
+		if closes[i].Day <= last && closes[i].Close > 0 {
+			return priceFromFloat(closes[i].Close)
+		}
+	}
+	return fallback
+}
+
+func copyStatementPositions(m map[string]statementPosition) map[string]statementPosition {
+	out := make(map[string]statementPosition, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// formatAmount writes 1234567.8 as "1,234,567.80".
+func formatAmount(v decimal.Decimal) string {
+	s := v.StringFixed(2)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	intPart, frac := s[:len(s)-3], s[len(s)-3:]
+
+	var b strings.Builder
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	out := b.String() + frac
+	if neg && out != "0.00" {
+		out = "-" + out
+	}
+	return out
+}
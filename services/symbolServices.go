@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	symbolsCollection = "symbols"
+
+	// profiles barely change; refresh them weekly
+	symbolProfileTTL = 7 * 24 * time.Hour
+
+	UnknownLabel = "Unknown"
+)
+
+// Finnhub only reports an industry; this groups its industries into the
+// usual GICS-style sectors.
+var industrySectors = map[string]string{
+	"Aerospace & Defense":              "Industrials",
+	"Airlines":                         "Industrials",
+	"Building":                         "Industrials",
+	"Commercial Services & Supplies":   "Industrials",
+	"Construction":                     "Industrials",
+	"Electrical Equipment":             "Industrials",
+	"Industrial Conglomerates":         "Industrials",
+	"Logistics & Transportation":       "Industrials",
+	"Machinery":                        "Industrials",
+	"Marine":                           "Industrials",
+	"Professional Services":            "Industrials",
+	"Road & Rail":                      "Industrials",
+	"Trading Companies & Distributors": "Industrials",
+	"Transportation Infrastructure":    "Industrials",
+	"Auto Components":                  "Consumer Discretionary",
+	"Automobiles":                      "Consumer Discretionary",
+	"Consumer products":                "Consumer Discretionary",
+	"Distributors":                     "Consumer Discretionary",
+	"Diversified Consumer Services":    "Consumer Discretionary",
+	"Hotels, Restaurants & Leisure":    "Consumer Discretionary",
+	"Leisure Products":                 "Consumer Discretionary",
+	"Retail":                           "Consumer Discretionary",
+	"Textiles, Apparel & Luxury Goods": "Consumer Discretionary",
+	"Beverages":                        "Consumer Staples",
+	"Food Products":                    "Consumer Staples",
+	"Tobacco":                          "Consumer Staples",
+	"Banking":                          "Financials",
+	"Financial Services":               "Financials",
+	"Insurance":                        "Financials",
+	"Biotechnology":                    "Health Care",
+	"Health Care":                      "Health Care",
+	"Life Sciences Tools & Services":   "Health Care",
+	"Pharmaceuticals":                  "Health Care",
+	"Semiconductors":                   "Information Technology",
+	"Technology":                       "Information Technology",
+	"Communications":                   "Communication Services",
+	"Media":                            "Communication Services",
+	"Telecommunication":                "Communication Services",
+	"Energy":                           "Energy",
+	"Chemicals":                        "Materials",
+	"Metals & Mining":                  "Materials",
+	"Packaging":                        "Materials",
+	"Paper & Forest":                   "Materials",
+	"Real Estate":                      "Real Estate",
+	"Utilities":                        "Utilities",
+}
+
+func EnsureSymbolIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(symbolsCollection)
+	_, _ = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "symbol", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+// GetSymbolProfile returns the cached profile, refreshing it from the market
+// data provider when it is missing or older than a week. A stale profile is
+// returned if the refresh fails.
+func GetSymbolProfile(symbol string) (models.Symbol, error) {
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	coll := db.Client.Database("gomarket").Collection(symbolsCollection)
+
+	var cached models.Symbol
+	err := coll.FindOne(ctx, bson.M{"symbol": sym}).Decode(&cached)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return models.Symbol{}, err
+	}
+	found := err == nil
+	if found && time.Since(cached.FetchedAt) < symbolProfileTTL {
+		return cached, nil
+	}
+
+	fresh, err := fetchSymbolProfile(sym)
+	if err != nil {
+		if found {
+			return cached, nil
+		}
+		log.Println("symbol profile:", sym, err)
+		return unknownSymbol(sym), nil
+	}
+
+	_, err = coll.UpdateOne(ctx, bson.M{"symbol": sym}, bson.M{"$set": fresh}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Println("symbol profile: save:", err)
+	}
+	return fresh, nil
+}
+
+func fetchSymbolProfile(sym string) (models.Symbol, error) {
+	p, err := FetchCompanyProfile(sym)
+	if err != nil {
+		return models.Symbol{}, err
+	}
+
+	s := unknownSymbol(sym)
+	s.FetchedAt = time.Now().UTC()
+	if p.Name != "" {
+		s.Name = p.Name
+	}
+	if p.FinnhubIndustry != "" && p.FinnhubIndustry != "N/A" {
+		s.Industry = p.FinnhubIndustry
+		if sector, ok := industrySectors[p.FinnhubIndustry]; ok {
+			s.Sector = sector
+		}
+	}
+	if p.Country != "" {
+		s.Country = p.Country
+	}
+	s.Currency = p.Currency
+	s.Exchange = p.Exchange
+	s.MarketCap = p.MarketCap
+	s.Logo = p.Logo
+
+	// the profile doesn't say what kind of security it is; symbol search does
+	if results, err := SearchSymbols(sym, 20); err == nil {
+		for _, r := range results {
+			if strings.EqualFold(r.Symbol, sym) {
+				if r.Type != "" {
+					s.AssetType = r.Type
+				}
+				if s.Name == sym && r.Description != "" {
+					s.Name = r.Description
+				}
+				break
+			}
+		}
+	}
+	return s, nil
+}
+
+func unknownSymbol(sym string) models.Symbol {
+	return models.Symbol{
+		Symbol:    sym,
+		Name:      sym,
+		AssetType: UnknownLabel,
+		Sector:    UnknownLabel,
+		Industry:  UnknownLabel,
+		Country:   UnknownLabel,
+	}
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Holding period classes.
+const (
+	TermShort = "short"
+	TermLong  = "long"
+)
+
+// CSV parts of the tax report.
+const (
+	TaxPartSummary = "summary"
+	TaxPartLots    = "lots"
+)
+
+// washSaleWindow is how far before and after a loss sale a purchase of the
+// same symbol counts as a replacement.
+const washSaleWindow = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidTaxYear = errors.New("invalid tax year")
+	ErrUnknownTaxPart = errors.New("unknown tax report part")
+)
+
+// TaxLotSale is one sell matched against one buy lot (FIFO). A sell that
+// spans several lots becomes several rows.
+type TaxLotSale struct {
+	Symbol   string          `json:"symbol"`
+	Qty      decimal.Decimal `json:"qty"`
+	Acquired time.Time       `json:"acquired"` // zero when Unmatched
+	Sold     time.Time       `json:"sold"`
+	Term     string          `json:"term"`
+
+	Proceeds  decimal.Decimal `json:"proceeds"`
+	CostBasis decimal.Decimal `json:"cost_basis"` // includes losses carried in from earlier wash sales
+
+	// Part of a loss that can't be claimed because the symbol was bought
+	// again within 30 days; it is added to the replacement shares' basis.
+	WashSale       bool            `json:"wash_sale"`
+	WashDisallowed decimal.Decimal `json:"wash_disallowed"`
+
+	Gain decimal.Decimal `json:"gain"` // proceeds - cost basis + disallowed loss
+
+	// No buy was found for these shares, so the basis is unknown (zero)
+	Unmatched bool `json:"unmatched,omitempty"`
+
+	// A short sale, closed by the buy on Acquired. It is reported when it
+	// is closed, and its gain is always short term.
+	Short bool `json:"short,omitempty"`
+}
+
+type TaxTotals struct {
+	Lots           int             `json:"lots"`
+	Proceeds       decimal.Decimal `json:"proceeds"`
+	CostBasis      decimal.Decimal `json:"cost_basis"`
+	WashDisallowed decimal.Decimal `json:"wash_disallowed"`
+	Gain           decimal.Decimal `json:"gain"`
+}
+
+// TaxReport lists the realized gains of one calendar year (UTC).
+type TaxReport struct {
+	Year int       `json:"year"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"` // exclusive
+
+	// Set while purchases can still turn a loss of the year into a wash
+	// sale (until 30 days after it ends).
+	Provisional bool `json:"provisional"`
+
+	ShortTerm TaxTotals `json:"short_term"`
+	LongTerm  TaxTotals `json:"long_term"`
+	Total     TaxTotals `json:"total"`
+
+	Lots []TaxLotSale `json:"lots"`
+}
+
+// taxLot is a buy, or the part of one that is still held.
+type taxLot struct {
+	qty      decimal.Decimal
+	price    decimal.Decimal // per share, incl. the buy's fees and disallowed losses added to it
+	acquired time.Time
+	// start of the holding period; earlier than acquired when the lot
+	// replaced shares sold in a wash sale
+	holdingFrom time.Time
+	replacement bool // already absorbed a wash sale
+}
+
+// ParseTaxYear accepts a year between the first supported one and now.
+func ParseTaxYear(s string, now time.Time) (int, error) {
+	y, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || y < 2000 || y > now.UTC().Year() {
+		return 0, ErrInvalidTaxYear
+	}
+	return y, nil
+}
+
+// TaxYears returns the years that have sells, newest first. The current
+// year is always included.
+func TaxYears(accountID primitive.ObjectID) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Year()
+	var first models.Order
+	err := db.Client.Database("gomarket").Collection("orders").FindOne(ctx,
+		bson.M{"account_id": accountID, "side": "sell"},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: 1}})).Decode(&first)
+	if err == mongo.ErrNoDocuments {
+		return []int{now}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	years := []int{}
+	for y := now; y >= first.CreatedAt.UTC().Year(); y-- {
+		years = append(years, y)
+	}
+	return years, nil
+}
+
+// BuildTaxReport matches every sell against the oldest shares still held
+// (FIFO) and reports the sells of the given year. Orders are replayed from
+// the start so lots and earlier wash-sale adjustments carry over, and up to
+// 30 days past the year so late repurchases are seen.
+func BuildTaxReport(accountID primitive.ObjectID, year int) (TaxReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(1, 0, 0)
+	rep := TaxReport{Year: year, From: from, To: to, Lots: []TaxLotSale{}}
+	rep.Provisional = time.Now().Before(to.Add(washSaleWindow))
+
+	cur, err := db.Client.Database("gomarket").Collection("orders").Find(ctx,
+		bson.M{"account_id": accountID, "created_at": bson.M{"$lt": to.Add(washSaleWindow)}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return rep, err
+	}
+	var orders []models.Order
+	if err := cur.All(ctx, &orders); err != nil {
+		return rep, err
+	}
+
+	longs, shorts := splitShortSales(orders)
+	sales := append(matchTaxLots(longs), shorts...)
+	sort.SliceStable(sales, func(i, j int) bool { return sales[i].Sold.Before(sales[j].Sold) })
+	for _, s := range sales {
+		if s.Sold.Before(from) || !s.Sold.Before(to) {
+			continue
+		}
+		rep.Lots = append(rep.Lots, s)
+		addTaxTotals(&rep.Total, s)
+		if s.Term == TermLong {
+			addTaxTotals(&rep.LongTerm, s)
+		} else {
+			addTaxTotals(&rep.ShortTerm, s)
+		}
+	}
+	return rep, nil
+}
+
+func addTaxTotals(t *TaxTotals, s TaxLotSale) {
+	t.Lots++
+	t.Proceeds = t.Proceeds.Add(s.Proceeds)
+	t.CostBasis = t.CostBasis.Add(s.CostBasis)
+	t.WashDisallowed = t.WashDisallowed.Add(s.WashDisallowed)
+	t.Gain = t.Gain.Add(s.Gain)
+}
+
+// shortLot is a short sale, or the part of one not yet bought back.
+type shortLot struct {
+	qty    decimal.Decimal
+	price  decimal.Decimal // per share, net of the sell's fees
+	opened time.Time
+}
+
+// feePerShare is o's commission and regulatory fees spread over its shares.
+// Buy fees add to the cost basis and sell fees come off the proceeds.
+func feePerShare(o models.Order) decimal.Decimal {
+	if !o.Qty.IsPositive() {
+		return decimal.Zero
+	}
+	return o.Fees().Div(o.Qty)
+}
+
+// splitShortSales takes the short side out of orders (oldest first). The
+// part of a sell beyond the shares held opens a short, and buys cover open
+// shorts (FIFO) before they add shares; each cover becomes a row. The
+// orders returned hold only the long side, for matchTaxLots.
+func splitShortSales(orders []models.Order) ([]models.Order, []TaxLotSale) {
+	held := map[string]decimal.Decimal{}
+	shorts := map[string][]*shortLot{}
+	longs := make([]models.Order, 0, len(orders))
+	var out []TaxLotSale
+
+	for _, o := range orders {
+		if !o.Qty.IsPositive() {
+			continue
+		}
+		sym := strings.ToUpper(o.Symbol)
+		qty := o.Qty
+
+		if o.Side == "sell" {
+			long := decimal.Min(qty, held[sym])
+			held[sym] = held[sym].Sub(long)
+			if short := qty.Sub(long); short.IsPositive() {
+				shorts[sym] = append(shorts[sym], &shortLot{qty: short, price: o.Price.Sub(feePerShare(o)), opened: o.CreatedAt})
+			}
+			qty = long
+		} else if o.Side == "buy" {
+			for _, l := range shorts[sym] {
+				if qty.IsZero() {
+					break
+				}
+				if l.qty.IsZero() {
+					continue
+				}
+				m := decimal.Min(qty, l.qty)
+				l.qty = l.qty.Sub(m)
+				qty = qty.Sub(m)
+
+				s := TaxLotSale{
+					Symbol:    sym,
+					Qty:       m,
+					Acquired:  o.CreatedAt,
+					Sold:      o.CreatedAt,
+					Term:      TermShort,
+					Proceeds:  costOf(l.price, m),
+					CostBasis: costOf(o.Price.Add(feePerShare(o)), m),
+					Short:     true,
+				}
+				s.Gain = s.Proceeds.Sub(s.CostBasis)
+				out = append(out, s)
+			}
+			held[sym] = held[sym].Add(qty)
+		}
+
+		if qty.IsPositive() {
+			// the long part keeps its share of the fees
+			if qty.LessThan(o.Qty) {
+				o.Commission = o.Commission.Mul(qty).Div(o.Qty)
+				o.RegFee = o.RegFee.Mul(qty).Div(o.Qty)
+			}
+			o.Qty = qty
+			longs = append(longs, o)
+		}
+	}
+	return longs, out
+}
+
+// matchTaxLots replays orders (oldest first) and returns one row per sell
+// and lot. All buys are known up front because a purchase up to 30 days
+// after a loss sale still makes it a wash sale.
+func matchTaxLots(orders []models.Order) []TaxLotSale {
+	lots := map[string][]*taxLot{}
+	for _, o := range orders {
+		if o.Side != "buy" || !o.Qty.IsPositive() {
+			continue
+		}
+		sym := strings.ToUpper(o.Symbol)
+		lots[sym] = append(lots[sym], &taxLot{
+			qty: o.Qty, price: o.Price.Add(feePerShare(o)), acquired: o.CreatedAt, holdingFrom: o.CreatedAt,
+		})
+	}
+
+	var out []TaxLotSale
+	for _, o := range orders {
+		if o.Side != "sell" || !o.Qty.IsPositive() {
+			continue
+		}
+		sym := strings.ToUpper(o.Symbol)
+		sold := map[*taxLot]bool{}
+		remaining := o.Qty
+		fee := feePerShare(o)
+
+		// FIFO first, so lots this sell draws from are never its own
+		// replacement shares
+		type portion struct {
+			sale        TaxLotSale
+			holdingFrom time.Time
+		}
+		var parts []portion
+		for _, l := range lots[sym] {
+			if remaining.IsZero() {
+				break
+			}
+			if l.qty.IsZero() || l.acquired.After(o.CreatedAt) {
+				continue
+			}
+			qty := decimal.Min(remaining, l.qty)
+			l.qty = l.qty.Sub(qty)
+			remaining = remaining.Sub(qty)
+			sold[l] = true
+
+			s := TaxLotSale{
+				Symbol:    sym,
+				Qty:       qty,
+				Acquired:  l.acquired,
+				Sold:      o.CreatedAt,
+				Term:      taxTerm(l.holdingFrom, o.CreatedAt),
+				Proceeds:  costOf(o.Price.Sub(fee), qty),
+				CostBasis: costOf(l.price, qty),
+			}
+			s.Gain = s.Proceeds.Sub(s.CostBasis)
+			parts = append(parts, portion{s, l.holdingFrom})
+		}
+
+		for _, p := range parts {
+			if p.sale.Gain.IsNegative() {
+				lots[sym] = applyWashSale(lots[sym], sold, &p.sale, p.holdingFrom)
+			}
+			out = append(out, p.sale)
+		}
+
+		if remaining.IsPositive() {
+			proceeds := costOf(o.Price.Sub(fee), remaining)
+			out = append(out, TaxLotSale{
+				Symbol: sym, Qty: remaining, Sold: o.CreatedAt, Term: TermShort,
+				Proceeds: proceeds, Gain: proceeds, Unmatched: true,
+			})
+		}
+	}
+	return out
+}
+
+// applyWashSale looks for shares of the symbol bought within 30 days of the
+// loss sale s (other than the lots it sold from). Each replacement share
+// takes its part of the loss into its basis and inherits the holding period
+// of the sold share; a lot that only partly replaces is split in two. The
+// lots are returned in their original order.
+func applyWashSale(queue []*taxLot, exclude map[*taxLot]bool, s *TaxLotSale, holdingFrom time.Time) []*taxLot {
+	loss := s.CostBasis.Sub(s.Proceeds)
+	need := s.Qty
+	held := s.Sold.Sub(holdingFrom)
+
+	for i := 0; i < len(queue) && need.IsPositive(); i++ {
+		l := queue[i]
+		if exclude[l] || l.replacement || l.qty.IsZero() {
+			continue
+		}
+		if l.acquired.Before(s.Sold.Add(-washSaleWindow)) || l.acquired.After(s.Sold.Add(washSaleWindow)) {
+			continue
+		}
+
+		m := decimal.Min(need, l.qty)
+		if m.LessThan(l.qty) {
+			rest := *l
+			rest.qty = l.qty.Sub(m)
+			l.qty = m
+			queue = append(queue[:i+1], append([]*taxLot{&rest}, queue[i+1:]...)...)
+		}
+
+		disallowed := loss.Mul(m).Div(s.Qty)
+		l.price = l.price.Add(disallowed.Div(m))
+		l.holdingFrom = l.acquired.Add(-held)
+		l.replacement = true
+
+		s.WashSale = true
+		s.WashDisallowed = s.WashDisallowed.Add(disallowed)
+		need = need.Sub(m)
+	}
+
+	s.WashDisallowed = money(s.WashDisallowed)
+	s.Gain = s.Proceeds.Sub(s.CostBasis).Add(s.WashDisallowed)
+	return queue
+}
+
+// taxTerm is long for shares held more than one year.
+func taxTerm(from, sold time.Time) string {
+	if sold.After(from.AddDate(1, 0, 0)) {
+		return TermLong
+	}
+	return TermShort
+}
+
+// TaxReportFilename is e.g. gomarket-tax-2024-lots.csv.
+func TaxReportFilename(year int, part string) string {
+	return "gomarket-tax-" + strconv.Itoa(year) + "-" + part + ".csv"
+}
+
+// WriteTaxReportCSV writes the summary (one row per term and a total) or the
+// per-lot detail.
+func WriteTaxReportCSV(w io.Writer, rep TaxReport, part string) error {
+	cw := csv.NewWriter(w)
+
+	switch part {
+	case TaxPartSummary:
+		_ = cw.Write([]string{"year", "term", "lots", "proceeds", "cost_basis", "wash_disallowed", "gain", "provisional"})
+		rows := []struct {
+			term string
+			t    TaxTotals
+		}{{TermShort, rep.ShortTerm}, {TermLong, rep.LongTerm}, {"total", rep.Total}}
+		for _, r := range rows {
+			_ = cw.Write([]string{
+				strconv.Itoa(rep.Year), r.term, strconv.Itoa(r.t.Lots),
+				exportText(r.t.Proceeds), exportText(r.t.CostBasis),
+				exportText(r.t.WashDisallowed), exportText(r.t.Gain),
+				strconv.FormatBool(rep.Provisional),
+			})
+		}
+	case TaxPartLots:
+		_ = cw.Write([]string{"symbol", "qty", "acquired", "sold", "term", "proceeds",
+			"cost_basis", "wash_sale", "wash_disallowed", "gain", "unmatched", "short"})
+		lots := append([]TaxLotSale(nil), rep.Lots...)
+		sort.SliceStable(lots, func(i, j int) bool { return lots[i].Sold.Before(lots[j].Sold) })
+		for _, s := range lots {
+			acquired := ""
+			if !s.Acquired.IsZero() {
+				acquired = s.Acquired.UTC().Format("2006-01-02")
+			}
+			_ = cw.Write([]string{
+				s.Symbol, s.Qty.String(), acquired, s.Sold.UTC().Format("2006-01-02"), s.Term,
+				exportText(s.Proceeds), exportText(s.CostBasis), strconv.FormatBool(s.WashSale),
+				exportText(s.WashDisallowed), exportText(s.Gain), strconv.FormatBool(s.Unmatched),
+				strconv.FormatBool(s.Short),
+			})
+		}
+	default:
+		return ErrUnknownTaxPart
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
+	"github.com/GeorgiStoyanov05/GoMarket/models"
+)
+
+var taxStart = time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+// taxOrder is "buy 10 @ 20 day 5", optionally followed by "fee 1".
+func taxOrder(t *testing.T, spec string) models.Order {
+	t.Helper()
+	var side, qty, price string
+	var day int
+	rest := ""
+	if i := strings.Index(spec, " fee "); i >= 0 {
+		spec, rest = spec[:i], spec[i+len(" fee "):]
+	}
+	if _, err := fmt.Sscanf(spec, "%s %s @ %s day %d", &side, &qty, &price, &day); err != nil {
+		t.Fatalf("bad order %q: %v", spec, err)
+	}
+	o := models.Order{
+		Symbol: "ACME", Side: side,
+		Qty: decimal.MustParse(qty), Price: decimal.MustParse(price),
+		CreatedAt: taxStart.AddDate(0, 0, day),
+	}
+	if rest != "" {
+		o.Commission = decimal.MustParse(rest)
+	}
+	return o
+}
+
+func taxOrders(t *testing.T, specs ...string) []models.Order {
+	out := make([]models.Order, len(specs))
+	for i, s := range specs {
+		out[i] = taxOrder(t, s)
+	}
+	return out
+}
+
+// taxRow sums a sale up as "qty term proceeds basis gain", with "wash
+// <disallowed>", "unmatched" and "short" appended when they apply.
+func taxRow(s TaxLotSale) string {
+	row := fmt.Sprintf("%s %s %s %s %s", s.Qty, s.Term, s.Proceeds, s.CostBasis, s.Gain)
+	if s.WashSale {
+		row += " wash " + s.WashDisallowed.String()
+	}
+	if s.Unmatched {
+		row += " unmatched"
+	}
+	if s.Short {
+		row += " short"
+	}
+	return row
+}
+
+func TestMatchTaxLots(t *testing.T) {
+	tests := []struct {
+		name   string
+		orders []string
+		want   []string
+	}{
+		{"fifo across lots",
+			[]string{"buy 10 @ 10 day 0", "buy 10 @ 20 day 10", "sell 15 @ 30 day 20"},
+			[]string{"10 short 300 100 200", "5 short 150 100 50"}},
+		{"partial lot stays for the next sell",
+			[]string{"buy 10 @ 10 day 0", "sell 4 @ 12 day 5", "sell 6 @ 13 day 6"},
+			[]string{"4 short 48 40 8", "6 short 78 60 18"}},
+		{"held exactly a year is short term",
+			[]string{"buy 1 @ 10 day 0", "sell 1 @ 20 day 366"}, // 2024 is a leap year
+			[]string{"1 short 20 10 10"}},
+		{"held longer is long term",
+			[]string{"buy 1 @ 10 day 0", "sell 1 @ 20 day 367"},
+			[]string{"1 long 20 10 10"}},
+		{"a sell without a buy is unmatched",
+			[]string{"sell 5 @ 10 day 0"},
+			[]string{"5 short 50 0 50 unmatched"}},
+		{"a buy after the sell is not its lot",
+			[]string{"sell 5 @ 10 day 0", "buy 5 @ 9 day 1"},
+			[]string{"5 short 50 0 50 unmatched"}},
+		{"fees in the basis and off the proceeds",
+			[]string{"buy 10 @ 10 day 0 fee 1", "sell 5 @ 12 day 5 fee 2", "sell 5 @ 12 day 6"},
+			[]string{"5 short 58 50.5 7.5", "5 short 60 50.5 9.5"}},
+		{"rebuy within 30 days is a wash sale",
+			[]string{"buy 10 @ 50 day 0", "sell 10 @ 40 day 100", "buy 10 @ 45 day 110", "sell 10 @ 60 day 120"},
+			[]string{"10 short 400 500 0 wash 100", "10 short 600 550 50"}},
+		{"rebuy before the loss counts too",
+			[]string{"buy 10 @ 50 day 0", "buy 10 @ 45 day 90", "sell 10 @ 40 day 100"},
+			[]string{"10 short 400 500 0 wash 100"}},
+		{"rebuy after 30 days is not",
+			[]string{"buy 10 @ 50 day 0", "sell 10 @ 40 day 100", "buy 10 @ 45 day 131"},
+			[]string{"10 short 400 500 -100"}},
+		{"partly replaced loss",
+			[]string{"buy 10 @ 50 day 0", "sell 10 @ 40 day 100", "buy 4 @ 45 day 105"},
+			[]string{"10 short 400 500 -60 wash 40"}},
+		{"replacement keeps the holding period",
+			[]string{"buy 1 @ 50 day 0", "sell 1 @ 40 day 360", "buy 1 @ 45 day 365", "sell 1 @ 70 day 380"},
+			[]string{"1 short 40 50 0 wash 10", "1 long 70 55 15"}},
+		{"gains are never wash sales",
+			[]string{"buy 10 @ 50 day 0", "sell 10 @ 60 day 100", "buy 10 @ 45 day 110"},
+			[]string{"10 short 600 500 100"}},
+	}
+	for _, tt := range tests {
+		sales := matchTaxLots(taxOrders(t, tt.orders...))
+		var got []string
+		for _, s := range sales {
+			got = append(got, taxRow(s))
+		}
+		if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitShortSales(t *testing.T) {
+	tests := []struct {
+		name   string
+		orders []string
+		shorts []string
+		longs  []string // "side qty fees"
+	}{
+		{"long only",
+			[]string{"buy 10 @ 10 day 0", "sell 10 @ 12 day 1"},
+			nil,
+			[]string{"buy 10 0", "sell 10 0"}},
+		{"short and cover",
+			[]string{"sell 10 @ 50 day 0", "buy 10 @ 40 day 5"},
+			[]string{"10 short 500 400 100 short"},
+			nil},
+		{"covered in two buys, at a loss",
+			[]string{"sell 10 @ 50 day 0", "buy 4 @ 55 day 5", "buy 6 @ 45 day 6"},
+			[]string{"4 short 200 220 -20 short", "6 short 300 270 30 short"},
+			nil},
+		{"sell through zero, then cover and go long",
+			[]string{"buy 5 @ 10 day 0", "sell 8 @ 20 day 1 fee 8", "buy 5 @ 15 day 2 fee 5"},
+			[]string{"3 short 57 48 9 short"},
+			[]string{"buy 5 0", "sell 5 5", "buy 2 2"}},
+		{"oldest short covered first",
+			[]string{"sell 2 @ 30 day 0", "sell 2 @ 40 day 1", "buy 3 @ 35 day 2"},
+			[]string{"2 short 60 70 -10 short", "1 short 40 35 5 short"},
+			nil},
+	}
+	for _, tt := range tests {
+		longs, shorts := splitShortSales(taxOrders(t, tt.orders...))
+		var gotShorts, gotLongs []string
+		for _, s := range shorts {
+			gotShorts = append(gotShorts, taxRow(s))
+		}
+		for _, o := range longs {
+			gotLongs = append(gotLongs, fmt.Sprintf("%s %s %s", o.Side, o.Qty, o.Fees()))
+		}
+		if strings.Join(gotShorts, "; ") != strings.Join(tt.shorts, "; ") {
+			t.Errorf("%s: shorts\n got %q\nwant %q", tt.name, gotShorts, tt.shorts)
+		}
+		if strings.Join(gotLongs, "; ") != strings.Join(tt.longs, "; ") {
+			t.Errorf("%s: longs\n got %q\nwant %q", tt.name, gotLongs, tt.longs)
+		}
+	}
+}
+
+func TestApplyWashSale(t *testing.T) {
+	d := decimal.MustParse
+	sold := taxStart.AddDate(0, 0, 100)
+	lot := func(qty string, day int) *taxLot {
+		at := taxStart.AddDate(0, 0, day)
+		return &taxLot{qty: d(qty), price: d("45"), acquired: at, holdingFrom: at}
+	}
+
+	tests := []struct {
+		name string
+		lots []*taxLot
+		want []string // "qty price replacement" per lot, in order
+		wash string   // disallowed loss
+	}{
+		{"no replacement", []*taxLot{lot("10", 50), lot("10", 131)},
+			[]string{"10 45 false", "10 45 false"}, "0"},
+		{"window edges", []*taxLot{lot("5", 70), lot("5", 130)},
+			[]string{"5 55 true", "5 55 true"}, "100"},
+		{"split lot", []*taxLot{lot("4", 105), lot("10", 110)},
+			[]string{"4 55 true", "6 55 true", "4 45 false"}, "100"},
+		{"already a replacement", []*taxLot{{qty: d("10"), price: d("45"), acquired: sold, holdingFrom: sold, replacement: true}},
+			[]string{"10 45 true"}, "0"},
+		{"only partly replaced", []*taxLot{lot("2", 95)},
+			[]string{"2 55 true"}, "20"},
+	}
+	for _, tt := range tests {
+		s := TaxLotSale{Qty: d("10"), Sold: sold, Proceeds: d("400"), CostBasis: d("500"), Gain: d("-100")}
+		queue := applyWashSale(tt.lots, map[*taxLot]bool{}, &s, taxStart)
+
+		var got []string
+		for _, l := range queue {
+			got = append(got, fmt.Sprintf("%s %s %v", l.qty, l.price, l.replacement))
+		}
+		if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+			t.Errorf("%s: lots\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+		if s.WashDisallowed.String() != tt.wash || s.WashSale != (tt.wash != "0") {
+			t.Errorf("%s: disallowed %s (wash %v), want %s", tt.name, s.WashDisallowed, s.WashSale, tt.wash)
+		}
+		if want := s.Proceeds.Sub(s.CostBasis).Add(s.WashDisallowed); s.Gain.Cmp(want) != 0 {
+			t.Errorf("%s: gain %s, want %s", tt.name, s.Gain, want)
+		}
+	}
+}
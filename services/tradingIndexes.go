@@ -19,14 +19,14 @@ func EnsureTradingIndexes() {
 	positions := d.Collection("positions")
 	orders := d.Collection("orders")
 
-	// One position per (user, symbol)
+	// One position per (account, symbol)
 	_, _ = positions.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "symbol", Value: 1}},
+		Keys:    bson.D{{Key: "account_id", Value: 1}, {Key: "symbol", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	})
 
-	// Helpful for listing user orders
+	// Helpful for listing account orders
 	_, _ = orders.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}},
 	})
 }
@@ -2,11 +2,11 @@ package services
 
 import (
 	"context"
-	"math"
 	"strings"
 	"time"
 
 	db "github.com/GeorgiStoyanov05/GoMarket/database"
+	"github.com/GeorgiStoyanov05/GoMarket/decimal"
 	"github.com/GeorgiStoyanov05/GoMarket/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,96 +16,203 @@ import (
 
 type BuyResult struct {
 	Symbol     string
-	Qty        int64
-	FillPrice  float64
-	Cost       float64
-	NewBalance float64
+	Qty        decimal.Decimal
+	FillPrice  decimal.Decimal
+	Cost       decimal.Decimal
+	Fees       decimal.Decimal
+	NewBalance decimal.Decimal
 	Position   models.Position
 }
 
 type SellResult struct {
 	Symbol     string
-	Qty        int64
-	FillPrice  float64
-	Proceeds   float64
-	NewBalance float64
-	Remaining  *models.Position // nil if position closed
+	Qty        decimal.Decimal
+	FillPrice  decimal.Decimal
+	Proceeds   decimal.Decimal
+	Fees       decimal.Decimal
+	NewBalance decimal.Decimal
+	Remaining  *models.Position // nil if position closed; negative qty if short
 }
 
-func roundMoney(v float64) float64 {
-	return math.Round(v*100) / 100
+// QtyPlaces is the finest fraction of a share that can be traded.
+const QtyPlaces = 6
+
+func checkQty(qty decimal.Decimal) string {
+	if !qty.IsPositive() {
+		return "Quantity must be greater than 0."
+	}
+	if qty.Places() > QtyPlaces {
+		return "Use at most 6 decimal places."
+	}
+	if qty.GreaterThan(maxOrderQty) {
+		return "Orders are limited to " + maxOrderQty.String() + " shares."
+	}
+	return ""
 }
 
-func MarketBuy(userID primitive.ObjectID, symbol string, qty int64) (BuyResult, map[string]string) {
+func MarketBuy(accountID primitive.ObjectID, symbol string, qty decimal.Decimal) (BuyResult, map[string]string) {
 	errs := map[string]string{}
 
 	sym := strings.ToUpper(strings.TrimSpace(symbol))
 	if sym == "" {
 		errs["symbol"] = "Missing symbol."
 	}
-	if qty <= 0 {
-		errs["qty"] = "Quantity must be greater than 0."
+	if msg := checkQty(qty); msg != "" {
+		errs["qty"] = msg
 	}
 	if len(errs) > 0 {
 		return BuyResult{}, errs
 	}
 
-	// 1) Get fill price (market buy = quote at click time)
-	price, err := FetchCurrentPrice(sym)
-	if err != nil {
-		errs["_form"] = "Could not fetch current price."
+	// 1) Get fill price from the fill model
+	price, errs := marketFill(sym, "buy", qty, decimal.Zero)
+	if len(errs) > 0 {
+		return BuyResult{}, errs
+	}
+
+	return buyAtPrice(accountID, sym, qty, price)
+}
+
+// MarketBuyAmount spends up to amount, fees included, on as many shares,
+// down to QtyPlaces decimals, as it buys at the current quote.
+func MarketBuyAmount(accountID primitive.ObjectID, symbol string, amount decimal.Decimal) (BuyResult, map[string]string) {
+	errs := map[string]string{}
+
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+	if sym == "" {
+		errs["symbol"] = "Missing symbol."
+	}
+	amount = money(amount)
+	if !amount.IsPositive() {
+		errs["amount"] = "Amount must be greater than 0."
+	} else if amount.GreaterThan(maxAmount) {
+		errs["amount"] = tooLarge
+	}
+	if len(errs) > 0 {
+		return BuyResult{}, errs
+	}
+
+	// Priced as if the whole amount bought shares at the last quote; the
+	// qty actually bought is smaller, so the slippage is never understated.
+	price, errs := marketFill(sym, "buy", decimal.Zero, amount)
+	if len(errs) > 0 {
+		return BuyResult{}, errs
+	}
+
+	// Take the fee for the whole amount off first; fees only grow with
+	// qty, so the smaller order still fits.
+	qty, err := amount.CheckedDiv(price)
+	if err != nil || qty.GreaterThan(maxOrderQty) {
+		errs["amount"] = "Orders are limited to " + maxOrderQty.String() + " shares."
+		return BuyResult{}, errs
+	}
+	qty = qty.Truncate(QtyPlaces)
+	acct, found := db.GetAccount(accountID)
+	if !found {
+		return BuyResult{}, map[string]string{"_form": "Account not found."}
+	}
+	if sched, ok := accountFeeSchedule(acct); ok {
+		fee := computeFees(sched, "buy", qty, price).Total()
+		qty = amount.Sub(fee).Div(price).Truncate(QtyPlaces)
+	}
+	if !qty.IsPositive() {
+		errs["amount"] = "Amount is too small to buy any shares."
 		return BuyResult{}, errs
 	}
-	price = roundMoney(price)
+	return buyAtPrice(accountID, sym, qty, price)
+}
+
+// buyAttempts is how many times a margin buy is checked and placed when
+// the balance keeps changing in between.
+const buyAttempts = 3
+
+func buyAtPrice(accountID primitive.ObjectID, sym string, qty, price decimal.Decimal) (BuyResult, map[string]string) {
+	cost, ok := orderValue(price, qty)
+	if !ok {
+		return BuyResult{}, map[string]string{"qty": tooLarge}
+	}
+
+	for attempt := 1; ; attempt++ {
+		acct, found := db.GetAccount(accountID)
+		if !found {
+			return BuyResult{}, map[string]string{"_form": "Account not found."}
+		}
+		var fees FeeQuote
+		if sched, ok := accountFeeSchedule(acct); ok {
+			fees = computeFees(sched, "buy", qty, price)
+		}
 
-	cost := roundMoney(price * float64(qty))
+		// Margin accounts can spend past their cash, up to their buying power
+		if errs := checkBuyingPower(acct, sym, qty, price, cost.Add(fees.Total())); len(errs) > 0 {
+			return BuyResult{}, errs
+		}
+
+		// Try transaction (works on Atlas/replica set). If not supported, fallback to sequential.
+		res, short := tryMarketBuyTxn(acct, sym, qty, price, cost, fees)
+		if res != nil {
+			return *res, nil
+		}
+		if !short {
+			// Fallback
+			var errs map[string]string
+			var out BuyResult
+			if out, errs, short = marketBuyNoTxn(acct, sym, qty, price, cost, fees); !short {
+				return out, errs
+			}
+		}
 
-	// Try transaction (works on Atlas/replica set). If not supported, fallback to sequential.
-	// Try transaction (works on Atlas/replica set). If not supported, fallback.
-	if ok := tryMarketBuyTxn(userID, sym, qty, price, cost); ok != nil {
-		if ok.NewBalance < 0 {
+		// A cash account's debit only goes through while its available
+		// cash covers it. A margin account's goes through while the balance
+		// is the one checkBuyingPower saw, so a miss means it moved
+		// meanwhile and the buying power has to be checked again.
+		if !acct.Margin() {
 			return BuyResult{}, map[string]string{"balance": "Not enough balance for this purchase."}
 		}
-		return *ok, nil
+		if attempt == buyAttempts {
+			return BuyResult{}, map[string]string{"_form": "Your balance changed while the order was placed. Please try again."}
+		}
 	}
-
-	// Fallback
-	return marketBuyNoTxn(userID, sym, qty, price, cost)
 }
 
-func tryMarketBuyTxn(userID primitive.ObjectID, sym string, qty int64, price, cost float64) *BuyResult {
+// tryMarketBuyTxn returns nil when transactions aren't supported, and
+// short when buyingPowerFilter no longer matches the account.
+func tryMarketBuyTxn(acct models.Account, sym string, qty, price, cost decimal.Decimal, fees FeeQuote) (res *BuyResult, short bool) {
 	client := db.Client
 	sess, err := client.StartSession()
 	if err != nil {
-		return nil
+		return nil, false
 	}
 	defer sess.EndSession(context.Background())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	usersColl := client.Database("gomarket").Collection("users")
+	accountsColl := client.Database("gomarket").Collection(accountsCollection)
 	posColl := client.Database("gomarket").Collection("positions")
 	ordersColl := client.Database("gomarket").Collection("orders")
 
 	now := time.Now().UTC()
 
+	debit := cost.Add(fees.Total())
+
 	var out BuyResult
+	var order models.Order
 	_, txnErr := sess.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
-		// A) Deduct balance atomically only if enough money
-		filter := bson.M{"_id": userID, "balance": bson.M{"$gte": cost}}
+		// A) Deduct balance atomically only if the buying power covers it
+		// Reserved cash (pending withdrawals) can't be spent
+		filter := buyingPowerFilter(acct, debit)
 		update := bson.M{
-			"$inc": bson.M{"balance": -cost},
+			"$inc": bson.M{"balance": debit.Neg()},
 			"$set": bson.M{"updated_at": now},
 		}
 
-		var updatedUser models.User
-		err := usersColl.FindOneAndUpdate(
+		var updatedAcct models.Account
+		err := accountsColl.FindOneAndUpdate(
 			sc,
 			filter,
 			update,
 			options.FindOneAndUpdate().SetReturnDocument(options.After),
-		).Decode(&updatedUser)
+		).Decode(&updatedAcct)
 
 		if err == mongo.ErrNoDocuments {
 			return nil, mongo.ErrNoDocuments
@@ -118,41 +225,8 @@ func tryMarketBuyTxn(userID primitive.ObjectID, sym string, qty int64, price, co
 		var updatedPos models.Position
 		err = posColl.FindOneAndUpdate(
 			sc,
-			bson.M{"user_id": userID, "symbol": sym},
-			mongo.Pipeline{
-				{{Key: "$set", Value: bson.D{
-					{Key: "user_id", Value: userID},
-					{Key: "symbol", Value: sym},
-					{Key: "created_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$created_at", now}}}},
-					{Key: "updated_at", Value: now},
-					// qty and avg_cost computed from OLD values
-					{Key: "qty", Value: bson.D{{Key: "$add", Value: bson.A{
-						bson.D{{Key: "$ifNull", Value: bson.A{"$qty", 0}}},
-						qty,
-					}}}},
-					{Key: "avg_cost", Value: bson.D{{Key: "$let", Value: bson.D{
-						{Key: "vars", Value: bson.D{
-							{Key: "oldQty", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$qty", 0}}}},
-							{Key: "oldAvg", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$avg_cost", 0}}}},
-							{Key: "buyQty", Value: qty},
-							{Key: "buyPrice", Value: price},
-						}},
-						{Key: "in", Value: bson.D{{Key: "$cond", Value: bson.A{
-							bson.D{{Key: "$gt", Value: bson.A{
-								bson.D{{Key: "$add", Value: bson.A{"$$oldQty", "$$buyQty"}}}, 0,
-							}}},
-							bson.D{{Key: "$divide", Value: bson.A{
-								bson.D{{Key: "$add", Value: bson.A{
-									bson.D{{Key: "$multiply", Value: bson.A{"$$oldQty", "$$oldAvg"}}},
-									bson.D{{Key: "$multiply", Value: bson.A{"$$buyQty", "$$buyPrice"}}},
-								}}},
-								bson.D{{Key: "$add", Value: bson.A{"$$oldQty", "$$buyQty"}}},
-							}}},
-							0,
-						}}}},
-					}}}},
-				}}},
-			},
+			bson.M{"account_id": acct.ID, "symbol": sym},
+			positionFillPipeline(acct, sym, qty, price, now),
 			options.FindOneAndUpdate().
 				SetUpsert(true).
 				SetReturnDocument(options.After),
@@ -161,15 +235,25 @@ func tryMarketBuyTxn(userID primitive.ObjectID, sym string, qty int64, price, co
 		if err != nil {
 			return nil, err
 		}
+		// a buy that covers a whole short closes the position
+		if updatedPos.Qty.IsZero() {
+			if _, err := posColl.DeleteOne(sc, bson.M{"_id": updatedPos.ID}); err != nil {
+				return nil, err
+			}
+		}
 
 		// C) Insert order (ledger)
-		order := models.Order{
-			UserID:    userID,
-			Symbol:    sym,
-			Side:      "buy",
-			Qty:       qty,
-			Price:     price,
-			CreatedAt: now,
+		order = models.Order{
+			ID:         primitive.NewObjectID(),
+			UserID:     acct.UserID,
+			AccountID:  acct.ID,
+			Symbol:     sym,
+			Side:       "buy",
+			Qty:        qty,
+			Price:      price,
+			Commission: fees.Commission,
+			RegFee:     fees.RegFee,
+			CreatedAt:  now,
 		}
 		if _, err := ordersColl.InsertOne(sc, order); err != nil {
 			return nil, err
@@ -180,7 +264,8 @@ func tryMarketBuyTxn(userID primitive.ObjectID, sym string, qty int64, price, co
 			Qty:        qty,
 			FillPrice:  price,
 			Cost:       cost,
-			NewBalance: roundMoney(updatedUser.Balance),
+			Fees:       fees.Total(),
+			NewBalance: updatedAcct.Balance,
 			Position:   updatedPos,
 		}
 		return nil, nil
@@ -190,84 +275,55 @@ func tryMarketBuyTxn(userID primitive.ObjectID, sym string, qty int64, price, co
 	if txnErr != nil {
 		// insufficient funds case
 		if txnErr == mongo.ErrNoDocuments {
-			return &BuyResult{Symbol: sym, Qty: qty, FillPrice: price, Cost: cost, NewBalance: -1}
+			return nil, true
 		}
 		// treat any txn error as "fallback"
-		return nil
+		return nil, false
 	}
 
-	return &out
+	recordFeeCash(ctx, order)
+	return &out, false
 }
 
-func marketBuyNoTxn(userID primitive.ObjectID, sym string, qty int64, price, cost float64) (BuyResult, map[string]string) {
+// marketBuyNoTxn is the buy without a transaction; short as in
+// tryMarketBuyTxn.
+func marketBuyNoTxn(acct models.Account, sym string, qty, price, cost decimal.Decimal, fees FeeQuote) (BuyResult, map[string]string, bool) {
 	errs := map[string]string{}
 	client := db.Client
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	usersColl := client.Database("gomarket").Collection("users")
+	accountsColl := client.Database("gomarket").Collection(accountsCollection)
 	posColl := client.Database("gomarket").Collection("positions")
 	ordersColl := client.Database("gomarket").Collection("orders")
 
 	now := time.Now().UTC()
+	debit := cost.Add(fees.Total())
 
 	// A) Deduct balance if enough
-	var updatedUser models.User
-	err := usersColl.FindOneAndUpdate(
+	var updatedAcct models.Account
+	err := accountsColl.FindOneAndUpdate(
 		ctx,
-		bson.M{"_id": userID, "balance": bson.M{"$gte": cost}},
-		bson.M{"$inc": bson.M{"balance": -cost}, "$set": bson.M{"updated_at": now}},
+		buyingPowerFilter(acct, debit),
+		bson.M{"$inc": bson.M{"balance": debit.Neg()}, "$set": bson.M{"updated_at": now}},
 		options.FindOneAndUpdate().SetReturnDocument(options.After),
-	).Decode(&updatedUser)
+	).Decode(&updatedAcct)
 
 	if err == mongo.ErrNoDocuments {
-		errs["balance"] = "Not enough balance for this purchase."
-		return BuyResult{}, errs
+		return BuyResult{}, nil, true
 	}
 	if err != nil {
 		errs["_form"] = "Database error while updating balance."
-		return BuyResult{}, errs
+		return BuyResult{}, errs, false
 	}
 
 	// B) Upsert position (same pipeline)
 	var updatedPos models.Position
 	err = posColl.FindOneAndUpdate(
 		ctx,
-		bson.M{"user_id": userID, "symbol": sym},
-		mongo.Pipeline{
-			{{Key: "$set", Value: bson.D{
-				{Key: "user_id", Value: userID},
-				{Key: "symbol", Value: sym},
-				{Key: "created_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$created_at", now}}}},
-				{Key: "updated_at", Value: now},
-				{Key: "qty", Value: bson.D{{Key: "$add", Value: bson.A{
-					bson.D{{Key: "$ifNull", Value: bson.A{"$qty", 0}}},
-					qty,
-				}}}},
-				{Key: "avg_cost", Value: bson.D{{Key: "$let", Value: bson.D{
-					{Key: "vars", Value: bson.D{
-						{Key: "oldQty", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$qty", 0}}}},
-						{Key: "oldAvg", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$avg_cost", 0}}}},
-						{Key: "buyQty", Value: qty},
-						{Key: "buyPrice", Value: price},
-					}},
-					{Key: "in", Value: bson.D{{Key: "$cond", Value: bson.A{
-						bson.D{{Key: "$gt", Value: bson.A{
-							bson.D{{Key: "$add", Value: bson.A{"$$oldQty", "$$buyQty"}}}, 0,
-						}}},
-						bson.D{{Key: "$divide", Value: bson.A{
-							bson.D{{Key: "$add", Value: bson.A{
-								bson.D{{Key: "$multiply", Value: bson.A{"$$oldQty", "$$oldAvg"}}},
-								bson.D{{Key: "$multiply", Value: bson.A{"$$buyQty", "$$buyPrice"}}},
-							}}},
-							bson.D{{Key: "$add", Value: bson.A{"$$oldQty", "$$buyQty"}}},
-						}}},
-						0,
-					}}}},
-				}}}},
-			}}},
-		},
+		bson.M{"account_id": acct.ID, "symbol": sym},
+		positionFillPipeline(acct, sym, qty, price, now),
 		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
 	).Decode(&updatedPos)
 
@@ -275,82 +331,165 @@ func marketBuyNoTxn(userID primitive.ObjectID, sym string, qty int64, price, cos
 		// Worst-case inconsistency: balance deducted but position failed.
 		// For MVP, we surface an error; later we’ll add txn-only or rollback logic.
 		errs["_form"] = "Bought balance updated, but position update failed."
-		return BuyResult{}, errs
+		return BuyResult{}, errs, false
+	}
+	if updatedPos.Qty.IsZero() {
+		_, _ = posColl.DeleteOne(ctx, bson.M{"_id": updatedPos.ID, "account_id": acct.ID})
 	}
 
 	// C) Insert order
 	order := models.Order{
-		UserID:    userID,
-		Symbol:    sym,
-		Side:      "buy",
-		Qty:       qty,
-		Price:     price,
-		CreatedAt: now,
+		ID:         primitive.NewObjectID(),
+		UserID:     acct.UserID,
+		AccountID:  acct.ID,
+		Symbol:     sym,
+		Side:       "buy",
+		Qty:        qty,
+		Price:      price,
+		Commission: fees.Commission,
+		RegFee:     fees.RegFee,
+		CreatedAt:  now,
 	}
 	if _, err := ordersColl.InsertOne(ctx, order); err != nil {
 		errs["_form"] = "Purchase saved partially (order insert failed)."
-		return BuyResult{}, errs
+		return BuyResult{}, errs, false
 	}
+	recordFeeCash(ctx, order)
 
 	return BuyResult{
 		Symbol:     sym,
 		Qty:        qty,
 		FillPrice:  price,
 		Cost:       cost,
-		NewBalance: roundMoney(updatedUser.Balance),
+		Fees:       fees.Total(),
+		NewBalance: updatedAcct.Balance,
 		Position:   updatedPos,
-	}, nil
+	}, nil, false
+}
+
+// positionFillPipeline upserts the account's sym position after a fill of
+// qty at price; qty is negative for a sell. It follows applyFill with
+// exact Decimal128 math, and starts or stops the borrow fee clock when the
+// position turns short or long.
+func positionFillPipeline(acct models.Account, sym string, qty, price decimal.Decimal, now time.Time) mongo.Pipeline {
+	oldQty := bson.D{{Key: "$ifNull", Value: bson.A{"$qty", 0}}}
+	newQty := bson.D{{Key: "$add", Value: bson.A{oldQty, qty}}}
+
+	return mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "user_id", Value: acct.UserID},
+			{Key: "account_id", Value: acct.ID},
+			{Key: "symbol", Value: sym},
+			{Key: "created_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$created_at", now}}}},
+			{Key: "updated_at", Value: now},
+			// qty, avg_cost and borrow_accrued_on computed from OLD values
+			{Key: "qty", Value: newQty},
+			{Key: "avg_cost", Value: bson.D{{Key: "$round", Value: bson.A{bson.D{{Key: "$let", Value: bson.D{
+				{Key: "vars", Value: bson.D{
+					{Key: "oldQty", Value: oldQty},
+					{Key: "oldAvg", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$avg_cost", 0}}}},
+					{Key: "newQty", Value: newQty},
+				}},
+				{Key: "in", Value: bson.D{{Key: "$switch", Value: bson.D{
+					{Key: "branches", Value: bson.A{
+						// closed
+						bson.D{
+							{Key: "case", Value: bson.D{{Key: "$eq", Value: bson.A{"$$newQty", 0}}}},
+							{Key: "then", Value: 0},
+						},
+						// opened, or crossed from long to short or back
+						bson.D{
+							{Key: "case", Value: bson.D{{Key: "$lte", Value: bson.A{
+								bson.D{{Key: "$multiply", Value: bson.A{"$$oldQty", "$$newQty"}}}, 0,
+							}}}},
+							{Key: "then", Value: price},
+						},
+						// added to: re-average
+						bson.D{
+							{Key: "case", Value: bson.D{{Key: "$gt", Value: bson.A{
+								bson.D{{Key: "$abs", Value: "$$newQty"}}, bson.D{{Key: "$abs", Value: "$$oldQty"}},
+							}}}},
+							{Key: "then", Value: bson.D{{Key: "$divide", Value: bson.A{
+								bson.D{{Key: "$add", Value: bson.A{
+									bson.D{{Key: "$multiply", Value: bson.A{bson.D{{Key: "$abs", Value: "$$oldQty"}}, "$$oldAvg"}}},
+									bson.D{{Key: "$multiply", Value: bson.A{qty.Abs(), price}}},
+								}}},
+								bson.D{{Key: "$abs", Value: "$$newQty"}},
+							}}}},
+						},
+					}},
+					// reduced: the average doesn't change
+					{Key: "default", Value: "$$oldAvg"},
+				}}}},
+			}}}, avgCostPlaces}}}},
+			{Key: "borrow_accrued_on", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$lt", Value: bson.A{newQty, 0}}},
+				bson.D{{Key: "$ifNull", Value: bson.A{"$borrow_accrued_on", now.Format("2006-01-02")}}},
+				"$$REMOVE",
+			}}}},
+		}}},
+	}
 }
 
-func MarketSell(userID primitive.ObjectID, symbol string, qty int64) (SellResult, map[string]string) {
+func MarketSell(accountID primitive.ObjectID, symbol string, qty decimal.Decimal) (SellResult, map[string]string) {
 	errs := map[string]string{}
 
 	sym := strings.ToUpper(strings.TrimSpace(symbol))
 	if sym == "" {
 		errs["symbol"] = "Missing symbol."
 	}
-	if qty <= 0 {
-		errs["qty"] = "Quantity must be greater than 0."
+	if msg := checkQty(qty); msg != "" {
+		errs["qty"] = msg
 	}
 	if len(errs) > 0 {
 		return SellResult{}, errs
 	}
 
-	// Fill price from quote
-	price, err := FetchCurrentPrice(sym)
-	if err != nil {
-		errs["_form"] = "Could not fetch current price."
+	// Fill price from the fill model
+	price, errs := marketFill(sym, "sell", qty, decimal.Zero)
+	if len(errs) > 0 {
 		return SellResult{}, errs
 	}
-	price = roundMoney(price)
 
-	proceeds := roundMoney(price * float64(qty))
+	acct, found := db.GetAccount(accountID)
+	if !found {
+		return SellResult{}, map[string]string{"_form": "Account not found."}
+	}
+
+	proceeds, ok := orderValue(price, qty)
+	if !ok {
+		return SellResult{}, map[string]string{"qty": tooLarge}
+	}
+	var fees FeeQuote
+	if sched, ok := accountFeeSchedule(acct); ok {
+		fees = computeFees(sched, "sell", qty, price)
+	}
 
 	// For now: no transactions to keep this chunk smaller.
 	// We'll do a safe sequential flow with validation using positions.
-	return marketSellNoTxn(userID, sym, qty, price, proceeds)
+	return marketSellNoTxn(acct, sym, qty, price, proceeds, fees)
 }
 
-func marketSellNoTxn(userID primitive.ObjectID, sym string, qty int64, price, proceeds float64) (SellResult, map[string]string) {
+func marketSellNoTxn(acct models.Account, sym string, qty, price, proceeds decimal.Decimal, fees FeeQuote) (SellResult, map[string]string) {
 	errs := map[string]string{}
 	client := db.Client
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	usersColl := client.Database("gomarket").Collection("users")
+	accountsColl := client.Database("gomarket").Collection(accountsCollection)
 	posColl := client.Database("gomarket").Collection("positions")
 	ordersColl := client.Database("gomarket").Collection("orders")
 
-	// 1) Ensure user has enough shares (atomic-ish: check and decrement with filter)
+	// 1) Ensure the account has enough shares (atomic-ish: check and decrement with filter)
 	now := time.Now().UTC()
 
-	// Decrement qty if enough
+	// Decrement qty if enough long shares
 	updateRes := posColl.FindOneAndUpdate(
 		ctx,
-		bson.M{"user_id": userID, "symbol": sym, "qty": bson.M{"$gte": qty}},
+		bson.M{"account_id": acct.ID, "symbol": sym, "qty": bson.M{"$gte": qty}},
 		bson.M{
-			"$inc": bson.M{"qty": -qty},
+			"$inc": bson.M{"qty": qty.Neg()},
 			"$set": bson.M{"updated_at": now},
 		},
 		options.FindOneAndUpdate().SetReturnDocument(options.After),
@@ -359,8 +498,16 @@ func marketSellNoTxn(userID primitive.ObjectID, sym string, qty int64, price, pr
 	var updatedPos models.Position
 	err := updateRes.Decode(&updatedPos)
 	if err == mongo.ErrNoDocuments {
-		errs["qty"] = "You don't have enough shares to sell."
-		return SellResult{}, errs
+		// Not enough long shares: a margin account sells short
+		if errs := checkShortSale(acct, sym, qty, price, fees); len(errs) > 0 {
+			return SellResult{}, errs
+		}
+		err = posColl.FindOneAndUpdate(
+			ctx,
+			bson.M{"account_id": acct.ID, "symbol": sym},
+			positionFillPipeline(acct, sym, qty.Neg(), price, now),
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&updatedPos)
 	}
 	if err != nil {
 		errs["_form"] = "Database error while selling."
@@ -369,21 +516,21 @@ func marketSellNoTxn(userID primitive.ObjectID, sym string, qty int64, price, pr
 
 	// If qty hit 0, delete the position doc
 	var remaining *models.Position
-	if updatedPos.Qty <= 0 {
-		_, _ = posColl.DeleteOne(ctx, bson.M{"_id": updatedPos.ID, "user_id": userID})
+	if updatedPos.Qty.IsZero() {
+		_, _ = posColl.DeleteOne(ctx, bson.M{"_id": updatedPos.ID, "account_id": acct.ID})
 		remaining = nil
 	} else {
 		remaining = &updatedPos
 	}
 
-	// 2) Credit balance
-	var updatedUser models.User
-	err = usersColl.FindOneAndUpdate(
+	// 2) Credit balance, net of fees
+	var updatedAcct models.Account
+	err = accountsColl.FindOneAndUpdate(
 		ctx,
-		bson.M{"_id": userID},
-		bson.M{"$inc": bson.M{"balance": proceeds}, "$set": bson.M{"updated_at": now}},
+		bson.M{"_id": acct.ID},
+		bson.M{"$inc": bson.M{"balance": proceeds.Sub(fees.Total())}, "$set": bson.M{"updated_at": now}},
 		options.FindOneAndUpdate().SetReturnDocument(options.After),
-	).Decode(&updatedUser)
+	).Decode(&updatedAcct)
 	if err != nil {
 		// Worst-case inconsistency: position decreased but balance update failed.
 		// We'll surface error; later we can wrap in txn for full safety.
@@ -393,24 +540,30 @@ func marketSellNoTxn(userID primitive.ObjectID, sym string, qty int64, price, pr
 
 	// 3) Insert order
 	order := models.Order{
-		UserID:    userID,
-		Symbol:    sym,
-		Side:      "sell",
-		Qty:       qty,
-		Price:     price,
-		CreatedAt: now,
+		ID:         primitive.NewObjectID(),
+		UserID:     acct.UserID,
+		AccountID:  acct.ID,
+		Symbol:     sym,
+		Side:       "sell",
+		Qty:        qty,
+		Price:      price,
+		Commission: fees.Commission,
+		RegFee:     fees.RegFee,
+		CreatedAt:  now,
 	}
 	if _, err := ordersColl.InsertOne(ctx, order); err != nil {
 		errs["_form"] = "Sold, but failed to record order."
 		return SellResult{}, errs
 	}
+	recordFeeCash(ctx, order)
 
 	return SellResult{
 		Symbol:     sym,
 		Qty:        qty,
 		FillPrice:  price,
 		Proceeds:   proceeds,
-		NewBalance: roundMoney(updatedUser.Balance),
+		Fees:       fees.Total(),
+		NewBalance: updatedAcct.Balance,
 		Remaining:  remaining,
 	}, nil
 }
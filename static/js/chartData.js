@@ -130,7 +130,8 @@
 
 			const qty = Number(pos.dataset.qty || 0);
 			const avg = Number(pos.dataset.avg || 0);
-			if (!Number.isFinite(qty) || qty <= 0) return;
+			// qty is negative for a short
+			if (!Number.isFinite(qty) || qty === 0) return;
 			if (!Number.isFinite(avg) || avg <= 0) return;
 
 			const lastEl = pos.querySelector('[data-role="pos-last-price"]');
@@ -142,7 +143,7 @@
 			lastEl.textContent = fmt2(price);
 
 			const pnl = (price - avg) * qty;
-			const pct = avg > 0 ? ((price - avg) / avg) * 100 : 0;
+			const pct = avg > 0 ? (pnl / (avg * Math.abs(qty))) * 100 : 0;
 
 			pnlVal.textContent = (pnl > 0 ? "+" : "") + fmt2(pnl);
 			pnlPct.textContent = (pct > 0 ? "+" : "") + fmt2(pct);
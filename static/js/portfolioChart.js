@@ -0,0 +1,148 @@
+// static/js/portfolioChart.js
+// Account value history on /portfolio (daily + intraday snapshots)
+// Same init/cleanup pattern as chartData.js so it survives HTMX swaps
+
+(function () {
+	function fmt2(x) {
+		return (Math.round(x * 100) / 100).toFixed(2);
+	}
+
+	function init(root) {
+		const wrap =
+			root?.querySelector?.('[data-portfolio-chart="1"]') ||
+			(root?.matches?.('[data-portfolio-chart="1"]') ? root : null);
+
+		if (!wrap || wrap.dataset.chartInit === "1") return;
+		wrap.dataset.chartInit = "1";
+
+		const chartEl = wrap.querySelector("#portfolioChart");
+		const valueEl = wrap.querySelector('[data-role="pc-value"]');
+		const changeEl = wrap.querySelector('[data-role="pc-change"]');
+		const emptyEl = wrap.querySelector('[data-role="pc-empty"]');
+		const buttons = wrap.querySelectorAll("[data-range]");
+
+		if (!chartEl) return;
+		if (!window.LightweightCharts) {
+			console.error("LightweightCharts not loaded");
+			return;
+		}
+
+		const chart = LightweightCharts.createChart(chartEl, {
+			width: chartEl.clientWidth,
+			height: chartEl.clientHeight || 300,
+			layout: {
+				background: { type: "solid", color: "#212529" },
+				textColor: "#e5e7eb",
+			},
+			grid: {
+				vertLines: { color: "rgba(255,255,255,0.06)" },
+				horzLines: { color: "rgba(255,255,255,0.06)" },
+			},
+			rightPriceScale: { borderColor: "rgba(255,255,255,0.12)" },
+			timeScale: {
+				borderColor: "rgba(255,255,255,0.12)",
+				timeVisible: true,
+				secondsVisible: false,
+			},
+		});
+
+		const series = chart.addAreaSeries({
+			lineColor: "#0d6efd",
+			topColor: "rgba(13,110,253,0.35)",
+			bottomColor: "rgba(13,110,253,0.02)",
+			lineWidth: 2,
+		});
+
+		const ro = new ResizeObserver(() => {
+			requestAnimationFrame(() => {
+				chart.applyOptions({
+					width: chartEl.clientWidth,
+					height: chartEl.clientHeight || 300,
+				});
+			});
+		});
+		ro.observe(chartEl);
+
+		let seq = 0;
+
+		async function load(range) {
+			const mySeq = ++seq;
+			buttons.forEach((b) =>
+				b.classList.toggle("active", b.dataset.range === range),
+			);
+
+			// returns for the same range (HTMX partial)
+			const perfEl = wrap.querySelector("#portfolioPerformance");
+			if (perfEl && window.htmx) {
+				htmx.ajax(
+					"GET",
+					`/portfolio/performance?range=${encodeURIComponent(range)}`,
+					{ target: perfEl, swap: "innerHTML" },
+				);
+			}
+
+			let body;
+			try {
+				const res = await fetch(
+					`/portfolio/history?range=${encodeURIComponent(range)}`,
+					{ credentials: "same-origin" },
+				);
+				if (!res.ok) return;
+				body = await res.json();
+			} catch {
+				return;
+			}
+			if (mySeq !== seq) return; // a newer range was clicked
+
+			// lightweight-charts needs strictly increasing times
+			const data = [];
+			for (const p of body.points || []) {
+				const t = Math.floor(new Date(p.time).getTime() / 1000);
+				if (data.length && data[data.length - 1].time >= t) continue;
+				data.push({ time: t, value: p.value });
+			}
+
+			series.setData(data);
+			chart.timeScale().fitContent();
+			emptyEl?.classList.toggle("d-none", data.length > 0);
+
+			if (!data.length) {
+				valueEl.textContent = "—";
+				changeEl.textContent = "";
+				return;
+			}
+
+			const first = data[0].value;
+			const last = data[data.length - 1].value;
+			const diff = last - first;
+			const pct = first > 0 ? (diff / first) * 100 : 0;
+
+			valueEl.textContent = fmt2(last);
+			changeEl.textContent =
+				(diff > 0 ? "+" : "") + fmt2(diff) + " (" + (pct > 0 ? "+" : "") + fmt2(pct) + "%)";
+			changeEl.classList.remove("text-success", "text-danger", "text-muted");
+			changeEl.classList.add(
+				diff > 0 ? "text-success" : diff < 0 ? "text-danger" : "text-muted",
+			);
+		}
+
+		buttons.forEach((b) =>
+			b.addEventListener("click", () => load(b.dataset.range)),
+		);
+
+		wrap._destroy = () => {
+			ro.disconnect();
+			chart.remove();
+		};
+
+		const active = wrap.querySelector("[data-range].active");
+		load(active ? active.dataset.range : "1M");
+	}
+
+	document.addEventListener("DOMContentLoaded", () => init(document));
+	document.addEventListener("htmx:load", (e) => init(e.target));
+	document.body.addEventListener("htmx:beforeCleanupElement", (e) => {
+		const el = e.target;
+		if (el?.dataset?.chartInit === "1" && el._destroy) el._destroy();
+	});
+})();